@@ -0,0 +1,455 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/formatter"
+
+	"github.com/jdbaldry/jsonnet-tool/pkg/traverse"
+)
+
+// astEdit is a semantic difference between two versions of a Jsonnet file.
+type astEdit struct {
+	// Path locates the edit in the value of the file, like $.spec.replicas.
+	Path    string
+	Message string
+	// LocationRange is in the new file, or in the old file for removals.
+	LocationRange LocationRange
+}
+
+// String returns the edit in the same format as lint diagnostics.
+func (e astEdit) String() string {
+	return fmt.Sprintf("%s %s: %s", e.LocationRange, e.Path, e.Message)
+}
+
+// unparen returns the expression inside any parentheses.
+func unparen(node ast.Node) ast.Node {
+	for {
+		parens, ok := node.(*ast.Parens)
+		if !ok {
+			return node
+		}
+		node = parens.Inner
+	}
+}
+
+// ignoredASTField returns true if the struct field only describes formatting.
+func ignoredASTField(field reflect.StructField) bool {
+	switch field.Name {
+	case "NodeBase", "LocRange", "TrailingComma", "BlockIndent", "BlockTermIndent":
+		return true
+	}
+	return field.Type == reflect.TypeOf(ast.Fodder{})
+}
+
+// astEqual returns true if the raw ASTs are equal, ignoring formatting, comments, parentheses, quoting, and
+// the spelling of numbers and field names.
+func astEqual(a, b ast.Node) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return valuesEqual(reflect.ValueOf(unparen(a)), reflect.ValueOf(unparen(b)))
+}
+
+// valuesEqual compares parts of raw ASTs for astEqual.
+func valuesEqual(a, b reflect.Value) bool {
+	if a.Kind() != b.Kind() {
+		return false
+	}
+	switch a.Kind() {
+	case reflect.Interface:
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() == b.IsNil()
+		}
+		if an, ok := a.Interface().(ast.Node); ok {
+			bn, _ := b.Interface().(ast.Node)
+			return astEqual(an, bn)
+		}
+		return valuesEqual(a.Elem(), b.Elem())
+	case reflect.Ptr:
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() == b.IsNil()
+		}
+		return valuesEqual(a.Elem(), b.Elem())
+	case reflect.Struct:
+		if a.Type() != b.Type() {
+			return false
+		}
+		switch av := a.Interface().(type) {
+		case ast.LiteralNumber:
+			return numbersEqual(av.OriginalString, b.Interface().(ast.LiteralNumber).OriginalString)
+		case ast.LiteralString:
+			return av.Value == b.Interface().(ast.LiteralString).Value
+		case ast.ObjectField:
+			return fieldsEqual(av, b.Interface().(ast.ObjectField))
+		}
+		for i := 0; i < a.NumField(); i++ {
+			if !ignoredASTField(a.Type().Field(i)) && !valuesEqual(a.Field(i), b.Field(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Slice:
+		if a.Len() != b.Len() {
+			return false
+		}
+		for i := 0; i < a.Len(); i++ {
+			if !valuesEqual(a.Index(i), b.Index(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.String:
+		return a.String() == b.String()
+	case reflect.Bool:
+		return a.Bool() == b.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return a.Int() == b.Int()
+	}
+	return false
+}
+
+// numbersEqual returns true if the number literals have the same value.
+func numbersEqual(a, b string) bool {
+	x, errX := strconv.ParseFloat(a, 64)
+	y, errY := strconv.ParseFloat(b, 64)
+	if errX != nil || errY != nil {
+		return a == b
+	}
+	return x == y
+}
+
+// fieldsEqual returns true if the object fields are equal. Fields with the same static name are equal however
+// their names are written.
+func fieldsEqual(a, b ast.ObjectField) bool {
+	aName, aStatic := fieldName(a)
+	bName, bStatic := fieldName(b)
+	switch {
+	case aStatic && bStatic:
+		if aName != bName {
+			return false
+		}
+	case aStatic || bStatic || a.Kind != b.Kind:
+		return false
+	case a.Kind == ast.ObjectLocal:
+		if *a.Id != *b.Id {
+			return false
+		}
+	default:
+		if !astEqual(a.Expr1, b.Expr1) {
+			return false
+		}
+	}
+	if a.Method != nil || b.Method != nil {
+		if a.Method == nil || b.Method == nil || !astEqual(a.Method, b.Method) {
+			return false
+		}
+	}
+	return a.Hide == b.Hide && a.SuperSugar == b.SuperSugar && astEqual(a.Expr2, b.Expr2) && astEqual(a.Expr3, b.Expr3)
+}
+
+// identifier matches the field names that can be written without quotes.
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// fieldPath returns the path of the named field of the object at path. Names that are not identifiers are quoted,
+// like $["app.kubernetes.io/name"], so that they cannot be mistaken for nested fields.
+func fieldPath(path, name string) string {
+	if identifier.MatchString(name) {
+		return path + "." + name
+	}
+	return fmt.Sprintf("%s[%q]", path, name)
+}
+
+// fieldKey identifies the fields of an object that correspond between versions. Fields with a static name are
+// identified by their name, locals by their variable, and other fields by their kind and position.
+func fieldKey(field ast.ObjectField, index int) string {
+	if name, ok := fieldName(field); ok {
+		return "field " + name
+	}
+	switch field.Kind {
+	case ast.ObjectLocal:
+		return "local " + string(*field.Id)
+	case ast.ObjectAssert:
+		return fmt.Sprintf("assert %d", index)
+	}
+	return fmt.Sprintf("computed field %d", index)
+}
+
+// hideOperators are the operators of each field visibility.
+var hideOperators = map[ast.ObjectFieldHide]string{
+	ast.ObjectFieldHidden:  "::",
+	ast.ObjectFieldInherit: ":",
+	ast.ObjectFieldVisible: ":::",
+}
+
+// fieldOperator returns the operator of the field, like : or +::, which determines its visibility and whether it
+// is merged with the inherited field.
+func fieldOperator(field ast.ObjectField) string {
+	if field.SuperSugar {
+		return "+" + hideOperators[field.Hide]
+	}
+	return hideOperators[field.Hide]
+}
+
+// astDiffer finds the semantic differences between two versions of a file.
+type astDiffer struct {
+	oldInput string
+	newInput string
+	edits    []astEdit
+}
+
+// add records an edit at the location of the node.
+func (d *astDiffer) add(path string, node ast.Node, format string, args ...interface{}) {
+	d.addAt(path, *node.Loc(), format, args...)
+}
+
+// addAt records an edit at the location.
+func (d *astDiffer) addAt(path string, loc ast.LocationRange, format string, args ...interface{}) {
+	d.edits = append(d.edits, astEdit{Path: path, Message: fmt.Sprintf(format, args...), LocationRange: makeLocationRange(&loc)})
+}
+
+// summary returns the source of a node of the old or new file on one line, shortened if it is long.
+func summary(input string, node ast.Node) string {
+	s := strings.Join(strings.Fields(sourceText(input, node)), " ")
+	if len(s) > 60 {
+		s = s[:57] + "..."
+	}
+	return "`" + s + "`"
+}
+
+// diff records the edits between the old and new nodes at the path.
+// Differences that are not described more specifically are recorded as changed expressions.
+func (d *astDiffer) diff(path string, old, new ast.Node) {
+	old, new = unparen(old), unparen(new)
+	if astEqual(old, new) {
+		return
+	}
+	if old == nil || new == nil {
+		if new != nil {
+			d.add(path, new, "expression %s added", summary(d.newInput, new))
+		} else {
+			d.add(path, old, "expression %s removed", summary(d.oldInput, old))
+		}
+		return
+	}
+	before := len(d.edits)
+	if reflect.TypeOf(old) == reflect.TypeOf(new) {
+		switch o := old.(type) {
+		case *ast.Object:
+			d.diffObject(path, o, new.(*ast.Object))
+		case *ast.Local:
+			d.diffLocal(path, o, new.(*ast.Local))
+		case *ast.Function:
+			d.diffFunction(path, o, new.(*ast.Function))
+		case *ast.Conditional:
+			n := new.(*ast.Conditional)
+			if !astEqual(o.Cond, n.Cond) {
+				d.add(path, n.Cond, "condition modified from %s to %s", summary(d.oldInput, o.Cond), summary(d.newInput, n.Cond))
+			}
+			d.diff(path, o.BranchTrue, n.BranchTrue)
+			d.diff(path, o.BranchFalse, n.BranchFalse)
+		case *ast.Array:
+			d.diffArray(path, o, new.(*ast.Array))
+		case *ast.Apply:
+			d.diffApply(path, o, new.(*ast.Apply))
+		case *ast.Index:
+			// A different field name changes the whole expression, whatever changed in the target.
+			if n := new.(*ast.Index); (o.Id == nil) == (n.Id == nil) && (o.Id == nil || *o.Id == *n.Id) {
+				d.diffChildren(path, old, new)
+			}
+		default:
+			d.diffChildren(path, old, new)
+		}
+	}
+	if len(d.edits) > before {
+		return
+	}
+	if isLiteral(old) && isLiteral(new) {
+		d.add(path, new, "value changed from %s to %s", summary(d.oldInput, old), summary(d.newInput, new))
+		return
+	}
+	d.add(path, new, "expression changed from %s to %s", summary(d.oldInput, old), summary(d.newInput, new))
+}
+
+// diffChildren records the differences between the children of nodes of the same type, if they have as many.
+func (d *astDiffer) diffChildren(path string, old, new ast.Node) {
+	oldChildren, newChildren := traverse.Children(old), traverse.Children(new)
+	if len(oldChildren) == len(newChildren) {
+		for i := range oldChildren {
+			d.diff(path, oldChildren[i], newChildren[i])
+		}
+	}
+}
+
+// fieldValue returns the value of an object field, which is a function for methods.
+func fieldValue(field ast.ObjectField) ast.Node {
+	if field.Method != nil {
+		return field.Method
+	}
+	return field.Expr2
+}
+
+// diffObject records the fields that were added, removed, or changed.
+func (d *astDiffer) diffObject(path string, old, new *ast.Object) {
+	oldFields := make(map[string]ast.ObjectField, len(old.Fields))
+	for i, field := range old.Fields {
+		oldFields[fieldKey(field, i)] = field
+	}
+	newKeys := make(map[string]bool, len(new.Fields))
+	for i, n := range new.Fields {
+		key := fieldKey(n, i)
+		newKeys[key] = true
+		childPath := path
+		if name, ok := fieldName(n); ok {
+			childPath = fieldPath(path, name)
+		}
+		o, ok := oldFields[key]
+		if !ok {
+			d.addAt(childPath, n.LocRange, "%s added", key)
+			continue
+		}
+		if n.Kind != ast.ObjectLocal && n.Kind != ast.ObjectAssert && fieldOperator(o) != fieldOperator(n) {
+			d.addAt(childPath, n.LocRange, "operator changed from %s to %s", fieldOperator(o), fieldOperator(n))
+		}
+		if n.Kind == ast.ObjectFieldExpr || n.Kind == ast.ObjectFieldStr {
+			if _, static := fieldName(n); !static {
+				d.diff(childPath, o.Expr1, n.Expr1)
+			}
+		}
+		d.diff(childPath, fieldValue(o), fieldValue(n))
+		d.diff(childPath, o.Expr3, n.Expr3)
+	}
+	for i, o := range old.Fields {
+		if key := fieldKey(o, i); !newKeys[key] {
+			childPath := path
+			if name, ok := fieldName(o); ok {
+				childPath = fieldPath(path, name)
+			}
+			d.addAt(childPath, o.LocRange, "%s removed", key)
+		}
+	}
+}
+
+// diffLocal records the local variables that were added, removed, or changed and the changes to the body.
+func (d *astDiffer) diffLocal(path string, old, new *ast.Local) {
+	bindValue := func(bind ast.LocalBind) ast.Node {
+		if bind.Fun != nil {
+			return bind.Fun
+		}
+		return bind.Body
+	}
+	oldBinds := make(map[ast.Identifier]ast.LocalBind, len(old.Binds))
+	for _, bind := range old.Binds {
+		oldBinds[bind.Variable] = bind
+	}
+	newBinds := make(map[ast.Identifier]bool, len(new.Binds))
+	for _, n := range new.Binds {
+		newBinds[n.Variable] = true
+		o, ok := oldBinds[n.Variable]
+		if !ok {
+			d.addAt(path, n.LocRange, "local %s added", n.Variable)
+			continue
+		}
+		d.diff(fmt.Sprintf("%s (local %s)", path, n.Variable), bindValue(o), bindValue(n))
+	}
+	for _, o := range old.Binds {
+		if !newBinds[o.Variable] {
+			d.addAt(path, o.LocRange, "local %s removed", o.Variable)
+		}
+	}
+	d.diff(path, old.Body, new.Body)
+}
+
+// diffFunction records the parameters that were added, removed, or had their default arguments changed, and the
+// changes to the body.
+func (d *astDiffer) diffFunction(path string, old, new *ast.Function) {
+	oldParams := make(map[ast.Identifier]ast.Parameter, len(old.Parameters))
+	for _, p := range old.Parameters {
+		oldParams[p.Name] = p
+	}
+	newParams := make(map[ast.Identifier]bool, len(new.Parameters))
+	for _, n := range new.Parameters {
+		newParams[n.Name] = true
+		o, ok := oldParams[n.Name]
+		switch {
+		case !ok:
+			d.addAt(path, n.LocRange, "parameter %s added", n.Name)
+		case o.DefaultArg == nil && n.DefaultArg != nil:
+			d.addAt(path, n.LocRange, "parameter %s is now optional with default %s", n.Name, summary(d.newInput, n.DefaultArg))
+		case o.DefaultArg != nil && n.DefaultArg == nil:
+			d.addAt(path, n.LocRange, "parameter %s is now required", n.Name)
+		case o.DefaultArg != nil && !astEqual(o.DefaultArg, n.DefaultArg):
+			d.addAt(path, n.LocRange, "default of parameter %s changed from %s to %s", n.Name, summary(d.oldInput, o.DefaultArg), summary(d.newInput, n.DefaultArg))
+		}
+	}
+	for _, o := range old.Parameters {
+		if !newParams[o.Name] {
+			d.addAt(path, o.LocRange, "parameter %s removed", o.Name)
+		}
+	}
+	d.diff(path, old.Body, new.Body)
+}
+
+// diffArray records the elements that were added, removed, or changed, by position.
+func (d *astDiffer) diffArray(path string, old, new *ast.Array) {
+	for i := 0; i < len(old.Elements) || i < len(new.Elements); i++ {
+		elementPath := fmt.Sprintf("%s[%d]", path, i)
+		switch {
+		case i >= len(old.Elements):
+			d.add(elementPath, new.Elements[i].Expr, "element %s added", summary(d.newInput, new.Elements[i].Expr))
+		case i >= len(new.Elements):
+			d.add(elementPath, old.Elements[i].Expr, "element %s removed", summary(d.oldInput, old.Elements[i].Expr))
+		default:
+			d.diff(elementPath, old.Elements[i].Expr, new.Elements[i].Expr)
+		}
+	}
+}
+
+// diffApply records the changes to the arguments of a function call, if the same function is called.
+func (d *astDiffer) diffApply(path string, old, new *ast.Apply) {
+	if !astEqual(old.Target, new.Target) || len(old.Arguments.Positional) != len(new.Arguments.Positional) {
+		return
+	}
+	for i := range new.Arguments.Positional {
+		d.diff(fmt.Sprintf("%s (argument %d)", path, i+1), old.Arguments.Positional[i].Expr, new.Arguments.Positional[i].Expr)
+	}
+	oldNamed := make(map[ast.Identifier]ast.Node, len(old.Arguments.Named))
+	for _, arg := range old.Arguments.Named {
+		oldNamed[arg.Name] = arg.Arg
+	}
+	newNamed := make(map[ast.Identifier]bool, len(new.Arguments.Named))
+	for _, arg := range new.Arguments.Named {
+		newNamed[arg.Name] = true
+		argPath := fmt.Sprintf("%s (argument %s)", path, arg.Name)
+		if o, ok := oldNamed[arg.Name]; ok {
+			d.diff(argPath, o, arg.Arg)
+		} else {
+			d.add(argPath, arg.Arg, "argument %s added", arg.Name)
+		}
+	}
+	for _, arg := range old.Arguments.Named {
+		if !newNamed[arg.Name] {
+			d.add(fmt.Sprintf("%s (argument %s)", path, arg.Name), arg.Arg, "argument %s removed", arg.Name)
+		}
+	}
+}
+
+// astDiff returns the semantic edits between the old and new versions of a file.
+func astDiff(oldFile, oldInput, newFile, newInput string) ([]astEdit, error) {
+	oldRoot, _, err := formatter.SnippetToRawAST(oldFile, oldInput)
+	if err != nil {
+		return nil, err
+	}
+	newRoot, _, err := formatter.SnippetToRawAST(newFile, newInput)
+	if err != nil {
+		return nil, err
+	}
+	d := &astDiffer{oldInput: oldInput, newInput: newInput}
+	d.diff("$", oldRoot, newRoot)
+	return d.edits, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// bundleFilename is the name of the generated file, in the bundle directory, that imports the files of the bundle.
+const bundleFilename = "<bundle>"
+
+// bundleModes are the ways that the files of a bundle can be combined.
+var bundleModes = []string{"object", "merge", "array"}
+
+// bundleFiles returns the .jsonnet and .libsonnet files in dir, relative to dir and in lexical order.
+// If recursive is true, the files in subdirectories are included, skipping hidden and vendor directories.
+// Hidden files and *_test.jsonnet files are never included.
+func bundleFiles(dir string, recursive bool) ([]string, error) {
+	var paths []string
+	if recursive {
+		found, err := findFiles(dir, ".jsonnet", ".libsonnet")
+		if err != nil {
+			return nil, err
+		}
+		paths = found
+	} else {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if ext := filepath.Ext(entry.Name()); !entry.IsDir() && (ext == ".jsonnet" || ext == ".libsonnet") {
+				paths = append(paths, filepath.Join(dir, entry.Name()))
+			}
+		}
+	}
+	var files []string
+	for _, path := range paths {
+		if strings.HasPrefix(filepath.Base(path), ".") || strings.HasSuffix(path, testFileSuffix) {
+			continue
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, filepath.ToSlash(rel))
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// bundleSnippet returns Jsonnet that imports the files, which are relative to the bundle directory, and combines them.
+// In object mode, the value of each file is a field named by its path without the extension.
+// In merge mode, the values are merged in order with the + operator.
+// In array mode, the values are elements in order.
+func bundleSnippet(files []string, mode string) (string, error) {
+	var b strings.Builder
+	switch mode {
+	case "object":
+		keys := make(map[string]string, len(files))
+		b.WriteString("{\n")
+		for _, file := range files {
+			key := strings.TrimSuffix(file, filepath.Ext(file))
+			if other, ok := keys[key]; ok {
+				return "", fmt.Errorf("files %s and %s would both be the field %s", other, file, key)
+			}
+			keys[key] = file
+			// JSON strings are also Jsonnet strings.
+			k, _ := json.Marshal(key)
+			f, _ := json.Marshal(file)
+			fmt.Fprintf(&b, "  %s: import %s,\n", k, f)
+		}
+		b.WriteString("}\n")
+	case "merge":
+		// Merging onto an empty object makes an empty bundle an empty object.
+		b.WriteString("{}")
+		for _, file := range files {
+			f, _ := json.Marshal(file)
+			fmt.Fprintf(&b, " +\n(import %s)", f)
+		}
+		b.WriteString("\n")
+	case "array":
+		b.WriteString("[\n")
+		for _, file := range files {
+			f, _ := json.Marshal(file)
+			fmt.Fprintf(&b, "  import %s,\n", f)
+		}
+		b.WriteString("]\n")
+	default:
+		return "", fmt.Errorf("unknown bundle mode %s, expected one of %s", mode, strings.Join(bundleModes, ", "))
+	}
+	return b.String(), nil
+}
+
+// makeBundle generates the bundle of the files in dir and returns its filename.
+// The bundle is imported from memory, so that it can be evaluated like any other file.
+func makeBundle(dir string, recursive bool, mode string) (string, error) {
+	files, err := bundleFiles(dir, recursive)
+	if err != nil {
+		return "", err
+	}
+	snippet, err := bundleSnippet(files, mode)
+	if err != nil {
+		return "", err
+	}
+	filename := filepath.Join(dir, bundleFilename)
+	setMemorySource(filename, []byte(snippet))
+	return filename, nil
+}
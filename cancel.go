@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// interruptedCode is the exit code of an interrupted command, following the shell convention of 128 plus the
+// number of SIGINT.
+const interruptedCode = 130
+
+// interruptGrace is how long an interrupted command has to clean up and exit on its own before it is exited.
+const interruptGrace = 2 * time.Second
+
+// notifyContext returns a context that is cancelled when the process receives SIGINT or SIGTERM, so that commands
+// can stop evaluating, write what they have, and remove partial output. Commands that do not watch the context,
+// or do not exit within interruptGrace, are exited, as is any command when a second signal is received.
+func notifyContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+		select {
+		case <-signals:
+		case <-time.After(interruptGrace):
+		}
+		os.Exit(interruptedCode)
+	}()
+	return ctx
+}
+
+// interrupted exits because the command was interrupted. Like any failed command, the --output file is left unchanged.
+func interrupted() {
+	fmt.Fprintln(os.Stderr, "Interrupted")
+	exit(interruptedCode)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/formatter"
+
+	"github.com/jdbaldry/jsonnet-tool/pkg/traverse"
+)
+
+// importNode is an import, importstr, or importbin expression.
+type importNode struct {
+	// Code is true for an import of Jsonnet code, which is parsed and checked in turn.
+	Code          bool
+	Path          string
+	LocationRange LocationRange
+}
+
+// findImportNodes returns the imports of the AST in source order.
+func findImportNodes(root ast.Node) []importNode {
+	var imports []importNode
+	traverse.Traverse(root,
+		func(node *ast.Node) error {
+			var file *ast.LiteralString
+			code := false
+			switch n := (*node).(type) {
+			case *ast.Import:
+				file, code = n.File, true
+			case *ast.ImportStr:
+				file = n.File
+			case *ast.ImportBin:
+				file = n.File
+			default:
+				return nil
+			}
+			imports = append(imports, importNode{Code: code, Path: file.Value, LocationRange: makeLocationRange((*node).Loc())})
+			return nil
+		}, traverse.Nop, traverse.Nop)
+	return imports
+}
+
+// checker validates files and their imports without evaluating them.
+type checker struct {
+	vm       *jsonnet.VM
+	importer jsonnet.Importer
+	// state is visiting for the files on the current import path and checked for the files that have been checked.
+	state map[string]string
+	// stack is the current import path.
+	stack       []string
+	diagnostics []diagnostic
+}
+
+// newChecker returns a checker that resolves imports like the eval command.
+func newChecker() *checker {
+	vm := makeVM()
+	importer := makeImporter()
+	vm.Importer(importer)
+	return &checker{vm: vm, importer: importer, state: make(map[string]string)}
+}
+
+// report records a diagnostic for the error at the location, or at the location of the error if it has one.
+func (c *checker) report(rule string, err error, at LocationRange) {
+	e := makeJsonnetError(err)
+	if e.LocationRange != nil {
+		at = *e.LocationRange
+	}
+	c.diagnostics = append(c.diagnostics, diagnostic{Rule: rule, Message: e.Message, LocationRange: at})
+}
+
+// check parses and statically analyzes the file, imported from importedFrom at the location, and then checks its imports.
+// Each file is only checked once.
+func (c *checker) check(importedFrom, path string, at LocationRange) {
+	root, foundAt, err := c.vm.ImportAST(importedFrom, path)
+	if err != nil && foundAt == "" {
+		c.report("unresolved-import", err, at)
+		return
+	}
+	if err != nil {
+		c.report("static", err, at)
+		// The imports of a file with static errors other than syntax errors can still be checked.
+		root = c.rawAST(importedFrom, path, foundAt)
+	}
+	// A file that failed to parse has already been reported.
+	if root == nil {
+		return
+	}
+	switch c.state[foundAt] {
+	case "checked":
+		return
+	case "visiting":
+		cycle := append([]string{}, c.stack[indexOf(c.stack, foundAt):]...)
+		cycle = append(cycle, foundAt)
+		c.diagnostics = append(c.diagnostics, diagnostic{
+			Rule:          "import-cycle",
+			Message:       fmt.Sprintf("Import cycle: %s", strings.Join(cycle, " -> ")),
+			LocationRange: at,
+		})
+		return
+	}
+	c.state[foundAt] = "visiting"
+	c.stack = append(c.stack, foundAt)
+	for _, imp := range findImportNodes(root) {
+		if imp.Code {
+			c.check(foundAt, imp.Path, imp.LocationRange)
+			continue
+		}
+		if _, err := c.vm.ResolveImport(foundAt, imp.Path); err != nil {
+			c.report("unresolved-import", err, imp.LocationRange)
+		}
+	}
+	c.stack = c.stack[:len(c.stack)-1]
+	c.state[foundAt] = "checked"
+}
+
+// rawAST returns the raw AST of the imported file, or nil if it cannot be parsed.
+func (c *checker) rawAST(importedFrom, path, foundAt string) ast.Node {
+	contents, _, err := c.importer.Import(importedFrom, path)
+	if err != nil {
+		return nil
+	}
+	root, _, err := formatter.SnippetToRawAST(foundAt, contents.String())
+	if err != nil {
+		return nil
+	}
+	return root
+}
+
+// indexOf returns the index of the first s in values, or -1 if there is none.
+func indexOf(values []string, s string) int {
+	for i, v := range values {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/formatter"
+
+	"github.com/jdbaldry/jsonnet-tool/pkg/traverse"
+	toolvm "github.com/jdbaldry/jsonnet-tool/pkg/vm"
+)
+
+// checkpointVersion is included in every checkpoint key so that changes to the checkpoint
+// format invalidate existing checkpoints.
+const checkpointVersion = "jsonnet-tool checkpoint 2"
+
+// checkpointedFile is an entrypoint whose top level object fields can be evaluated and cached separately.
+type checkpointedFile struct {
+	file  string
+	input string
+	// fields are the visible fields of the top level object.
+	fields []ast.ObjectField
+	// context are the nodes that every field can refer to: the top level local variables,
+	// and the object local variables and assertions.
+	context []ast.Node
+	// contextRanges are the source locations of the context nodes.
+	contextRanges []ast.LocationRange
+}
+
+// parseCheckpointedFile parses the entrypoint file.
+// It returns false if the file does not evaluate to an object literal with static, visible field names,
+// optionally preceded by local variables.
+func parseCheckpointedFile(file string) (checkpointedFile, bool, error) {
+	b, err := readInput(file)
+	if err != nil {
+		return checkpointedFile{}, false, err
+	}
+	cf := checkpointedFile{file: file, input: string(b)}
+	root, _, err := formatter.SnippetToRawAST(file, cf.input)
+	if err != nil {
+		return cf, false, err
+	}
+	for node := root; ; {
+		switch i := node.(type) {
+		case *ast.Local:
+			for _, bind := range i.Binds {
+				cf.context = append(cf.context, bind.Body)
+				cf.contextRanges = append(cf.contextRanges, bind.LocRange)
+			}
+			node = i.Body
+			continue
+		case *ast.Parens:
+			node = i.Inner
+			continue
+		case *ast.Object:
+			for _, field := range i.Fields {
+				switch {
+				case field.Kind == ast.ObjectLocal || field.Kind == ast.ObjectAssert:
+					cf.context = append(cf.context, field.Expr2, field.Expr3)
+					cf.contextRanges = append(cf.contextRanges, field.LocRange)
+				case field.Hide == ast.ObjectFieldHidden:
+				default:
+					if _, ok := fieldName(field); !ok {
+						return cf, false, nil
+					}
+					cf.fields = append(cf.fields, field)
+				}
+			}
+			return cf, len(cf.fields) > 0, nil
+		}
+		return cf, false, nil
+	}
+}
+
+// source returns the source text of the location range.
+func (cf checkpointedFile) source(loc ast.LocationRange) string {
+	begin, end := sourceOffset(cf.input, loc.Begin), sourceOffset(cf.input, loc.End)
+	if begin > end {
+		return ""
+	}
+	return cf.input[begin:end]
+}
+
+// importPaths returns the paths of the import, importstr, and importbin expressions in the nodes.
+// Code imports are returned separately from string and binary imports.
+func importPaths(nodes []ast.Node) (code, data []string) {
+	for _, node := range nodes {
+		if node == nil {
+			continue
+		}
+		traverse.Traverse(node,
+			func(node *ast.Node) error {
+				switch i := (*node).(type) {
+				case *ast.Import:
+					code = append(code, i.File.Value)
+				case *ast.ImportStr:
+					data = append(data, i.File.Value)
+				case *ast.ImportBin:
+					data = append(data, i.File.Value)
+				}
+				return nil
+			},
+			traverse.Nop,
+			traverse.Nop,
+		)
+	}
+	return code, data
+}
+
+// refersToObject returns true if any of the nodes refer to self, $, or super, in which case
+// a field may depend on any other field of the top level object.
+func refersToObject(nodes []ast.Node) bool {
+	found := false
+	for _, node := range nodes {
+		if node == nil {
+			continue
+		}
+		traverse.Traverse(node,
+			func(node *ast.Node) error {
+				switch (*node).(type) {
+				case *ast.Self, *ast.Dollar, *ast.SuperIndex, *ast.InSuper:
+					found = true
+				}
+				return nil
+			},
+			traverse.Nop,
+			traverse.Nop,
+		)
+	}
+	return found
+}
+
+// key returns the checkpoint key of a field.
+// The key is a hash of the source of the field and the context it can refer to, the contents of the files it
+// can transitively import, and the inputs of the VM: the import hooks and search directories, the external
+// variables, and the manifestYamlFromJson mode. If the field or context refer to the object itself, the whole
+// file is hashed. Evaluations that can read environment variables must not be checkpointed because the
+// variables they read are not known in advance.
+func (cf checkpointedFile) key(vm *jsonnet.VM, importer jsonnet.Importer, field ast.ObjectField) (string, error) {
+	h := sha256.New()
+	write := func(parts ...string) {
+		for _, part := range parts {
+			fmt.Fprintf(h, "%d:%s", len(part), part)
+		}
+	}
+	// Maps are marshalled with sorted keys.
+	hooks, _ := json.Marshal(config.ImportHooks)
+	jpaths, _ := json.Marshal(importJPaths())
+	extVars, _ := json.Marshal(config.ExtVars)
+	write(checkpointVersion, absPath(cf.file), string(hooks), string(jpaths), string(extVars), fmt.Sprint(config.ManifestYamlAsJSON))
+
+	nodes := append([]ast.Node{field.Expr1, field.Expr2, field.Expr3}, cf.context...)
+	if field.Method != nil {
+		for _, param := range field.Method.Parameters {
+			nodes = append(nodes, param.DefaultArg)
+		}
+	}
+	if refersToObject(nodes) {
+		write(cf.input)
+	} else {
+		write(cf.source(field.LocRange))
+		for _, loc := range cf.contextRanges {
+			write(cf.source(loc))
+		}
+	}
+
+	code, data := importPaths(nodes)
+	var files []string
+	for _, path := range append(code, data...) {
+		_, foundAt, err := importer.Import(cf.file, path)
+		if err != nil {
+			return "", err
+		}
+		files = append(files, foundAt)
+	}
+	if len(code) > 0 {
+		dependencies, err := vm.FindDependencies(cf.file, code)
+		if err != nil {
+			return "", err
+		}
+		files = append(files, dependencies...)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		contents, err := os.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		write(absPath(file), string(contents))
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// indentValue indents every line but the first of a manifested Jsonnet value so that it can be nested in an object.
+func indentValue(value string) string {
+	return strings.ReplaceAll(strings.TrimSuffix(value, "\n"), "\n", "\n   ")
+}
+
+// evaluateCheckpointed evaluates the file one top level field at a time, reusing the evaluations of fields
+// whose checkpoint key is in dir, and writing checkpoints for the fields that are evaluated.
+// It writes the names of the evaluated fields to log.
+// The output is the same as evaluating the whole file. If the context is cancelled, the context error is returned.
+func evaluateCheckpointed(ctx context.Context, vm *jsonnet.VM, importer jsonnet.Importer, cf checkpointedFile, dir string, log io.Writer) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("unable to create checkpoint directory: %w", err)
+	}
+	values := make(map[string]string, len(cf.fields))
+	names := make([]string, 0, len(cf.fields))
+	for _, field := range cf.fields {
+		name, _ := fieldName(field)
+		names = append(names, name)
+		key, err := cf.key(vm, importer, field)
+		if err != nil {
+			return "", err
+		}
+		checkpoint := filepath.Join(dir, key+".json")
+		if b, err := os.ReadFile(checkpoint); err == nil {
+			values[name] = string(b)
+			continue
+		}
+		// JSON strings are also Jsonnet strings.
+		path, _ := json.Marshal(absPath(cf.file))
+		quoted, _ := json.Marshal(name)
+		snippet := fmt.Sprintf("(import %s)[%s]", path, quoted)
+		node, err := jsonnet.SnippetToAST(cf.file, snippet)
+		if err != nil {
+			return "", err
+		}
+		value, err := toolvm.Evaluate(ctx, vm, node)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(log, "Evaluated field %s\n", name)
+		values[name] = value
+		// Checkpoints are renamed into place so that an interrupted evaluation cannot leave a truncated one.
+		if err := writeFileAtomic(checkpoint, []byte(value), false); err != nil {
+			return "", fmt.Errorf("unable to write checkpoint: %w", err)
+		}
+	}
+
+	sort.Strings(names)
+	var output strings.Builder
+	output.WriteString("{\n")
+	for i, name := range names {
+		var key bytes.Buffer
+		encoder := json.NewEncoder(&key)
+		encoder.SetEscapeHTML(false)
+		encoder.Encode(name)
+		output.WriteString(fmt.Sprintf("   %s: %s", strings.TrimSuffix(key.String(), "\n"), indentValue(values[name])))
+		if i < len(names)-1 {
+			output.WriteString(",")
+		}
+		output.WriteString("\n")
+	}
+	output.WriteString("}\n")
+	return output.String(), nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseCheckpointedFile(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		snippet string
+		fields  []string
+		want    bool
+	}{
+		{"object", "{ a: 1, b: 2 }", []string{"a", "b"}, true},
+		{"locals and parentheses", "local x = 1; ({ local y = 2, a: x + y })", []string{"a"}, true},
+		{"hidden fields are not checkpointed", "{ a: 1, b:: 2 }", []string{"a"}, true},
+		{"only hidden fields", "{ a:: 1 }", nil, false},
+		{"computed field name", "{ a: 1, [std.toString(2)]: 2 }", nil, false},
+		{"not an object", "[1, 2]", nil, false},
+		{"merge", "{ a: 1 } + { b: 2 }", nil, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			inTempDir(t, map[string]string{"test.jsonnet": tc.snippet})
+			cf, ok, err := parseCheckpointedFile("test.jsonnet")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tc.want {
+				t.Errorf("got checkpointed %t, want %t", ok, tc.want)
+			}
+			if !ok {
+				return
+			}
+			var fields []string
+			for _, field := range cf.fields {
+				name, _ := fieldName(field)
+				fields = append(fields, name)
+			}
+			if !reflect.DeepEqual(fields, tc.fields) {
+				t.Errorf("got fields %q, want %q", fields, tc.fields)
+			}
+		})
+	}
+}
+
+// evaluateCheckpointedFile evaluates the file with the checkpoints in dir, checks that the output is the same as
+// evaluating the whole file, and returns the names of the fields that were evaluated.
+func evaluateCheckpointedFile(t *testing.T, file, dir string) []string {
+	t.Helper()
+	cf, ok, err := parseCheckpointedFile(file)
+	if err != nil || !ok {
+		t.Fatalf("unable to checkpoint %s: %t, %v", file, ok, err)
+	}
+	vm := makeVM()
+	importer := makeImporter()
+	vm.Importer(importer)
+	var log bytes.Buffer
+	output, err := evaluateCheckpointed(context.Background(), vm, importer, cf, dir, &log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := makeVM().EvaluateFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != want {
+		t.Errorf("got output:\n%s\nwant:\n%s", output, want)
+	}
+	var evaluated []string
+	for _, line := range strings.Split(strings.TrimSpace(log.String()), "\n") {
+		if line != "" {
+			evaluated = append(evaluated, strings.TrimPrefix(line, "Evaluated field "))
+		}
+	}
+	return evaluated
+}
+
+func TestEvaluateCheckpointed(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		files map[string]string
+		// changes are written over the files after the first evaluation.
+		changes map[string]string
+		// evaluated are the fields that are evaluated again after the changes.
+		evaluated []string
+	}{
+		{
+			name:  "unchanged",
+			files: map[string]string{"test.jsonnet": "{ a: 1, b: 2 }"},
+		},
+		{
+			name:      "changed field",
+			files:     map[string]string{"test.jsonnet": "{ a: 1, b: 2 }"},
+			changes:   map[string]string{"test.jsonnet": "{ a: 1, b: 3 }"},
+			evaluated: []string{"b"},
+		},
+		{
+			name:      "added field",
+			files:     map[string]string{"test.jsonnet": "{ a: 1 }"},
+			changes:   map[string]string{"test.jsonnet": "{ a: 1, 'b.c': 2 }"},
+			evaluated: []string{"b.c"},
+		},
+		{
+			name: "changed import",
+			files: map[string]string{
+				"test.jsonnet":  "{ a: (import 'lib.libsonnet').x, b: 2 }",
+				"lib.libsonnet": "{ x: 1 }",
+			},
+			changes:   map[string]string{"lib.libsonnet": "{ x: 2 }"},
+			evaluated: []string{"a"},
+		},
+		{
+			name: "changed importstr",
+			files: map[string]string{
+				"test.jsonnet": "{ a: 1, b: importstr 'data.txt' }",
+				"data.txt":     "one",
+			},
+			changes:   map[string]string{"data.txt": "two"},
+			evaluated: []string{"b"},
+		},
+		{
+			name:      "changed local",
+			files:     map[string]string{"test.jsonnet": "local n = 1; { a: n, b: 2 }"},
+			changes:   map[string]string{"test.jsonnet": "local n = 2; { a: n, b: 2 }"},
+			evaluated: []string{"a", "b"},
+		},
+		{
+			name:      "self reference keyed by the whole file",
+			files:     map[string]string{"test.jsonnet": "{ a: 1, b: self.a, c: 3 }"},
+			changes:   map[string]string{"test.jsonnet": "{ a: 1, b: self.a, c: 4 }"},
+			evaluated: []string{"b", "c"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			inTempDir(t, tc.files)
+			evaluateCheckpointedFile(t, "test.jsonnet", "checkpoints")
+			for name, contents := range tc.changes {
+				if err := os.WriteFile(name, []byte(contents), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if evaluated := evaluateCheckpointedFile(t, "test.jsonnet", "checkpoints"); !reflect.DeepEqual(evaluated, tc.evaluated) {
+				t.Errorf("got evaluated fields %q, want %q", evaluated, tc.evaluated)
+			}
+		})
+	}
+}
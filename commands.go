@@ -0,0 +1,1010 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// sampleFile is a file used by an example.
+type sampleFile struct {
+	Name     string
+	Contents string
+}
+
+// example is a runnable example of a command.
+type example struct {
+	Description string
+	Files       []sampleFile
+	// Args are the arguments of the command, not including the program and command names. Args that run the
+	// program again name it like the program was run, with os.Args[0].
+	Args string
+}
+
+// exitCode is an exit status of a command and its meaning.
+type exitCode struct {
+	Code    int
+	Meaning string
+}
+
+// commandDoc documents a command.
+// It is the single source of the top level help text, the help for each command, and command examples.
+type commandDoc struct {
+	Name string
+	// Summary describes the command in the top level help text.
+	Summary string
+	// Usage are the synopses of the command, not including the program and command names.
+	Usage       []string
+	Description string
+	Examples    []example
+	// ExitCodes are the exit statuses of the command other than the defaults for success and errors.
+	ExitCodes []exitCode
+}
+
+// defaultExitCodes are the exit statuses shared by all commands.
+var defaultExitCodes = []exitCode{
+	{0, "success"},
+	{1, "an error occurred"},
+	{2, "invalid flags"},
+	{interruptedCode, "interrupted by SIGINT or SIGTERM"},
+}
+
+// limitsDescription describes the resource limit flags of the commands that evaluate files.
+const limitsDescription = `Evaluation fails with a stack overflow error after --max-stack nested stack frames, like function calls,
+and the stack traces of errors are cut to --max-trace frames. After --timeout, evaluation is stopped and the
+command exits with status 124, like timeout(1), rather than hanging until a CI job is killed. Output larger
+than --max-output-bytes is reported as an error instead of being written. With more than one <file>, the
+timeout applies to all of them together and the output limit to each of them.`
+
+// sampleJsonnet is a sample Jsonnet file shared by the examples of many commands.
+var sampleJsonnet = sampleFile{
+	Name: "example.jsonnet",
+	Contents: `local lib = import 'lib.libsonnet';
+{
+  greeting: lib.greet('world'),
+  labels: { app: 'example' },
+}
+`,
+}
+
+// sampleLibsonnet is a sample library imported by sampleJsonnet.
+var sampleLibsonnet = sampleFile{
+	Name: "lib.libsonnet",
+	Contents: `{
+  greet(name): 'Hello, %s!' % name,
+  unused: 'never evaluated',
+}
+`,
+}
+
+// commands documents every command, in the order they are listed in the top level help text.
+var commands = []commandDoc{
+	{
+		Name:    "astdiff",
+		Summary: "Report the semantic differences between two versions of a Jsonnet file",
+		Usage:   []string{"<old> <new>"},
+		Description: `Compares the ASTs of <old> and <new>, ignoring formatting, comments, parentheses, quoting, and the
+spelling of numbers, and reports each semantic edit with its location and path, like fields, locals, and
+parameters that were added or removed, changed field operators and default arguments, modified conditions,
+and changed values and expressions. Removals are located in <old> and other edits in <new>.
+Useful for reviewing vendored library updates where textual diffs are dominated by formatting.`,
+		Examples: []example{{
+			Description: "Compare two versions of a library",
+			Files: []sampleFile{
+				{Name: "old.libsonnet", Contents: `function(name, replicas=1) {
+  name: name,
+  replicas: replicas,
+  debug: false,
+}
+`},
+				{Name: "new.libsonnet", Contents: `function(name, replicas=3, labels={})
+  { name: name, replicas: replicas, labels:: labels }
+`},
+			},
+			Args: "old.libsonnet new.libsonnet",
+		}},
+		ExitCodes: []exitCode{{1, "the files differ or an error occurred"}},
+	},
+	{
+		Name:    "check",
+		Summary: "Check that each <file> parses and that its imports resolve, without evaluating it",
+		Usage:   []string{"<file>..."},
+		Description: `Parses each <file> and the files it imports, reporting syntax errors, static errors like undefined
+variables, imports that cannot be resolved against the import paths used by the eval command, and circular
+imports, in the same format as lint diagnostics. Nothing is evaluated, so checking is fast enough for a
+pre-commit hook even when evaluation is slow. <file> may be a glob pattern.`,
+		Examples: []example{{
+			Description: "Check a file with a missing import",
+			Files: []sampleFile{{Name: "broken.jsonnet", Contents: `local lib = import 'missing.libsonnet';
+{ value: lib.value + undefined }
+`}},
+			Args: "broken.jsonnet",
+		}},
+		ExitCodes: []exitCode{{1, "there are diagnostics or an error occurred"}},
+	},
+	{
+		Name:    "complete",
+		Summary: "List completion candidates at a position in a file as JSON",
+		Usage:   []string{"<file>:<line>:<column>"},
+		Description: `Lists what can be written at the position as a JSON array of candidates, each with a label, a kind,
+a signature for functions, and the location of its definition, for editors without a language server.
+Lines and columns start at one. After a '.', the candidates are the fields of the object being indexed,
+resolved through local variables, parameters with no known value excepted, merges, self, $, and imports,
+or the members of std. Otherwise, they are the local variables and parameters in scope, innermost first,
+then self, super, and $ within objects, and std. Only candidates that start with the partial identifier
+before the position are listed. Objects that are only known by evaluating, like the results of function
+calls, have no candidates.`,
+		Examples: []example{{
+			Description: "Complete the fields of an imported library",
+			Files:       []sampleFile{sampleJsonnet, sampleLibsonnet},
+			Args:        "example.jsonnet:3:17",
+		}},
+	},
+	{
+		Name:    "coverage",
+		Summary: "Report the local variables, object fields, and functions in <file> and its imports that are never evaluated",
+		Usage:   []string{"[--imported-only] <file>"},
+		Description: `Evaluates <file> with every local variable, object field, and function instrumented and reports,
+for each file, the number of definitions that were evaluated and the outermost definitions that were not.
+Definitions nested within an unevaluated definition are not listed.
+
+The .jsonnet and .libsonnet files in the current directory and the library paths, and their subdirectories
+other than hidden and vendor directories, that <file> never imports are reported with none of their definitions
+evaluated, so that unused files are not mistaken for covered ones. Test files, and files that cannot be parsed,
+are not reported. With --imported-only, only the files that <file> imports are reported.`,
+		Examples: []example{{
+			Description: "Find the unused definitions of a library",
+			Files:       []sampleFile{sampleJsonnet, sampleLibsonnet},
+			Args:        "example.jsonnet",
+		}},
+	},
+	{
+		Name:    "daemon",
+		Summary: "Keep parsed imports in memory to answer complete, definition, eval, imports, lint, and symbols quickly",
+		Usage:   []string{"[--addr <address>] [--max-stack <n>] [--max-trace <n>] [--timeout <duration>] [--max-output-bytes <n>]"},
+		Description: `Serves the project of the current directory to clients run with the --use-daemon global option, like
+editors that invoke jsonnet-tool for every keystroke or save. The complete, definition, eval, imports, lint,
+and symbols commands are run in the daemon, where the files found in the Jsonnet library paths, and the parsed ASTs and
+evaluated values of imported files, are kept between requests. Other commands are run by the client as usual.
+The file given to a command is parsed for each request so that editing it does not clear the caches. When any
+imported file changes, or an import fails, all of the caches are cleared before the next request, since
+go-jsonnet cannot forget a single file. Flags that would change the caches, like -J, are not supported by
+commands run in the daemon, nor is input from stdin.
+
+The ` + projectConfigFile + ` project configuration, with its lint rules, is reloaded like that of the serve
+command, and reloading it clears all of the caches, as does running jb install.
+
+By default, the daemon listens on the unix socket ` + daemonSocketName + ` in the current directory, which
+--use-daemon finds at or above the current directory of the client. Requests are run one at a time, so the
+limit flags apply to the evaluations of every request: an evaluation that takes longer than --timeout fails with
+exit code 124, like that of the eval command, and all of the caches are cleared. The daemon stops on SIGINT or
+SIGTERM.`,
+		Examples: []example{{
+			Description: "Serve clients run with --use-daemon=tcp://localhost:9000",
+			Args:        "--addr tcp://localhost:9000",
+		}},
+	},
+	{
+		Name:    "dataflow",
+		Summary: "Report which output paths of <file> are influenced by each external variable and top level argument",
+		Usage:   []string{"[--format table|dot] <file>"},
+		Description: `Statically traces each std.extVar reference in <file> and its imports, and each top level argument
+if <file> evaluates to a function, to the outermost output paths that their values can influence, whether
+directly or through conditions. The analysis is an approximation: self and $ refer to the object literal
+that contains them rather than the final merged object, array elements are not distinguished, and standard
+library functions are assumed to combine the influences of all of their arguments.
+The dot format relates inputs to their references and references to output paths.`,
+		Examples: []example{{
+			Description: "Find what an external variable controls",
+			Files: []sampleFile{{Name: "example.jsonnet", Contents: `local replicas = std.extVar('replicas');
+function(env='dev') {
+  deployment: { spec: { replicas: replicas } },
+  [if env == 'prod' then 'alerts']: {},
+  name: 'example-' + env,
+}
+`}},
+			Args: "example.jsonnet",
+		}},
+	},
+	{
+		Name:    "debug",
+		Summary: "Step through the evaluation of <file> with breakpoints and inspect the variables in scope",
+		Usage:   []string{"[-b <file>:<line>]... <file>"},
+		Description: `Reads debugger commands from stdin, one per line. Enter h for help with debugger commands.
+Every local variable, object field, and function body of <file> and its imports is instrumented so that
+evaluation can stop before it is evaluated: at a breakpoint set with -b or the b command on the line where
+it starts, or when stepping into, over, or out of the evaluations that it causes. When stopped, the variables
+in scope, self, super, and $ can be inspected, along with their fields and elements. As Jsonnet is lazy,
+inspecting a value evaluates it, and an inspection that fails ends the evaluation.
+Errors are reported without their stack traces because the instrumented files differ from the source files.`,
+		Examples: []example{{
+			Description: "Stop at a field of a library and inspect the arguments of the function that returns it",
+			Files:       []sampleFile{sampleJsonnet, sampleLibsonnet},
+			Args:        "-b lib.libsonnet:2 example.jsonnet <<'EOF'\nr\nl\np name\nbt\nc\nEOF",
+		}},
+	},
+	{
+		Name:    "definition",
+		Summary: "Print the definition of the variable, field, or import at a position in a file as JSON",
+		Usage:   []string{"<file>:<line>:<column>"},
+		Description: `Prints the completion candidate for the variable or field named by the identifier at the position, with
+the location of its definition, for editors without a language server. Lines and columns start at one.
+Definitions are resolved like the candidates of the complete command, so a variable is defined by the
+innermost local or parameter with its name, and a field by the last object in a merge that has it.
+At the path of an import, the location is the start of the imported file. Fails if the definition is not
+found or is not in a file, like the members of std.`,
+		Examples: []example{{
+			Description: "Go to the definition of a function in an imported library",
+			Files:       []sampleFile{sampleJsonnet, sampleLibsonnet},
+			Args:        "example.jsonnet:3:17",
+		}},
+	},
+	{
+		Name:    "dot",
+		Summary: "Produce a .dot diagram of the Jsonnet AST for <file>",
+		Usage:   []string{"[--follow-imports] [--at <line>:<column> [--focus]] <file>", "[--follow-imports] [--at <line>:<column> [--focus]] [--filename <name>] -"},
+		Description: `Parses <file> without desugaring and writes a Graphviz diagram of the AST to stdout.
+Comments and whitespace are not included.
+With --follow-imports, the ASTs of the files imported by <file> are included, transitively, each in a cluster
+labelled with its path, with a dashed edge from each import to the root of the imported AST.
+With --at, the chain of nodes from the root to the innermost node at the position in <file> is drawn in red,
+and the innermost node is filled. --focus omits the rest of the AST except the children of the nodes in the
+chain and the nodes within the innermost node, and only follows the imports that are drawn.`,
+		Examples: []example{{
+			Description: "Render the AST as an SVG image with Graphviz",
+			Files:       []sampleFile{{Name: "example.jsonnet", Contents: "{ a: 1 + 2 }\n"}},
+			Args:        "example.jsonnet | dot -Tsvg > example.svg",
+		}, {
+			Description: "Render the ASTs of an overlay and the library it extends",
+			Files: []sampleFile{
+				{Name: "base.libsonnet", Contents: "{ replicas: 1 }\n"},
+				{Name: "overlay.jsonnet", Contents: "(import 'base.libsonnet') + { replicas: 3 }\n"},
+			},
+			Args: "--follow-imports overlay.jsonnet | dot -Tsvg > overlay.svg",
+		}, {
+			Description: "Render the branch of the AST at a position",
+			Files:       []sampleFile{{Name: "example.jsonnet", Contents: "{ a: 1 + 2, b: [3, 4] }\n"}},
+			Args:        "--at 1:10 --focus example.jsonnet | dot -Tsvg > example.svg",
+		}},
+	},
+	{
+		Name:    "duplicates",
+		Summary: "Find object keys that are produced more than once in <file>, statically and by evaluation",
+		Usage:   []string{"<file>"},
+		Description: `Statically finds fields with the same name in an object and object comprehensions whose field name
+does not depend on the comprehension variables, unless the comprehension is over array literals with at
+most one element. If none are found, <file> is evaluated and the expressions that produce any duplicate key
+found at runtime are described on stderr with the evaluation error.`,
+		Examples: []example{{
+			Description: "Find a duplicate key produced by an object comprehension",
+			Files:       []sampleFile{{Name: "example.jsonnet", Contents: "{ ['key']: x for x in [1, 2] }\n"}},
+			Args:        "example.jsonnet",
+		}},
+		ExitCodes: []exitCode{{1, "duplicate keys were found or an error occurred"}},
+	},
+	{
+		Name:    "env",
+		Summary: "Export the import paths and external variables of the project configuration for other Jsonnet tools",
+		Usage:   []string{"export [--format direnv|nix]"},
+		Description: `Writes the environment that makes the jsonnet command and other tools resolve imports and external
+variables like jsonnet-tool does in the current directory, as a .envrc snippet for direnv or as a Nix
+attribute set of environment variables for mkShell or mkDerivation. JSONNET_PATH lists the lib and vendor
+directories of a jsonnet-bundler project and the jpath directories of the jsonnet-tool.json project
+configuration, highest precedence first. Each of the extVars of the project configuration is exported as
+an environment variable of the same name, which the jsonnet command reads with --ext-str <name>.`,
+		Examples: []example{{
+			Description: "Keep a direnv environment in sync with the project configuration",
+			Files: []sampleFile{{Name: "jsonnet-tool.json", Contents: `{
+  "jpath": ["lib"],
+  "extVars": { "cluster": "dev" }
+}
+`}},
+			Args: "export > .envrc && cat .envrc",
+		}},
+	},
+	{
+		Name:    "eval",
+		Summary: "Evaluate Jsonnet using the jsonnet-tool interpreter",
+		Usage:   []string{"[--recursion-report] [--vm-stats] [--source-map <out.map>] [--checkpoint-dir <dir>] [--manifest-yaml-as-json] [--allow-env] [--trace-format text|json] [--trace-out <file>] [--validate <schema.json>] [--openapi <swagger.json>] [--max-stack <n>] [--max-trace <n>] [--timeout <duration>] [--max-output-bytes <n>] <file>", "[<flags>] [--filename <name>] -", "[<flags>] --bundle-dir <dir> [--recursive] [--bundle-mode object|merge|array]", "[<flags>] [-j <n>] [-m <dir>] [--suffix <ext>] [--vm-cache-size <n>] <file>..."},
+		Description: `Evaluates <file> and writes the JSON output to stdout. The Tanka native functions are available, along with
+manifestYamlStream, and manifestYamlFromJson produces YAML with sorted keys unless --manifest-yaml-as-json
+or the manifestYamlAsJson project configuration is set. The env native function reads environment
+variables only with --allow-env, or the allowEnv project configuration with --trust-project-config, so that
+evaluation is hermetic by default.
+Imports are resolved relative to the importing file, then the JSONNET_PATH directories, then the vendor
+and lib directories of a jsonnet-bundler project containing the current directory.
+Imported files are transformed by any matching import hooks of the jsonnet-tool.json project configuration,
+and hooks that run commands are an error without --trust-project-config.
+Reports are written to stderr, whether or not evaluation succeeds.
+std.trace messages are written to stderr, or the --trace-out file, as text or, with --trace-format json,
+as JSON records, one per line, with the message and the file and line of the std.trace call.
+Output larger than --terminal-limit bytes is piped through $PAGER when stdout is a terminal, or truncated
+if $PAGER is unset, unless --full is given. Output written to a pipe or file is never paged or truncated.
+If <file> is -, the snippet is read from stdin and imports are resolved relative to --filename.
+
+With --bundle-dir, the .jsonnet and .libsonnet files in <dir> are imported and combined instead of evaluating
+a <file>, replacing a hand-maintained index file. With --recursive, the files in subdirectories are included,
+except for hidden and vendor directories. Test files are never included. With --bundle-mode object, the
+default, each file is a field named by its path relative to <dir> without the extension. With merge, the
+files are merged in lexical order with +, and with array, they are the elements of an array in lexical order.
+
+With more than one <file>, -m, or --suffix, the files are evaluated in parallel by -j workers, each with its
+own VM. <file> may be a glob pattern. The output of each file is written next to it, or to the same path within
+the -m directory, with its extension replaced by --suffix, and the paths written are listed on stdout.
+Errors are reported after every file has been evaluated. --progress reports progress, as for the test command.
+
+With --source-map, the range of each value of the output is related to the object field that defines it, or to
+that of its closest ancestor, and identified by its path, like $.metadata.labels["app.kubernetes.io/name"].
+Field names that are not identifiers are quoted so that they cannot be mistaken for nested fields.
+
+With --checkpoint-dir, a file that evaluates to an object literal is evaluated one top level field at a time
+and each field is cached in <dir>, keyed by a hash of the source of the field, the local variables it can
+refer to, the files it can import, the import hooks and search directories, the external variables, and
+--manifest-yaml-as-json. Only the fields whose key has changed are evaluated again. Fields that refer to
+self, super, or $ are keyed by the whole file. Checkpoints are not used with --allow-env because the
+environment variables that evaluation reads are not known in advance.
+
+With --vm-stats, the evaluation time, the number of imports and how many were served from the import caches
+of the VMs, the most frequently imported files, and the heap and garbage collection statistics of the Go
+runtime are reported on stderr. A VM evaluates each imported file once and caches its value, so every other
+import of the file is a hit. When evaluating more than one <file>, each VM keeps its cache for the next file it
+evaluates, which is fastest when the files share imports but holds the values of all of them in memory.
+--vm-cache-size clears the cache of a VM before its next file once it holds more than <n> files, trading speed
+for memory; the statistics report how many times caches were cleared and the largest cache. go-jsonnet has no
+hooks into the caches of values within a file, so they are neither reported nor sized.
+
+With --validate or --openapi, the output is checked as by the validate command and the violations are
+reported on stderr instead of writing the output.
+
+` + limitsDescription,
+		Examples: []example{
+			{
+				Description: "Evaluate a file",
+				Files:       []sampleFile{sampleJsonnet, sampleLibsonnet},
+				Args:        "example.jsonnet",
+			},
+			{
+				Description: "Find which source fields produced the output",
+				Files:       []sampleFile{sampleJsonnet, sampleLibsonnet},
+				Args:        "--source-map example.map example.jsonnet && cat example.map",
+			},
+			{
+				Description: "Evaluate again reusing unchanged fields",
+				Files:       []sampleFile{sampleJsonnet, sampleLibsonnet},
+				Args:        "--checkpoint-dir .checkpoints example.jsonnet && " + os.Args[0] + " eval --checkpoint-dir .checkpoints example.jsonnet",
+			},
+			{
+				Description: "Evaluate a snippet from stdin that imports a library",
+				Files:       []sampleFile{sampleLibsonnet},
+				Args:        "- <<'EOF'\n(import 'lib.libsonnet').greet('stdin')\nEOF",
+			},
+			{
+				Description: "Evaluate every file in a directory as an object keyed by filename",
+				Files: []sampleFile{
+					{Name: "dashboards/cpu.jsonnet", Contents: "{ title: 'CPU', panels: 4 }\n"},
+					{Name: "dashboards/memory.jsonnet", Contents: "{ title: 'Memory', panels: 2 }\n"},
+				},
+				Args: "--bundle-dir dashboards",
+			},
+			{
+				Description: "Evaluate many files in parallel, writing their outputs to a directory",
+				Files: []sampleFile{
+					{Name: "environments/dev/main.jsonnet", Contents: "{ replicas: 1 }\n"},
+					{Name: "environments/prod/main.jsonnet", Contents: "{ replicas: 3 }\n"},
+				},
+				Args: "-m rendered 'environments/*/main.jsonnet'",
+			},
+			{
+				Description: "Fail quickly on runaway recursion",
+				Files:       []sampleFile{{Name: "loop.jsonnet", Contents: "local f(n) = f(n + 1) + 1; f(0)\n"}},
+				Args:        "--max-stack 100 --max-trace 4 --timeout 10s loop.jsonnet",
+			},
+		},
+		ExitCodes: []exitCode{{timedOutCode, "evaluation exceeded --timeout"}},
+	},
+	{
+		Name:        "examples",
+		Summary:     "Print runnable examples for <command>, with sample files",
+		Usage:       []string{"[<command>]"},
+		Description: `Prints shell commands that create sample files and run <command> on them. Without <command>, prints the examples of every command.`,
+		Examples: []example{{
+			Description: "Run the examples of the eval command, creating the sample files in the current directory",
+			Args:        "eval | sh",
+		}},
+	},
+	{
+		Name:        "expand",
+		Summary:     "Produce an expanded Jsonnet representation",
+		Usage:       []string{"<file>"},
+		Description: `Parses <file>. Expansion of the parsed file is not yet implemented.`,
+	},
+	{
+		Name:    "explore",
+		Summary: "Explore the AST, evaluation, and layers of <file> in the terminal",
+		Usage:   []string{"[--max-stack <n>] [--max-trace <n>] [--timeout <duration>] [--max-output-bytes <n>] <file>"},
+		Description: `Shows a collapsible tree of the AST of <file>, or of its evaluated value, beside a pane with the
+source of the selected node or the layers of the object merges of <file>, as written by the layers command.
+The nodes of the value tree are located at the object fields that define them, as in the source map of eval
+--source-map, so that s jumps from a value to its definition in the AST tree. The keys are listed at the
+bottom of the screen:
+
+  ↑ ↓ j k, PgUp PgDn    move the selection
+  → l, ← h, Enter       expand, collapse, or toggle the selected node; * expands every node within it
+  Tab                   switch between the AST and value trees
+  s                     select the AST node at the location of the selected node
+  L                     switch the pane between the source and the layers
+  [ ]                   show the previous or next layer; m selects the AST node of its merge
+  J K                   scroll the pane
+  o                     open the location of the selected node in $VISUAL or $EDITOR
+  q                     quit
+
+stdin and stdout must be a terminal, with stty available. The limit flags apply to the evaluation and the
+layers as for the eval command, and an evaluation that fails is shown as the error in the value tree.`,
+	},
+	{
+		Name:    "extract",
+		Summary: "Bind the expression at a range in a file to a local variable",
+		Usage:   []string{"[--all] [-w] <file>:<line>:<column>-<line>:<column> <name>"},
+		Description: `Replaces the smallest expression that contains the range with the variable <name>, bound by a
+local name = ...; at the nearest enclosing scope: the body of a local or function, the value of a field, or the
+file. The end of the range is the position after its last character, like an editor selection. With --all,
+the expressions that are the same apart from whitespace and comments are replaced too, and the local is added
+at the nearest scope that encloses all of them. Expressions that refer to other variables, or to other objects
+with self, super, or $, are not replaced, and the local is never added outside the scope of the variables and
+objects that the expression refers to. The file is written formatted like jsonnetfmt.`,
+		Examples: []example{{
+			Description: "Deduplicate the labels of a deployment and service",
+			Files: []sampleFile{{Name: "example.jsonnet", Contents: `{
+  deployment: { metadata: { labels: { app: 'example' } } },
+  service: { metadata: { labels: { app: 'example' } } },
+}
+`}},
+			Args: "--all example.jsonnet:2:37-2:55 labels",
+		}},
+	},
+	{
+		Name:    "import-usage",
+		Summary: "Report which imports of <file> are evaluated and the time spent evaluating each imported file",
+		Usage:   []string{"<file>"},
+		Description: `Evaluates <file> with instrumentation and writes a JSON array describing each transitive import:
+whether it was evaluated, how many import expressions for it were evaluated, and the time spent evaluating
+expressions in the file excluding other files.`,
+		Examples: []example{{
+			Description: "Report import usage",
+			Files:       []sampleFile{sampleJsonnet, sampleLibsonnet},
+			Args:        "example.jsonnet",
+		}},
+	},
+	{
+		Name:    "hover",
+		Summary: "Print the value of the expression at a position in a file",
+		Usage:   []string{"[--eval <file>] [--shape] <file>:<line>:<column>"},
+		Description: `Finds the smallest expression at the position that can be evaluated on its own and prints its value,
+as JSON with functions described as "<function>", the first time that it is evaluated in context, with the local
+variables, parameters, and self of that evaluation. With --shape, a summary of the type and shape of the value
+is printed instead, like the \t command of the REPL. Lines and columns start at one.
+
+<file> is evaluated, or the --eval file that imports it, like an environment that uses a library. Expressions
+that are never evaluated, like the bodies of functions that are never called, have no value.`,
+		Examples: []example{{
+			Description: "Print the value of a function argument",
+			Files:       []sampleFile{sampleJsonnet, sampleLibsonnet},
+			Args:        "--eval example.jsonnet lib.libsonnet:2:33",
+		}},
+	},
+	{
+		Name:    "imports",
+		Summary: "List the imports for <file>, or produce a Makefile (or Ninja) dependency rule for <file>",
+		Usage:   []string{"<file>", "--format make [--target <target>] <file>", "[<flags>] [--filename <name>] -"},
+		Description: `Writes the transitive imports of <file> as a JSON array, or as a Make and Ninja compatible
+dependency rule with a phony rule for each import so that deleted imports do not break the build.`,
+		Examples: []example{{
+			Description: "Produce a dependency rule for the evaluated output",
+			Files:       []sampleFile{sampleJsonnet, sampleLibsonnet},
+			Args:        "--format make --target example.json example.jsonnet",
+		}},
+	},
+	{
+		Name:    "inline",
+		Summary: "Replace an import of <file> with the contents of the imported file",
+		Usage:   []string{"--import <path> [-w] <file>"},
+		Description: `Replaces every import of <path> in <file> with the contents of the imported file, in parentheses, and
+writes the file formatted like jsonnetfmt, keeping the comments of both files. <path> is matched with the
+imports as written, or as a file path relative to the current directory. If no import matches, <path> can be
+the name of a local variable bound to an import, to inline only that import. importstr and importbin imports
+are replaced with a string and an array of bytes.
+
+The result is standalone Jsonnet that evaluates like <file>: relative imports of the inlined file are rewritten
+to resolve from <file>, and an inlined file that refers to $ is bound to a local variable at the top of <file>
+if it is imported within an object, where $ would refer to the enclosing object instead. Unlike expand, only
+the chosen import is inlined, which is useful to produce self-contained reproductions.`,
+		Examples: []example{{
+			Description: "Inline a library",
+			Files:       []sampleFile{sampleJsonnet, sampleLibsonnet},
+			Args:        "--import lib.libsonnet example.jsonnet",
+		}},
+	},
+	{
+		Name:    "layers",
+		Summary: "Produce a JSON array of the layers of object evaluations for <file>",
+		Usage:   []string{"[-m <dir>] [<limits>] <file>", "[-m <dir>] [<limits>] [--filename <name>] -"},
+		Description: `Evaluates each operand of the object merges in <file> and writes the intermediate states
+of the merged object as a JSON array, outermost first.
+With -m, each layer is instead written to its own file in <dir>, named by its position and the file and line of
+the merge, like 03_envs-prod.libsonnet_L42.json, for comparing layers in an editor or diff tool, with an
+index.json that lists the files in order with their locations. The paths of the written files are printed.
+Object merges are the + operator, the a { b: c } syntax, std.mergePatch calls, and merges over an array
+literal of overlays by std.foldl or an object comprehension, which are peeled apart one overlay at a time.
+
+The <limits> are --max-stack, --max-trace, --timeout, and --max-output-bytes, as for the eval command, with
+--max-output-bytes limiting the size of the JSON array of layers.`,
+		ExitCodes: []exitCode{{timedOutCode, "evaluation exceeded --timeout"}},
+		Examples: []example{{
+			Description: "Show the layers of a merge",
+			Files:       []sampleFile{{Name: "example.jsonnet", Contents: "{ a: 1 } + { b: 2 } + { a: 3 }\n"}},
+			Args:        "example.jsonnet",
+		}, {
+			Description: "Compare the first and second layers of a merge",
+			Files:       []sampleFile{{Name: "example.jsonnet", Contents: "{ a: 1 } + { b: 2 } + { a: 3 }\n"}},
+			Args:        "-m layers example.jsonnet && diff layers/01_*.json layers/00_*.json",
+		}, {
+			Description: "Show the layers of a fold over a list of overlays",
+			Files: []sampleFile{{Name: "overlays.jsonnet", Contents: `std.foldl(function(acc, overlay) acc + overlay, [
+  { replicas: 3 },
+  { image: 'app:v2' },
+], { replicas: 1, image: 'app:v1' })
+`}},
+			Args: "overlays.jsonnet",
+		}},
+	},
+	{
+		Name:    "lint",
+		Summary: "Lint <file> with AST level checks, exiting non-zero if there are any diagnostics",
+		Usage:   []string{"[--enable <rules>] [--disable <rules>] [--config <file>] [--fix] [--report <file> [--previous <file>]] [--progress auto|tty|json|none] <file>|<dir>...", "--list"},
+		Description: `Checks each <file> with the lint rules listed by --list and writes diagnostics in the same format as
+go-jsonnet static errors. Rules are all enabled unless disabled by flags or a JSON --config file
+like {"rules": {"bare-error": false}}, or by the lint object of the ` + projectConfigFile + ` project configuration
+if there is no --config file. Flags take precedence over the configuration.
+With --fix, fixable diagnostics are fixed in place and the remaining diagnostics are reported.
+Each <dir> is searched for .jsonnet and .libsonnet files, skipping hidden and vendor directories.
+--report and --previous write a local report of the results, and --progress reports progress, as for the test command.`,
+		Examples: []example{{
+			Description: "Lint a file without the string concatenation rule",
+			Files: []sampleFile{{Name: "example.jsonnet", Contents: `local unused = 1;
+local name = 'world';
+{ greeting: 'Hello, ' + name + '!' }
+`}},
+			Args: "--disable string-concatenation example.jsonnet",
+		}},
+		ExitCodes: []exitCode{{1, "there are diagnostics or an error occurred"}},
+	},
+	{
+		Name:    "params",
+		Summary: "Describe the parameters of the function that <file> evaluates to",
+		Usage:   []string{"<file>", "[--filename <name>] -"},
+		Description: `Writes the parameters of the function that <file> evaluates to as JSON, so that wrappers and
+user interfaces can generate forms or flags for parameterized libraries. Each parameter has its name,
+whether it is required, its default argument, and its doc comment. Default arguments are unparsed as
+Jsonnet source, and those that are literals are also evaluated to their JSON value. Required parameters are
+the top level arguments that must be given to evaluate an entrypoint, like with jsonnet --tla-str.
+Comments before a parameter, or at the end of its line, are its doc comment, and comments before the
+function, or before the local variable that it is bound to, are the doc comment of the function.
+<file> must evaluate to a function literal, optionally through local variables.`,
+		Examples: []example{{
+			Description: "Describe the parameters of a library function",
+			Files: []sampleFile{{Name: "dashboard.libsonnet", Contents: `// Creates a dashboard.
+function(
+  title,  // The title of the dashboard.
+  // The refresh interval.
+  refresh='1m',
+  panels=[],
+  uid=std.md5(title),
+) { title: title, refresh: refresh, panels: panels, uid: uid }
+`}},
+			Args: "dashboard.libsonnet",
+		}},
+	},
+	{
+		Name:    "repl",
+		Summary: "Run a Jsonnet REPL",
+		Usage:   []string{"[--allow-env] [--color auto|always|never] [--indent <n>] [--page-lines <n>] [--trace-format text|json] [--trace-out <file>] [--workspace <file>|none] [--listen <address>] [--max-stack <n>] [--max-trace <n>] [--timeout <duration>] [--max-output-bytes <n>] [--as <name>] [<file>]"},
+		Description: `Reads Jsonnet expressions and REPL commands terminated by ';;' from stdin and writes
+their evaluations to stdout. Enter \h for help with REPL commands.
+
+With <file>, the session starts with local it = import '<file>' in namespace 0, or the local variable named
+by --as, and with the directory of <file> added to the Jpaths, with the highest precedence, so that the
+libraries that <file> imports can be imported by name too.
+
+--max-stack and --max-trace limit evaluations as for the eval command. An evaluation that takes longer than
+--timeout, or whose result is larger than --max-output-bytes, fails with an error and the session continues.
+After a timeout, the imports cached by the session are evaluated again because the evaluation cannot be stopped.
+
+Evaluations are indented by --indent spaces and, when stdout is a terminal and NO_COLOR is unset, syntax
+highlighted. Evaluations taller than the terminal, or than --page-lines, are piped through $PAGER if it is
+set and stdout is a terminal, and are otherwise truncated, with the rest shown a page at a time by \more.
+std.trace messages are written like those of the eval command, according to --trace-format and --trace-out.
+
+The session is restored from and saved to the workspace file given by --workspace or, by default, the
+closest ` + workspaceFileName + ` file at or above the current directory. The workspace keeps the namespaces,
+external variables, and recent evaluations of the session, along with state of other tools, like watched files.
+Create an empty workspace file in a project to start saving sessions.
+
+With --listen, the REPL instead serves sessions to clients, like editors evaluating regions of a file, that
+connect to a Unix socket, given as unix:///path/to/socket, or a TCP address, given as tcp://host:port or
+host:port. Each client sends expressions and commands terminated by ';;' as on stdin and receives the help,
+then each evaluation followed by the prompt, which includes the namespace of the session. All sessions share
+one VM, so imports stay cached between expressions, and are evaluated one at a time. A session starts in a
+namespace of its own and can switch to the namespace of another session with \n. \q closes the session.
+The server stops on SIGINT or SIGTERM.`,
+		Examples: []example{{
+			Description: "Evaluate expressions from a script",
+			Args:        "<<'EOF'\n\\v local greeting = 'Hello';;\ngreeting + ', world!';;\n\\q;;\nEOF",
+		}, {
+			Description: "Explore an environment",
+			Files:       []sampleFile{sampleJsonnet, sampleLibsonnet},
+			Args:        "--workspace none example.jsonnet <<'EOF'\nit.greeting;;\n\\q;;\nEOF",
+		}},
+	},
+	{
+		Name:    "schema",
+		Summary: "Infer a JSON Schema of the evaluation of <file>",
+		Usage:   []string{"<file>", "[--filename <name>] -"},
+		Description: `Evaluates <file> and writes a JSON Schema describing the output document, for consumers of the rendered
+configuration to validate against. Every field of an object is required, and the elements of an array are
+described by one schema, in which fields are only required if every element has them.
+Fields defined by conditionals whose branches are all literals, like if env == 'prod' then 'large' else 'small',
+are described by an enum of those values, unless a later merge redefines them.`,
+		Examples: []example{{
+			Description: "Infer a schema",
+			Files: []sampleFile{{Name: "example.jsonnet", Contents: `local env = 'prod';
+{
+  name: 'example',
+  size: if env == 'prod' then 'large' else 'small',
+  replicas: 3,
+  ports: [{ port: 80, name: 'http' }, { port: 443 }],
+}
+`}},
+			Args: "example.jsonnet",
+		}},
+	},
+	{
+		Name:    "scrub",
+		Summary: "Replace secrets, hostnames, and IP addresses in the string literals of <file> with placeholders",
+		Usage:   []string{"[--keep <regexp>] [--evaluate] [-w] <file>"},
+		Description: `Rewrites the string literals of <file>, including quoted field names, replacing private keys,
+API tokens, high entropy strings, email addresses, IP addresses, and hostnames with placeholders so that
+the file can be shared without disclosing them. String values of fields and local variables with names
+like password, secret, token, or key are replaced entirely. The same value is always replaced with the
+same placeholder, so references between values are preserved. Hostnames matching the --keep regular
+expression are not replaced. Comments are not scrubbed. With --evaluate, <file> is evaluated and its JSON
+output is scrubbed instead. A summary of the replacements is written to stderr.`,
+		Examples: []example{{
+			Description: "Scrub a configuration file",
+			Files: []sampleFile{{Name: "example.jsonnet", Contents: `local host = 'db.prod.acme.com';
+{
+  url: 'postgres://admin:hunter2@%s:5432/app' % host,
+  password: 'hunter2',
+  backup: { host: host, ip: '10.1.2.3' },
+}
+`}},
+			Args: "example.jsonnet",
+		}},
+	},
+	{
+		Name:    "serve",
+		Summary: "Serve Jsonnet evaluations over HTTP",
+		Usage:   []string{"[--addr <address>] [--root <dir>] [-J <dir>]... [--timeout <duration>] [--max-concurrent <n>] [--allow-env]"},
+		Description: `Serves evaluations of Jsonnet snippets and files to HTTP clients, like dashboards and configuration
+previews, without starting a process per evaluation. POST a JSON object to /eval with either a "snippet" of
+Jsonnet source or the "file" path of a file within --root, and optionally "extVars", an object of string
+external variables. The response is the JSON evaluation.
+
+Errors are responded to with a JSON object like those written with --error-format json: status 400 for invalid
+requests, 404 for missing files, 422 for Jsonnet errors, and 503 for evaluations that take longer than
+--timeout or that wait longer than --timeout for one of the --max-concurrent evaluation slots. An evaluation
+that times out keeps its slot until it finishes. Imports are resolved like those of the eval command, with
+the -J directories taking precedence, and files outside of --root and the library paths cannot be imported,
+even through symbolic links. The server listens on localhost:8080 unless --addr is given.
+
+The ` + projectConfigFile + ` project configuration and the jsonnet-bundler jsonnetfile.json and
+jsonnetfile.lock.json are checked before each request and reloaded if they have been created, changed, or
+removed. They are also reloaded as soon as the server receives SIGHUP. Evaluations in progress finish with the
+configuration that they started with, and a configuration that cannot be loaded is reported and ignored until it
+changes again. Flags, like -J and --allow-env, apply to every configuration. The server stops on SIGINT or
+SIGTERM.`,
+		Examples: []example{{
+			Description: "Serve evaluations of the files in the current directory to other hosts on port 8080",
+			Args:        "--addr :8080",
+		}},
+	},
+	{
+		Name:    "shrink",
+		Summary: "Reduce <file> and its imports to the smallest files that fail to evaluate with the same error",
+		Usage:   []string{"[--dir <dir>] <file>"},
+		Description: `Evaluates <file>, which must fail, and repeatedly removes object fields, array elements, local variables,
+and function arguments, and replaces expressions with their subexpressions or null, keeping each change
+that still fails with the same error message. Imported files are reduced too. The reduced files that are
+still imported are written to <dir> at their paths relative to the current directory and listed on stdout.
+This is useful to produce a minimal reproduction of a bug in go-jsonnet or a deep library failure.
+Comments are not removed.`,
+		Examples: []example{{
+			Description: "Reduce a failing file",
+			Files: []sampleFile{{Name: "example.jsonnet", Contents: `local port(p) = if p > 1000 then p else error 'port too low: %d' % p;
+{
+  name: 'example',
+  ports: [port(8080), port(80)],
+}
+`}},
+			Args: "example.jsonnet && cat shrunk/example.jsonnet",
+		}},
+	},
+	{
+		Name:    "sort-fields",
+		Summary: "Sort object fields in <file> so that the fields named by --order come first",
+		Usage:   []string{"[--order apiVersion,kind,metadata,spec] [-w] <file>..."},
+		Description: `Reorders the fields of every object so that the fields named by --order come first, in that order,
+followed by the remaining fields in their original order. Comments move with their fields.`,
+		Examples: []example{{
+			Description: "Sort a Kubernetes manifest",
+			Files: []sampleFile{{Name: "example.jsonnet", Contents: `{
+  spec: { replicas: 1 },
+  kind: 'Deployment',
+  metadata: { name: 'example' },
+  apiVersion: 'apps/v1',
+}
+`}},
+			Args: "example.jsonnet",
+		}},
+	},
+	{
+		Name:    "symbols",
+		Summary: "List the referenceable symbols in <file>",
+		Usage:   []string{"[--position-encoding rune|byte|utf-16] <file>", "[--position-encoding rune|byte|utf-16] [--filename <name>] -"},
+		Description: `Writes the local variables and object fields of <file> as a JSON array with the location
+of their definitions and the path used to reference them.
+
+Lines and columns start at one. Columns count Unicode characters, like go-jsonnet errors, unless
+--position-encoding is byte, to count the bytes of the UTF-8 source, or utf-16, to count UTF-16 code units
+like Language Server Protocol clients and many editors do. The encodings only differ on lines with
+multibyte characters.`,
+		Examples: []example{{
+			Description: "List symbols",
+			Files:       []sampleFile{sampleJsonnet, sampleLibsonnet},
+			Args:        "example.jsonnet",
+		}},
+	},
+	{
+		Name:    "test",
+		Summary: "Run the *_test.jsonnet test files in <dir> and its subdirectories",
+		Usage:   []string{"[--update] [-v] [--allow-env] [--report <file> [--previous <file>]] [--progress auto|tty|json|none] [<dir>]"},
+		Description: `Evaluates each *_test.jsonnet file in <dir>, which defaults to the current directory, skipping hidden
+and vendor directories. A test file evaluates to an object of test names to tests. A test is either a
+boolean assertion or an object with an actual field and either an expected field or a golden field with
+the path, relative to the test file, of a JSON file containing the expected value.
+Failures are reported with the location of the test in the test file.
+With --report, the per-file results and timings are written to a local JSON report, or an HTML report if
+the file ends in .html, compared with the JSON report of an earlier run given by --previous.
+Progress is shown on stderr as a status line with the number of files done, the current file, and the
+estimated time remaining when stderr is a terminal. With --progress json, progress is instead written to
+stderr as JSON line events when each file starts and finishes and when every file is done, for CI systems.`,
+		Examples: []example{{
+			Description: "Run tests, creating any missing golden files",
+			Files: []sampleFile{{Name: "example_test.jsonnet", Contents: `local lib = { double(x): x * 2 };
+{
+  double_is_even: lib.double(3) % 2 == 0,
+  double: { actual: lib.double(2), expected: 4 },
+  golden: { actual: { doubled: std.map(lib.double, [1, 2]) }, golden: 'testdata/golden.json' },
+}
+`}},
+			Args: "--update -v . && " + os.Args[0] + " test",
+		}},
+		ExitCodes: []exitCode{{1, "a test failed or an error occurred"}},
+	},
+	{
+		Name:    "transform",
+		Summary: "Rewrite <file> with composable canonicalization passes",
+		Usage:   []string{"[--sort-fields] [--strip-comments] [--quotes single|double|leave] [--minify] [-w] <file>..."},
+		Description: `Reformats <file> like jsonnetfmt after applying the chosen passes. --sort-fields sorts the statically
+named fields of every object alphabetically, moving comments with their fields. --strip-comments removes
+all comments. --quotes rewrites string literals in the quote style, unless that would need more escapes.
+--minify writes the shortest equivalent source the formatter can produce, on one line without comments,
+indentation, or trailing commas, though text blocks keep their lines. Passes compose, so
+--sort-fields --minify gives a canonical form for comparing files.`,
+		Examples: []example{{
+			Description: "Sort fields and minify",
+			Files: []sampleFile{{Name: "example.jsonnet", Contents: `// The service.
+{
+  name: "example",
+  ports: [80, 443],  // HTTP and HTTPS.
+  enabled: true,
+}
+`}},
+			Args: "--sort-fields --minify example.jsonnet",
+		}},
+	},
+	{
+		Name:    "upgrade-check",
+		Summary: "Report the API changes of a candidate version of a vendored library and the call sites they would break",
+		Usage:   []string{"[--lock <file>] <dependency> <candidate>"},
+		Description: `Compares the API of the version of <dependency> vendored by the jsonnet-bundler lock file with the
+candidate version in the directory <candidate>, like a checkout of the library at the new version, before the
+dependency is updated. <dependency> is the path of the dependency in the vendor directory, its legacy name, or
+its git remote. The API of a library is the fields of the objects written in each of its files, and the parameters
+of its functions. The API changes are written first, followed by a diagnostic for each reference in the
+Jsonnet files of the project to a file or field of the library that would be removed and each call whose
+arguments would no longer match the parameters. References through local variables bound to an import of a
+library file are followed, but references through other variables are not.`,
+		Examples: []example{{
+			Description: "Check a library update that adds a required parameter",
+			Files: []sampleFile{
+				{Name: "jsonnetfile.lock.json", Contents: `{
+  "version": 1,
+  "dependencies": [
+    { "source": { "git": { "remote": "https://github.com/example/libs.git", "subdir": "app" } }, "version": "v1" }
+  ]
+}
+`},
+				{Name: "vendor/github.com/example/libs/app/app.libsonnet", Contents: "{ new(name, replicas=1):: { name: name, replicas: replicas } }\n"},
+				{Name: "candidate/app.libsonnet", Contents: "{ new(name, image, replicas=1):: { name: name, image: image, replicas: replicas } }\n"},
+				{Name: "main.jsonnet", Contents: "local app = import 'github.com/example/libs/app/app.libsonnet';\napp.new('web')\n"},
+			},
+			Args: "github.com/example/libs/app candidate",
+		}},
+		ExitCodes: []exitCode{{1, "call sites would break or an error occurred"}},
+	},
+	{
+		Name:    "validate",
+		Summary: "Check the evaluation of each <file> against a JSON Schema or Kubernetes OpenAPI definitions",
+		Usage:   []string{"[--schema <schema.json>] [--openapi <swagger.json>] <file>..."},
+		Description: `Evaluates each <file> and writes a line for each value of the output that violates the JSON Schema of
+--schema, with the source location of the field that defines the value, or of its closest ancestor whose
+definition can be found statically as for eval --source-map.
+With --openapi, every Kubernetes object in the output, which is an object with a string apiVersion and kind,
+is checked against the definition of its kind in the Kubernetes OpenAPI document, like the swagger.json of a
+cluster's API server. Unlike JSON Schema, fields that are not in the definition of an object are violations.
+The type, enum, const, properties, required, additionalProperties, items, prefixItems, length, range, pattern,
+allOf, anyOf, oneOf, and not keywords and references within the schema are supported. Other keywords are ignored.`,
+		Examples: []example{{
+			Description: "Validate a file against a JSON Schema",
+			Files: []sampleFile{
+				{Name: "example.jsonnet", Contents: "{\n  name: 'example',\n  replicas: '3',\n}\n"},
+				{Name: "schema.json", Contents: `{
+  "type": "object",
+  "properties": { "name": { "type": "string" }, "replicas": { "type": "integer", "minimum": 1 } },
+  "required": ["name", "replicas"]
+}
+`},
+			},
+			Args: "--schema schema.json example.jsonnet",
+		}},
+		ExitCodes: []exitCode{{1, "there are violations or an error occurred"}},
+	},
+}
+
+// findCommand returns the documentation of the named command.
+func findCommand(name string) (commandDoc, bool) {
+	for _, doc := range commands {
+		if doc.Name == name {
+			return doc, true
+		}
+	}
+	return commandDoc{}, false
+}
+
+// help writes help text.
+// If no writer is provided, it writes to stderr.
+func help(w io.Writer) {
+	if w == nil {
+		w = os.Stderr
+	}
+	fmt.Fprintln(w, "A tool for working with Jsonnet files.")
+	for _, doc := range commands {
+		fmt.Fprintf(w, "\n%s:\n", doc.Summary)
+		for _, usage := range doc.usage() {
+			fmt.Fprintf(w, "  $ %s %s\n", os.Args[0], usage)
+		}
+	}
+	fmt.Fprintf(w, "\nGlobal options, which can be given before or after <command>:\n%s", globalUsage)
+	fmt.Fprintf(w, "\nFor detailed help with a command:\n  $ %s <command> --help\n", os.Args[0])
+}
+
+// usage returns the synopses of the command.
+func (doc commandDoc) usage() []string {
+	if len(doc.Usage) == 0 {
+		return []string{doc.Name}
+	}
+	usage := make([]string, len(doc.Usage))
+	for i, u := range doc.Usage {
+		usage[i] = doc.Name + " " + u
+	}
+	return usage
+}
+
+// indent indents every non-empty line of s.
+func indent(s, prefix string) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = prefix + line
+		}
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// commandHelp writes detailed help for the command in the style of a man page.
+// The options are described by flags, which may be nil if the command has none.
+func commandHelp(w io.Writer, doc commandDoc, flags *flag.FlagSet) {
+	fmt.Fprintf(w, "NAME\n  %s %s - %s\n", os.Args[0], doc.Name, doc.Summary)
+	fmt.Fprintln(w, "\nSYNOPSIS")
+	for _, usage := range doc.usage() {
+		fmt.Fprintf(w, "  %s %s\n", os.Args[0], usage)
+	}
+	fmt.Fprintf(w, "\nDESCRIPTION\n%s", indent(doc.Description, "  "))
+	hasFlags := false
+	if flags != nil {
+		flags.VisitAll(func(*flag.Flag) { hasFlags = true })
+	}
+	if hasFlags {
+		fmt.Fprintln(w, "\nOPTIONS")
+		output := flags.Output()
+		flags.SetOutput(w)
+		flags.PrintDefaults()
+		flags.SetOutput(output)
+	}
+	fmt.Fprintf(w, "\nGLOBAL OPTIONS\n%s", globalUsage)
+	if len(doc.Examples) > 0 {
+		fmt.Fprintln(w, "\nEXAMPLES")
+		for _, ex := range doc.Examples {
+			fmt.Fprintf(w, "  %s:\n    $ %s %s %s\n", ex.Description, os.Args[0], doc.Name, strings.SplitN(ex.Args, "\n", 2)[0])
+		}
+		fmt.Fprintf(w, "\n  For runnable examples with sample files:\n    $ %s examples %s\n", os.Args[0], doc.Name)
+	}
+	fmt.Fprintln(w, "\nEXIT STATUS")
+	codes := append([]exitCode(nil), defaultExitCodes...)
+	for _, code := range doc.ExitCodes {
+		replaced := false
+		for i := range codes {
+			if codes[i].Code == code.Code {
+				codes[i], replaced = code, true
+			}
+		}
+		if !replaced {
+			codes = append(codes, code)
+		}
+	}
+	for _, code := range codes {
+		fmt.Fprintf(w, "  %d  %s\n", code.Code, code.Meaning)
+	}
+}
+
+// writeExamples writes the examples of the command as a shell script that creates the sample files
+// and runs the examples.
+func writeExamples(w io.Writer, doc commandDoc) {
+	for _, ex := range doc.Examples {
+		fmt.Fprintf(w, "# %s %s: %s.\n", os.Args[0], doc.Name, ex.Description)
+		for _, file := range ex.Files {
+			if dir := path.Dir(file.Name); dir != "." {
+				fmt.Fprintf(w, "mkdir -p %s\n", dir)
+			}
+			fmt.Fprintf(w, "cat > %s <<'EOF'\n%sEOF\n", file.Name, file.Contents)
+		}
+		fmt.Fprintf(w, "%s %s %s\n\n", os.Args[0], doc.Name, ex.Args)
+	}
+}
+
+// newFlagSet returns a flag set for the command whose usage is the detailed help of the command.
+func newFlagSet(command string) *flag.FlagSet {
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	flags.Usage = func() {
+		doc, ok := findCommand(command)
+		if !ok {
+			help(flags.Output())
+			return
+		}
+		commandHelp(flags.Output(), doc, flags)
+	}
+	return flags
+}
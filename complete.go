@@ -0,0 +1,555 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/formatter"
+
+	"github.com/jdbaldry/jsonnet-tool/pkg/traverse"
+)
+
+// completionPlaceholder replaces the identifier being completed so that the file can be parsed.
+const completionPlaceholder = "__jt_complete"
+
+// maxCompletionDepth limits how many variables, fields, and imports are followed to resolve an object.
+const maxCompletionDepth = 32
+
+// sourcePosition is a position in a file, like an editor cursor.
+type sourcePosition struct {
+	File     string
+	Location ast.Location
+}
+
+// parseSourcePosition parses a position written as FILE:LINE:COLUMN, with lines and columns starting at one.
+func parseSourcePosition(s string) (sourcePosition, error) {
+	invalid := fmt.Errorf("invalid position %s, wanted FILE:LINE:COLUMN", s)
+	rest, column, ok := cutLast(s, ":")
+	if !ok {
+		return sourcePosition{}, invalid
+	}
+	file, line, ok := cutLast(rest, ":")
+	if !ok {
+		return sourcePosition{}, invalid
+	}
+	l, err := parseLineColumn(line + ":" + column)
+	if err != nil {
+		return sourcePosition{}, invalid
+	}
+	return sourcePosition{File: file, Location: l}, nil
+}
+
+// parseLineColumn parses a location written as LINE:COLUMN, with lines and columns starting at one.
+func parseLineColumn(s string) (ast.Location, error) {
+	invalid := fmt.Errorf("invalid location %s, wanted LINE:COLUMN", s)
+	line, column, ok := strings.Cut(s, ":")
+	if !ok {
+		return ast.Location{}, invalid
+	}
+	l, err := strconv.Atoi(line)
+	if err != nil || l < 1 {
+		return ast.Location{}, invalid
+	}
+	c, err := strconv.Atoi(column)
+	if err != nil || c < 1 {
+		return ast.Location{}, invalid
+	}
+	return ast.Location{Line: l, Column: c}, nil
+}
+
+// cutLast slices s around the last instance of sep.
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// completion is a completion candidate.
+type completion struct {
+	Label string
+	// Kind is local, parameter, field, object for self, super, and $, or std for std and its members.
+	Kind string
+	// Detail is the signature of functions.
+	Detail        string         `json:",omitempty"`
+	LocationRange *LocationRange `json:",omitempty"`
+}
+
+// completionBinding is a variable in scope at the completion position.
+type completionBinding struct {
+	name string
+	// kind is local or parameter.
+	kind string
+	// value is the expression bound to the variable, if it is known, and scope is the scope it is evaluated in.
+	value ast.Node
+	scope completionScope
+	loc   *ast.LocationRange
+}
+
+// completionObject is an object literal and the scope that it is written in.
+type completionObject struct {
+	object *ast.Object
+	scope  completionScope
+}
+
+// completionScope is what can be referenced by an expression.
+type completionScope struct {
+	bindings []completionBinding
+	// self and dollar are the innermost and outermost objects, or nil outside of objects.
+	self, dollar *completionObject
+}
+
+// with returns the scope with the additional bindings.
+func (s completionScope) with(bindings ...completionBinding) completionScope {
+	s.bindings = append(append([]completionBinding{}, s.bindings...), bindings...)
+	return s
+}
+
+// lookup returns the innermost binding of the variable.
+func (s completionScope) lookup(name string) (completionBinding, bool) {
+	for i := len(s.bindings) - 1; i >= 0; i-- {
+		if s.bindings[i].name == name {
+			return s.bindings[i], true
+		}
+	}
+	return completionBinding{}, false
+}
+
+// withLocals returns the scope with the bindings of the local expression, which are in scope of each other.
+func (s completionScope) withLocals(binds ast.LocalBinds) completionScope {
+	inner := s
+	start := len(inner.bindings)
+	for i := range binds {
+		bind := &binds[i]
+		b := completionBinding{name: string(bind.Variable), kind: "local", value: bind.Body, loc: &bind.LocRange}
+		if bind.Fun != nil {
+			b.value = bind.Fun
+		}
+		inner = inner.with(b)
+	}
+	for i := start; i < len(inner.bindings); i++ {
+		inner.bindings[i].scope = inner
+	}
+	return inner
+}
+
+// withParameters returns the scope with the parameters of the function.
+func (s completionScope) withParameters(fn *ast.Function) completionScope {
+	inner := s
+	for i := range fn.Parameters {
+		p := &fn.Parameters[i]
+		inner = inner.with(completionBinding{name: string(p.Name), kind: "parameter", loc: &p.LocRange})
+	}
+	return inner
+}
+
+// withFor returns the scope with the variables of the for specification and the specifications it is nested in.
+func (s completionScope) withFor(spec *ast.ForSpec) completionScope {
+	for _, name := range forVars(spec) {
+		s = s.with(completionBinding{name: name, kind: "local"})
+	}
+	return s
+}
+
+// fields returns the scope of the fields of the object, which is written in the scope s.
+func (o *completionObject) fields() completionScope {
+	inner := o.scope
+	inner.self = o
+	if inner.dollar == nil {
+		inner.dollar = o
+	}
+	start := len(inner.bindings)
+	for i := range o.object.Fields {
+		field := &o.object.Fields[i]
+		if field.Kind == ast.ObjectLocal {
+			b := completionBinding{name: string(*field.Id), kind: "local", value: field.Expr2, loc: &field.LocRange}
+			if field.Method != nil {
+				b.value = field.Method
+			}
+			inner = inner.with(b)
+		}
+	}
+	for i := start; i < len(inner.bindings); i++ {
+		inner.bindings[i].scope = inner
+	}
+	return inner
+}
+
+// completer finds completion candidates in a file.
+type completer struct {
+	importer jsonnet.Importer
+	// asts are the raw ASTs of imported files by where they were found.
+	asts map[string]ast.Node
+}
+
+// completionContext is what is being completed: a variable, or a field of target.
+type completionContext struct {
+	target ast.Node
+	scope  completionScope
+	found  bool
+}
+
+// find returns the context of the placeholder in the raw AST, whose nodes are in the scope s.
+func (c *completer) find(node ast.Node, s completionScope) completionContext {
+	switch n := node.(type) {
+	case *ast.Var:
+		if n.Id == completionPlaceholder {
+			return completionContext{scope: s, found: true}
+		}
+	case *ast.Index:
+		if n.Id != nil && *n.Id == completionPlaceholder {
+			return completionContext{target: n.Target, scope: s, found: true}
+		}
+	case *ast.Local:
+		inner := s.withLocals(n.Binds)
+		for _, bind := range n.Binds {
+			if bind.Fun != nil {
+				if ctx := c.findFunction(bind.Fun, inner); ctx.found {
+					return ctx
+				}
+			} else if ctx := c.find(bind.Body, inner); ctx.found {
+				return ctx
+			}
+		}
+		return c.find(n.Body, inner)
+	case *ast.Function:
+		return c.findFunction(n, s)
+	case *ast.Object:
+		return c.findFields(n.Fields, &completionObject{object: n, scope: s}, s)
+	case *ast.ObjectComp:
+		if ctx := c.findForSpec(&n.Spec, s); ctx.found {
+			return ctx
+		}
+		inner := s.withFor(&n.Spec)
+		return c.findFields(n.Fields, &completionObject{object: &ast.Object{Fields: n.Fields}, scope: inner}, inner)
+	case *ast.ArrayComp:
+		if ctx := c.findForSpec(&n.Spec, s); ctx.found {
+			return ctx
+		}
+		return c.find(n.Body, s.withFor(&n.Spec))
+	}
+	for _, child := range traverse.Children(node) {
+		if ctx := c.find(child, s); ctx.found {
+			return ctx
+		}
+	}
+	return completionContext{}
+}
+
+// findFunction finds the placeholder in the default arguments and body of the function, in the scope s with
+// its parameters.
+func (c *completer) findFunction(fn *ast.Function, s completionScope) completionContext {
+	inner := s.withParameters(fn)
+	for _, p := range fn.Parameters {
+		if p.DefaultArg != nil {
+			if ctx := c.find(p.DefaultArg, inner); ctx.found {
+				return ctx
+			}
+		}
+	}
+	return c.find(fn.Body, inner)
+}
+
+// findFields finds the placeholder in the fields of the object o. Computed field names are in the scope s.
+func (c *completer) findFields(fields ast.ObjectFields, o *completionObject, s completionScope) completionContext {
+	inner := o.fields()
+	for _, field := range fields {
+		if field.Kind == ast.ObjectFieldExpr {
+			if ctx := c.find(field.Expr1, s); ctx.found {
+				return ctx
+			}
+		}
+		if field.Method != nil {
+			if ctx := c.findFunction(field.Method, inner); ctx.found {
+				return ctx
+			}
+			continue
+		}
+		for _, expr := range []ast.Node{field.Expr2, field.Expr3} {
+			if expr != nil {
+				if ctx := c.find(expr, inner); ctx.found {
+					return ctx
+				}
+			}
+		}
+	}
+	return completionContext{}
+}
+
+// findForSpec finds the placeholder in the expressions of the for specification and the specifications it is
+// nested in.
+func (c *completer) findForSpec(spec *ast.ForSpec, s completionScope) completionContext {
+	if spec.Outer != nil {
+		if ctx := c.findForSpec(spec.Outer, s); ctx.found {
+			return ctx
+		}
+		s = s.withFor(spec.Outer)
+	}
+	if ctx := c.find(spec.Expr, s); ctx.found {
+		return ctx
+	}
+	s = s.with(completionBinding{name: string(spec.VarName), kind: "local"})
+	for _, cond := range spec.Conditions {
+		if ctx := c.find(cond.Expr, s); ctx.found {
+			return ctx
+		}
+	}
+	return completionContext{}
+}
+
+// imported returns the raw AST of the file imported by the node, relative to the file that the node is in.
+func (c *completer) imported(imp *ast.Import) (ast.Node, bool) {
+	contents, foundAt, err := c.importer.Import(makeLocationRange(imp.Loc()).FileName, imp.File.Value)
+	if err != nil {
+		return nil, false
+	}
+	if root, ok := c.asts[foundAt]; ok {
+		return root, root != nil
+	}
+	root, _, err := formatter.SnippetToRawAST(foundAt, contents.String())
+	if err != nil {
+		root = nil
+	}
+	c.asts[foundAt] = root
+	return root, root != nil
+}
+
+// objects returns the object literals that the node, in the scope s, evaluates to a merge of, in order.
+// Objects that are only known at evaluation, like the results of function calls, are not included.
+func (c *completer) objects(node ast.Node, s completionScope, depth int) []*completionObject {
+	if depth > maxCompletionDepth {
+		return nil
+	}
+	switch n := unparen(node).(type) {
+	case *ast.Object:
+		return []*completionObject{{object: n, scope: s}}
+	case *ast.Binary:
+		if n.Op == ast.BopPlus {
+			return append(c.objects(n.Left, s, depth+1), c.objects(n.Right, s, depth+1)...)
+		}
+	case *ast.ApplyBrace:
+		return append(c.objects(n.Left, s, depth+1), c.objects(n.Right, s, depth+1)...)
+	case *ast.Conditional:
+		return append(c.objects(n.BranchTrue, s, depth+1), c.objects(n.BranchFalse, s, depth+1)...)
+	case *ast.Local:
+		return c.objects(n.Body, s.withLocals(n.Binds), depth+1)
+	case *ast.Var:
+		if b, ok := s.lookup(string(n.Id)); ok && b.value != nil {
+			return c.objects(b.value, b.scope, depth+1)
+		}
+	case *ast.Self:
+		if s.self != nil {
+			return []*completionObject{s.self}
+		}
+	case *ast.Dollar:
+		if s.dollar != nil {
+			return []*completionObject{s.dollar}
+		}
+	case *ast.Import:
+		if root, ok := c.imported(n); ok {
+			return c.objects(root, completionScope{}, depth+1)
+		}
+	case *ast.Index:
+		name, ok := indexName(n)
+		if !ok {
+			return nil
+		}
+		objects := c.objects(n.Target, s, depth+1)
+		for i := len(objects) - 1; i >= 0; i-- {
+			for _, field := range objects[i].object.Fields {
+				if fieldName, ok := fieldName(field); ok && fieldName == name && field.Method == nil && field.Kind != ast.ObjectLocal {
+					return c.objects(field.Expr2, objects[i].fields(), depth+1)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// indexName returns the name of the field accessed by the index, if it is static.
+func indexName(index *ast.Index) (string, bool) {
+	if index.Id != nil {
+		return string(*index.Id), true
+	}
+	if name, ok := unparen(index.Index).(*ast.LiteralString); ok {
+		return name.Value, true
+	}
+	return "", false
+}
+
+// fieldCompletions returns the fields of the objects, with the fields of later objects replacing those of
+// earlier objects, sorted by name.
+func fieldCompletions(objects []*completionObject) []completion {
+	fields := make(map[string]completion)
+	for _, o := range objects {
+		for _, field := range o.object.Fields {
+			name, ok := fieldName(field)
+			if !ok || field.Kind == ast.ObjectLocal || field.Kind == ast.ObjectAssert {
+				continue
+			}
+			loc := makeLocationRange(&field.LocRange)
+			fc := completion{Label: name, Kind: "field", LocationRange: &loc}
+			fn := field.Method
+			if f, ok := unparen(field.Expr2).(*ast.Function); ok && fn == nil {
+				fn = f
+			}
+			if fn != nil {
+				fc.Detail = "function" + functionSymbol("", fn.Parameters, &field.LocRange).signature()
+			}
+			fields[name] = fc
+		}
+	}
+	completions := make([]completion, 0, len(fields))
+	for _, name := range sortedKeys(fields) {
+		completions = append(completions, fields[name])
+	}
+	return completions
+}
+
+// variableCompletions returns the variables in scope, innermost first, followed by self, super, and $ in
+// objects, and std.
+func variableCompletions(s completionScope) []completion {
+	var completions []completion
+	seen := make(map[string]bool)
+	for i := len(s.bindings) - 1; i >= 0; i-- {
+		b := s.bindings[i]
+		if seen[b.name] || strings.HasPrefix(b.name, "__jt_") {
+			continue
+		}
+		seen[b.name] = true
+		vc := completion{Label: b.name, Kind: b.kind}
+		if b.loc != nil {
+			loc := makeLocationRange(b.loc)
+			vc.LocationRange = &loc
+		}
+		if fn, ok := b.value.(*ast.Function); ok {
+			vc.Detail = "function" + functionSymbol("", fn.Parameters, b.loc).signature()
+		}
+		completions = append(completions, vc)
+	}
+	if s.self != nil {
+		for _, name := range []string{"self", "super", "$"} {
+			completions = append(completions, completion{Label: name, Kind: "object"})
+		}
+	}
+	if !seen["std"] {
+		completions = append(completions, completion{Label: "std", Kind: "std"})
+	}
+	return completions
+}
+
+// stdCompletions returns the members of the std library.
+func stdCompletions() ([]completion, error) {
+	output, err := jsonnet.MakeVM().EvaluateAnonymousSnippet("std", "std.objectFieldsAll(std)")
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	if err := json.Unmarshal([]byte(output), &names); err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	var completions []completion
+	for _, name := range names {
+		// Members starting with __ are internal to the implementation of std.
+		if identifier.MatchString(name) && !strings.HasPrefix(name, "__") {
+			completions = append(completions, completion{Label: name, Kind: "std"})
+		}
+	}
+	return completions, nil
+}
+
+// isIdentifierByte returns true if the byte can be part of an identifier.
+func isIdentifierByte(b byte) bool {
+	return b == '_' || 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z' || '0' <= b && b <= '9'
+}
+
+// complete returns the completion candidates at the position in the input of the file: the variables in scope,
+// or the fields of the object being indexed, resolved through variables, merges, and imports, or the members of
+// std. Only candidates that start with the partial identifier at the position are returned.
+func complete(file, input string, loc ast.Location) ([]completion, error) {
+	return newCompleter(makeImporter()).complete(file, input, loc)
+}
+
+// newCompleter returns a completer that imports files with the importer.
+func newCompleter(importer jsonnet.Importer) *completer {
+	return &completer{importer: importer, asts: make(map[string]ast.Node)}
+}
+
+// complete returns the completion candidates at the position in the input of the file, like the complete function.
+// The raw ASTs of imported files are cached by the completer, but the input is always parsed.
+func (c *completer) complete(file, input string, loc ast.Location) ([]completion, error) {
+	offset := sourceOffset(input, loc)
+	start, end := identifierAt(input, offset)
+	candidates, err := c.candidates(file, input, loc, start, end)
+	if err != nil {
+		return nil, err
+	}
+	prefix := input[start:offset]
+	completions := []completion{}
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate.Label, prefix) {
+			completions = append(completions, candidate)
+		}
+	}
+	return completions, nil
+}
+
+// identifierAt returns the offsets of the start and end of the identifier around the offset in the input.
+// They are the same if there is no identifier at the offset.
+func identifierAt(input string, offset int) (int, int) {
+	start, end := offset, offset
+	for start > 0 && isIdentifierByte(input[start-1]) {
+		start--
+	}
+	for end < len(input) && isIdentifierByte(input[end]) {
+		end++
+	}
+	return start, end
+}
+
+// candidates returns every completion candidate for the identifier between the start and end offsets of the input
+// of the file, which is at the location.
+func (c *completer) candidates(file, input string, loc ast.Location, start, end int) ([]completion, error) {
+	patched := input[:start] + completionPlaceholder + input[end:]
+	root, _, err := formatter.SnippetToRawAST(file, patched)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s with the position completed: %w", file, err)
+	}
+	ctx := c.find(root, completionScope{})
+	if !ctx.found {
+		return nil, fmt.Errorf("there is no variable or field reference at %s:%d:%d", file, loc.Line, loc.Column)
+	}
+	switch target := unparen(ctx.target).(type) {
+	case nil:
+		return variableCompletions(ctx.scope), nil
+	case *ast.Var:
+		if _, shadowed := ctx.scope.lookup("std"); target.Id == "std" && !shadowed {
+			return stdCompletions()
+		}
+	}
+	return fieldCompletions(c.objects(ctx.target, ctx.scope, 0)), nil
+}
+
+// readSourcePosition reads the file of the position, exiting if it cannot be read.
+func readSourcePosition(arg string) (sourcePosition, string) {
+	pos, err := parseSourcePosition(arg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		exit(1)
+	}
+	input, err := os.ReadFile(pos.File)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading file %s: %v\n", pos.File, err)
+		exit(1)
+	}
+	pos.File = filepath.Clean(pos.File)
+	return pos, string(input)
+}
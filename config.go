@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// projectConfigFile is the name of the jsonnet-tool project configuration file.
+const projectConfigFile = "jsonnet-tool.json"
+
+// projectConfig is the configuration of a project, read from the closest jsonnet-tool.json
+// at or above the current directory.
+type projectConfig struct {
+	// Dir is the directory containing the configuration file.
+	// Relative paths in the configuration are relative to Dir.
+	Dir         string       `json:"-"`
+	ImportHooks []importHook `json:"importHooks"`
+	// ManifestYamlAsJSON makes the manifestYamlFromJson native function produce JSON rather than YAML.
+	ManifestYamlAsJSON bool `json:"manifestYamlAsJson"`
+	// AllowEnv allows the env native function to read environment variables.
+	AllowEnv bool `json:"allowEnv"`
+	// JPath are additional import search directories, relative to Dir.
+	// Later directories take precedence.
+	JPath []string `json:"jpath"`
+	// ExtVars are the string external variables available to std.extVar.
+	ExtVars map[string]string `json:"extVars"`
+	// Lint configures the lint rules of the lint command when it is not given a --config file.
+	Lint lintConfig `json:"lint"`
+}
+
+// jpaths returns the absolute JPath directories of the configuration.
+func (c projectConfig) jpaths() []string {
+	jpaths := make([]string, 0, len(c.JPath))
+	for _, jpath := range c.JPath {
+		if !filepath.IsAbs(jpath) {
+			jpath = filepath.Join(c.Dir, jpath)
+		}
+		jpaths = append(jpaths, jpath)
+	}
+	return jpaths
+}
+
+// config is the configuration of the project containing the current directory.
+var config projectConfig
+
+// configMu guards config in the commands that reload it while requests are being handled.
+var configMu sync.RWMutex
+
+// trustedConfig is true if the project configuration may run import hook commands and allow the env native
+// function, as set by the --trust-project-config global option. Otherwise, running any command in an
+// untrusted checkout could run its programs or read secrets from the environment.
+var trustedConfig bool
+
+// ignoredAllowEnv is true if the allowEnv of the project configuration is ignored because the configuration
+// is not trusted. The first VM that is made without --allow-env warns about it.
+var (
+	ignoredAllowEnv     bool
+	warnIgnoredAllowEnv sync.Once
+)
+
+// loadProjectConfig reads the configuration of the project containing dir.
+// If there is no configuration file, the zero configuration is returned.
+func loadProjectConfig(dir string) (projectConfig, error) {
+	var c projectConfig
+	root, ok := findDirContaining(dir, []string{projectConfigFile})
+	if !ok {
+		return c, nil
+	}
+	path := filepath.Join(root, projectConfigFile)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return c, fmt.Errorf("unable to read project configuration: %w", err)
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, fmt.Errorf("unable to parse project configuration %s: %w", path, err)
+	}
+	c.Dir = root
+	for i, hook := range c.ImportHooks {
+		if err := hook.validate(); err != nil {
+			return c, fmt.Errorf("invalid import hook %d in %s: %w", i, path, err)
+		}
+	}
+	for name := range c.Lint.Rules {
+		if _, ok := findLintRule(name); !ok {
+			return c, fmt.Errorf("unknown lint rule %s in %s", name, path)
+		}
+	}
+	return c, nil
+}
+
+// setProjectConfig makes c the configuration of the project, ignoring its allowEnv unless it is trusted.
+func setProjectConfig(c projectConfig) {
+	ignoredAllowEnv = c.AllowEnv && !trustedConfig
+	if ignoredAllowEnv {
+		c.AllowEnv = false
+	}
+	config = c
+}
+
+// reloadProjectConfig reloads the configuration of the project containing the current directory for a
+// long-lived command, which allows the env native function to read environment variables if allowEnv is set by
+// its --allow-env flag.
+func reloadProjectConfig(allowEnv bool) error {
+	c, err := loadProjectConfig(".")
+	if err != nil {
+		return err
+	}
+	configMu.Lock()
+	defer configMu.Unlock()
+	setProjectConfig(c)
+	if allowEnv {
+		config.AllowEnv = true
+	}
+	return nil
+}
+
+// projectFiles returns the files that the project configuration and the jsonnet-bundler library paths of the
+// current directory are read from, for long-lived commands to reload them when they change. Running jb install
+// updates jsonnetfile.lock.json along with the vendor directory.
+func projectFiles() []string {
+	dir, err := filepath.Abs(".")
+	if err != nil {
+		return nil
+	}
+	configDir, bundlerDir := dir, dir
+	if root, ok := findDirContaining(dir, []string{projectConfigFile}); ok {
+		configDir = root
+	}
+	if root, ok := findProjectRoot(dir); ok {
+		bundlerDir = root
+	}
+	files := []string{filepath.Join(configDir, projectConfigFile)}
+	for _, name := range jsonnetfiles {
+		files = append(files, filepath.Join(bundlerDir, name))
+	}
+	return files
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/go-jsonnet"
+
+	"github.com/jdbaldry/jsonnet-tool/pkg/location"
+)
+
+// coverageRecorder records which probes of an instrumented evaluation were evaluated.
+type coverageRecorder struct {
+	in *instrumenter
+
+	mu      sync.Mutex
+	entered map[int]bool
+}
+
+// newCoverageRecorder returns a coverageRecorder for the probes of the instrumenter.
+func newCoverageRecorder(in *instrumenter) *coverageRecorder {
+	return &coverageRecorder{in: in, entered: make(map[int]bool)}
+}
+
+// enter records the evaluation of a probe.
+func (r *coverageRecorder) enter(id int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entered[id] = true
+}
+
+// files returns the absolute paths of the files that have been instrumented.
+func (r *coverageRecorder) files() map[string]bool {
+	r.in.mu.Lock()
+	defer r.in.mu.Unlock()
+	files := make(map[string]bool)
+	for _, p := range r.in.probes {
+		files[absPath(p.LocationRange.FileName)] = true
+	}
+	return files
+}
+
+// unimportedFiles returns the .jsonnet and .libsonnet files in the directories and their subdirectories, other
+// than test files and the files of hidden and vendor subdirectories, that are not in imported, in order.
+// Directories that do not exist are ignored.
+func unimportedFiles(dirs []string, imported map[string]bool) ([]string, error) {
+	var unimported []string
+	seen := make(map[string]bool)
+	for _, dir := range dirs {
+		if _, err := os.Stat(dir); err != nil {
+			continue
+		}
+		files, err := findFiles(dir, ".jsonnet", ".libsonnet")
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			abs := absPath(file)
+			if strings.HasSuffix(file, testFileSuffix) || imported[abs] || seen[abs] {
+				continue
+			}
+			seen[abs] = true
+			unimported = append(unimported, file)
+		}
+	}
+	sort.Strings(unimported)
+	return unimported, nil
+}
+
+// addUnimported instruments the files in the directories that have not been imported with the importer, which
+// must be the importer of the instrumenter, so that their definitions are reported as unevaluated.
+// Files that cannot be imported are reported to warnings.
+func (r *coverageRecorder) addUnimported(importer jsonnet.Importer, dirs []string, warnings io.Writer) error {
+	unimported, err := unimportedFiles(dirs, r.files())
+	if err != nil {
+		return err
+	}
+	for _, file := range unimported {
+		if _, _, err := importer.Import("", file); err != nil {
+			fmt.Fprintf(warnings, "Unable to instrument unimported file %s: %v\n", file, err)
+		}
+	}
+	return nil
+}
+
+// contains returns true if the location range outer contains the location range inner.
+func contains(outer, inner LocationRange) bool {
+	return outer.FileName == inner.FileName && !location.Before(inner.Begin, outer.Begin) && !location.Before(outer.End, inner.End)
+}
+
+// fileCoverage is the coverage of the definitions in a single file.
+type fileCoverage struct {
+	File      string
+	Evaluated int
+	Total     int
+	// Unevaluated are the outermost definitions that were never evaluated.
+	// Definitions nested within them are also unevaluated but are not listed.
+	Unevaluated []probe
+}
+
+// coverage returns the coverage of the definitions in each evaluated file, ordered by file name.
+// Definitions are local variables, object fields, and functions.
+func (r *coverageRecorder) coverage() []fileCoverage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.in.mu.Lock()
+	probes := append([]probe(nil), r.in.probes...)
+	r.in.mu.Unlock()
+
+	files := make(map[string]*fileCoverage)
+	for id, p := range probes {
+		if p.Kind == probeFile {
+			continue
+		}
+		fc, ok := files[p.LocationRange.FileName]
+		if !ok {
+			fc = &fileCoverage{File: p.LocationRange.FileName}
+			files[fc.File] = fc
+		}
+		fc.Total++
+		if r.entered[id] {
+			fc.Evaluated++
+			continue
+		}
+		fc.Unevaluated = append(fc.Unevaluated, p)
+	}
+
+	coverage := make([]fileCoverage, 0, len(files))
+	for _, fc := range files {
+		// Sorting outer definitions before the definitions nested within them means that
+		// a definition is nested if it is contained by the last outermost definition.
+		sort.SliceStable(fc.Unevaluated, func(i, j int) bool {
+			a, b := fc.Unevaluated[i].LocationRange, fc.Unevaluated[j].LocationRange
+			if a.Begin == b.Begin {
+				return location.Before(b.End, a.End)
+			}
+			return location.Before(a.Begin, b.Begin)
+		})
+		var outermost []probe
+		for _, p := range fc.Unevaluated {
+			if len(outermost) > 0 && contains(outermost[len(outermost)-1].LocationRange, p.LocationRange) {
+				continue
+			}
+			outermost = append(outermost, p)
+		}
+		fc.Unevaluated = outermost
+		coverage = append(coverage, *fc)
+	}
+	sort.Slice(coverage, func(i, j int) bool { return coverage[i].File < coverage[j].File })
+	return coverage
+}
+
+// report returns a human readable coverage report listing the unevaluated definitions of each file.
+func (r *coverageRecorder) report() string {
+	builder := strings.Builder{}
+	var evaluated, total int
+	for _, fc := range r.coverage() {
+		evaluated += fc.Evaluated
+		total += fc.Total
+		builder.WriteString(fmt.Sprintf("%s: %d/%d definitions evaluated (%s)\n", fc.File, fc.Evaluated, fc.Total, percentage(fc.Evaluated, fc.Total)))
+		for _, p := range fc.Unevaluated {
+			builder.WriteString(fmt.Sprintf("  %s\n", p))
+		}
+	}
+	builder.WriteString(fmt.Sprintf("Total: %d/%d definitions evaluated (%s)\n", evaluated, total, percentage(evaluated, total)))
+	return builder.String()
+}
+
+// percentage returns n as a percentage of total.
+func percentage(n, total int) string {
+	if total == 0 {
+		return "100.0%"
+	}
+	return fmt.Sprintf("%.1f%%", float64(n)*100/float64(total))
+}
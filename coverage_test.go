@@ -0,0 +1,102 @@
+package main
+
+import (
+	"io"
+	"testing"
+)
+
+func TestCoverage(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		files map[string]string
+		// unimported adds the files that test.jsonnet does not import to the report.
+		unimported bool
+		want       string
+	}{
+		{
+			name:  "every definition evaluated",
+			files: map[string]string{"test.jsonnet": "local a = 1; { b: a }"},
+			want: `test.jsonnet: 2/2 definitions evaluated (100.0%)
+Total: 2/2 definitions evaluated (100.0%)
+`,
+		},
+		{
+			name:  "unevaluated definitions",
+			files: map[string]string{"test.jsonnet": "local unused = 1; local f(x) = x; { a:: f(1), b: 2 }"},
+			want: `test.jsonnet: 1/4 definitions evaluated (25.0%)
+  test.jsonnet:1:16-17 local unused
+  test.jsonnet:1:32-33 function f
+  test.jsonnet:1:41-45 field a
+Total: 1/4 definitions evaluated (25.0%)
+`,
+		},
+		{
+			name: "definitions nested within an unevaluated definition",
+			files: map[string]string{
+				"test.jsonnet":  "local lib = import 'lib.libsonnet'; { a: lib.a }",
+				"lib.libsonnet": "{ a: 1, b: { c: 2, d: 3 } }",
+			},
+			want: `lib.libsonnet: 1/4 definitions evaluated (25.0%)
+  lib.libsonnet:1:12-26 field b
+test.jsonnet: 2/2 definitions evaluated (100.0%)
+Total: 3/6 definitions evaluated (50.0%)
+`,
+		},
+		{
+			name: "imported files only",
+			files: map[string]string{
+				"test.jsonnet":       "{ a: 1 }",
+				"unused.libsonnet":   "{ b: 2 }",
+				"other_test.jsonnet": "{ c: 3 }",
+			},
+			want: `test.jsonnet: 1/1 definitions evaluated (100.0%)
+Total: 1/1 definitions evaluated (100.0%)
+`,
+		},
+		{
+			name: "unimported files",
+			files: map[string]string{
+				"test.jsonnet":           "{ a: 1 }",
+				"unused.libsonnet":       "{ b: 2 }",
+				"lib/nested.libsonnet":   "local c = 3; {}",
+				"other_test.jsonnet":     "{ d: 4 }",
+				"vendor/dep.libsonnet":   "{ e: 5 }",
+				".hidden/file.libsonnet": "{ f: 6 }",
+				"broken.libsonnet":       "{",
+			},
+			unimported: true,
+			want: `lib/nested.libsonnet: 0/1 definitions evaluated (0.0%)
+  lib/nested.libsonnet:1:11-12 local c
+test.jsonnet: 1/1 definitions evaluated (100.0%)
+unused.libsonnet: 0/1 definitions evaluated (0.0%)
+  unused.libsonnet:1:6-7 field b
+Total: 1/3 definitions evaluated (33.3%)
+`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			inTempDir(t, tc.files)
+			in := &instrumenter{}
+			recorder := newCoverageRecorder(in)
+			importer := in.importer(makeImporter())
+			vm := makeVM()
+			vm.Importer(importer)
+			vm.SetTraceOut(&probeWriter{w: io.Discard, onEnter: recorder.enter})
+			root, _, err := vm.ImportAST("", "test.jsonnet")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := vm.Evaluate(root); err != nil {
+				t.Fatal(err)
+			}
+			if tc.unimported {
+				if err := recorder.addUnimported(importer, []string{"."}, io.Discard); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := recorder.report(); got != tc.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,546 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+
+	"github.com/jdbaldry/jsonnet-tool/pkg/location"
+	"github.com/jdbaldry/jsonnet-tool/pkg/symbols"
+	"github.com/jdbaldry/jsonnet-tool/pkg/traverse"
+	toolvm "github.com/jdbaldry/jsonnet-tool/pkg/vm"
+)
+
+// daemonSocketName is the name of the unix socket that the daemon listens on by default, in the directory it is
+// started in, and that --use-daemon looks for at or above the current directory.
+const daemonSocketName = ".jsonnet-tool-daemon.sock"
+
+// daemonCommands are the commands that --use-daemon runs in the daemon, with the usage they support there.
+// Other commands run as usual.
+var daemonCommands = map[string]string{
+	"complete":   "<file>:<line>:<column>",
+	"definition": "<file>:<line>:<column>",
+	"eval":       "<file>",
+	"imports":    "[--format json|make] [--target <target>] <file>",
+	"lint":       "[--enable <rules>] [--disable <rules>] [--config <file>] <file>...",
+	"symbols":    "[--position-encoding rune|byte|utf-16] <file>",
+}
+
+// daemonRequest is a command that a client runs in the daemon.
+type daemonRequest struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+	// Dir is the working directory of the client, which relative file arguments are resolved from.
+	Dir string `json:"dir"`
+	// ErrorFormat is the --error-format of the client.
+	ErrorFormat string `json:"errorFormat"`
+}
+
+// daemonResponse is what a command run in the daemon wrote, and how it exited.
+type daemonResponse struct {
+	Stdout string `json:"stdout"`
+	Stderr string `json:"stderr"`
+	Code   int    `json:"code"`
+}
+
+// watchingImporter is a jsonnet.Importer that records the version of each file it imports so that caches of
+// the imported files can be cleared once any of them changes.
+type watchingImporter struct {
+	importer jsonnet.Importer
+
+	mu       sync.Mutex
+	versions map[string]fileVersion
+	// failed is set when an import fails, because the missing file may be created later.
+	failed bool
+}
+
+// newWatchingImporter returns a watchingImporter that imports files with the importer.
+func newWatchingImporter(importer jsonnet.Importer) *watchingImporter {
+	return &watchingImporter{importer: importer, versions: make(map[string]fileVersion)}
+}
+
+// Import imports the file using the wrapped importer and records its version when it is first imported.
+func (w *watchingImporter) Import(importedFrom, importedPath string) (jsonnet.Contents, string, error) {
+	contents, foundAt, err := w.importer.Import(importedFrom, importedPath)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err != nil {
+		w.failed = true
+		return contents, foundAt, err
+	}
+	if _, ok := w.versions[foundAt]; !ok {
+		w.versions[foundAt] = statFile(foundAt)
+	}
+	return contents, foundAt, nil
+}
+
+// stale returns why the imported files may have changed: a file that has changed since it was imported,
+// or a failed import. It returns false if none of the files have changed.
+func (w *watchingImporter) stale() (string, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.failed {
+		return "an import failed", true
+	}
+	for path, version := range w.versions {
+		if statFile(path) != version {
+			return fmt.Sprintf("%s changed", path), true
+		}
+	}
+	return "", false
+}
+
+// daemon runs the daemonCommands for clients with caches that are kept warm between requests: the files found
+// in the Jsonnet library paths, and the parsed ASTs and evaluated values of imported files.
+// The file given to a command is parsed for each request, so that editing it does not clear the caches.
+// go-jsonnet cannot forget a single file, so all of the caches are cleared when any imported file changes.
+// Requests are run one at a time.
+type daemon struct {
+	// dir is the working directory of the daemon, which the project configuration was loaded from.
+	dir string
+	// settings reloads the project configuration, and clears the caches, when it changes.
+	settings *reloader
+
+	mu        sync.Mutex
+	importer  *watchingImporter
+	vm        *jsonnet.VM
+	completer *completer
+}
+
+// newDaemon returns a daemon with empty caches that serves the project of the working directory.
+func newDaemon() (*daemon, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	d := &daemon{dir: dir}
+	d.reset()
+	d.settings = newReloader(projectFiles, d.reload)
+	return d, nil
+}
+
+// reload reloads the project configuration and replaces the caches with empty ones.
+func (d *daemon) reload() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := reloadProjectConfig(false); err != nil {
+		return err
+	}
+	d.reset()
+	return nil
+}
+
+// reset replaces the caches with empty ones.
+func (d *daemon) reset() {
+	d.importer = newWatchingImporter(makeImporter())
+	d.vm = makeVM()
+	d.vm.Importer(d.importer)
+	d.completer = newCompleter(d.importer)
+}
+
+// serve runs the requests of clients connected to the listener until the context is cancelled, when the
+// listener is closed.
+func (d *daemon) serve(ctx context.Context, l net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go d.handle(ctx, conn)
+	}
+}
+
+// handle reads a request from the connection and writes the response.
+func (d *daemon) handle(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	var req daemonRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		json.NewEncoder(conn).Encode(daemonResponse{Stderr: fmt.Sprintf("Invalid request: %v\n", err), Code: 1})
+		return
+	}
+	json.NewEncoder(conn).Encode(d.run(ctx, req))
+}
+
+// run runs the request, first reloading the project configuration if it has changed, and clearing the caches if
+// any of the files imported into them have changed.
+func (d *daemon) run(ctx context.Context, req daemonRequest) daemonResponse {
+	d.settings.check()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if reason, stale := d.importer.stale(); stale {
+		fmt.Printf("Clearing caches: %s\n", reason)
+		d.reset()
+	}
+	var stdout, stderr bytes.Buffer
+	d.vm.SetTraceOut(&stderr)
+	code := d.command(ctx, req, &stdout, &stderr)
+	return daemonResponse{Stdout: stdout.String(), Stderr: stderr.String(), Code: code}
+}
+
+// parseDaemonFlags parses flags that may be interspersed with positional arguments, like parseFlags, but returns
+// an error rather than exiting.
+func parseDaemonFlags(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := flags.Args()
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		if len(rest) < len(args) && args[len(args)-len(rest)-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// file returns the path of a file argument of the client, relative to the directory of the daemon if it is
+// within it so that errors and locations are written as they are without the daemon.
+func (d *daemon) file(req daemonRequest, name string) string {
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(req.Dir, name)
+	}
+	if rel, err := filepath.Rel(d.dir, name); err == nil && filepath.IsLocal(rel) {
+		return rel
+	}
+	return name
+}
+
+// parse parses the file without adding it to the caches.
+func (d *daemon) parse(file string) (ast.Node, error) {
+	contents, foundAt, err := makeImporter().Import("", file)
+	if err != nil {
+		return nil, err
+	}
+	return jsonnet.SnippetToAST(foundAt, contents.String())
+}
+
+// command runs the command of the request with the caches of the daemon, writing to stdout and stderr, and
+// returns its exit code. Flags that would change the caches, like -J, are not supported.
+// Evaluations are limited by the limit flags of the daemon.
+func (d *daemon) command(ctx context.Context, req daemonRequest, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet(req.Command, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s --use-daemon %s %s\n", os.Args[0], req.Command, daemonCommands[req.Command])
+	}
+	var format, target, positionEncoding, enable, disable, configFile *string
+	switch req.Command {
+	case "imports":
+		format = flags.String("format", "json", "output format, one of json or make")
+		target = flags.String("target", "", "target of the make rule (default is <file> with a .json extension)")
+	case "lint":
+		enable = flags.String("enable", "", "comma separated lint rules to enable")
+		disable = flags.String("disable", "", "comma separated lint rules to disable")
+		configFile = flags.String("config", "", "JSON lint configuration file")
+	case "symbols":
+		positionEncoding = flags.String("position-encoding", string(location.Runes), "unit that columns are counted in: rune, byte, or utf-16")
+	}
+	args, err := parseDaemonFlags(flags, req.Args)
+	if err != nil {
+		return 2
+	}
+	if len(args) == 0 || len(args) > 1 && req.Command != "lint" {
+		flags.Usage()
+		return 1
+	}
+	for _, arg := range args {
+		if arg == stdinFile {
+			fmt.Fprintf(stderr, "Input from stdin cannot be sent to the daemon\n")
+			return 1
+		}
+	}
+
+	if req.Command == "lint" {
+		if *configFile != "" {
+			*configFile = d.file(req, *configFile)
+		}
+		settings, err := lintSettings(*enable, *disable, *configFile)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error configuring lint rules: %v\n", err)
+			return 1
+		}
+		return d.lint(req, args, settings, stdout, stderr)
+	}
+
+	if req.Command == "complete" || req.Command == "definition" {
+		pos, err := parseSourcePosition(args[0])
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		pos.File = d.file(req, pos.File)
+		input, err := os.ReadFile(pos.File)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error reading file %s: %v\n", pos.File, err)
+			return 1
+		}
+		if req.Command == "definition" {
+			def, err := d.completer.definition(pos.File, string(input), pos.Location)
+			if err != nil {
+				fmt.Fprintf(stderr, "Error finding the definition at %s: %v\n", args[0], err)
+				return 1
+			}
+			return writeDaemonJSON(stdout, stderr, def)
+		}
+		completions, err := d.completer.complete(pos.File, string(input), pos.Location)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error completing %s: %v\n", args[0], err)
+			return 1
+		}
+		return writeDaemonJSON(stdout, stderr, completions)
+	}
+
+	file := d.file(req, args[0])
+	root, err := d.parse(file)
+	if err != nil {
+		message := "Unable to produce AST for file %s: %v\n"
+		if req.Command == "eval" {
+			message = "Error evaluating Jsonnet for file %s:\n%v\n"
+		}
+		writeJsonnetError(stderr, req.ErrorFormat, err, message, file, d.vm.ErrorFormatter.Format(err))
+		return 1
+	}
+	switch req.Command {
+	case "eval":
+		evalCtx, cancel := limits.withTimeout(ctx)
+		defer cancel()
+		output, err := toolvm.Evaluate(evalCtx, d.vm, root)
+		if evalCtx.Err() != nil {
+			// The abandoned evaluation keeps running with the VM, so it is replaced along with the caches.
+			d.reset()
+			if errors.Is(evalCtx.Err(), context.DeadlineExceeded) {
+				fmt.Fprintf(stderr, "Evaluation timed out after %s\n", limits.timeout)
+				return timedOutCode
+			}
+			fmt.Fprintf(stderr, "Evaluation cancelled because the daemon is stopping\n")
+			return 1
+		}
+		if err != nil {
+			writeJsonnetError(stderr, req.ErrorFormat, err, "Error evaluating Jsonnet for file %s:\n%v\n", file, d.vm.ErrorFormatter.Format(err))
+			if explanation, ok := explainDuplicateKey(err); ok && req.ErrorFormat == "text" {
+				fmt.Fprint(stderr, explanation)
+			}
+			return 1
+		}
+		if err := limits.checkOutput([]byte(output)); err != nil {
+			fmt.Fprintf(stderr, "Error evaluating Jsonnet for file %s: %v\n", file, err)
+			return 1
+		}
+		fmt.Fprint(stdout, output)
+	case "imports":
+		imports, err := d.dependencies(file, root)
+		if err != nil {
+			fmt.Fprintf(stderr, "Unable to find imports for file %s: %v\n", file, err)
+			return 1
+		}
+		switch *format {
+		case "json":
+			return writeDaemonJSON(stdout, stderr, imports)
+		case "make":
+			if *target == "" {
+				*target = strings.TrimSuffix(file, filepath.Ext(file)) + ".json"
+			}
+			fmt.Fprint(stdout, makeRule(*target, file, imports))
+		default:
+			fmt.Fprintf(stderr, "Unrecognized imports format %s\n", *format)
+			return 1
+		}
+	case "symbols":
+		encoding, err := location.ParseEncoding(*positionEncoding)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		found, err := symbols.Find(&root, []string{"$"})
+		if err != nil {
+			fmt.Fprintf(stderr, "Error processing symbols for file %s: %v\n", file, err)
+			return 1
+		}
+		if encoding != location.Runes {
+			source, err := os.ReadFile(file)
+			if err != nil {
+				fmt.Fprintf(stderr, "Error reading file %s: %v\n", file, err)
+				return 1
+			}
+			symbols.Encode(found, string(source), encoding)
+		}
+		return writeDaemonJSON(stdout, stderr, found)
+	}
+	return 0
+}
+
+// lint lints the files of the arguments, and the .jsonnet and .libsonnet files in the directories of the
+// arguments, writing their diagnostics to stdout. Like the lint command, it returns 1 if there are any.
+func (d *daemon) lint(req daemonRequest, args []string, settings lintConfig, stdout, stderr io.Writer) int {
+	code := 0
+	for _, arg := range args {
+		files := []string{d.file(req, arg)}
+		if info, err := os.Stat(files[0]); err == nil && info.IsDir() {
+			if files, err = findFiles(files[0], ".jsonnet", ".libsonnet"); err != nil {
+				fmt.Fprintf(stderr, "Error finding files in %s: %v\n", arg, err)
+				return 1
+			}
+		}
+		for _, file := range files {
+			diagnostics, err := lint(file, settings)
+			if err != nil {
+				fmt.Fprintf(stderr, "Error linting file %s: %v\n", file, err)
+				return 1
+			}
+			for _, diagnostic := range diagnostics {
+				fmt.Fprintln(stdout, diagnostic)
+			}
+			if len(diagnostics) > 0 {
+				code = 1
+			}
+		}
+	}
+	return code
+}
+
+// writeDaemonJSON writes the value as indented JSON and returns the exit code of the command.
+func writeDaemonJSON(stdout, stderr io.Writer, v interface{}) int {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		fmt.Fprintf(stderr, "Unable to marshal to JSON: %v\n", err)
+		return 1
+	}
+	stdout.Write(append(b, '\n'))
+	return 0
+}
+
+// dependencies returns the absolute paths of the files transitively imported by the root node of the file,
+// like FindDependencies of the VM, which would cache the file itself.
+func (d *daemon) dependencies(file string, root ast.Node) ([]string, error) {
+	found := make(map[string]bool)
+	err := traverse.Traverse(root,
+		func(node *ast.Node) error {
+			var path string
+			switch i := (*node).(type) {
+			case *ast.Import:
+				imports, err := d.vm.FindDependencies(file, []string{i.File.Value})
+				if err != nil {
+					return err
+				}
+				for _, imported := range imports {
+					found[imported] = true
+				}
+				path = i.File.Value
+			case *ast.ImportStr:
+				path = i.File.Value
+			case *ast.ImportBin:
+				path = i.File.Value
+			default:
+				return nil
+			}
+			foundAt, err := d.vm.ResolveImport(file, path)
+			if err != nil {
+				return err
+			}
+			found[foundAt] = true
+			return nil
+		},
+		traverse.Nop,
+		traverse.Nop,
+	)
+	if err != nil {
+		return nil, err
+	}
+	imports := make([]string, 0, len(found))
+	seen := make(map[string]bool, len(found))
+	for path := range found {
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+		if resolved, err := filepath.EvalSymlinks(path); err == nil {
+			path = resolved
+		}
+		if !seen[path] {
+			seen[path] = true
+			imports = append(imports, path)
+		}
+	}
+	sort.Strings(imports)
+	return imports, nil
+}
+
+// findDaemon returns the address of the closest daemon socket at or above the current directory.
+// It returns false if there is no such socket.
+func findDaemon() (string, bool) {
+	dir, ok := findDirContaining(".", []string{daemonSocketName})
+	if !ok {
+		return "", false
+	}
+	return "unix://" + filepath.Join(dir, daemonSocketName), true
+}
+
+// removeStaleSocket removes the unix socket at the path if nothing is listening on it, like the socket of a
+// daemon that was killed.
+func removeStaleSocket(path string) {
+	if _, err := os.Stat(path); err != nil {
+		return
+	}
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		conn.Close()
+		return
+	}
+	os.Remove(path)
+}
+
+// runInDaemon runs the command with the arguments in the daemon at the address, writing what it wrote to stdout
+// and stderr, and returns its exit code.
+func runInDaemon(ctx context.Context, addr, command string, args []string) (int, error) {
+	network, address, err := listenAddress(addr)
+	if err != nil {
+		return 0, err
+	}
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return 0, fmt.Errorf("unable to connect to the daemon at %s: %w", addr, err)
+	}
+	defer conn.Close()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	dir, err := os.Getwd()
+	if err != nil {
+		return 0, err
+	}
+	req := daemonRequest{Command: command, Args: args, Dir: dir, ErrorFormat: errorFormat}
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return 0, fmt.Errorf("unable to send the request to the daemon: %w", err)
+	}
+	var resp daemonResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return 0, fmt.Errorf("unable to read the response of the daemon: %w", err)
+	}
+	io.WriteString(os.Stdout, resp.Stdout)
+	io.WriteString(os.Stderr, resp.Stderr)
+	return resp.Code, nil
+}
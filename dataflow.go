@@ -0,0 +1,503 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+
+	"github.com/jdbaldry/jsonnet-tool/pkg/traverse"
+)
+
+const (
+	// maxDataflowDepth limits the depth of function calls followed by the data flow analysis,
+	// which stops recursive functions from being followed forever.
+	maxDataflowDepth = 32
+	// maxDataflowSteps limits the number of expressions analyzed so that analysis of
+	// large codebases finishes in reasonable time.
+	maxDataflowSteps = 2000000
+)
+
+// input is a reference to an external input: a call of std.extVar or a top level argument.
+type input struct {
+	// Kind is either extVar or tla.
+	Kind          string
+	Name          string
+	LocationRange LocationRange
+}
+
+// String returns the kind and name of the input.
+func (i input) String() string {
+	return fmt.Sprintf("%s %s", i.Kind, i.Name)
+}
+
+// taints is the set of inputs that influence a value.
+type taints map[input]bool
+
+// union adds the inputs of other to t and returns t.
+func (t taints) union(other taints) taints {
+	for i := range other {
+		t[i] = true
+	}
+	return t
+}
+
+// abstractValue is the static approximation of a Jsonnet value used by the data flow analysis.
+type abstractValue struct {
+	// taints influence the whole value.
+	taints taints
+	// fields are the fields of an object whose fields are known.
+	fields map[string]*abstractThunk
+	// hidden are the names of hidden fields.
+	hidden map[string]bool
+	// fn and env are set for a function value.
+	fn  *ast.Function
+	env *abstractEnv
+}
+
+// abstractThunk is a lazily analyzed expression.
+type abstractThunk struct {
+	node ast.Node
+	env  *abstractEnv
+	// alternatives are thunks whose values are joined, for fields of objects produced by conditionals.
+	alternatives []*abstractThunk
+	value        *abstractValue
+	analyzing    bool
+}
+
+// abstractEnv binds variables, self, and $ for the data flow analysis.
+type abstractEnv struct {
+	parent *abstractEnv
+	vars   map[ast.Identifier]*abstractThunk
+	self   *abstractValue
+	dollar *abstractValue
+}
+
+// lookup returns the thunk bound to the variable or nil if it is unbound.
+func (e *abstractEnv) lookup(id ast.Identifier) *abstractThunk {
+	for ; e != nil; e = e.parent {
+		if t, ok := e.vars[id]; ok {
+			return t
+		}
+	}
+	return nil
+}
+
+// extend returns a new environment nested in e.
+func (e *abstractEnv) extend() *abstractEnv {
+	child := &abstractEnv{parent: e, vars: make(map[ast.Identifier]*abstractThunk)}
+	if e != nil {
+		child.self, child.dollar = e.self, e.dollar
+	}
+	return child
+}
+
+// dataflow statically analyzes which external inputs influence which values.
+// It is an approximation: references through self and $ resolve to the object literal that contains
+// them rather than the final merged object, arrays are not analyzed per element,
+// and functions of the standard library are assumed to combine the influences of all of their arguments.
+type dataflow struct {
+	vm      *jsonnet.VM
+	imports map[string]*abstractThunk
+	depth   int
+	steps   int
+	// inputs are all the references to external inputs found during analysis.
+	inputs taints
+}
+
+// newDataflow returns a data flow analysis that imports files using the VM.
+func newDataflow(vm *jsonnet.VM) *dataflow {
+	return &dataflow{vm: vm, imports: make(map[string]*abstractThunk), inputs: make(taints)}
+}
+
+// incomplete returns true if the analysis stopped early because it analyzed too many expressions.
+func (d *dataflow) incomplete() bool {
+	return d.steps > maxDataflowSteps
+}
+
+// force returns the value of the thunk, analyzing it if it has not been analyzed.
+// Thunks that refer to themselves are treated as uninfluenced.
+func (d *dataflow) force(t *abstractThunk) *abstractValue {
+	if t.value != nil {
+		return t.value
+	}
+	if t.analyzing {
+		return &abstractValue{taints: taints{}}
+	}
+	t.analyzing = true
+	if t.alternatives != nil {
+		value := &abstractValue{taints: taints{}}
+		for _, alternative := range t.alternatives {
+			value = join(value, d.force(alternative))
+		}
+		t.value = value
+	} else {
+		t.value = d.analyze(t.node, t.env)
+	}
+	t.analyzing = false
+	return t.value
+}
+
+// join returns the approximation of a value that is either a or b.
+func join(a, b *abstractValue) *abstractValue {
+	value := &abstractValue{taints: taints{}}
+	value.taints.union(a.taints).union(b.taints)
+	if a.fields == nil || b.fields == nil {
+		if a.fields == nil {
+			a, b = b, a
+		}
+		value.fields, value.hidden = a.fields, a.hidden
+		return value
+	}
+	value.fields = make(map[string]*abstractThunk)
+	value.hidden = make(map[string]bool)
+	for name, t := range a.fields {
+		value.fields[name] = t
+		value.hidden[name] = a.hidden[name]
+	}
+	for name, t := range b.fields {
+		if existing, ok := value.fields[name]; ok {
+			value.fields[name] = &abstractThunk{alternatives: []*abstractThunk{existing, t}}
+			value.hidden[name] = value.hidden[name] && b.hidden[name]
+			continue
+		}
+		value.fields[name] = t
+		value.hidden[name] = b.hidden[name]
+	}
+	return value
+}
+
+// merge returns the approximation of the object a + b.
+func merge(a, b *abstractValue) *abstractValue {
+	value := &abstractValue{taints: taints{}, fields: make(map[string]*abstractThunk), hidden: make(map[string]bool)}
+	value.taints.union(a.taints).union(b.taints)
+	for _, object := range []*abstractValue{a, b} {
+		for name, t := range object.fields {
+			value.fields[name] = t
+			value.hidden[name] = object.hidden[name]
+		}
+	}
+	return value
+}
+
+// influences returns the taints of all the values in nodes.
+func (d *dataflow) influences(env *abstractEnv, nodes ...ast.Node) taints {
+	t := taints{}
+	for _, node := range nodes {
+		if node != nil {
+			t.union(d.analyze(node, env).taints)
+		}
+	}
+	return t
+}
+
+// isExtVar returns the name of the external variable if node is a call of std.extVar with a literal name.
+func isExtVar(node *ast.Apply) (string, bool) {
+	index, ok := node.Target.(*ast.Index)
+	if !ok || len(node.Arguments.Positional) != 1 {
+		return "", false
+	}
+	target, ok := index.Target.(*ast.Var)
+	if !ok || (target.Id != "std" && target.Id != "$std") {
+		return "", false
+	}
+	field, ok := index.Index.(*ast.LiteralString)
+	if !ok || field.Value != "extVar" {
+		return "", false
+	}
+	name, ok := node.Arguments.Positional[0].Expr.(*ast.LiteralString)
+	if !ok {
+		return "", false
+	}
+	return name.Value, true
+}
+
+// call returns the approximation of applying the function value to the arguments.
+func (d *dataflow) call(fn *abstractValue, args ast.Arguments, env *abstractEnv) *abstractValue {
+	callEnv := fn.env.extend()
+	for i, param := range fn.fn.Parameters {
+		var arg *abstractThunk
+		if i < len(args.Positional) {
+			arg = &abstractThunk{node: args.Positional[i].Expr, env: env}
+		}
+		for _, named := range args.Named {
+			if named.Name == param.Name {
+				arg = &abstractThunk{node: named.Arg, env: env}
+			}
+		}
+		if arg == nil && param.DefaultArg != nil {
+			arg = &abstractThunk{node: param.DefaultArg, env: callEnv}
+		}
+		if arg == nil {
+			arg = &abstractThunk{value: &abstractValue{taints: taints{}}}
+		}
+		callEnv.vars[param.Name] = arg
+	}
+	d.depth++
+	defer func() { d.depth-- }()
+	value := d.analyze(fn.fn.Body, callEnv)
+	result := *value
+	result.taints = taints{}
+	result.taints.union(value.taints).union(fn.taints)
+	return &result
+}
+
+// analyze returns the approximation of the value of the desugared node in the environment.
+func (d *dataflow) analyze(node ast.Node, env *abstractEnv) *abstractValue {
+	d.steps++
+	if d.incomplete() {
+		return &abstractValue{taints: taints{}}
+	}
+	switch i := node.(type) {
+	case *ast.Apply:
+		if name, ok := isExtVar(i); ok {
+			in := input{Kind: "extVar", Name: name, LocationRange: makeLocationRange(i.Loc())}
+			d.inputs[in] = true
+			return &abstractValue{taints: taints{in: true}}
+		}
+		target := d.analyze(i.Target, env)
+		if target.fn != nil && d.depth < maxDataflowDepth {
+			return d.call(target, i.Arguments, env)
+		}
+		// The function is unknown, so it is assumed to combine all of its arguments.
+		// Function arguments, like those of std.map, are called with all the other arguments.
+		value := &abstractValue{taints: taints{}}
+		value.taints.union(target.taints)
+		var fns []*abstractValue
+		var argNodes []ast.Node
+		for _, arg := range i.Arguments.Positional {
+			argNodes = append(argNodes, arg.Expr)
+		}
+		for _, arg := range i.Arguments.Named {
+			argNodes = append(argNodes, arg.Arg)
+		}
+		for _, arg := range argNodes {
+			v := d.analyze(arg, env)
+			value.taints.union(v.taints)
+			if v.fn != nil {
+				fns = append(fns, v)
+			}
+		}
+		for _, fn := range fns {
+			if d.depth >= maxDataflowDepth {
+				break
+			}
+			callEnv := fn.env.extend()
+			for _, param := range fn.fn.Parameters {
+				callEnv.vars[param.Name] = &abstractThunk{value: &abstractValue{taints: value.taints}}
+			}
+			d.depth++
+			value.taints.union(d.analyze(fn.fn.Body, callEnv).taints)
+			d.depth--
+		}
+		return value
+	case *ast.Array:
+		value := &abstractValue{taints: taints{}}
+		for _, element := range i.Elements {
+			value.taints.union(d.analyze(element.Expr, env).taints)
+		}
+		return value
+	case *ast.Binary:
+		left, right := d.analyze(i.Left, env), d.analyze(i.Right, env)
+		if i.Op == ast.BopPlus && left.fields != nil && right.fields != nil {
+			return merge(left, right)
+		}
+		value := &abstractValue{taints: taints{}}
+		value.taints.union(left.taints).union(right.taints)
+		return value
+	case *ast.Conditional:
+		value := join(d.analyze(i.BranchTrue, env), d.analyze(i.BranchFalse, env))
+		result := *value
+		result.taints = taints{}
+		result.taints.union(value.taints).union(d.analyze(i.Cond, env).taints)
+		return &result
+	case *ast.DesugaredObject:
+		object := &abstractValue{taints: taints{}, fields: make(map[string]*abstractThunk), hidden: make(map[string]bool)}
+		fieldEnv := env.extend()
+		fieldEnv.self = object
+		if fieldEnv.dollar == nil {
+			fieldEnv.dollar = object
+		}
+		for _, bind := range i.Locals {
+			fieldEnv.vars[bind.Variable] = &abstractThunk{node: bind.Body, env: fieldEnv}
+		}
+		for _, field := range i.Fields {
+			name, ok := field.Name.(*ast.LiteralString)
+			if !ok {
+				// The field name is computed so any of the object fields may be influenced by it.
+				object.taints.union(d.analyze(field.Name, env).taints)
+				continue
+			}
+			object.fields[name.Value] = &abstractThunk{node: field.Body, env: fieldEnv}
+			object.hidden[name.Value] = field.Hide == ast.ObjectFieldHidden
+		}
+		return object
+	case *ast.Function:
+		return &abstractValue{taints: taints{}, fn: i, env: env}
+	case *ast.Import:
+		imported, foundAt, err := d.vm.ImportAST(i.Loc().FileName, i.File.Value)
+		if err != nil {
+			return &abstractValue{taints: taints{}}
+		}
+		t, ok := d.imports[foundAt]
+		if !ok {
+			// Imported files have their own scope.
+			t = &abstractThunk{node: imported, env: (*abstractEnv)(nil).extend()}
+			d.imports[foundAt] = t
+		}
+		return d.force(t)
+	case *ast.Index:
+		target := d.analyze(i.Target, env)
+		if name, ok := i.Index.(*ast.LiteralString); ok && target.fields != nil {
+			if t, ok := target.fields[name.Value]; ok {
+				field := d.force(t)
+				result := *field
+				result.taints = taints{}
+				result.taints.union(field.taints).union(target.taints)
+				return &result
+			}
+		}
+		value := &abstractValue{taints: taints{}}
+		value.taints.union(target.taints).union(d.analyze(i.Index, env).taints)
+		return value
+	case *ast.Local:
+		localEnv := env.extend()
+		for _, bind := range i.Binds {
+			localEnv.vars[bind.Variable] = &abstractThunk{node: bind.Body, env: localEnv}
+		}
+		return d.analyze(i.Body, localEnv)
+	case *ast.Self:
+		if env != nil && env.self != nil {
+			return env.self
+		}
+	case *ast.Dollar:
+		if env != nil && env.dollar != nil {
+			return env.dollar
+		}
+	case *ast.Var:
+		if t := env.lookup(i.Id); t != nil {
+			return d.force(t)
+		}
+	default:
+		return &abstractValue{taints: d.influences(env, traverse.Children(node)...)}
+	}
+	return &abstractValue{taints: taints{}}
+}
+
+// analyzeEntrypoint analyzes the root of an entrypoint. If it is a function, its parameters are top level arguments.
+func (d *dataflow) analyzeEntrypoint(root ast.Node) *abstractValue {
+	value := d.analyze(root, (*abstractEnv)(nil).extend())
+	if value.fn == nil {
+		return value
+	}
+	env := value.env.extend()
+	for _, param := range value.fn.Parameters {
+		in := input{Kind: "tla", Name: string(param.Name), LocationRange: makeLocationRange(&param.LocRange)}
+		d.inputs[in] = true
+		env.vars[param.Name] = &abstractThunk{value: &abstractValue{taints: taints{in: true}}}
+	}
+	return d.analyze(value.fn.Body, env)
+}
+
+// outputTaints returns the inputs that influence each visible output path of the value, identified by its path from the root "$".
+// Values at a path inherit the influences of their ancestors.
+func (d *dataflow) outputTaints(value *abstractValue) map[string]taints {
+	paths := make(map[string]taints)
+	visiting := make(map[*abstractValue]bool)
+	var walk func(value *abstractValue, path string, inherited taints)
+	walk = func(value *abstractValue, path string, inherited taints) {
+		if visiting[value] || strings.Count(path, ".") > maxDataflowDepth {
+			return
+		}
+		visiting[value] = true
+		defer delete(visiting, value)
+		own := taints{}
+		own.union(inherited).union(value.taints)
+		paths[path] = own
+		for name, t := range value.fields {
+			if !value.hidden[name] {
+				walk(d.force(t), fieldPath(path, name), own)
+			}
+		}
+	}
+	walk(value, "$", taints{})
+	return paths
+}
+
+// influencedPaths returns, for each input, the outermost output paths that it influences.
+func influencedPaths(paths map[string]taints) map[input][]string {
+	influenced := make(map[input][]string)
+	for path, t := range paths {
+		for in := range t {
+			if parent := parentPath(path); parent != path && paths[parent][in] {
+				continue
+			}
+			influenced[in] = append(influenced[in], path)
+		}
+	}
+	for in := range influenced {
+		sort.Strings(influenced[in])
+	}
+	return influenced
+}
+
+// sortedInputs returns the inputs ordered by name, kind, and location.
+func sortedInputs(inputs taints) []input {
+	sorted := make([]input, 0, len(inputs))
+	for in := range inputs {
+		sorted = append(sorted, in)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.LocationRange.String() < b.LocationRange.String()
+	})
+	return sorted
+}
+
+// writeDataflowTable writes a table of each input reference and the output paths it influences.
+func writeDataflowTable(w io.Writer, inputs taints, influenced map[input][]string) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "INPUT\tREFERENCE\tINFLUENCES")
+	for _, in := range sortedInputs(inputs) {
+		paths := strings.Join(influenced[in], ", ")
+		if paths == "" {
+			paths = "(no output)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", in, in.LocationRange, paths)
+	}
+	return tw.Flush()
+}
+
+// writeDataflowDot writes a DOT graph with edges from each input to its references and from
+// each reference to the output paths it influences.
+func writeDataflowDot(w io.Writer, inputs taints, influenced map[input][]string) {
+	fmt.Fprintln(w, "digraph {")
+	fmt.Fprintln(w, "  rankdir=LR")
+	seen := make(map[string]bool)
+	node := func(id, shape string) {
+		if !seen[id] {
+			seen[id] = true
+			fmt.Fprintf(w, "  %q [shape=%s]\n", id, shape)
+		}
+	}
+	for _, in := range sortedInputs(inputs) {
+		node(in.String(), "box")
+		node(in.LocationRange.String(), "ellipse")
+		fmt.Fprintf(w, "  %q -> %q\n", in.String(), in.LocationRange.String())
+		for _, path := range influenced[in] {
+			node(path, "note")
+			fmt.Fprintf(w, "  %q -> %q\n", in.LocationRange.String(), path)
+		}
+	}
+	fmt.Fprintln(w, "}")
+}
@@ -0,0 +1,691 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/formatter"
+
+	"github.com/jdbaldry/jsonnet-tool/pkg/traverse"
+)
+
+// debugNative is the name of the native function that instrumented probes call to let the debugger stop evaluation.
+const debugNative = "jsonnetToolDebugBreak"
+
+// debugHelpers binds, at the root of each instrumented file, the functions that describe a value at a path
+// without failing if the path does not exist. Functions are described rather than manifested.
+const debugHelpers = `
+local
+  __jt_index(acc, k) =
+    if !acc.ok then acc
+    else if std.isObject(acc.value) && std.isString(k) && std.objectHasAll(acc.value, k) then { ok: true, value: acc.value[k] }
+    else if std.isArray(acc.value) && std.isNumber(k) && k >= 0 && k < std.length(acc.value) then { ok: true, value: acc.value[k] }
+    else { ok: false, missing: k },
+  __jt_safe(v) =
+    if std.isFunction(v) then '<function>'
+    else if std.isObject(v) then { [k]: __jt_safe(v[k]) for k in std.objectFields(v) }
+    else if std.isArray(v) then std.map(__jt_safe, v)
+    else v,
+  __jt_inspect(path, value) =
+    local r = std.foldl(__jt_index, path, { ok: true, value: value });
+    if r.ok then std.manifestJsonEx(__jt_safe(r.value), '  ')
+    else 'no field or element ' + std.manifestJson(r.missing);
+__jt_body
+`
+
+// debugProbe is the Jsonnet that replaces a probed expression, which is __jt_body. Before the expression is
+// evaluated, the debugger is called and either lets evaluation continue or returns a command to inspect a variable,
+// whose description is passed back to the debugger, until it lets evaluation continue.
+// The format arguments are the name of the native function, the probe ID, and the inspection of the command.
+const debugProbe = `
+local __jt_loop(__jt_result) =
+  local __jt_command = std.native('%s')(%d, __jt_result);
+  if __jt_command == null then __jt_body
+  else __jt_loop(local __jt_path = __jt_command.path; %s);
+__jt_loop(null)
+`
+
+// debugScope is what can be inspected when evaluation is stopped at a probe.
+type debugScope struct {
+	// vars are the variables in scope.
+	vars []string
+	// inObject is true if self, super, and $ are in scope.
+	inObject bool
+}
+
+// with returns the scope with the additional variables.
+func (s debugScope) with(vars ...string) debugScope {
+	return debugScope{vars: append(append([]string{}, s.vars...), vars...), inObject: s.inObject}
+}
+
+// names returns the names that can be inspected.
+func (s debugScope) names() []string {
+	var names []string
+	seen := make(map[string]bool)
+	for i := len(s.vars) - 1; i >= 0; i-- {
+		if v := s.vars[i]; !seen[v] && !strings.HasPrefix(v, "__jt_") {
+			seen[v] = true
+			names = append([]string{v}, names...)
+		}
+	}
+	if s.inObject {
+		names = append(names, "self", "super", "$")
+	}
+	return names
+}
+
+// inspection returns the Jsonnet that describes the value of __jt_command.name at __jt_path in the scope.
+func (s debugScope) inspection() string {
+	var fields []string
+	for _, name := range s.names() {
+		if name != "self" && name != "super" && name != "$" {
+			fields = append(fields, fmt.Sprintf("%q: %s", name, name))
+		}
+	}
+	vars := fmt.Sprintf("__jt_inspect(__jt_path, { %s }[__jt_command.name])", strings.Join(fields, ", "))
+	if !s.inObject {
+		return vars
+	}
+	return fmt.Sprintf(`if __jt_command.name == 'self' then __jt_inspect(__jt_path, self)
+    else if __jt_command.name == '$' then __jt_inspect(__jt_path, $)
+    else if __jt_command.name == 'super' then (
+      if __jt_path[0] in super then __jt_inspect(__jt_path[1:], super[__jt_path[0]])
+      else 'no field ' + std.manifestJson(__jt_path[0])
+    )
+    else %s`, vars)
+}
+
+// parameterNames returns the names of the parameters.
+func parameterNames(params []ast.Parameter) []string {
+	names := make([]string, len(params))
+	for i, p := range params {
+		names[i] = string(p.Name)
+	}
+	return names
+}
+
+// forVars returns the variables bound by the for specification and the specifications it is nested in.
+func forVars(spec *ast.ForSpec) []string {
+	var vars []string
+	for ; spec != nil; spec = spec.Outer {
+		vars = append([]string{string(spec.VarName)}, vars...)
+	}
+	return vars
+}
+
+// debugBreakpoint is a line of a file at which evaluation stops.
+type debugBreakpoint struct {
+	File string
+	Line int
+}
+
+// String returns the breakpoint as file:line.
+func (b debugBreakpoint) String() string {
+	return fmt.Sprintf("%s:%d", b.File, b.Line)
+}
+
+// parseDebugBreakpoint parses a breakpoint written as FILE:LINE.
+func parseDebugBreakpoint(s string) (debugBreakpoint, error) {
+	i := strings.LastIndex(s, ":")
+	if i < 0 {
+		return debugBreakpoint{}, fmt.Errorf("invalid breakpoint %s, wanted FILE:LINE", s)
+	}
+	line, err := strconv.Atoi(s[i+1:])
+	if err != nil || line < 1 {
+		return debugBreakpoint{}, fmt.Errorf("invalid breakpoint %s, wanted FILE:LINE", s)
+	}
+	return debugBreakpoint{File: s[:i], Line: line}, nil
+}
+
+// matches returns true if the breakpoint is at the start of the location.
+func (b debugBreakpoint) matches(loc LocationRange) bool {
+	if loc.Begin.Line != b.Line {
+		return false
+	}
+	file := filepath.ToSlash(filepath.Clean(loc.FileName))
+	return absPath(loc.FileName) == absPath(b.File) || strings.HasSuffix(file, "/"+filepath.ToSlash(filepath.Clean(b.File)))
+}
+
+// debugEvent is sent by the evaluation to the debugger.
+type debugEvent struct {
+	// kind is paused when evaluation stops at a probe, result when an inspection has been evaluated,
+	// and done when evaluation has finished.
+	kind   string
+	id     int
+	result string
+	output string
+	err    error
+}
+
+// debugCommand is sent by the debugger to a stopped evaluation.
+type debugCommand struct {
+	// mode is how evaluation continues: continue, step, next, or out. If it is empty, name is inspected.
+	mode string
+	name string
+	path []interface{}
+}
+
+// debugger is a step debugger built on AST instrumentation. Each local variable, object field, and function
+// body is probed, and evaluation can stop before any of them is evaluated.
+type debugger struct {
+	in     *instrumenter
+	mu     sync.Mutex
+	scopes map[int]debugScope
+	// The remaining fields are only used by the evaluation while it runs and by the debugger while it is stopped.
+	breakpoints []debugBreakpoint
+	// stack are the IDs of the probes being evaluated, innermost last.
+	stack []int
+	// mode is how evaluation continues, and depth is the depth of the stack when it last stopped.
+	mode       string
+	depth      int
+	inspecting bool
+	events     chan debugEvent
+	commands   chan debugCommand
+	// stopped is the ID of the probe at which evaluation is stopped, or -1 if it is not stopped.
+	stopped int
+}
+
+// newDebugger returns a debugger that continues to the first breakpoint when run.
+func newDebugger() *debugger {
+	return &debugger{
+		in:       &instrumenter{},
+		scopes:   make(map[int]debugScope),
+		mode:     "continue",
+		events:   make(chan debugEvent),
+		commands: make(chan debugCommand),
+		stopped:  -1,
+	}
+}
+
+// scope returns the scope of the probe.
+func (d *debugger) scope(id int) debugScope {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.scopes[id]
+}
+
+// splice parses the Jsonnet template and replaces its __jt_body variable, which is found by follow, with node.
+func splice(template string, node ast.Node, follow func(ast.Node) (*ast.Node, bool)) (ast.Node, error) {
+	root, _, err := formatter.SnippetToRawAST("<debug>", template)
+	if err != nil {
+		return nil, err
+	}
+	body, ok := follow(root)
+	if !ok {
+		return nil, fmt.Errorf("unexpected debugger template")
+	}
+	*body = node
+	return root, nil
+}
+
+// wrap returns node wrapped in the Jsonnet of a new probe with the scope.
+func (d *debugger) wrap(kind probeKind, name string, node ast.Node, s debugScope) (ast.Node, error) {
+	p := probe{Kind: kind, Name: name}
+	if loc := node.Loc(); loc != nil {
+		p.LocationRange = makeLocationRange(loc)
+	}
+	id := d.in.add(p)
+	d.mu.Lock()
+	d.scopes[id] = s
+	d.mu.Unlock()
+	wrapped, err := splice(fmt.Sprintf(debugProbe, debugNative, id, s.inspection()), node, func(root ast.Node) (*ast.Node, bool) {
+		loop, ok := root.(*ast.Local)
+		if !ok || len(loop.Binds) != 1 {
+			return nil, false
+		}
+		command, ok := loop.Binds[0].Body.(*ast.Local)
+		if !ok {
+			return nil, false
+		}
+		cond, ok := command.Body.(*ast.Conditional)
+		if !ok {
+			return nil, false
+		}
+		return &cond.BranchTrue, true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return traceProbe(id, wrapped), nil
+}
+
+// fields probes the fields of an object. Computed field names are in the scope s and the fields are
+// in the scope s with the object locals.
+func (d *debugger) fields(fields ast.ObjectFields, s debugScope) error {
+	inner := s
+	for _, field := range fields {
+		if field.Kind == ast.ObjectLocal {
+			inner = inner.with(string(*field.Id))
+		}
+	}
+	inner.inObject = true
+	for i := range fields {
+		field := &fields[i]
+		if field.Kind == ast.ObjectFieldExpr {
+			if err := d.walk(field.Expr1, s); err != nil {
+				return err
+			}
+		}
+		name, ok := fieldName(*field)
+		kind := probeField
+		switch {
+		case field.Kind == ast.ObjectLocal:
+			name, kind = string(*field.Id), probeLocal
+		case !ok:
+			name = "[computed]"
+		}
+		var err error
+		switch {
+		case field.Kind == ast.ObjectAssert:
+			if err := d.walk(field.Expr2, inner); err != nil {
+				return err
+			}
+			if field.Expr3 != nil {
+				if err := d.walk(field.Expr3, inner); err != nil {
+					return err
+				}
+			}
+		case field.Method != nil:
+			fs := inner.with(parameterNames(field.Method.Parameters)...)
+			if err := d.walkFunction(field.Method, fs); err != nil {
+				return err
+			}
+			field.Expr2, err = d.wrap(probeFunction, name, field.Expr2, fs)
+			field.Method.Body = field.Expr2
+		default:
+			if err := d.walk(field.Expr2, inner); err != nil {
+				return err
+			}
+			field.Expr2, err = d.wrap(kind, name, field.Expr2, inner)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkFunction probes the default arguments and body of a function, in the scope s with its parameters.
+func (d *debugger) walkFunction(fn *ast.Function, s debugScope) error {
+	for _, p := range fn.Parameters {
+		if p.DefaultArg != nil {
+			if err := d.walk(p.DefaultArg, s); err != nil {
+				return err
+			}
+		}
+	}
+	return d.walk(fn.Body, s)
+}
+
+// walk probes every function body, local variable, and object field of the raw AST in the scope s.
+func (d *debugger) walk(node ast.Node, s debugScope) error {
+	switch n := node.(type) {
+	case *ast.Local:
+		inner := s
+		for _, bind := range n.Binds {
+			inner = inner.with(string(bind.Variable))
+		}
+		for j := range n.Binds {
+			bind := &n.Binds[j]
+			var err error
+			if bind.Fun != nil {
+				fs := inner.with(parameterNames(bind.Fun.Parameters)...)
+				if err := d.walkFunction(bind.Fun, fs); err != nil {
+					return err
+				}
+				bind.Body, err = d.wrap(probeFunction, string(bind.Variable), bind.Body, fs)
+				bind.Fun.Body = bind.Body
+			} else {
+				if err := d.walk(bind.Body, inner); err != nil {
+					return err
+				}
+				bind.Body, err = d.wrap(probeLocal, string(bind.Variable), bind.Body, inner)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return d.walk(n.Body, inner)
+	case *ast.Function:
+		fs := s.with(parameterNames(n.Parameters)...)
+		if err := d.walkFunction(n, fs); err != nil {
+			return err
+		}
+		var err error
+		n.Body, err = d.wrap(probeFunction, "anonymous", n.Body, fs)
+		return err
+	case *ast.Object:
+		return d.fields(n.Fields, s)
+	case *ast.ObjectComp:
+		if err := d.walkForSpec(&n.Spec, s); err != nil {
+			return err
+		}
+		return d.fields(n.Fields, s.with(forVars(&n.Spec)...))
+	case *ast.ArrayComp:
+		if err := d.walkForSpec(&n.Spec, s); err != nil {
+			return err
+		}
+		return d.walk(n.Body, s.with(forVars(&n.Spec)...))
+	}
+	for _, child := range traverse.Children(node) {
+		if err := d.walk(child, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkForSpec probes the expressions of the for specification and the specifications it is nested in.
+// Each expression is in the scope s with the variables of the specifications it is nested in.
+func (d *debugger) walkForSpec(spec *ast.ForSpec, s debugScope) error {
+	if spec.Outer != nil {
+		if err := d.walkForSpec(spec.Outer, s); err != nil {
+			return err
+		}
+		s = s.with(forVars(spec.Outer)...)
+	}
+	if err := d.walk(spec.Expr, s); err != nil {
+		return err
+	}
+	s = s.with(string(spec.VarName))
+	for _, cond := range spec.Conditions {
+		if err := d.walk(cond.Expr, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// transform is an import transform that instruments Jsonnet files for debugging.
+// Like the instrumenter transform, other files and files that cannot be parsed are not modified.
+func (d *debugger) transform(foundAt string, contents jsonnet.Contents) (jsonnet.Contents, error) {
+	if ext := filepath.Ext(foundAt); ext != ".jsonnet" && ext != ".libsonnet" {
+		return contents, nil
+	}
+	root, finalFodder, err := formatter.SnippetToRawAST(foundAt, contents.String())
+	if err != nil {
+		return contents, nil
+	}
+	if err := d.walk(root, debugScope{}); err != nil {
+		return contents, err
+	}
+	root, err = splice(debugHelpers, root, func(root ast.Node) (*ast.Node, bool) {
+		helpers, ok := root.(*ast.Local)
+		if !ok {
+			return nil, false
+		}
+		return &helpers.Body, true
+	})
+	if err != nil {
+		return contents, err
+	}
+	output, err := formatter.FormatNode(root, finalFodder, formatter.DefaultOptions())
+	if err != nil {
+		return contents, err
+	}
+	return jsonnet.MakeContents(output), nil
+}
+
+// shouldStop returns true if evaluation should stop at the probe that has just been entered.
+func (d *debugger) shouldStop(id int) bool {
+	switch {
+	case d.mode == "step",
+		d.mode == "next" && len(d.stack) <= d.depth,
+		d.mode == "out" && len(d.stack) < d.depth:
+		return true
+	}
+	loc := d.in.probe(id).LocationRange
+	for _, b := range d.breakpoints {
+		if b.matches(loc) {
+			return true
+		}
+	}
+	return false
+}
+
+// native returns the native function called by probes before they are evaluated. It returns null to continue
+// evaluation, or a command to inspect a variable, in which case it is called again with the description.
+func (d *debugger) native() *jsonnet.NativeFunction {
+	return &jsonnet.NativeFunction{
+		Name:   debugNative,
+		Params: ast.Identifiers{"id", "result"},
+		Func: func(args []interface{}) (interface{}, error) {
+			id, ok := args[0].(float64)
+			if !ok {
+				return nil, fmt.Errorf("expected a probe ID, got %v", args[0])
+			}
+			if result, ok := args[1].(string); ok {
+				d.inspecting = false
+				d.events <- debugEvent{kind: "result", result: result}
+			} else if d.inspecting || !d.shouldStop(int(id)) {
+				// Probes evaluated by an inspection never stop.
+				return nil, nil
+			} else {
+				d.events <- debugEvent{kind: "paused", id: int(id)}
+			}
+			command := <-d.commands
+			if command.mode != "" {
+				d.mode, d.depth = command.mode, len(d.stack)
+				return nil, nil
+			}
+			d.inspecting = true
+			return map[string]interface{}{"name": command.name, "path": command.path}, nil
+		},
+	}
+}
+
+// debugPathSegment matches a field or element access.
+var debugPathSegment = regexp.MustCompile(`^(?:\.([A-Za-z_][A-Za-z0-9_]*)|\[(\d+)\]|\[("(?:[^"\\]|\\.)*"|'[^']*')\])`)
+
+// debugVariable matches the variable at the start of an inspected expression.
+var debugVariable = regexp.MustCompile(`^(\$|[A-Za-z_][A-Za-z0-9_]*)`)
+
+// parseDebugPath parses an expression like x.a["b"][0] into its variable and path.
+func parseDebugPath(expr string) (string, []interface{}, error) {
+	name := debugVariable.FindString(expr)
+	if name == "" {
+		return "", nil, fmt.Errorf("expected a variable, self, super, or $, got %s", expr)
+	}
+	var path []interface{}
+	for rest := expr[len(name):]; rest != ""; {
+		m := debugPathSegment.FindStringSubmatch(rest)
+		if m == nil {
+			return "", nil, fmt.Errorf("expected .field, [index], or [\"field\"] at %s", rest)
+		}
+		switch {
+		case m[1] != "":
+			path = append(path, m[1])
+		case m[2] != "":
+			i, _ := strconv.Atoi(m[2])
+			path = append(path, float64(i))
+		case strings.HasPrefix(m[3], "'"):
+			path = append(path, strings.Trim(m[3], "'"))
+		default:
+			s, err := strconv.Unquote(m[3])
+			if err != nil {
+				return "", nil, fmt.Errorf("invalid field name %s: %w", m[3], err)
+			}
+			path = append(path, s)
+		}
+		rest = rest[len(m[0]):]
+	}
+	if name == "super" && len(path) == 0 {
+		return "", nil, fmt.Errorf("super can only be inspected with a field, like super.name")
+	}
+	return name, path, nil
+}
+
+// sourceLine returns the line of the file, or an empty string if it cannot be read.
+func sourceLine(file string, line int) string {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return ""
+	}
+	lines := strings.Split(string(b), "\n")
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+	return lines[line-1]
+}
+
+// debugHelp describes the debugger commands.
+const debugHelp = `Commands:
+  b FILE:LINE   sets a breakpoint before the local, field, or function body that starts at LINE of FILE.
+  b             lists the breakpoints.
+  d N           deletes the Nth breakpoint (zero indexed).
+  r             runs the evaluation until a breakpoint is reached.
+  c             continues until the next breakpoint.
+  s             steps into the next evaluation, including those caused by the current one.
+  n             steps over the evaluations caused by the current one, to the next at the same depth or above.
+  o             steps out of the current evaluation.
+  l             lists the variables that can be inspected.
+  p EXPR        prints the value of a variable, self, super, or $, optionally followed by .field,
+                ["field"], or [index] accesses, like p self.spec.replicas.
+  bt            prints the stack of evaluations, innermost first.
+  h             prints this help.
+  q             quits.
+Values are evaluated lazily, so inspecting one evaluates it, and an inspection that fails ends the run with
+the error.
+`
+
+// debugError describes an evaluation error without its stack trace, whose locations are in the instrumented files.
+// The eval command reports the error with the locations of the source files.
+func debugError(err error) string {
+	return makeJsonnetError(err).Message + "\nEvaluate the file for its stack trace."
+}
+
+// wait waits for evaluation to stop or finish and describes what happened. It returns false if evaluation finished.
+func (d *debugger) wait(out io.Writer) bool {
+	event := <-d.events
+	if event.kind == "done" {
+		d.stopped = -1
+		if event.err != nil {
+			fmt.Fprintf(out, "Evaluation failed: %s\n", debugError(event.err))
+		} else {
+			fmt.Fprintf(out, "Evaluation finished:\n%s", event.output)
+		}
+		return false
+	}
+	d.stopped = event.id
+	p := d.in.probe(event.id)
+	fmt.Fprintf(out, "Stopped at %s\n", p)
+	if line := sourceLine(p.LocationRange.FileName, p.LocationRange.Begin.Line); line != "" {
+		fmt.Fprintf(out, "%5d | %s\n", p.LocationRange.Begin.Line, line)
+	}
+	return true
+}
+
+// run runs the debugger command loop on the file, reading commands from in and writing to out.
+func (d *debugger) run(vm *jsonnet.VM, file string, in io.Reader, out io.Writer) {
+	scanner := bufio.NewScanner(in)
+	running := false
+	for {
+		fmt.Fprint(out, "(debug) ")
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return
+		}
+		command, arg, _ := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		arg = strings.TrimSpace(arg)
+		switch command {
+		case "":
+		case "b", "break":
+			if arg == "" {
+				for i, b := range d.breakpoints {
+					fmt.Fprintf(out, "[%d] %s\n", i, b)
+				}
+				continue
+			}
+			b, err := parseDebugBreakpoint(arg)
+			if err != nil {
+				fmt.Fprintln(out, err)
+				continue
+			}
+			d.breakpoints = append(d.breakpoints, b)
+			fmt.Fprintf(out, "Breakpoint %d at %s\n", len(d.breakpoints)-1, b)
+		case "d", "delete":
+			i, err := strconv.Atoi(arg)
+			if err != nil || i < 0 || i >= len(d.breakpoints) {
+				fmt.Fprintf(out, "No breakpoint %s\n", arg)
+				continue
+			}
+			d.breakpoints = append(d.breakpoints[:i], d.breakpoints[i+1:]...)
+		case "r", "run":
+			if running {
+				fmt.Fprintln(out, "The evaluation is already running, use c to continue")
+				continue
+			}
+			running = true
+			d.mode = "continue"
+			go func() {
+				root, _, err := vm.ImportAST("", file)
+				var output string
+				if err == nil {
+					output, err = vm.Evaluate(root)
+				}
+				d.events <- debugEvent{kind: "done", output: output, err: err}
+			}()
+			running = d.wait(out)
+		case "c", "continue", "s", "step", "n", "next", "o", "out":
+			if d.stopped < 0 {
+				fmt.Fprintln(out, "The evaluation is not stopped, use r to run it")
+				continue
+			}
+			mode := map[string]string{"c": "continue", "s": "step", "n": "next", "o": "out"}[command[:1]]
+			d.commands <- debugCommand{mode: mode}
+			running = d.wait(out)
+		case "l", "locals":
+			if d.stopped < 0 {
+				fmt.Fprintln(out, "The evaluation is not stopped")
+				continue
+			}
+			fmt.Fprintln(out, strings.Join(d.scope(d.stopped).names(), " "))
+		case "p", "print":
+			if d.stopped < 0 {
+				fmt.Fprintln(out, "The evaluation is not stopped")
+				continue
+			}
+			name, path, err := parseDebugPath(arg)
+			if err != nil {
+				fmt.Fprintf(out, "Invalid expression: %v\n", err)
+				continue
+			}
+			if indexOf(d.scope(d.stopped).names(), name) < 0 {
+				fmt.Fprintf(out, "%s is not in scope\n", name)
+				continue
+			}
+			d.commands <- debugCommand{name: name, path: path}
+			event := <-d.events
+			if event.kind == "done" {
+				// The inspection failed and ended the evaluation.
+				d.stopped, running = -1, false
+				fmt.Fprintf(out, "Evaluation failed: %s\n", debugError(event.err))
+				continue
+			}
+			fmt.Fprintln(out, event.result)
+		case "bt", "backtrace":
+			if d.stopped < 0 {
+				fmt.Fprintln(out, "The evaluation is not stopped")
+				continue
+			}
+			for i := len(d.stack) - 1; i >= 0; i-- {
+				fmt.Fprintf(out, "%s\n", d.in.probe(d.stack[i]))
+			}
+		case "h", "help":
+			fmt.Fprint(out, debugHelp)
+		case "q", "quit":
+			return
+		default:
+			fmt.Fprintf(out, "Unknown command %s, use h for help\n", command)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/formatter"
+
+	"github.com/jdbaldry/jsonnet-tool/pkg/location"
+	"github.com/jdbaldry/jsonnet-tool/pkg/traverse"
+)
+
+// errFoundImport stops the traversal for the import at the definition position.
+var errFoundImport = errors.New("found import")
+
+// definition returns the candidate for the variable or field at the position in the input of the file, with the
+// location where it is defined, or the file imported by the import path at the position.
+func definition(file, input string, loc ast.Location) (completion, error) {
+	return newCompleter(makeImporter()).definition(file, input, loc)
+}
+
+// definition returns the definition at the position in the input of the file, like the definition function.
+// Variables and fields are resolved like their completion candidates, so the innermost variable and the field
+// of the last object in a merge are their definitions.
+func (c *completer) definition(file, input string, loc ast.Location) (completion, error) {
+	root, _, err := formatter.SnippetToRawAST(file, input)
+	if err != nil {
+		return completion{}, fmt.Errorf("unable to parse %s: %w", file, err)
+	}
+	if path, ok := importPathAt(root, loc); ok {
+		_, foundAt, err := c.importer.Import(file, path)
+		if err != nil {
+			return completion{}, err
+		}
+		begin := ast.Location{Line: 1, Column: 1}
+		return completion{Label: path, Kind: "import", LocationRange: &LocationRange{FileName: foundAt, Begin: begin, End: begin}}, nil
+	}
+
+	start, end := identifierAt(input, sourceOffset(input, loc))
+	if start == end {
+		return completion{}, fmt.Errorf("there is no identifier or import path at %s:%d:%d", file, loc.Line, loc.Column)
+	}
+	name := input[start:end]
+	candidates, err := c.candidates(file, input, loc, start, end)
+	if err != nil {
+		return completion{}, err
+	}
+	for _, candidate := range candidates {
+		if candidate.Label != name {
+			continue
+		}
+		if candidate.LocationRange == nil {
+			return completion{}, fmt.Errorf("%s is not defined in a file", name)
+		}
+		return candidate, nil
+	}
+	return completion{}, fmt.Errorf("unable to find the definition of %s", name)
+}
+
+// importPathAt returns the path of the import whose path string contains the location.
+func importPathAt(root ast.Node, loc ast.Location) (string, bool) {
+	var path string
+	find := func(node *ast.Node) error {
+		var file *ast.LiteralString
+		switch n := (*node).(type) {
+		case *ast.Import:
+			file = n.File
+		case *ast.ImportStr:
+			file = n.File
+		case *ast.ImportBin:
+			file = n.File
+		default:
+			return nil
+		}
+		r := file.Loc()
+		if !location.Before(loc, r.Begin) && location.Before(loc, r.End) {
+			path = file.Value
+			return errFoundImport
+		}
+		return nil
+	}
+	err := traverse.Traverse(root, find, traverse.Nop, traverse.Nop)
+	return path, errors.Is(err, errFoundImport)
+}
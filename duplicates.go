@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/formatter"
+
+	"github.com/jdbaldry/jsonnet-tool/pkg/traverse"
+)
+
+// duplicateFieldNamePrefix is the prefix of the go-jsonnet runtime error message for duplicate keys.
+const duplicateFieldNamePrefix = "Duplicate field name: "
+
+// forSpecVariables returns the variables bound by a comprehension for specification and all of its outer specifications.
+func forSpecVariables(spec *ast.ForSpec) (vars []string) {
+	for ; spec != nil; spec = spec.Outer {
+		vars = append(vars, string(spec.VarName))
+	}
+	return vars
+}
+
+// references returns true if the expression refers to any of the variables.
+// Shadowing is not taken into account.
+func references(node ast.Node, vars []string) bool {
+	found := false
+	traverse.Traverse(node,
+		func(node *ast.Node) error {
+			if v, ok := (*node).(*ast.Var); ok {
+				for _, name := range vars {
+					if string(v.Id) == name {
+						found = true
+					}
+				}
+			}
+			return nil
+		},
+		traverse.Nop,
+		traverse.Nop,
+	)
+	return found
+}
+
+// comprehensionField returns the single field of an object comprehension.
+func comprehensionField(comp *ast.ObjectComp) (ast.ObjectField, bool) {
+	for _, field := range comp.Fields {
+		if field.Kind == ast.ObjectFieldExpr {
+			return field, true
+		}
+	}
+	return ast.ObjectField{}, false
+}
+
+// singleIteration returns true if the comprehension for specification and its outer specifications iterate at
+// most once, because each of them is over an array literal with at most one element or one of them is over an
+// empty array literal.
+func singleIteration(spec *ast.ForSpec) bool {
+	single := true
+	for ; spec != nil; spec = spec.Outer {
+		array, ok := spec.Expr.(*ast.Array)
+		switch {
+		case ok && len(array.Elements) == 0:
+			return true
+		case !ok || len(array.Elements) > 1:
+			single = false
+		}
+	}
+	return single
+}
+
+// findDuplicateKeys statically finds object keys in the raw Jsonnet AST that are produced more than once.
+// This includes computed field names that are literal strings and object comprehensions whose field
+// name does not depend on any of the comprehension variables, unless the comprehension iterates at most once.
+func findDuplicateKeys(root ast.Node) (diagnostics []string, err error) {
+	err = traverse.Traverse(root,
+		func(node *ast.Node) error {
+			switch i := (*node).(type) {
+			case *ast.Object:
+				seen := make(map[string]ast.LocationRange)
+				for _, field := range i.Fields {
+					name, ok := fieldName(field)
+					if !ok {
+						continue
+					}
+					if first, ok := seen[name]; ok {
+						diagnostics = append(diagnostics, fmt.Sprintf("%s Duplicate field name: %q, first defined at %s",
+							field.LocRange.String(), name, first.String()))
+						continue
+					}
+					seen[name] = field.LocRange
+				}
+			case *ast.ObjectComp:
+				field, ok := comprehensionField(i)
+				if !ok {
+					return nil
+				}
+				vars := forSpecVariables(&i.Spec)
+				if !references(field.Expr1, vars) && !singleIteration(&i.Spec) {
+					diagnostics = append(diagnostics, fmt.Sprintf("%s Object comprehension field name does not depend on the comprehension variables (%s) so it is duplicated by every iteration",
+						field.Expr1.Loc().String(), strings.Join(vars, ", ")))
+				}
+			}
+			return nil
+		},
+		traverse.Nop,
+		traverse.Nop,
+	)
+	return diagnostics, err
+}
+
+// explainDuplicateKey describes the expressions that generate the key of a duplicate field name
+// runtime error. The go-jsonnet error only includes the location of the object.
+// It returns false if the error is not a duplicate field name error or the object cannot be found.
+func explainDuplicateKey(err error) (string, bool) {
+	var runtimeErr jsonnet.RuntimeError
+	if !errors.As(err, &runtimeErr) || !strings.HasPrefix(runtimeErr.Msg, duplicateFieldNamePrefix) {
+		return "", false
+	}
+	key := strings.TrimPrefix(runtimeErr.Msg, duplicateFieldNamePrefix)
+	// The innermost frame with a source location is the object that produced the duplicate.
+	var loc ast.LocationRange
+	for i := len(runtimeErr.StackTrace) - 1; i >= 0; i-- {
+		if runtimeErr.StackTrace[i].Loc.IsSet() {
+			loc = runtimeErr.StackTrace[i].Loc
+			break
+		}
+	}
+	if !loc.IsSet() {
+		return "", false
+	}
+
+	input, err := readInput(loc.FileName)
+	if err != nil {
+		return "", false
+	}
+	root, _, err := formatter.SnippetToRawAST(loc.FileName, string(input))
+	if err != nil {
+		return "", false
+	}
+
+	var generators []string
+	traverse.Traverse(root,
+		func(node *ast.Node) error {
+			if (*node).Loc() == nil || (*node).Loc().Begin != loc.Begin || (*node).Loc().End != loc.End {
+				return nil
+			}
+			switch i := (*node).(type) {
+			case *ast.Object:
+				for _, field := range i.Fields {
+					if field.Kind != ast.ObjectFieldExpr {
+						continue
+					}
+					generators = append(generators, fmt.Sprintf("%s computed field name", field.Expr1.Loc().String()))
+				}
+			case *ast.ObjectComp:
+				if field, ok := comprehensionField(i); ok {
+					generators = append(generators, fmt.Sprintf("%s object comprehension field name", field.Expr1.Loc().String()))
+				}
+				for spec := &i.Spec; spec != nil; spec = spec.Outer {
+					generators = append(generators, fmt.Sprintf("%s for %s in ...", spec.Expr.Loc().String(), spec.VarName))
+				}
+			}
+			return nil
+		},
+		traverse.Nop,
+		traverse.Nop,
+	)
+	if len(generators) == 0 {
+		return "", false
+	}
+	return fmt.Sprintf("The duplicate key %s is generated by:\n\t%s\n", key, strings.Join(generators, "\n\t")), true
+}
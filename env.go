@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// environmentVariable is a variable of the environment derived from the project configuration.
+type environmentVariable struct {
+	Name  string
+	Value string
+}
+
+// shellName matches the names of environment variables that can be set by a shell.
+var shellName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// projectEnvironment returns the environment that makes other Jsonnet tools resolve imports and
+// external variables like jsonnet-tool does in the current directory.
+// JSONNET_PATH lists the project JPaths with the highest precedence first, as the jsonnet command expects.
+// Each external variable is a variable of the same name that the jsonnet command reads with --ext-str <name>.
+// External variables whose names cannot be environment variable names are returned separately.
+func projectEnvironment() (variables []environmentVariable, skipped []string) {
+	jpaths := projectJPaths()
+	for i, j := 0, len(jpaths)-1; i < j; i, j = i+1, j-1 {
+		jpaths[i], jpaths[j] = jpaths[j], jpaths[i]
+	}
+	for i := range jpaths {
+		jpaths[i] = absPath(jpaths[i])
+	}
+	variables = append(variables, environmentVariable{"JSONNET_PATH", strings.Join(jpaths, string(os.PathListSeparator))})
+
+	names := make([]string, 0, len(config.ExtVars))
+	for name := range config.ExtVars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !shellName.MatchString(name) || name == "JSONNET_PATH" {
+			skipped = append(skipped, name)
+			continue
+		}
+		variables = append(variables, environmentVariable{name, config.ExtVars[name]})
+	}
+	return variables, skipped
+}
+
+// extStrFlags returns the jsonnet command flags that read the external variables from the environment.
+func extStrFlags(variables []environmentVariable) string {
+	var flags []string
+	for _, v := range variables[1:] {
+		flags = append(flags, "--ext-str "+v.Name)
+	}
+	return strings.Join(flags, " ")
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// writeDirenv writes the variables as a .envrc snippet for direnv.
+func writeDirenv(w io.Writer, variables []environmentVariable) {
+	fmt.Fprintf(w, "# Generated by jsonnet-tool env export from %s.\n", projectConfigDescription())
+	if flags := extStrFlags(variables); flags != "" {
+		fmt.Fprintf(w, "# Pass the external variables to jsonnet with: %s\n", flags)
+	}
+	for _, v := range variables {
+		fmt.Fprintf(w, "export %s=%s\n", v.Name, shellQuote(v.Value))
+	}
+}
+
+// nixString quotes s as a Nix string.
+func nixString(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "${", `\${`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// writeNix writes the variables as a Nix attribute set that can be merged into the attributes of
+// a mkShell or mkDerivation, which are exported as environment variables.
+func writeNix(w io.Writer, variables []environmentVariable) {
+	fmt.Fprintf(w, "# Generated by jsonnet-tool env export from %s.\n", projectConfigDescription())
+	if flags := extStrFlags(variables); flags != "" {
+		fmt.Fprintf(w, "# Pass the external variables to jsonnet with: %s\n", flags)
+	}
+	fmt.Fprintln(w, "{")
+	for _, v := range variables {
+		fmt.Fprintf(w, "  %s = %s;\n", v.Name, nixString(v.Value))
+	}
+	fmt.Fprintln(w, "}")
+}
+
+// projectConfigDescription describes where the project configuration was read from.
+func projectConfigDescription() string {
+	if config.Dir == "" {
+		return "the current directory, which has no " + projectConfigFile
+	}
+	return absPath(filepath.Join(config.Dir, projectConfigFile))
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+)
+
+// errorFormat is the format of Jsonnet parse and evaluation errors set by the --error-format global option.
+var errorFormat = "text"
+
+// stackFrame is a frame of the stack trace of a Jsonnet evaluation error.
+type stackFrame struct {
+	// Name describes the frame, for example the function or field being evaluated.
+	Name          string
+	LocationRange *LocationRange `json:",omitempty"`
+}
+
+// jsonnetError is a machine-readable Jsonnet parse or evaluation error.
+type jsonnetError struct {
+	// Kind is static for parse and static analysis errors, runtime for evaluation errors,
+	// and error for other errors, like unreadable files.
+	Kind    string
+	Message string
+	// LocationRange is where the error occurred: the location of a static error, or the innermost
+	// frame of the stack trace with a location for a runtime error.
+	LocationRange *LocationRange `json:",omitempty"`
+	// StackTrace are the frames of a runtime error, innermost first.
+	StackTrace []stackFrame `json:",omitempty"`
+}
+
+// makeJsonnetError converts an unformatted go-jsonnet error into a jsonnetError.
+func makeJsonnetError(err error) jsonnetError {
+	var runtimeErr jsonnet.RuntimeError
+	if errors.As(err, &runtimeErr) {
+		e := jsonnetError{Kind: "runtime", Message: runtimeErr.Msg}
+		// go-jsonnet orders frames outermost first.
+		for i := len(runtimeErr.StackTrace) - 1; i >= 0; i-- {
+			frame := runtimeErr.StackTrace[i]
+			f := stackFrame{Name: strings.TrimSpace(frame.Name)}
+			// Frames without a location, like the manifestation of a field, are described by their file name.
+			if !frame.Loc.IsSet() && f.Name == "" {
+				f.Name = frame.Loc.FileName
+			}
+			if frame.Loc.IsSet() {
+				loc := makeLocationRange(&frame.Loc)
+				f.LocationRange = &loc
+				if e.LocationRange == nil {
+					e.LocationRange = &loc
+				}
+			}
+			e.StackTrace = append(e.StackTrace, f)
+		}
+		return e
+	}
+	// Static errors are prefixed with their location.
+	if static, ok := err.(interface{ Loc() ast.LocationRange }); ok {
+		loc := static.Loc()
+		e := jsonnetError{Kind: "static", Message: err.Error()}
+		if loc.IsSet() {
+			lr := makeLocationRange(&loc)
+			e.LocationRange = &lr
+			e.Message = strings.TrimPrefix(e.Message, loc.String()+" ")
+		}
+		e.Message = strings.TrimSpace(e.Message)
+		return e
+	}
+	return jsonnetError{Kind: "error", Message: err.Error()}
+}
+
+// reportJsonnetError writes an unformatted go-jsonnet parse or evaluation error to stderr.
+// With --error-format json, the error is written as a single line JSON record.
+// Otherwise, the format and args are written as text.
+func reportJsonnetError(err error, format string, args ...interface{}) {
+	writeJsonnetError(os.Stderr, errorFormat, err, format, args...)
+}
+
+// writeJsonnetError writes an unformatted go-jsonnet error to w in the error format, text or json.
+func writeJsonnetError(w io.Writer, errorFormat string, err error, format string, args ...interface{}) {
+	if errorFormat != "json" {
+		fmt.Fprintf(w, format, args...)
+		return
+	}
+	b, _ := json.Marshal(makeJsonnetError(err))
+	fmt.Fprintf(w, "%s\n", b)
+}
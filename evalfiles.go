@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	toolvm "github.com/jdbaldry/jsonnet-tool/pkg/vm"
+)
+
+// evalResult is the evaluation of one file of a parallel evaluation.
+type evalResult struct {
+	file string
+	// path is where the output is written.
+	path   string
+	output string
+	err    error
+	// formatted is the error formatted with its stack trace.
+	formatted string
+}
+
+// expandFiles returns the files matched by the patterns, in order. Patterns that are not globs are returned unchanged
+// so that missing files are reported when they are evaluated.
+func expandFiles(patterns []string) ([]string, error) {
+	var files []string
+	for _, pattern := range patterns {
+		if !strings.ContainsAny(pattern, "*?[") {
+			files = append(files, pattern)
+			continue
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %s: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("pattern %s matches no files", pattern)
+		}
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+// outputPath returns the path that the output of the file is written to. If dir is set, it is the path of the file
+// relative to the current directory within dir. Otherwise, it is next to the file. The extension of the file is
+// replaced with suffix.
+func outputPath(file, dir, suffix string) string {
+	path := strings.TrimSuffix(file, filepath.Ext(file)) + suffix
+	if dir == "" {
+		return path
+	}
+	rel, err := filepath.Rel(".", path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		rel = filepath.Base(path)
+	}
+	return filepath.Join(dir, rel)
+}
+
+// outputPaths returns the output path of each file, or an error if the outputs of two files would be written
+// to the same path or would replace an input file.
+func outputPaths(files []string, dir, suffix string) ([]string, error) {
+	paths := make([]string, len(files))
+	inputs := make(map[string]bool, len(files))
+	for _, file := range files {
+		inputs[absPath(file)] = true
+	}
+	written := make(map[string]string, len(files))
+	for i, file := range files {
+		paths[i] = outputPath(file, dir, suffix)
+		abs := absPath(paths[i])
+		if other, ok := written[abs]; ok {
+			return nil, fmt.Errorf("the outputs of %s and %s would both be written to %s", other, file, paths[i])
+		}
+		if inputs[abs] {
+			return nil, fmt.Errorf("the output of %s would replace the input file %s", file, paths[i])
+		}
+		written[abs] = file
+	}
+	return paths, nil
+}
+
+// evalFiles evaluates the files with a pool of workers, each with its own VM, and writes the output of each file
+// that evaluates successfully to its path, reporting the progress of each file. If workers is not positive, there is a worker per CPU.
+// A VM keeps the files imported by the files it evaluates cached for the next file, unless cacheSize is positive
+// and it has cached more files than that, when its cache is cleared first. The imports are recorded in stats.
+// The outputs are staged until every file is evaluated so that if the context is cancelled, no output is written
+// and the context error is returned. The results are returned in the order of the files.
+func evalFiles(ctx context.Context, files, paths []string, workers, cacheSize int, stats *vmStats, progress *progress) ([]evalResult, error) {
+	results := make([]evalResult, len(files))
+	staged := &stagedFiles{}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			vm := makeVM()
+			cache := stats.importer(makeImporter())
+			vm.Importer(cache)
+			for i := range jobs {
+				if cacheSize > 0 && cache.size() > cacheSize {
+					cache = stats.clear(vm, makeImporter())
+				}
+				r := evalResult{file: files[i], path: paths[i]}
+				progress.start(r.file)
+				root, _, err := vm.ImportAST("", r.file)
+				if err == nil {
+					r.output, err = toolvm.Evaluate(ctx, vm, root)
+				}
+				if ctx.Err() != nil {
+					// The VM may still be evaluating.
+					return
+				}
+				if err != nil {
+					r.err, r.formatted = err, vm.ErrorFormatter.Format(err)
+				} else if err := limits.checkOutput([]byte(r.output)); err != nil {
+					r.err, r.formatted = err, err.Error()
+				} else if err := staged.write(r.path, []byte(r.output)); err != nil {
+					r.err, r.formatted = err, err.Error()
+				}
+				progress.finish(r.file, r.err == nil)
+				results[i] = r
+			}
+		}()
+	}
+dispatch:
+	for i := range files {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		staged.discard()
+		return nil, err
+	}
+	if err := staged.commit(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// reportEvalResults writes the paths of the written outputs to stdout and the errors to stderr,
+// and returns the number of files that failed.
+func reportEvalResults(results []evalResult) int {
+	failed := 0
+	for _, r := range results {
+		if r.err != nil {
+			failed++
+			reportJsonnetError(r.err, "Error evaluating Jsonnet for file %s:\n%v\n", r.file, r.formatted)
+			continue
+		}
+		fmt.Println(r.path)
+	}
+	if failed > 0 && errorFormat == "text" {
+		fmt.Fprintf(os.Stderr, "%d of %d files failed to evaluate\n", failed, len(results))
+	}
+	return failed
+}
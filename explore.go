@@ -0,0 +1,743 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/go-jsonnet/ast"
+
+	"github.com/jdbaldry/jsonnet-tool/internal/english"
+	"github.com/jdbaldry/jsonnet-tool/pkg/layers"
+	"github.com/jdbaldry/jsonnet-tool/pkg/location"
+	"github.com/jdbaldry/jsonnet-tool/pkg/traverse"
+	toolvm "github.com/jdbaldry/jsonnet-tool/pkg/vm"
+)
+
+// exploreNode is a node of a tree shown by the explore command: an AST node, an evaluated value, or an object
+// field or local variable that groups the nodes of its definition.
+type exploreNode struct {
+	label    string
+	loc      LocationRange
+	parent   *exploreNode
+	children []*exploreNode
+	expanded bool
+}
+
+// add appends the child to the children of the node and returns it.
+func (n *exploreNode) add(child *exploreNode) *exploreNode {
+	child.parent = n
+	n.children = append(n.children, child)
+	return child
+}
+
+// maxLabelValue is the maximum number of characters of a string or value in a label.
+const maxLabelValue = 40
+
+// truncate shortens s to at most n characters, ending it with an ellipsis if it is shortened.
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n-1]) + "…"
+}
+
+// exploreLocation returns the location range of a raw AST node, or an unset range if it has none.
+func exploreLocation(loc *ast.LocationRange) LocationRange {
+	if loc == nil || !loc.IsSet() {
+		return LocationRange{}
+	}
+	return makeLocationRange(loc)
+}
+
+// parameters returns the parameters of the function as they are listed in its definition.
+func parameters(f *ast.Function) string {
+	return strings.Join(parameterNames(f.Parameters), ", ")
+}
+
+// astLabel describes the raw AST node on one line.
+func astLabel(node ast.Node) string {
+	name := strings.TrimPrefix(fmt.Sprintf("%T", node), "*ast.")
+	switch n := node.(type) {
+	case *ast.Binary:
+		return name + " " + n.Op.String()
+	case *ast.Unary:
+		return name + " " + n.Op.String()
+	case *ast.Var:
+		return name + " " + string(n.Id)
+	case *ast.Index:
+		if n.Id != nil {
+			return name + " ." + string(*n.Id)
+		}
+	case *ast.LiteralString:
+		return name + " " + truncate(strconv.Quote(n.Value), maxLabelValue)
+	case *ast.LiteralNumber:
+		return name + " " + n.OriginalString
+	case *ast.LiteralBoolean:
+		return fmt.Sprintf("%s %t", name, n.Value)
+	case *ast.Function:
+		return name + "(" + parameters(n) + ")"
+	}
+	if path, ok := importPath(node); ok {
+		return name + " " + strconv.Quote(path.Value)
+	}
+	return name
+}
+
+// fieldLabel describes an object field like it is written, without its value.
+func fieldLabel(field ast.ObjectField) string {
+	switch field.Kind {
+	case ast.ObjectAssert:
+		return "assert"
+	case ast.ObjectLocal:
+		label := "local " + string(*field.Id)
+		if field.Method != nil {
+			label += "(" + parameters(field.Method) + ")"
+		}
+		return label + " ="
+	}
+	label, ok := fieldName(field)
+	switch {
+	case !ok:
+		label = "[…]"
+	case !identifier.MatchString(label):
+		label = strconv.Quote(label)
+	}
+	if field.Method != nil {
+		label += "(" + parameters(field.Method) + ")"
+	}
+	if field.SuperSugar {
+		label += "+"
+	}
+	switch field.Hide {
+	case ast.ObjectFieldHidden:
+		return label + "::"
+	case ast.ObjectFieldVisible:
+		return label + ":::"
+	}
+	return label + ":"
+}
+
+// astTree returns the tree of the raw AST. The fields of objects and the variables of locals are nodes of
+// their own, with their definitions as children.
+func astTree(node ast.Node) *exploreNode {
+	n := &exploreNode{label: astLabel(node), loc: exploreLocation(node.Loc())}
+	fields := func(fields ast.ObjectFields) {
+		for _, field := range fields {
+			f := n.add(&exploreNode{label: fieldLabel(field), loc: exploreLocation(&field.LocRange)})
+			for _, expr := range []ast.Node{field.Expr1, field.Expr2, field.Expr3} {
+				// The names of fields are in their labels.
+				if expr != nil && (expr != field.Expr1 || field.Kind == ast.ObjectFieldExpr) {
+					f.add(astTree(expr))
+				}
+			}
+		}
+	}
+	specs := func(spec *ast.ForSpec) {
+		for _, spec := range forSpecs(spec) {
+			n.add(&exploreNode{label: "for " + string(spec.VarName) + " in"}).add(astTree(spec.Expr))
+			for _, cond := range spec.Conditions {
+				n.add(&exploreNode{label: "if"}).add(astTree(cond.Expr))
+			}
+		}
+	}
+	switch node := node.(type) {
+	case *ast.Local:
+		for _, bind := range node.Binds {
+			label := "local " + string(bind.Variable)
+			if bind.Fun != nil {
+				label += "(" + parameters(bind.Fun) + ")"
+			}
+			n.add(&exploreNode{label: label + " =", loc: exploreLocation(&bind.LocRange)}).add(astTree(bind.Body))
+		}
+		n.add(astTree(node.Body))
+	case *ast.Object:
+		fields(node.Fields)
+	case *ast.ObjectComp:
+		fields(node.Fields)
+		specs(&node.Spec)
+	case *ast.ArrayComp:
+		n.add(astTree(node.Body))
+		specs(&node.Spec)
+	default:
+		for _, child := range traverse.Children(node) {
+			n.add(astTree(child))
+		}
+	}
+	return n
+}
+
+// valueTree returns the tree of the evaluated JSON output, with the location of the definition of each value
+// from the source map of the output.
+func valueTree(output string, sm sourceMap) (*exploreNode, error) {
+	decoder := json.NewDecoder(strings.NewReader(output))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	locations := make(map[string]LocationRange, len(sm.Mappings))
+	for _, m := range sm.Mappings {
+		locations[m.Path] = m.Source
+	}
+	var node func(label, path string, value interface{}) *exploreNode
+	node = func(label, path string, value interface{}) *exploreNode {
+		n := &exploreNode{loc: locations[path]}
+		switch v := value.(type) {
+		case map[string]interface{}:
+			keys := make([]string, 0, len(v))
+			for key := range v {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			n.label = fmt.Sprintf("%s: {…} %s", label, english.Plural(len(keys), "field"))
+			for _, key := range keys {
+				name := key
+				if !identifier.MatchString(name) {
+					name = strconv.Quote(name)
+				}
+				n.add(node(name, fieldPath(path, key), v[key]))
+			}
+		case []interface{}:
+			n.label = fmt.Sprintf("%s: […] %s", label, english.Plural(len(v), "element"))
+			for i, element := range v {
+				n.add(node(fmt.Sprintf("[%d]", i), fmt.Sprintf("%s[%d]", path, i), element))
+			}
+		default:
+			b, _ := json.Marshal(v)
+			n.label = label + ": " + truncate(string(b), maxLabelValue)
+		}
+		return n
+	}
+	return node("$", "$", value), nil
+}
+
+// errorTree returns a tree describing an error, with a node for each line of its message.
+func errorTree(label string, message string) *exploreNode {
+	lines := strings.Split(strings.TrimSpace(message), "\n")
+	n := &exploreNode{label: label + ": " + lines[0]}
+	for _, line := range lines[1:] {
+		n.add(&exploreNode{label: strings.TrimSpace(line)})
+	}
+	return n
+}
+
+// The trees of the explorer.
+const (
+	astView = iota
+	valueView
+)
+
+// The panes beside the tree of the explorer.
+const (
+	sourcePane = iota
+	layersPane
+)
+
+// exploreHelp lists the keys of the explorer.
+const exploreHelp = "↑↓ move  ←→ fold  * unfold all  tab AST/value  s definition  L layers  [ ] step  m merge  J K scroll  o edit  q quit"
+
+// exploreRow is a visible node of a tree and its depth.
+type exploreRow struct {
+	node  *exploreNode
+	depth int
+}
+
+// explorer is the state of the explore command.
+type explorer struct {
+	file string
+	// trees are the AST and value trees, with the node selected in each and the first row shown.
+	trees    [2]*exploreNode
+	selected [2]*exploreNode
+	offset   [2]int
+	view     int
+	pane     int
+	layers   []layers.Layer
+	// layer is the index of the layer shown, and layersError describes why there are none.
+	layer       int
+	layersError string
+	// scroll is the number of lines that the pane is scrolled by.
+	scroll int
+	// height is the number of rows of the tree, for paging.
+	height  int
+	sources map[string][]string
+	// message is shown instead of the help until the next key.
+	message string
+}
+
+// newExplorer returns an explorer of the raw AST of the file, its evaluation, and its layers.
+// Evaluation stops when the context is cancelled.
+func newExplorer(ctx context.Context, file string, root ast.Node) *explorer {
+	e := &explorer{file: file, sources: make(map[string][]string)}
+	e.trees[astView] = astTree(root)
+
+	failed := func(err error) string {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Sprintf("timed out after %s", limits.timeout)
+		}
+		return err.Error()
+	}
+	vm := makeVM()
+	evalRoot, _, err := vm.ImportAST("", file)
+	var output string
+	if err == nil {
+		output, err = toolvm.Evaluate(ctx, vm, evalRoot)
+	}
+	var value *exploreNode
+	if err == nil {
+		var sm sourceMap
+		if sm, err = makeSourceMap(vm, evalRoot, output); err == nil {
+			value, err = valueTree(output, sm)
+		}
+	}
+	if err != nil {
+		value = errorTree("Evaluation failed", failed(err))
+	}
+	e.trees[valueView] = value
+
+	// Finding layers removes the merges from the AST, which is cached by the VM.
+	vm = makeVM()
+	layersRoot, _, err := vm.ImportAST("", file)
+	if err == nil {
+		e.layers, err = layers.Find(ctx, vm, layersRoot)
+	}
+	if err != nil {
+		e.layersError = failed(err)
+	}
+
+	for view, tree := range e.trees {
+		tree.expanded = true
+		e.selected[view] = tree
+	}
+	return e
+}
+
+// rows returns the visible nodes of the tree in order.
+func (e *explorer) rows() []exploreRow {
+	var rows []exploreRow
+	var visit func(n *exploreNode, depth int)
+	visit = func(n *exploreNode, depth int) {
+		rows = append(rows, exploreRow{node: n, depth: depth})
+		if n.expanded {
+			for _, child := range n.children {
+				visit(child, depth+1)
+			}
+		}
+	}
+	visit(e.trees[e.view], 0)
+	return rows
+}
+
+// selectNode selects the node, expanding its ancestors so that it is visible.
+func (e *explorer) selectNode(n *exploreNode) {
+	for p := n.parent; p != nil; p = p.parent {
+		p.expanded = true
+	}
+	e.selected[e.view] = n
+	e.scroll = 0
+}
+
+// expandAll expands the node and every node within it.
+func expandAll(n *exploreNode) {
+	n.expanded = true
+	for _, child := range n.children {
+		expandAll(child)
+	}
+}
+
+// jump selects the innermost node of the AST whose location contains the location range.
+func (e *explorer) jump(loc LocationRange) {
+	if !loc.Begin.IsSet() {
+		e.message = "There is no source location"
+		return
+	}
+	if absPath(loc.FileName) != absPath(e.file) {
+		e.message = fmt.Sprintf("%s is not in %s", loc, e.file)
+		return
+	}
+	var found *exploreNode
+	var visit func(n *exploreNode)
+	visit = func(n *exploreNode) {
+		if n.loc.Begin.IsSet() && !location.Before(loc.Begin, n.loc.Begin) && !location.Before(n.loc.End, loc.End) {
+			found = n
+		}
+		for _, child := range n.children {
+			visit(child)
+		}
+	}
+	visit(e.trees[astView])
+	if found == nil {
+		e.message = fmt.Sprintf("There is no AST node at %s", loc)
+		return
+	}
+	e.view = astView
+	e.selectNode(found)
+}
+
+// key updates the explorer for the key. It returns true if the key quits the explorer.
+func (e *explorer) key(key string) bool {
+	e.message = ""
+	rows := e.rows()
+	i := 0
+	for j, row := range rows {
+		if row.node == e.selected[e.view] {
+			i = j
+		}
+	}
+	move := func(j int) {
+		if j < 0 {
+			j = 0
+		}
+		if j >= len(rows) {
+			j = len(rows) - 1
+		}
+		e.selectNode(rows[j].node)
+	}
+	selected := e.selected[e.view]
+	switch key {
+	case "q", "ctrl-c":
+		return true
+	case "down", "j":
+		move(i + 1)
+	case "up", "k":
+		move(i - 1)
+	case "pgdn":
+		move(i + e.height)
+	case "pgup":
+		move(i - e.height)
+	case "home":
+		move(0)
+	case "end":
+		move(len(rows) - 1)
+	case "right", "l":
+		if len(selected.children) > 0 && selected.expanded {
+			move(i + 1)
+		}
+		selected.expanded = true
+	case "left", "h":
+		if selected.expanded && len(selected.children) > 0 {
+			selected.expanded = false
+		} else if selected.parent != nil {
+			e.selectNode(selected.parent)
+		}
+	case "enter", "space":
+		selected.expanded = !selected.expanded
+	case "*":
+		expandAll(selected)
+	case "tab":
+		e.view = 1 - e.view
+		e.scroll = 0
+	case "s":
+		e.jump(selected.loc)
+	case "L":
+		e.pane = 1 - e.pane
+		e.scroll = 0
+	case "[", "]":
+		e.pane = layersPane
+		e.scroll = 0
+		if key == "[" && e.layer > 0 {
+			e.layer--
+		}
+		if key == "]" && e.layer < len(e.layers)-1 {
+			e.layer++
+		}
+	case "m":
+		if e.layer < len(e.layers) {
+			e.jump(e.layers[e.layer].LocationRange)
+		}
+	case "J":
+		e.scroll++
+	case "K":
+		if e.scroll > 0 {
+			e.scroll--
+		}
+	}
+	return false
+}
+
+// source returns the lines of the file, reading it the first time.
+func (e *explorer) source(file string) ([]string, error) {
+	if lines, ok := e.sources[file]; ok {
+		return lines, nil
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.ReplaceAll(string(b), "\t", "  "), "\n")
+	e.sources[file] = lines
+	return lines, nil
+}
+
+// sourceLines returns the lines of the source pane: the source around the location of the selected node, with
+// the lines of the location marked.
+func (e *explorer) sourceLines(height int) []string {
+	loc := e.selected[e.view].loc
+	if !loc.Begin.IsSet() {
+		return []string{"No source location"}
+	}
+	lines, err := e.source(loc.FileName)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	out := []string{loc.String()}
+	first := loc.Begin.Line - height/3 + e.scroll
+	if first < 1 {
+		first = 1
+	}
+	for line := first; line <= len(lines) && len(out) < height; line++ {
+		marker := " "
+		if line >= loc.Begin.Line && line <= loc.End.Line {
+			marker = "▌"
+		}
+		out = append(out, fmt.Sprintf("%s%4d  %s", marker, line, lines[line-1]))
+	}
+	return out
+}
+
+// layerLines returns the lines of the layers pane: the location of the merge of the layer and its evaluation.
+func (e *explorer) layerLines(height int) []string {
+	if len(e.layers) == 0 {
+		if e.layersError != "" {
+			return append([]string{"Finding layers failed:"}, strings.Split(e.layersError, "\n")...)
+		}
+		return []string{"There are no layers"}
+	}
+	l := e.layers[e.layer]
+	out := []string{fmt.Sprintf("Layer %d of %d  %s", e.layer+1, len(e.layers), l.LocationRange)}
+	lines := strings.Split(strings.TrimSuffix(l.Evaluation, "\n"), "\n")
+	if e.scroll < len(lines) {
+		lines = lines[e.scroll:]
+	} else {
+		lines = nil
+	}
+	for _, line := range lines {
+		if len(out) == height {
+			break
+		}
+		out = append(out, strings.ReplaceAll(line, "\t", "  "))
+	}
+	return out
+}
+
+// fit truncates or pads the line to the width.
+func fit(line string, width int) string {
+	n := utf8.RuneCountInString(line)
+	if n > width {
+		return string([]rune(line)[:width])
+	}
+	return line + strings.Repeat(" ", width-n)
+}
+
+// render returns the screen of the explorer for a terminal of the size: a header, the tree beside the pane, and
+// the help or message.
+func (e *explorer) render(width, height int) string {
+	e.height = height - 2
+	if e.height < 1 {
+		e.height = 1
+	}
+	rows := e.rows()
+	i := 0
+	for j, row := range rows {
+		if row.node == e.selected[e.view] {
+			i = j
+		}
+	}
+	if i < e.offset[e.view] {
+		e.offset[e.view] = i
+	}
+	if i >= e.offset[e.view]+e.height {
+		e.offset[e.view] = i - e.height + 1
+	}
+
+	views := [2]string{"AST", "value"}
+	panes := [2]string{"source", "layers"}
+	var b strings.Builder
+	b.WriteString("\x1b[H")
+	header := fmt.Sprintf(" %s  [%s]  %s", e.file, views[e.view], panes[e.pane])
+	b.WriteString("\x1b[1m" + fit(header, width) + "\x1b[0m\r\n")
+
+	left := width / 2
+	right := width - left - 1
+	var pane []string
+	if e.pane == layersPane {
+		pane = e.layerLines(e.height)
+	} else {
+		pane = e.sourceLines(e.height)
+	}
+	for r := 0; r < e.height; r++ {
+		line := ""
+		if j := e.offset[e.view] + r; j < len(rows) {
+			row := rows[j]
+			marker := "  "
+			if len(row.node.children) > 0 {
+				marker = "▸ "
+				if row.node.expanded {
+					marker = "▾ "
+				}
+			}
+			line = fit(strings.Repeat("  ", row.depth)+marker+row.node.label, left)
+			if j == i {
+				line = "\x1b[7m" + line + "\x1b[0m"
+			}
+		} else {
+			line = fit("", left)
+		}
+		b.WriteString(line + "│")
+		if r < len(pane) {
+			b.WriteString(fit(pane[r], right))
+		} else {
+			b.WriteString(fit("", right))
+		}
+		b.WriteString("\r\n")
+	}
+	footer := exploreHelp
+	if e.message != "" {
+		footer = e.message
+	}
+	b.WriteString(fit(" "+footer, width))
+	return b.String()
+}
+
+// exploreKeys are the escape sequences of the keys of the explorer.
+var exploreKeys = map[string]string{
+	"\x1b[A": "up", "\x1b[B": "down", "\x1b[C": "right", "\x1b[D": "left",
+	"\x1bOA": "up", "\x1bOB": "down", "\x1bOC": "right", "\x1bOD": "left",
+	"\x1b[5~": "pgup", "\x1b[6~": "pgdn", "\x1b[H": "home", "\x1b[F": "end", "\x1b[1~": "home", "\x1b[4~": "end",
+	"\r": "enter", "\n": "enter", "\t": "tab", " ": "space", "\x03": "ctrl-c",
+}
+
+// parseKeys returns the keys of the input read from a terminal in raw mode.
+func parseKeys(input []byte) []string {
+	var keys []string
+	for len(input) > 0 {
+		matched := false
+		for sequence, key := range exploreKeys {
+			if strings.HasPrefix(string(input), sequence) {
+				keys = append(keys, key)
+				input = input[len(sequence):]
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			r, size := utf8.DecodeRune(input)
+			keys = append(keys, string(r))
+			input = input[size:]
+		}
+	}
+	return keys
+}
+
+// stty runs stty with the arguments on the terminal of stdin and returns its output.
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// terminalSize returns the number of rows and columns of the terminal, falling back to $LINES and $COLUMNS, and
+// then to 24 by 80.
+func terminalSize() (int, int) {
+	if size, err := stty("size"); err == nil {
+		var rows, columns int
+		if _, err := fmt.Sscan(size, &rows, &columns); err == nil && rows > 0 && columns > 0 {
+			return rows, columns
+		}
+	}
+	rows, columns := 24, 80
+	if n, err := strconv.Atoi(os.Getenv("LINES")); err == nil && n > 0 {
+		rows = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		columns = n
+	}
+	return rows, columns
+}
+
+// edit opens the location of the selected node in $VISUAL or $EDITOR, which is given the line as +LINE like
+// vi and emacs expect.
+func (e *explorer) edit() error {
+	loc := e.selected[e.view].loc
+	if !loc.Begin.IsSet() {
+		e.message = "There is no source location"
+		return nil
+	}
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		e.message = "Set $VISUAL or $EDITOR to open " + loc.String()
+		return nil
+	}
+	cmd := exec.Command("sh", "-c", editor+` "+$1" "$2"`, "sh", strconv.Itoa(loc.Begin.Line), loc.FileName)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		e.message = fmt.Sprintf("Unable to run editor %s: %v", editor, err)
+	}
+	// The edited source is read again.
+	delete(e.sources, loc.FileName)
+	return nil
+}
+
+// run shows the explorer on the terminal, reading keys from in and writing the screen to out, until it is quit.
+// The terminal is in raw mode, on the alternate screen, while the explorer is shown.
+func (e *explorer) run(in io.Reader, out io.Writer) error {
+	saved, err := stty("-g")
+	if err != nil {
+		return fmt.Errorf("unable to read the terminal settings: %w", err)
+	}
+	enter := func() error {
+		if _, err := stty("raw", "-echo"); err != nil {
+			return fmt.Errorf("unable to configure the terminal: %w", err)
+		}
+		_, err := io.WriteString(out, "\x1b[?1049h\x1b[?25l\x1b[2J")
+		return err
+	}
+	leave := func() {
+		io.WriteString(out, "\x1b[?25h\x1b[?1049l")
+		stty(saved)
+	}
+	if err := enter(); err != nil {
+		leave()
+		return err
+	}
+	defer leave()
+	buf := make([]byte, 64)
+	for {
+		rows, columns := terminalSize()
+		if _, err := io.WriteString(out, e.render(columns, rows)); err != nil {
+			return err
+		}
+		n, err := in.Read(buf)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, key := range parseKeys(buf[:n]) {
+			if key == "o" {
+				leave()
+				e.edit()
+				if err := enter(); err != nil {
+					return err
+				}
+				continue
+			}
+			if e.key(key) {
+				return nil
+			}
+		}
+	}
+}
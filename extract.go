@@ -0,0 +1,390 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/formatter"
+
+	"github.com/jdbaldry/jsonnet-tool/pkg/location"
+)
+
+// sourceRange is a range in a file, like an editor selection.
+type sourceRange struct {
+	File       string
+	Begin, End ast.Location
+}
+
+// parseSourceRange parses a range written as FILE:LINE:COLUMN-LINE:COLUMN, with lines and columns starting at
+// one. The end is the position after the last character of the range.
+func parseSourceRange(s string) (sourceRange, error) {
+	invalid := fmt.Errorf("invalid range %s, wanted FILE:LINE:COLUMN-LINE:COLUMN", s)
+	rest, end, ok := cutLast(s, "-")
+	if !ok {
+		return sourceRange{}, invalid
+	}
+	begin, err := parseSourcePosition(rest)
+	if err != nil {
+		return sourceRange{}, invalid
+	}
+	l, err := parseLineColumn(end)
+	if err != nil {
+		return sourceRange{}, invalid
+	}
+	r := sourceRange{File: begin.File, Begin: begin.Location, End: l}
+	if location.Before(r.End, r.Begin) {
+		return sourceRange{}, fmt.Errorf("invalid range %s, the end is before the beginning", s)
+	}
+	return r, nil
+}
+
+// extractScope is what variables, self, and $ refer to at an expression of a raw AST.
+type extractScope struct {
+	// bindings maps variables to the nodes that bind them.
+	bindings map[ast.Identifier]ast.Node
+	// self is the innermost enclosing object and dollar the outermost, or nil outside of objects.
+	self, dollar ast.Node
+}
+
+// bind returns the scope with the variables bound by the binder.
+func (s extractScope) bind(binder ast.Node, names ...ast.Identifier) extractScope {
+	bindings := make(map[ast.Identifier]ast.Node, len(s.bindings)+len(names))
+	for name, node := range s.bindings {
+		bindings[name] = node
+	}
+	for _, name := range names {
+		bindings[name] = binder
+	}
+	s.bindings = bindings
+	return s
+}
+
+// object returns the scope within the fields of the object.
+func (s extractScope) object(object ast.Node) extractScope {
+	s.self = object
+	if s.dollar == nil {
+		s.dollar = object
+	}
+	return s
+}
+
+// extractSlot is an expression that can be wrapped in a local without parentheses, like the body of a local or
+// function or the value of a field, and the scope that it is in.
+type extractSlot struct {
+	node  ast.Node
+	scope extractScope
+}
+
+// childNodes returns the child expressions of a raw AST node that does not bind variables.
+func childNodes(node ast.Node) []ast.Node {
+	var children []ast.Node
+	var visit func(v reflect.Value)
+	visit = func(v reflect.Value) {
+		switch v.Kind() {
+		case reflect.Interface:
+			if !v.IsNil() && v.Type() == nodeType {
+				children = append(children, v.Interface().(ast.Node))
+			}
+		case reflect.Ptr:
+			if !v.IsNil() {
+				visit(v.Elem())
+			}
+		case reflect.Struct:
+			if v.Type() == reflect.TypeOf(ast.LocationRange{}) {
+				return
+			}
+			for i := 0; i < v.NumField(); i++ {
+				visit(v.Field(i))
+			}
+		case reflect.Slice:
+			for i := 0; i < v.Len(); i++ {
+				visit(v.Index(i))
+			}
+		}
+	}
+	visit(reflect.ValueOf(node).Elem())
+	return children
+}
+
+// forSpecs returns the for and if specifications of a comprehension in source order.
+func forSpecs(spec *ast.ForSpec) []*ast.ForSpec {
+	var specs []*ast.ForSpec
+	for ; spec != nil; spec = spec.Outer {
+		specs = append([]*ast.ForSpec{spec}, specs...)
+	}
+	return specs
+}
+
+// visitScopes calls visit with every expression of the raw AST, the scope that it is in, and the slots that
+// enclose it, from the outermost. An expression that is a slot is the last of its slots.
+func visitScopes(root ast.Node, visit func(node ast.Node, scope extractScope, slots []extractSlot)) {
+	var walk func(node ast.Node, scope extractScope, slots []extractSlot, slot bool)
+	walk = func(node ast.Node, scope extractScope, slots []extractSlot, slot bool) {
+		if node == nil {
+			return
+		}
+		if slot {
+			slots = append(slots[:len(slots):len(slots)], extractSlot{node: node, scope: scope})
+		}
+		visit(node, scope, slots)
+		function := func(f *ast.Function, scope extractScope, body ast.Node) {
+			names := make([]ast.Identifier, len(f.Parameters))
+			for i, param := range f.Parameters {
+				names[i] = param.Name
+			}
+			scope = scope.bind(f, names...)
+			for _, param := range f.Parameters {
+				walk(param.DefaultArg, scope, slots, false)
+			}
+			walk(body, scope, slots, true)
+		}
+		fields := func(object ast.Node, objectFields ast.ObjectFields, scope extractScope) {
+			var locals []ast.Identifier
+			for _, field := range objectFields {
+				if field.Kind == ast.ObjectLocal {
+					locals = append(locals, *field.Id)
+				}
+			}
+			inner := scope.object(object).bind(object, locals...)
+			for _, field := range objectFields {
+				if field.Kind == ast.ObjectFieldExpr {
+					walk(field.Expr1, scope, slots, false)
+				}
+				if field.Method != nil {
+					function(field.Method, inner, field.Expr2)
+				} else {
+					walk(field.Expr2, inner, slots, field.Kind != ast.ObjectAssert)
+				}
+				walk(field.Expr3, inner, slots, false)
+			}
+		}
+		comprehension := func(comp ast.Node, spec *ast.ForSpec, scope extractScope) extractScope {
+			for _, spec := range forSpecs(spec) {
+				walk(spec.Expr, scope, slots, false)
+				scope = scope.bind(comp, spec.VarName)
+				for _, cond := range spec.Conditions {
+					walk(cond.Expr, scope, slots, false)
+				}
+			}
+			return scope
+		}
+		switch n := node.(type) {
+		case *ast.Local:
+			names := make([]ast.Identifier, len(n.Binds))
+			for i, bind := range n.Binds {
+				names[i] = bind.Variable
+			}
+			inner := scope.bind(n, names...)
+			for _, bind := range n.Binds {
+				if bind.Fun != nil {
+					function(bind.Fun, inner, bind.Body)
+				} else {
+					walk(bind.Body, inner, slots, true)
+				}
+			}
+			walk(n.Body, inner, slots, true)
+		case *ast.Function:
+			function(n, scope, n.Body)
+		case *ast.Object:
+			fields(n, n.Fields, scope)
+		case *ast.ObjectComp:
+			fields(n, n.Fields, comprehension(n, &n.Spec, scope))
+		case *ast.ArrayComp:
+			walk(n.Body, comprehension(n, &n.Spec, scope), slots, true)
+		default:
+			for _, child := range childNodes(node) {
+				walk(child, scope, slots, false)
+			}
+		}
+	}
+	walk(root, extractScope{}, nil, true)
+}
+
+// sameExpression returns true if the raw ASTs are the same apart from their whitespace and comments.
+func sameExpression(a, b ast.Node) bool {
+	var same func(a, b reflect.Value) bool
+	same = func(a, b reflect.Value) bool {
+		if a.Type() != b.Type() {
+			return false
+		}
+		switch a.Type() {
+		case reflect.TypeOf(ast.Fodder{}), reflect.TypeOf(ast.LocationRange{}):
+			return true
+		}
+		switch a.Kind() {
+		case reflect.Interface, reflect.Ptr:
+			if a.IsNil() || b.IsNil() {
+				return a.IsNil() == b.IsNil()
+			}
+			return same(a.Elem(), b.Elem())
+		case reflect.Struct:
+			for i := 0; i < a.NumField(); i++ {
+				if !same(a.Field(i), b.Field(i)) {
+					return false
+				}
+			}
+			return true
+		case reflect.Slice:
+			if a.Len() != b.Len() {
+				return false
+			}
+			for i := 0; i < a.Len(); i++ {
+				if !same(a.Index(i), b.Index(i)) {
+					return false
+				}
+			}
+			return true
+		case reflect.Map, reflect.Func, reflect.Chan:
+			// Only desugared ASTs have contexts and free variables.
+			return true
+		}
+		return a.Interface() == b.Interface()
+	}
+	return same(reflect.ValueOf(&a).Elem(), reflect.ValueOf(&b).Elem())
+}
+
+// freeReferences returns the variables that the expression refers to but does not bind, and whether it refers
+// to self or super outside of its own objects and to $.
+func freeReferences(node ast.Node) (vars []ast.Identifier, self, dollar bool) {
+	seen := make(map[ast.Identifier]bool)
+	visitScopes(node, func(node ast.Node, scope extractScope, _ []extractSlot) {
+		switch n := node.(type) {
+		case *ast.Var:
+			if _, ok := scope.bindings[n.Id]; !ok && !seen[n.Id] {
+				seen[n.Id] = true
+				vars = append(vars, n.Id)
+			}
+		case *ast.Self, *ast.SuperIndex, *ast.InSuper:
+			self = self || scope.self == nil
+		case *ast.Dollar:
+			// $ refers to the outermost object, even within the objects of the expression.
+			dollar = true
+		}
+	})
+	return vars, self, dollar
+}
+
+// sameReferences returns true if the free references of an expression refer to the same bindings and objects
+// in both scopes.
+func sameReferences(a, b extractScope, vars []ast.Identifier, self, dollar bool) bool {
+	for _, v := range vars {
+		if a.bindings[v] != b.bindings[v] {
+			return false
+		}
+	}
+	return (!self || a.self == b.self) && (!dollar || a.dollar == b.dollar)
+}
+
+// hasSlot returns true if the node is one of the slots.
+func hasSlot(slots []extractSlot, node ast.Node) bool {
+	for _, slot := range slots {
+		if slot.node == node {
+			return true
+		}
+	}
+	return false
+}
+
+// extractLocal binds the smallest expression of the raw AST that contains the range to a local variable with
+// the name, and replaces the expression with the variable. If all is true, the expressions that are the same
+// apart from whitespace and comments, and refer to the same variables and objects, are replaced too. The local
+// is added at the nearest enclosing scope of the replaced expressions where their variables are bound:
+// the body of a local or function, the value of a field, or the file. It returns the number of replaced
+// expressions.
+func extractLocal(root *ast.Node, r sourceRange, name string, all bool) (int, error) {
+	if parsed, _, err := formatter.SnippetToRawAST("", fmt.Sprintf("local %s = null; null", name)); err != nil || string(parsed.(*ast.Local).Binds[0].Variable) != name {
+		return 0, fmt.Errorf("%s is not a valid variable name", name)
+	}
+
+	type occurrence struct {
+		node  ast.Node
+		scope extractScope
+		slots []extractSlot
+	}
+	var selected *occurrence
+	visitScopes(*root, func(node ast.Node, scope extractScope, slots []extractSlot) {
+		loc := node.Loc()
+		if loc == nil || !loc.IsSet() || location.Before(r.Begin, loc.Begin) || location.Before(loc.End, r.End) {
+			return
+		}
+		// Expressions are visited before the expressions within them.
+		selected = &occurrence{node: node, scope: scope, slots: slots}
+	})
+	if selected == nil {
+		return 0, fmt.Errorf("there is no expression at %d:%d-%d:%d", r.Begin.Line, r.Begin.Column, r.End.Line, r.End.Column)
+	}
+
+	vars, self, dollar := freeReferences(selected.node)
+	valid := func(slot extractSlot) bool {
+		return sameReferences(slot.scope, selected.scope, vars, self, dollar)
+	}
+	occurrences := []occurrence{*selected}
+	if all {
+		// The outermost slot where the variables are bound encloses the occurrences that can be replaced.
+		var outermost ast.Node
+		for _, slot := range selected.slots {
+			if valid(slot) {
+				outermost = slot.node
+				break
+			}
+		}
+		occurrences = nil
+		visitScopes(*root, func(node ast.Node, scope extractScope, slots []extractSlot) {
+			if node == selected.node || hasSlot(slots, outermost) && sameExpression(node, selected.node) && sameReferences(scope, selected.scope, vars, self, dollar) {
+				occurrences = append(occurrences, occurrence{node: node, scope: scope, slots: slots})
+			}
+		})
+	}
+	var scope ast.Node
+	for i := len(selected.slots) - 1; i >= 0 && scope == nil; i-- {
+		slot := selected.slots[i]
+		if !valid(slot) {
+			continue
+		}
+		scope = slot.node
+		for _, o := range occurrences {
+			if !hasSlot(o.slots, slot.node) {
+				scope = nil
+				break
+			}
+		}
+	}
+	if scope == nil {
+		return 0, fmt.Errorf("there is no scope that encloses the expression where its variables are bound")
+	}
+	if variables(scope)[name] {
+		return 0, fmt.Errorf("%s is already used in the scope of the expression", name)
+	}
+
+	replaced := make(map[ast.Node]bool, len(occurrences))
+	for _, o := range occurrences {
+		replaced[o.node] = true
+	}
+	replace := func(node ast.Node) (ast.Node, bool) {
+		if !replaced[node] {
+			return nil, false
+		}
+		return &ast.Var{NodeBase: ast.NodeBase{Fodder: append(ast.Fodder{}, *node.OpenFodder()...)}, Id: ast.Identifier(name)}, true
+	}
+	rewriteNodes(root, func(node ast.Node) (ast.Node, bool) {
+		if node != scope {
+			return nil, false
+		}
+		body := node
+		rewriteNodes(&body, replace)
+		fodder := *node.OpenFodder()
+		*body.OpenFodder() = ast.Fodder{{Kind: ast.FodderLineEnd}}
+		// Within a line, like the value of a field, the local starts on its own line.
+		if node != *root && ast.FodderCountNewlines(fodder) == 0 {
+			fodder = append(ast.Fodder{{Kind: ast.FodderLineEnd}}, fodder...)
+		}
+		return &ast.Local{
+			NodeBase: ast.NodeBase{Fodder: fodder},
+			Binds:    []ast.LocalBind{{Variable: ast.Identifier(name), Body: selected.node}},
+			Body:     body,
+		}, true
+	})
+	*selected.node.OpenFodder() = nil
+	return len(occurrences), nil
+}
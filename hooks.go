@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-jsonnet"
+	"gopkg.in/yaml.v3"
+)
+
+// importHook transforms imported files that match a glob before they are parsed.
+// The VM cannot distinguish import from importstr so files imported with importstr are also transformed.
+type importHook struct {
+	// Glob is matched against the base name of the imported file, or against the
+	// whole path relative to the project directory if it contains a path separator.
+	Glob string `json:"glob"`
+	// Builtin is the name of a builtin transformer.
+	Builtin string `json:"builtin,omitempty"`
+	// Command is a program and its arguments that filters the file from stdin to stdout.
+	// It is only run if the configuration is trusted, in the project directory and the absolute path of the imported file is in
+	// the JSONNET_TOOL_IMPORT environment variable.
+	Command []string `json:"command,omitempty"`
+}
+
+// builtinHooks are the builtin import transformers by name.
+var builtinHooks = map[string]func(contents []byte) ([]byte, error){
+	"strip-bom":    stripBOM,
+	"yaml-to-json": yamlToJSON,
+}
+
+// validate returns an error if the import hook is misconfigured.
+func (h importHook) validate() error {
+	if _, err := filepath.Match(h.Glob, ""); err != nil || h.Glob == "" {
+		return fmt.Errorf("invalid glob %q", h.Glob)
+	}
+	if (h.Builtin == "") == (len(h.Command) == 0) {
+		return errors.New("exactly one of builtin or command must be set")
+	}
+	if _, ok := builtinHooks[h.Builtin]; h.Builtin != "" && !ok {
+		return fmt.Errorf("unknown builtin %s", h.Builtin)
+	}
+	return nil
+}
+
+// matches returns true if the hook applies to the file found at foundAt in the project directory dir.
+func (h importHook) matches(dir, foundAt string) bool {
+	name := filepath.Base(foundAt)
+	if strings.ContainsRune(h.Glob, '/') {
+		rel, err := filepath.Rel(dir, absPath(foundAt))
+		if err != nil {
+			return false
+		}
+		name = filepath.ToSlash(rel)
+	}
+	ok, _ := filepath.Match(h.Glob, name)
+	return ok
+}
+
+// run applies the hook to the contents of the file found at foundAt in the project directory dir.
+func (h importHook) run(dir, foundAt string, contents []byte) ([]byte, error) {
+	if h.Builtin != "" {
+		return builtinHooks[h.Builtin](contents)
+	}
+	if !trustedConfig {
+		return nil, fmt.Errorf("import hook for %s runs %s, which requires --trust-project-config", h.Glob, h.Command[0])
+	}
+	cmd := exec.Command(h.Command[0], h.Command[1:]...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "JSONNET_TOOL_IMPORT="+absPath(foundAt))
+	cmd.Stdin = bytes.NewReader(contents)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w: %s", strings.Join(h.Command, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return output, nil
+}
+
+// hookTransform returns an import transform that applies the matching hooks of the configuration in order.
+func hookTransform(c projectConfig) transform {
+	return func(foundAt string, contents jsonnet.Contents) (jsonnet.Contents, error) {
+		data := contents.Data()
+		transformed := false
+		for _, hook := range c.ImportHooks {
+			if !hook.matches(c.Dir, foundAt) {
+				continue
+			}
+			var err error
+			if data, err = hook.run(c.Dir, foundAt, data); err != nil {
+				return contents, err
+			}
+			transformed = true
+		}
+		if !transformed {
+			return contents, nil
+		}
+		return jsonnet.MakeContentsRaw(data), nil
+	}
+}
+
+// stripBOM removes a leading UTF-8 byte order mark.
+func stripBOM(contents []byte) ([]byte, error) {
+	return bytes.TrimPrefix(contents, []byte("\xef\xbb\xbf")), nil
+}
+
+// yamlToJSON converts YAML to JSON, which is also valid Jsonnet.
+// A stream of more than one YAML document is converted to an array of documents.
+func yamlToJSON(contents []byte) ([]byte, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(contents))
+	var documents []interface{}
+	for {
+		var document interface{}
+		err := decoder.Decode(&document)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse YAML: %w", err)
+		}
+		document, err = jsonCompatible(document)
+		if err != nil {
+			return nil, err
+		}
+		documents = append(documents, document)
+	}
+	if len(documents) == 1 {
+		return json.Marshal(documents[0])
+	}
+	return json.Marshal(documents)
+}
+
+// jsonCompatible converts YAML mappings with non-string keys into objects with string keys.
+func jsonCompatible(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, element := range v {
+			converted, err := jsonCompatible(element)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = converted
+		}
+		return v, nil
+	case map[interface{}]interface{}:
+		object := make(map[string]interface{}, len(v))
+		for key, element := range v {
+			converted, err := jsonCompatible(element)
+			if err != nil {
+				return nil, err
+			}
+			object[fmt.Sprint(key)] = converted
+		}
+		return object, nil
+	case []interface{}:
+		for i, element := range v {
+			converted, err := jsonCompatible(element)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = converted
+		}
+		return v, nil
+	}
+	return value, nil
+}
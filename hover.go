@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/formatter"
+
+	"github.com/jdbaldry/jsonnet-tool/pkg/location"
+	"github.com/jdbaldry/jsonnet-tool/pkg/repl"
+	"github.com/jdbaldry/jsonnet-tool/pkg/traverse"
+	toolvm "github.com/jdbaldry/jsonnet-tool/pkg/vm"
+)
+
+// hoverCaptured and hoverCapture are the names of the native functions that report whether the hovered
+// expression has been captured and that capture the description of its value.
+const (
+	hoverCaptured = "jsonnetToolHoverCaptured"
+	hoverCapture  = "jsonnetToolHoverCapture"
+)
+
+// hoverSafe describes __jt_value as JSON without failing on functions, which are described rather than manifested.
+const hoverSafe = `(local __jt_safe(v) = if std.isFunction(v) then '<function>' else if std.isObject(v) then { [k]: __jt_safe(v[k]) for k in std.objectFields(v) } else if std.isArray(v) then std.map(__jt_safe, v) else v; __jt_safe(__jt_value))`
+
+// containsLocation returns true if the location range contains the location.
+func containsLocation(loc *ast.LocationRange, l ast.Location) bool {
+	return loc != nil && loc.IsSet() && !location.Before(l, loc.Begin) && location.Before(l, loc.End)
+}
+
+// hoverTarget returns the smallest expression of the raw AST that contains the location and can be evaluated on
+// its own. Field names, the paths of imports, and the objects of a { b: c } merge cannot be.
+func hoverTarget(node ast.Node, l ast.Location) (ast.Node, bool) {
+	if loc := node.Loc(); loc == nil || !loc.IsSet() {
+		// Nodes without a location, like the functions of methods, are searched through.
+		for _, child := range traverse.Children(node) {
+			if target, ok := hoverTarget(child, l); ok {
+				return target, true
+			}
+		}
+		return nil, false
+	}
+	if !containsLocation(node.Loc(), l) {
+		return nil, false
+	}
+	var excluded ast.Node
+	switch n := node.(type) {
+	case *ast.Import, *ast.ImportStr, *ast.ImportBin:
+		return node, true
+	case *ast.ApplyBrace:
+		excluded = n.Right
+	case *ast.Object:
+		for _, field := range n.Fields {
+			if field.Kind == ast.ObjectFieldStr && containsLocation(field.Expr1.Loc(), l) {
+				return node, true
+			}
+		}
+	}
+	for _, child := range traverse.Children(node) {
+		if target, ok := hoverTarget(child, l); ok {
+			if target == excluded {
+				return node, true
+			}
+			return target, true
+		}
+	}
+	return node, true
+}
+
+// hover captures the description of the value of an expression the first time that it is evaluated.
+type hover struct {
+	file string
+	// patched is the source of the file with the expression wrapped so that its value is captured.
+	patched string
+	// loc is the location of the expression.
+	loc LocationRange
+
+	mu       sync.Mutex
+	captured bool
+	value    interface{}
+}
+
+// newHover returns a hover of the smallest expression at the location in the input of the file. If shape is true,
+// the shape of the value is captured instead of the value.
+func newHover(file, input string, l ast.Location, shape bool) (*hover, error) {
+	root, _, err := formatter.SnippetToRawAST(file, input)
+	if err != nil {
+		return nil, err
+	}
+	target, ok := hoverTarget(root, l)
+	if !ok {
+		return nil, fmt.Errorf("there is no expression at %s:%d:%d", file, l.Line, l.Column)
+	}
+	loc := target.Loc()
+	begin, end := sourceOffset(input, loc.Begin), sourceOffset(input, loc.End)
+	description := hoverSafe
+	if shape {
+		// The wrapper is kept on one line so that the lines of the file are unchanged.
+		description = "(local shapeValue = __jt_value; " + strings.Join(strings.Fields(repl.ShapeSnippet), " ") + ")"
+	}
+	wrapped := fmt.Sprintf("(local __jt_value = (%s); if std.native('%s')() || std.native('%s')(%s) then __jt_value else __jt_value)",
+		input[begin:end], hoverCaptured, hoverCapture, description)
+	return &hover{file: file, patched: input[:begin] + wrapped + input[end:], loc: makeLocationRange(loc)}, nil
+}
+
+// transform is an import transform that wraps the hovered expression of the file.
+func (h *hover) transform(foundAt string, contents jsonnet.Contents) (jsonnet.Contents, error) {
+	if absPath(foundAt) != absPath(h.file) {
+		return contents, nil
+	}
+	return jsonnet.MakeContents(h.patched), nil
+}
+
+// natives returns the native functions called by the wrapped expression.
+func (h *hover) natives() []*jsonnet.NativeFunction {
+	return []*jsonnet.NativeFunction{{
+		Name: hoverCaptured,
+		Func: func(_ []interface{}) (interface{}, error) {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			return h.captured, nil
+		},
+	}, {
+		Name:   hoverCapture,
+		Params: ast.Identifiers{"value"},
+		Func: func(args []interface{}) (interface{}, error) {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			h.captured, h.value = true, args[0]
+			return true, nil
+		},
+	}}
+}
+
+// evaluate evaluates the entry file, which is the hovered file or imports it, and returns the description of
+// the value of the hovered expression. It returns false if the expression is not evaluated.
+// If the context is cancelled, the context error is returned.
+func (h *hover) evaluate(ctx context.Context, entry string) (interface{}, bool, error) {
+	vm := makeVM()
+	vm.Importer(&transformingImporter{importer: makeImporter(), transforms: []transform{h.transform}})
+	for _, native := range h.natives() {
+		vm.NativeFunction(native)
+	}
+	root, _, err := vm.ImportAST("", entry)
+	if err == nil {
+		_, err = toolvm.Evaluate(ctx, vm, root)
+	}
+	if ctx.Err() != nil {
+		return nil, false, err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	// The evaluation of the file can fail after the expression is evaluated.
+	if h.captured {
+		return h.value, true, nil
+	}
+	return nil, false, err
+}
+
+// describeHover formats the captured description as indented JSON, or as a shape summary if summary is true.
+func describeHover(value interface{}, summary bool) (string, error) {
+	var b strings.Builder
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(value); err != nil {
+		return "", err
+	}
+	if !summary {
+		return b.String(), nil
+	}
+	var s repl.Shape
+	if err := json.Unmarshal([]byte(b.String()), &s); err != nil {
+		return "", err
+	}
+	return s.Describe(), nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/google/go-jsonnet"
+)
+
+// transform rewrites the contents of an imported file that was found at foundAt.
+type transform func(foundAt string, contents jsonnet.Contents) (jsonnet.Contents, error)
+
+// transformingImporter is a jsonnet.Importer that applies transforms to the contents of imported files
+// before they are parsed by the VM.
+type transformingImporter struct {
+	importer   jsonnet.Importer
+	transforms []transform
+
+	mu sync.Mutex
+	// cache holds the transformed contents by the path they were found at.
+	// The VM requires that the same Contents instance is returned every time a path is imported.
+	cache map[string]jsonnet.Contents
+}
+
+// Import imports the file using the wrapped importer and applies each transform in order.
+func (t *transformingImporter) Import(importedFrom, importedPath string) (jsonnet.Contents, string, error) {
+	contents, foundAt, err := t.importer.Import(importedFrom, importedPath)
+	if err != nil {
+		return contents, foundAt, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if cached, ok := t.cache[foundAt]; ok {
+		return cached, foundAt, nil
+	}
+	for _, transform := range t.transforms {
+		contents, err = transform(foundAt, contents)
+		if err != nil {
+			return contents, foundAt, fmt.Errorf("unable to transform import %s: %w", foundAt, err)
+		}
+	}
+	if t.cache == nil {
+		t.cache = make(map[string]jsonnet.Contents)
+	}
+	t.cache[foundAt] = contents
+	return contents, foundAt, nil
+}
+
+// stdinFile is the file argument that makes a command read its input from stdin.
+const stdinFile = "-"
+
+// defaultStdinFilename is the filename used for input read from stdin unless overridden with --filename.
+// Imports are resolved relative to the directory of the filename.
+const defaultStdinFilename = "<stdin>"
+
+// memorySource is input that is not read from disk, like input read from stdin or a generated bundle, if any.
+// It is imported by its filename.
+var memorySource *struct {
+	filename string
+	contents jsonnet.Contents
+}
+
+// setMemorySource makes the contents the input with the filename.
+func setMemorySource(filename string, contents []byte) {
+	memorySource = &struct {
+		filename string
+		contents jsonnet.Contents
+	}{filename, jsonnet.MakeContentsRaw(contents)}
+}
+
+// inputFile returns the file that a command reads given its file argument.
+// If the file argument is "-", stdin is read and served as filename by readInput and makeImporter.
+func inputFile(file, filename string) (string, error) {
+	if file != stdinFile {
+		return file, nil
+	}
+	b, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return "", fmt.Errorf("unable to read stdin: %w", err)
+	}
+	setMemorySource(filename, b)
+	return filename, nil
+}
+
+// readInput reads the file, or the in-memory input if the file is its filename.
+func readInput(file string) ([]byte, error) {
+	if memorySource != nil && absPath(file) == absPath(memorySource.filename) {
+		return memorySource.contents.Data(), nil
+	}
+	return os.ReadFile(file)
+}
+
+// memoryImporter is a jsonnet.Importer that imports the in-memory input by its filename
+// and all other files using the wrapped importer.
+type memoryImporter struct {
+	importer jsonnet.Importer
+}
+
+// Import imports the in-memory input if the imported path resolves to its filename.
+func (m memoryImporter) Import(importedFrom, importedPath string) (jsonnet.Contents, string, error) {
+	path := importedPath
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(importedFrom), importedPath)
+	}
+	if absPath(path) == absPath(memorySource.filename) {
+		return memorySource.contents, memorySource.filename, nil
+	}
+	return m.importer.Import(importedFrom, importedPath)
+}
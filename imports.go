@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+)
+
+// makeEscaper escapes the characters that are special in Makefile rule targets and prerequisites.
+// Backslashes are escaped so that a path ending in one, or containing an escaped character, cannot be confused
+// with an escape, and colons so that they do not separate the targets from the prerequisites.
+var makeEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	":", `\:`,
+	" ", `\ `,
+	"#", `\#`,
+	"$", "$$",
+)
+
+// makeRule produces a Makefile rule declaring that target depends on the main file and its imports.
+// Each import also gets an empty rule of its own so that make does not fail when an
+// import is removed, in the same way as the output of `gcc -MP`.
+// The output is also a valid Ninja depfile.
+func makeRule(target, file string, imports []string) string {
+	builder := strings.Builder{}
+	builder.WriteString(makeEscaper.Replace(target))
+	builder.WriteString(": ")
+	builder.WriteString(makeEscaper.Replace(file))
+	for _, dep := range imports {
+		builder.WriteString(" ")
+		builder.WriteString(makeEscaper.Replace(dep))
+	}
+	builder.WriteString("\n")
+	for _, dep := range imports {
+		builder.WriteString("\n")
+		builder.WriteString(makeEscaper.Replace(dep))
+		builder.WriteString(":\n")
+	}
+	return builder.String()
+}
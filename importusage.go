@@ -0,0 +1,113 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/go-jsonnet"
+)
+
+// importUsage describes whether an imported file was evaluated and how long was spent evaluating it.
+type importUsage struct {
+	File string
+	// Evaluated is false if no import of the file was evaluated and the file was only imported lazily
+	// from code that was never evaluated.
+	Evaluated bool
+	// Imports is the number of evaluated import expressions for the file.
+	Imports int
+	// SelfTime is the time spent evaluating expressions in the file, excluding time spent in other files.
+	// It includes the overhead of instrumentation.
+	SelfTime string
+}
+
+// timingFrame is an entered probe whose evaluation has not yet finished.
+type timingFrame struct {
+	id    int
+	start time.Time
+	// children is the total time spent in probes entered during this probe.
+	children time.Duration
+}
+
+// importRecorder is a jsonnet.Importer that records the evaluated imports and the time spent
+// in each file of an instrumented evaluation.
+// The VM calls the importer every time an import expression is evaluated.
+type importRecorder struct {
+	importer jsonnet.Importer
+	in       *instrumenter
+
+	mu      sync.Mutex
+	imports map[string]int
+	stack   []timingFrame
+	self    map[string]time.Duration
+}
+
+// newImportRecorder returns an importRecorder that wraps importer and times the probes of the instrumenter.
+func newImportRecorder(importer jsonnet.Importer, in *instrumenter) *importRecorder {
+	return &importRecorder{
+		importer: importer,
+		in:       in,
+		imports:  make(map[string]int),
+		self:     make(map[string]time.Duration),
+	}
+}
+
+// Import imports the file using the wrapped importer and records the import.
+func (r *importRecorder) Import(importedFrom, importedPath string) (jsonnet.Contents, string, error) {
+	contents, foundAt, err := r.importer.Import(importedFrom, importedPath)
+	if err == nil {
+		r.mu.Lock()
+		r.imports[absPath(foundAt)]++
+		r.mu.Unlock()
+	}
+	return contents, foundAt, err
+}
+
+// enter records the start of the evaluation of a probe.
+func (r *importRecorder) enter(id int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stack = append(r.stack, timingFrame{id: id, start: time.Now()})
+}
+
+// exit records the end of the evaluation of a probe and attributes the time spent in it,
+// less the time spent in nested probes, to the file containing the probe.
+func (r *importRecorder) exit(id int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.stack) == 0 {
+		return
+	}
+	frame := r.stack[len(r.stack)-1]
+	r.stack = r.stack[:len(r.stack)-1]
+	elapsed := time.Since(frame.start)
+	r.self[absPath(r.in.probe(frame.id).LocationRange.FileName)] += elapsed - frame.children
+	if len(r.stack) > 0 {
+		r.stack[len(r.stack)-1].children += elapsed
+	}
+}
+
+// usage returns the usage of the entrypoint file and each of its transitive imports.
+// Files are sorted by the time spent evaluating them, followed by the files that were never evaluated.
+func (r *importRecorder) usage(file string, dependencies []string) []importUsage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	files := append([]string{absPath(file)}, dependencies...)
+	usage := make([]importUsage, 0, len(files))
+	for _, f := range files {
+		f = absPath(f)
+		usage = append(usage, importUsage{
+			File:      f,
+			Evaluated: r.imports[f] > 0,
+			Imports:   r.imports[f],
+			SelfTime:  r.self[f].String(),
+		})
+	}
+	sort.SliceStable(usage, func(i, j int) bool {
+		if usage[i].Evaluated != usage[j].Evaluated {
+			return usage[i].Evaluated
+		}
+		return r.self[usage[i].File] > r.self[usage[j].File]
+	})
+	return usage
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/formatter"
+
+	"github.com/jdbaldry/jsonnet-tool/pkg/traverse"
+)
+
+// nodeType is the type of AST node fields.
+var nodeType = reflect.TypeOf((*ast.Node)(nil)).Elem()
+
+// rewriteNodes replaces the nodes of the raw Jsonnet AST, including the root, for which rewrite returns true.
+// The replacements are not visited. Nodes that appear more than once, like the bodies of methods, are
+// rewritten once.
+func rewriteNodes(root *ast.Node, rewrite func(ast.Node) (ast.Node, bool)) {
+	rewritten := make(map[ast.Node]ast.Node)
+	var visit func(v reflect.Value)
+	visit = func(v reflect.Value) {
+		switch v.Kind() {
+		case reflect.Interface:
+			if v.IsNil() {
+				return
+			}
+			if v.Type() == nodeType && v.CanSet() {
+				node := v.Interface().(ast.Node)
+				if replacement, ok := rewritten[node]; ok {
+					v.Set(reflect.ValueOf(replacement))
+					return
+				}
+				if replacement, ok := rewrite(node); ok {
+					rewritten[node] = replacement
+					v.Set(reflect.ValueOf(replacement))
+					return
+				}
+				rewritten[node] = node
+			}
+			visit(v.Elem())
+		case reflect.Ptr:
+			if !v.IsNil() {
+				visit(v.Elem())
+			}
+		case reflect.Struct:
+			// Locations refer to the source, which has no nodes.
+			if v.Type() == reflect.TypeOf(ast.LocationRange{}) {
+				return
+			}
+			for i := 0; i < v.NumField(); i++ {
+				visit(v.Field(i))
+			}
+		case reflect.Slice:
+			for i := 0; i < v.Len(); i++ {
+				visit(v.Index(i))
+			}
+		}
+	}
+	visit(reflect.ValueOf(root).Elem())
+}
+
+// importPath returns the path of an import, importstr, or importbin node of a raw AST.
+func importPath(node ast.Node) (*ast.LiteralString, bool) {
+	switch i := node.(type) {
+	case *ast.Import:
+		return i.File, true
+	case *ast.ImportStr:
+		return i.File, true
+	case *ast.ImportBin:
+		return i.File, true
+	}
+	return nil, false
+}
+
+// importTargets returns the imports of the raw AST of the file to inline: those of the target path, as written
+// or resolved from the current directory, or otherwise the import bound to the local variable named target.
+// inObject is set for the imports that are within an object, where $ refers to the object.
+func importTargets(importer jsonnet.Importer, file string, root ast.Node, target string) (imports map[ast.Node]bool, inObject map[ast.Node]bool, err error) {
+	imports, inObject = make(map[ast.Node]bool), make(map[ast.Node]bool)
+	bound := make(map[ast.Node]bool)
+	bind := func(name ast.Identifier, body ast.Node) {
+		if string(name) == target {
+			if _, ok := importPath(unparen(body)); ok {
+				bound[unparen(body)] = true
+			}
+		}
+	}
+	objects := 0
+	err = traverse.Traverse(root,
+		func(node *ast.Node) error {
+			switch i := (*node).(type) {
+			case *ast.Object, *ast.ObjectComp:
+				objects++
+			case *ast.Local:
+				for _, b := range i.Binds {
+					bind(b.Variable, b.Body)
+				}
+			}
+			if object, ok := (*node).(*ast.Object); ok {
+				for _, field := range object.Fields {
+					if field.Kind == ast.ObjectLocal {
+						bind(*field.Id, field.Expr2)
+					}
+				}
+			}
+			path, ok := importPath(*node)
+			if !ok {
+				return nil
+			}
+			inObject[*node] = objects > 0
+			if path.Value == target {
+				imports[*node] = true
+				return nil
+			}
+			if _, foundAt, err := importer.Import(file, path.Value); err == nil && absPath(foundAt) == absPath(target) {
+				imports[*node] = true
+			}
+			return nil
+		},
+		traverse.Nop,
+		func(node *ast.Node) error {
+			switch (*node).(type) {
+			case *ast.Object, *ast.ObjectComp:
+				objects--
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(imports) == 0 {
+		imports = bound
+	}
+	if len(imports) == 0 {
+		return nil, nil, fmt.Errorf("there is no import of %s, or local variable %s bound to an import, in %s", target, target, file)
+	}
+	return imports, inObject, nil
+}
+
+// relocateImports rewrites the relative import paths of the raw AST of the file at from so that they resolve to
+// the same files when the AST is inlined into the file at to. Imports found in the library paths are unchanged.
+func relocateImports(root ast.Node, from, to string) error {
+	return traverse.Traverse(root,
+		func(node *ast.Node) error {
+			path, ok := importPath(*node)
+			if !ok || filepath.IsAbs(path.Value) {
+				return nil
+			}
+			local := filepath.Join(filepath.Dir(from), path.Value)
+			if _, err := os.Stat(local); err != nil {
+				return nil
+			}
+			relocated, err := filepath.Rel(filepath.Dir(to), local)
+			if err != nil {
+				return err
+			}
+			path.Value = filepath.ToSlash(relocated)
+			return nil
+		},
+		traverse.Nop,
+		traverse.Nop,
+	)
+}
+
+// usesDollar returns true if the raw AST refers to $.
+func usesDollar(root ast.Node) bool {
+	found := false
+	traverse.Traverse(root,
+		func(node *ast.Node) error {
+			if _, ok := (*node).(*ast.Dollar); ok {
+				found = true
+			}
+			return nil
+		},
+		traverse.Nop,
+		traverse.Nop,
+	)
+	return found
+}
+
+// inlinedContents returns the expression that replaces the import node: the raw AST of the imported Jsonnet
+// file in parentheses, or a string or array of bytes for importstr and importbin.
+func inlinedContents(imp ast.Node, contents jsonnet.Contents, foundAt, file string) (ast.Node, error) {
+	fodder := imp.OpenFodder()
+	switch imp.(type) {
+	case *ast.ImportStr:
+		return &ast.LiteralString{NodeBase: ast.NodeBase{Fodder: *fodder}, Value: contents.String(), Kind: ast.StringDouble}, nil
+	case *ast.ImportBin:
+		array := &ast.Array{NodeBase: ast.NodeBase{Fodder: *fodder}}
+		for _, b := range contents.Data() {
+			array.Elements = append(array.Elements, ast.CommaSeparatedExpr{Expr: &ast.LiteralNumber{OriginalString: strconv.Itoa(int(b))}})
+		}
+		return array, nil
+	}
+	inner, finalFodder, err := formatter.SnippetToRawAST(foundAt, contents.String())
+	if err != nil {
+		return nil, err
+	}
+	// Comments at the top of the file start on the line after the opening parenthesis.
+	if fodder := inner.OpenFodder(); len(*fodder) > 0 && (*fodder)[0].Kind != ast.FodderLineEnd {
+		*fodder = append(ast.Fodder{{Kind: ast.FodderLineEnd}}, *fodder...)
+	}
+	if err := relocateImports(inner, foundAt, file); err != nil {
+		return nil, err
+	}
+	return &ast.Parens{NodeBase: ast.NodeBase{Fodder: *fodder}, Inner: inner, CloseFodder: finalFodder}, nil
+}
+
+// identifierChars are the characters that cannot be in an identifier.
+var identifierChars = regexp.MustCompile(`[^A-Za-z0-9_]+`)
+
+// variables returns the names of the variables and parameters of the raw AST.
+func variables(root ast.Node) map[string]bool {
+	names := make(map[string]bool)
+	traverse.Traverse(root,
+		func(node *ast.Node) error {
+			switch i := (*node).(type) {
+			case *ast.Var:
+				names[string(i.Id)] = true
+			case *ast.Local:
+				for _, bind := range i.Binds {
+					names[string(bind.Variable)] = true
+				}
+			case *ast.Function:
+				for _, param := range i.Parameters {
+					names[string(param.Name)] = true
+				}
+			case *ast.Object:
+				for _, field := range i.Fields {
+					if field.Kind == ast.ObjectLocal {
+						names[string(*field.Id)] = true
+					}
+				}
+			}
+			return nil
+		},
+		traverse.Nop,
+		traverse.Nop,
+	)
+	return names
+}
+
+// hoistName returns a name for a local variable holding the contents of the imported file that is not one of
+// the used names, and adds it to them.
+func hoistName(used map[string]bool, foundAt string) ast.Identifier {
+	base := identifierChars.ReplaceAllString(strings.TrimSuffix(filepath.Base(foundAt), filepath.Ext(foundAt)), "_")
+	if base == "" || base[0] >= '0' && base[0] <= '9' {
+		base = "_" + base
+	}
+	name := base
+	for i := 2; used[name]; i++ {
+		name = fmt.Sprintf("%s%d", base, i)
+	}
+	used[name] = true
+	return ast.Identifier(name)
+}
+
+// inlineImports replaces the target imports of the raw AST of the file, as found by importTargets, with the
+// contents of the imported files, so that the file no longer needs them. Relative imports of the inlined files
+// are rewritten to resolve from the file. An inlined file that refers to $ is bound to a local variable at the
+// top of the file if it is imported within an object, where $ would refer to the enclosing object instead.
+// It returns the number of imports inlined.
+func inlineImports(importer jsonnet.Importer, file string, root *ast.Node, target string) (int, error) {
+	imports, inObject, err := importTargets(importer, file, *root, target)
+	if err != nil {
+		return 0, err
+	}
+	var hoisted []ast.LocalBind
+	hoistedFiles := make(map[string]ast.Identifier)
+	used := variables(*root)
+	var inlineErr error
+	rewriteNodes(root, func(node ast.Node) (ast.Node, bool) {
+		if !imports[node] || inlineErr != nil {
+			return nil, false
+		}
+		path, _ := importPath(node)
+		contents, foundAt, err := importer.Import(file, path.Value)
+		if err != nil {
+			inlineErr = err
+			return nil, false
+		}
+		if name, ok := hoistedFiles[foundAt]; ok {
+			return &ast.Var{NodeBase: ast.NodeBase{Fodder: *node.OpenFodder()}, Id: name}, true
+		}
+		inlined, err := inlinedContents(node, contents, foundAt, file)
+		if err != nil {
+			inlineErr = fmt.Errorf("unable to parse %s: %w", foundAt, err)
+			return nil, false
+		}
+		if parens, ok := inlined.(*ast.Parens); ok && inObject[node] && usesDollar(parens.Inner) {
+			name := hoistName(used, foundAt)
+			hoistedFiles[foundAt] = name
+			hoisted = append(hoisted, ast.LocalBind{Variable: name, Body: parens.Inner})
+			return &ast.Var{NodeBase: ast.NodeBase{Fodder: *node.OpenFodder()}, Id: name}, true
+		}
+		return inlined, true
+	})
+	if inlineErr != nil {
+		return 0, inlineErr
+	}
+	if len(hoisted) > 0 {
+		// The comments before the file stay at the top.
+		body := *root
+		fodder := *body.OpenFodder()
+		*body.OpenFodder() = ast.Fodder{{Kind: ast.FodderLineEnd}}
+		*root = &ast.Local{NodeBase: ast.NodeBase{Fodder: fodder}, Binds: hoisted, Body: body}
+	}
+	return len(imports), nil
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/formatter"
+
+	"github.com/jdbaldry/jsonnet-tool/pkg/traverse"
+)
+
+// probeMarker prefixes the std.trace messages emitted by probes so that they can be
+// distinguished from the traces of the Jsonnet being evaluated.
+const probeMarker = "jsonnet-tool:"
+
+// probeKind is the kind of evaluation observed by a probe.
+type probeKind string
+
+const (
+	// probeFunction observes the evaluation of a function body, which is a function call.
+	probeFunction probeKind = "function"
+	// probeLocal observes the evaluation of a local variable.
+	probeLocal probeKind = "local"
+	// probeField observes the evaluation of an object field.
+	probeField probeKind = "field"
+	// probeFile observes the evaluation of a whole file.
+	probeFile probeKind = "file"
+)
+
+// probe is a point in the Jsonnet source at which evaluation is observed.
+type probe struct {
+	Kind          probeKind
+	Name          string
+	LocationRange LocationRange
+}
+
+// String returns the location and description of the probe.
+func (p probe) String() string {
+	return fmt.Sprintf("%s %s %s", p.LocationRange, p.Kind, p.Name)
+}
+
+// instrumenter rewrites Jsonnet source so that evaluations are reported through std.trace.
+// Each probed expression e is replaced with:
+//
+//	std.trace(std.trace('jsonnet-tool:>ID', 'jsonnet-tool:<ID'), e)
+//
+// The arguments of std.trace are evaluated in order before the outer message is written,
+// so the enter event is written before e is evaluated and the exit event after.
+type instrumenter struct {
+	mu     sync.Mutex
+	probes []probe
+}
+
+// add records a new probe and returns its ID.
+func (in *instrumenter) add(p probe) int {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.probes = append(in.probes, p)
+	return len(in.probes) - 1
+}
+
+// probe returns the probe with the given ID.
+func (in *instrumenter) probe(id int) probe {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.probes[id]
+}
+
+// wrap returns node wrapped in the std.trace calls for a new probe.
+func (in *instrumenter) wrap(kind probeKind, name string, node ast.Node) ast.Node {
+	p := probe{Kind: kind, Name: name}
+	if loc := node.Loc(); loc != nil {
+		p.LocationRange = makeLocationRange(loc)
+	}
+	return traceProbe(in.add(p), node)
+}
+
+// traceProbe returns node wrapped in the std.trace calls for the probe with the ID.
+func traceProbe(id int, node ast.Node) ast.Node {
+	trace := func(message, rest ast.Node) ast.Node {
+		std, fn := ast.Identifier("std"), ast.Identifier("trace")
+		return &ast.Apply{
+			Target: &ast.Index{Target: &ast.Var{Id: std}, Id: &fn},
+			Arguments: ast.Arguments{Positional: []ast.CommaSeparatedExpr{
+				{Expr: message},
+				{Expr: rest},
+			}},
+		}
+	}
+	enter := &ast.LiteralString{Value: fmt.Sprintf("%s>%d", probeMarker, id), Kind: ast.StringSingle}
+	exit := &ast.LiteralString{Value: fmt.Sprintf("%s<%d", probeMarker, id), Kind: ast.StringSingle}
+	return trace(trace(enter, exit), node)
+}
+
+// instrument inserts probes for every function body, local variable, and object field in the raw Jsonnet AST.
+// The transform also inserts a probe for the whole file.
+func (in *instrumenter) instrument(root ast.Node) error {
+	// Functions that are probed by their enclosing local or object field are not probed again.
+	probed := make(map[*ast.Function]bool)
+	fields := func(fields ast.ObjectFields) {
+		for i := range fields {
+			field := &fields[i]
+			name, ok := fieldName(*field)
+			switch {
+			case field.Kind == ast.ObjectLocal:
+				name = string(*field.Id)
+			case !ok:
+				name = "[computed]"
+			}
+			switch {
+			case field.Kind == ast.ObjectAssert:
+			case field.Method != nil:
+				field.Expr2 = in.wrap(probeFunction, name, field.Expr2)
+				field.Method.Body = field.Expr2
+				probed[field.Method] = true
+			default:
+				field.Expr2 = in.wrap(probeField, name, field.Expr2)
+			}
+		}
+	}
+	return traverse.Traverse(root,
+		func(node *ast.Node) error {
+			switch i := (*node).(type) {
+			case *ast.Local:
+				for j := range i.Binds {
+					bind := &i.Binds[j]
+					if bind.Fun != nil {
+						bind.Body = in.wrap(probeFunction, string(bind.Variable), bind.Body)
+						bind.Fun.Body = bind.Body
+						probed[bind.Fun] = true
+						continue
+					}
+					bind.Body = in.wrap(probeLocal, string(bind.Variable), bind.Body)
+				}
+			case *ast.Object:
+				fields(i.Fields)
+			case *ast.ObjectComp:
+				fields(i.Fields)
+			case *ast.Function:
+				if !probed[i] {
+					i.Body = in.wrap(probeFunction, "anonymous", i.Body)
+				}
+			}
+			return nil
+		},
+		traverse.Nop,
+		traverse.Nop,
+	)
+}
+
+// transform is an import transform that instruments Jsonnet files.
+// Files without a .jsonnet or .libsonnet extension are assumed to be imported with importstr or importbin
+// and are not modified. Files that cannot be parsed are not modified so that the VM reports the error.
+func (in *instrumenter) transform(foundAt string, contents jsonnet.Contents) (jsonnet.Contents, error) {
+	if ext := filepath.Ext(foundAt); ext != ".jsonnet" && ext != ".libsonnet" {
+		return contents, nil
+	}
+	root, finalFodder, err := formatter.SnippetToRawAST(foundAt, contents.String())
+	if err != nil {
+		return contents, nil
+	}
+	if err := in.instrument(root); err != nil {
+		return contents, err
+	}
+	root = in.wrap(probeFile, foundAt, root)
+	output, err := formatter.FormatNode(root, finalFodder, formatter.DefaultOptions())
+	if err != nil {
+		return contents, err
+	}
+	return jsonnet.MakeContents(output), nil
+}
+
+// probeWriter is an io.Writer for std.trace output that dispatches probe events
+// and forwards all other traces to the wrapped writer.
+type probeWriter struct {
+	w       io.Writer
+	onEnter func(id int)
+	onExit  func(id int)
+}
+
+// Write handles a single std.trace message which has the form "TRACE: <file>:<line> <message>\n".
+func (pw *probeWriter) Write(p []byte) (int, error) {
+	line := strings.TrimSuffix(string(p), "\n")
+	i := strings.LastIndex(line, " "+probeMarker)
+	if !strings.HasPrefix(line, "TRACE: ") || i < 0 {
+		return pw.w.Write(p)
+	}
+	event := line[i+1+len(probeMarker):]
+	if len(event) < 2 {
+		return pw.w.Write(p)
+	}
+	id, err := strconv.Atoi(event[1:])
+	if err != nil {
+		return pw.w.Write(p)
+	}
+	switch event[0] {
+	case '>':
+		if pw.onEnter != nil {
+			pw.onEnter(id)
+		}
+	case '<':
+		if pw.onExit != nil {
+			pw.onExit(id)
+		}
+	default:
+		return pw.w.Write(p)
+	}
+	return len(p), nil
+}
+
+// importer returns an importer that instruments the Jsonnet files imported by the wrapped importer.
+func (in *instrumenter) importer(importer jsonnet.Importer) jsonnet.Importer {
+	return &transformingImporter{importer: importer, transforms: []transform{in.transform}}
+}
@@ -0,0 +1,12 @@
+// Package english formats counts of things in English messages.
+package english
+
+import "fmt"
+
+// Plural returns the count and noun, pluralized if the count is not one.
+func Plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// jpathFlags are the directories given by the -J flags of the command, in order of increasing precedence.
+var jpathFlags []string
+
+// jsonnetfiles are the jsonnet-bundler files that mark the root of a project.
+var jsonnetfiles = []string{"jsonnetfile.json", "jsonnetfile.lock.json"}
+
+// findProjectRoot returns the closest directory at or above dir that contains a
+// jsonnet-bundler jsonnetfile.json or jsonnetfile.lock.json.
+// It returns false if there is no such directory.
+func findProjectRoot(dir string) (string, bool) {
+	return findDirContaining(dir, jsonnetfiles)
+}
+
+// findDirContaining returns the closest directory at or above dir that contains any of the named files.
+// It returns false if there is no such directory.
+func findDirContaining(dir string, names []string) (string, bool) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", false
+	}
+	for {
+		for _, name := range names {
+			if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+				return dir, true
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+// bundlerJPaths returns the JPaths of the jsonnet-bundler project containing dir.
+// Like Tanka, the lib directory takes precedence over the vendor directory.
+// Paths later in the slice take precedence in the go-jsonnet FileImporter.
+func bundlerJPaths(dir string) []string {
+	root, ok := findProjectRoot(dir)
+	if !ok {
+		return nil
+	}
+	return []string{filepath.Join(root, "vendor"), filepath.Join(root, "lib")}
+}
+
+// projectJPaths returns the JPaths derived from the jsonnet-bundler project and the project configuration
+// of the current directory, in order of increasing precedence.
+func projectJPaths() []string {
+	return append(bundlerJPaths("."), config.jpaths()...)
+}
+
+// containsPath returns true if any of the paths is the same file path as path.
+func containsPath(paths []string, path string) bool {
+	for _, p := range paths {
+		if absPath(p) == absPath(path) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,72 +1,63 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"path/filepath"
+	"strings"
 
-	"github.com/google/go-jsonnet"
-	"github.com/google/go-jsonnet/ast"
+	"github.com/jdbaldry/jsonnet-tool/pkg/layers"
 )
 
-// layer is an intermediate Jsonnet evaluation and its location.
-type layer struct {
-	Evaluation    string
+// layerFile describes a layer written to its own file.
+type layerFile struct {
+	// File is the name of the file in the layers directory.
+	File          string
 	LocationRange LocationRange
 }
 
-// evaluatesToObject returns a boolean representing whether or not the evaluation of a Jsonnet
-// node evaluates to a JSON object value.
-// TODO: implement.
-func evaluatesToObject(node *ast.Node) bool {
-	return true
+// layerFileName returns the name of the file of the ith of n layers, named by the source location of the merge,
+// like 03_envs-prod.libsonnet_L42.json.
+func layerFileName(i, n int, loc LocationRange) string {
+	width := len(fmt.Sprint(n - 1))
+	if width < 2 {
+		width = 2
+	}
+	name := filepath.ToSlash(filepath.Clean(loc.FileName))
+	for strings.HasPrefix(name, "../") {
+		name = strings.TrimPrefix(name, "../")
+	}
+	name = strings.ReplaceAll(strings.TrimPrefix(name, "/"), "/", "-")
+	return fmt.Sprintf("%0*d_%s_L%d.json", width, i, name, loc.Begin.Line)
 }
 
-// findLayers returns intermediate layers of evaluation of the top level Jsonnet. The first layer in the slice is the final evaluation.
-// Each subsequent layer steps through the binary merges of objects.
-// For example: { a: 1 } + { a: 2 } would return layers:
-// { "a": 2 }
-// { "a": 1 }
-func findLayers(vm *jsonnet.VM, root ast.Node) (layers []layer, err error) {
-	final, err := vm.Evaluate(root)
+// writeLayers writes each layer to its own file in dir, in order, and an index.json describing the ordering.
+// Either all of the files are written or none are. It returns the paths of the written files.
+func writeLayers(dir string, found []layers.Layer) ([]string, error) {
+	staged := &stagedFiles{}
+	index := make([]layerFile, len(found))
+	var paths []string
+	for i, l := range found {
+		index[i] = layerFile{File: layerFileName(i, len(found), l.LocationRange), LocationRange: l.LocationRange}
+		path := filepath.Join(dir, index[i].File)
+		if err := staged.write(path, []byte(l.Evaluation)); err != nil {
+			staged.discard()
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	b, err := json.MarshalIndent(index, "", "  ")
 	if err != nil {
-		return layers, fmt.Errorf("error evaluating root Jsonnet: %w", err)
+		staged.discard()
+		return nil, err
 	}
-	layers = append(layers, layer{
-		Evaluation: final,
-		LocationRange: LocationRange{
-			FileName: root.Loc().FileName,
-			Begin:    root.Loc().Begin,
-			End:      root.Loc().End,
-		},
-	})
-
-	// Perform a pre-order traversal of the AST, removing the RHS of any '+' binary operation performed on objects.
-	err = traverse(root,
-		func(node *ast.Node) error {
-			switch i := (*node).(type) {
-			case *ast.Binary:
-				if i.Op == ast.BopPlus {
-					if evaluatesToObject(&i.Right) {
-						intermediate := layer{
-							LocationRange: LocationRange{
-								FileName: i.Left.Loc().FileName,
-								Begin:    i.Left.Loc().Begin,
-								End:      i.Left.Loc().End,
-							},
-						}
-						i.Right = &ast.DesugaredObject{}
-						intermediate.Evaluation, err = vm.Evaluate(root)
-						// Not all errors are evaluation errors but for simplicity, this is ignored.
-						if err != nil {
-							intermediate.Evaluation = fmt.Sprintln(err)
-						}
-						layers = append(layers, intermediate)
-					}
-				}
-			}
-			return nil
-		},
-		nop,
-		nop,
-	)
-	return
+	path := filepath.Join(dir, "index.json")
+	if err := staged.write(path, append(b, '\n')); err != nil {
+		staged.discard()
+		return nil, err
+	}
+	if err := staged.commit(); err != nil {
+		return nil, err
+	}
+	return append(paths, path), nil
 }
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+// timedOutCode is the exit code of a command whose evaluation exceeded --timeout, following timeout(1).
+const timedOutCode = 124
+
+// defaultMaxStack and defaultMaxTrace are the go-jsonnet defaults for the maximum number of stack frames of
+// evaluations and of the stack traces of errors.
+const (
+	defaultMaxStack = 500
+	defaultMaxTrace = 20
+)
+
+// evalLimits are the resource limits of the evaluations of a command.
+type evalLimits struct {
+	maxStack int
+	// maxTrace is the maximum number of stack frames in the stack traces of errors, or 0 for all of them.
+	maxTrace int
+	// timeout is the maximum duration of evaluation, if it is positive.
+	timeout time.Duration
+	// maxOutputBytes is the maximum size of the output, if it is positive.
+	maxOutputBytes int
+}
+
+// limits are the resource limits of the command, set by addLimitFlags. makeVM applies the stack limits and the
+// commands apply the others.
+var limits = evalLimits{maxStack: defaultMaxStack, maxTrace: defaultMaxTrace}
+
+// addLimitFlags adds the flags that set the resource limits of evaluation to the flags of a command.
+func addLimitFlags(flags *flag.FlagSet) {
+	flags.IntVar(&limits.maxStack, "max-stack", defaultMaxStack, "maximum number of stack frames, like nested function calls, before evaluation fails")
+	flags.IntVar(&limits.maxTrace, "max-trace", defaultMaxTrace, "maximum number of stack frames in the stack traces of errors, or 0 for all of them")
+	flags.DurationVar(&limits.timeout, "timeout", 0, "stop evaluating and fail after this duration, like 30s or 5m (default is no timeout)")
+	flags.IntVar(&limits.maxOutputBytes, "max-output-bytes", 0, "fail instead of writing output larger than this many bytes (default is no limit)")
+}
+
+// maxTraceOption returns the MaxTrace of the VM options for the limit.
+func (l evalLimits) maxTraceOption() int {
+	if l.maxTrace == 0 {
+		return -1
+	}
+	return l.maxTrace
+}
+
+// withTimeout returns a context that is also cancelled when the timeout elapses, if there is one.
+func (l evalLimits) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if l.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, l.timeout)
+}
+
+// checkOutput returns an error if the output is larger than the maximum size.
+func (l evalLimits) checkOutput(output []byte) error {
+	if l.maxOutputBytes > 0 && len(output) > l.maxOutputBytes {
+		return fmt.Errorf("output of %d bytes is larger than --max-output-bytes %d", len(output), l.maxOutputBytes)
+	}
+	return nil
+}
+
+// stopped exits because the evaluation of the context was stopped, either by --timeout or because the command
+// was interrupted.
+func stopped(ctx context.Context) {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		fmt.Fprintf(os.Stderr, "Evaluation timed out after %s\n", limits.timeout)
+		exit(timedOutCode)
+	}
+	interrupted()
+}
@@ -0,0 +1,674 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/formatter"
+
+	"github.com/jdbaldry/jsonnet-tool/pkg/location"
+	"github.com/jdbaldry/jsonnet-tool/pkg/traverse"
+)
+
+// textEdit replaces the source in a location range with new text.
+type textEdit struct {
+	LocationRange LocationRange
+	NewText       string
+}
+
+// diagnostic is a problem found in Jsonnet source by a lint rule.
+type diagnostic struct {
+	Rule          string
+	Message       string
+	LocationRange LocationRange
+	// Fix is an optional edit that fixes the problem.
+	Fix *textEdit
+}
+
+// String returns the diagnostic in the same format as go-jsonnet static errors so that editor
+// error checkers like flycheck can parse it.
+func (d diagnostic) String() string {
+	return fmt.Sprintf("%s %s [%s]", d.LocationRange, d.Message, d.Rule)
+}
+
+// lintFile is a file being linted.
+type lintFile struct {
+	Name  string
+	Input string
+	// Root is the raw AST of the file.
+	Root ast.Node
+}
+
+// lintRule is an individually toggleable check of a raw Jsonnet AST.
+type lintRule struct {
+	Name        string
+	Description string
+	// Check returns the diagnostics for the file.
+	Check func(f lintFile) []diagnostic
+}
+
+// lintRules are all the available lint rules.
+var lintRules = []lintRule{
+	{
+		Name:        "unused-local",
+		Description: "local variables that are never referenced",
+		Check:       checkUnusedLocals,
+	},
+	{
+		Name:        "shadowed-variable",
+		Description: "variables that shadow a variable from an enclosing scope",
+		Check:       checkShadowedVariables,
+	},
+	{
+		Name:        "override-without-plus",
+		Description: "object fields that replace an inherited object with ':' instead of merging with '+:', making the inherited fields unreachable",
+		Check:       checkOverridesWithoutPlus,
+	},
+	{
+		Name:        "string-concatenation",
+		Description: "string concatenation that is clearer using '%' formatting",
+		Check:       checkStringConcatenation,
+	},
+	{
+		Name:        "bare-error",
+		Description: "error expressions with an empty message and assertions without a message",
+		Check:       checkBareErrors,
+	},
+	{
+		Name:        "unicode-invisible",
+		Description: "invisible characters in strings, like zero width spaces and bidirectional controls, which are fixed by escaping them",
+		Check:       checkInvisibleCharacters,
+	},
+	{
+		Name:        "unicode-normalization",
+		Description: "strings that are not in Unicode Normalization Form C, like a letter followed by a combining accent that has a precomposed form",
+		Check:       checkNormalization,
+	},
+	{
+		Name:        "unicode-byte-slicing",
+		Description: "indexing or slicing of std.encodeUTF8 bytes, which can split multi-byte characters",
+		Check:       checkByteSlicing,
+	},
+}
+
+// lintConfig configures which lint rules are enabled.
+// It can be read from a JSON file like {"rules": {"string-concatenation": false}}.
+type lintConfig struct {
+	Rules map[string]bool `json:"rules"`
+}
+
+// readLintConfig reads a lint configuration file.
+func readLintConfig(path string) (lintConfig, error) {
+	var config lintConfig
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return config, fmt.Errorf("unable to read lint configuration: %w", err)
+	}
+	if err := json.Unmarshal(b, &config); err != nil {
+		return config, fmt.Errorf("unable to parse lint configuration %s: %w", path, err)
+	}
+	for name := range config.Rules {
+		if _, ok := findLintRule(name); !ok {
+			return config, fmt.Errorf("unknown lint rule %s in %s", name, path)
+		}
+	}
+	return config, nil
+}
+
+// lintSettings returns the lint configuration of the configFile, or of the project configuration if configFile
+// is empty, with the comma separated rules of enable and disable enabled and disabled. The rules of the flags
+// take precedence over the configuration, and disable takes precedence over enable.
+func lintSettings(enable, disable, configFile string) (lintConfig, error) {
+	settings := lintConfig{Rules: make(map[string]bool)}
+	base := config.Lint
+	if configFile != "" {
+		var err error
+		if base, err = readLintConfig(configFile); err != nil {
+			return settings, err
+		}
+	}
+	for name, enabled := range base.Rules {
+		settings.Rules[name] = enabled
+	}
+	for _, rules := range []struct {
+		names   string
+		enabled bool
+	}{{enable, true}, {disable, false}} {
+		if rules.names == "" {
+			continue
+		}
+		for _, name := range strings.Split(rules.names, ",") {
+			if _, ok := findLintRule(name); !ok {
+				return settings, fmt.Errorf("unknown lint rule %s", name)
+			}
+			settings.Rules[name] = rules.enabled
+		}
+	}
+	return settings, nil
+}
+
+// findLintRule returns the lint rule with the given name.
+func findLintRule(name string) (lintRule, bool) {
+	for _, rule := range lintRules {
+		if rule.Name == name {
+			return rule, true
+		}
+	}
+	return lintRule{}, false
+}
+
+// enabled returns true if the rule is enabled. Rules are enabled unless configured otherwise.
+func (c lintConfig) enabled(rule string) bool {
+	enabled, ok := c.Rules[rule]
+	return !ok || enabled
+}
+
+// lint runs the enabled lint rules on a file and returns the diagnostics in source order.
+func lint(file string, config lintConfig) ([]diagnostic, error) {
+	input, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read file: %w", err)
+	}
+	root, _, err := formatter.SnippetToRawAST(file, string(input))
+	if err != nil {
+		return nil, err
+	}
+	var diagnostics []diagnostic
+	for _, rule := range lintRules {
+		if !config.enabled(rule.Name) {
+			continue
+		}
+		diagnostics = append(diagnostics, rule.Check(lintFile{Name: file, Input: string(input), Root: root})...)
+	}
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		return location.Before(diagnostics[i].LocationRange.Begin, diagnostics[j].LocationRange.Begin)
+	})
+	return diagnostics, nil
+}
+
+// binding is a variable bound in a scope.
+type binding struct {
+	loc  LocationRange
+	used bool
+	// local is true for local variables, as opposed to function parameters and comprehension variables.
+	local bool
+}
+
+// scope is a lexical scope of variables.
+type scope struct {
+	parent   *scope
+	bindings map[ast.Identifier]*binding
+	order    []ast.Identifier
+}
+
+// newScope returns a new scope nested in parent.
+func newScope(parent *scope) *scope {
+	return &scope{parent: parent, bindings: make(map[ast.Identifier]*binding)}
+}
+
+// lookup returns the binding of the variable in the closest scope.
+func (s *scope) lookup(id ast.Identifier) *binding {
+	for ; s != nil; s = s.parent {
+		if b, ok := s.bindings[id]; ok {
+			return b
+		}
+	}
+	return nil
+}
+
+// scopeWalker walks a raw Jsonnet AST tracking the variables in scope.
+// It reports variables that are never used and variables that shadow another variable.
+type scopeWalker struct {
+	onUnused func(id ast.Identifier, b *binding)
+	onShadow func(id ast.Identifier, b *binding, shadowed *binding)
+}
+
+// declare binds a variable in the scope.
+func (w *scopeWalker) declare(s *scope, id ast.Identifier, loc *ast.LocationRange, local bool) {
+	b := &binding{loc: makeLocationRange(loc), local: local}
+	if shadowed := s.parent.lookup(id); shadowed != nil && w.onShadow != nil {
+		w.onShadow(id, b, shadowed)
+	}
+	s.bindings[id] = b
+	s.order = append(s.order, id)
+}
+
+// close reports the unused variables of a scope that is no longer needed.
+func (w *scopeWalker) close(s *scope) {
+	for _, id := range s.order {
+		if b := s.bindings[id]; !b.used && b.local && w.onUnused != nil {
+			w.onUnused(id, b)
+		}
+	}
+}
+
+// function walks a function with the parameters and body.
+func (w *scopeWalker) function(params []ast.Parameter, body ast.Node, s *scope) {
+	inner := newScope(s)
+	for i := range params {
+		w.declare(inner, params[i].Name, &params[i].LocRange, false)
+	}
+	for _, param := range params {
+		if param.DefaultArg != nil {
+			w.walk(param.DefaultArg, inner)
+		}
+	}
+	w.walk(body, inner)
+	w.close(inner)
+}
+
+// object walks object fields. Computed field names are evaluated outside of the object scope.
+func (w *scopeWalker) object(fields ast.ObjectFields, outer, s *scope) {
+	for _, field := range fields {
+		if field.Kind == ast.ObjectFieldExpr {
+			w.walk(field.Expr1, outer)
+		}
+	}
+	inner := newScope(s)
+	for i := range fields {
+		if fields[i].Kind == ast.ObjectLocal {
+			w.declare(inner, *fields[i].Id, &fields[i].LocRange, true)
+		}
+	}
+	for _, field := range fields {
+		if field.Method != nil {
+			w.function(field.Method.Parameters, field.Expr2, inner)
+			continue
+		}
+		if field.Expr2 != nil {
+			w.walk(field.Expr2, inner)
+		}
+		if field.Expr3 != nil {
+			w.walk(field.Expr3, inner)
+		}
+	}
+	w.close(inner)
+}
+
+// forSpecs walks comprehension specifications from the outermost and returns the scope of the innermost.
+func (w *scopeWalker) forSpecs(spec *ast.ForSpec, s *scope) *scope {
+	var specs []*ast.ForSpec
+	for ; spec != nil; spec = spec.Outer {
+		specs = append([]*ast.ForSpec{spec}, specs...)
+	}
+	for _, spec := range specs {
+		w.walk(spec.Expr, s)
+		s = newScope(s)
+		w.declare(s, spec.VarName, spec.Expr.Loc(), false)
+		for _, cond := range spec.Conditions {
+			w.walk(cond.Expr, s)
+		}
+	}
+	return s
+}
+
+// walk walks the node in the scope.
+func (w *scopeWalker) walk(node ast.Node, s *scope) {
+	if node == nil {
+		return
+	}
+	switch i := node.(type) {
+	case *ast.Var:
+		if b := s.lookup(i.Id); b != nil {
+			b.used = true
+		}
+	case *ast.Local:
+		inner := newScope(s)
+		for j := range i.Binds {
+			w.declare(inner, i.Binds[j].Variable, &i.Binds[j].LocRange, true)
+		}
+		for _, bind := range i.Binds {
+			if bind.Fun != nil {
+				w.function(bind.Fun.Parameters, bind.Body, inner)
+				continue
+			}
+			w.walk(bind.Body, inner)
+		}
+		w.walk(i.Body, inner)
+		w.close(inner)
+	case *ast.Function:
+		w.function(i.Parameters, i.Body, s)
+	case *ast.Object:
+		w.object(i.Fields, s, s)
+	case *ast.ObjectComp:
+		w.object(i.Fields, s, w.forSpecs(&i.Spec, s))
+	case *ast.ArrayComp:
+		w.walk(i.Body, w.forSpecs(&i.Spec, s))
+	default:
+		for _, child := range traverse.Children(node) {
+			w.walk(child, s)
+		}
+	}
+}
+
+// rootScope returns the scope containing the standard library.
+func rootScope() *scope {
+	s := newScope(nil)
+	s.bindings["std"] = &binding{used: true}
+	return s
+}
+
+// checkUnusedLocals reports local variables that are never referenced.
+func checkUnusedLocals(f lintFile) (diagnostics []diagnostic) {
+	w := scopeWalker{onUnused: func(id ast.Identifier, b *binding) {
+		diagnostics = append(diagnostics, diagnostic{
+			Rule:          "unused-local",
+			Message:       fmt.Sprintf("Local variable %s is never used", id),
+			LocationRange: b.loc,
+		})
+	}}
+	w.walk(f.Root, rootScope())
+	return diagnostics
+}
+
+// checkShadowedVariables reports variables that shadow a variable from an enclosing scope.
+func checkShadowedVariables(f lintFile) (diagnostics []diagnostic) {
+	w := scopeWalker{onShadow: func(id ast.Identifier, b *binding, shadowed *binding) {
+		message := fmt.Sprintf("Variable %s shadows the variable at %s", id, shadowed.loc)
+		if !shadowed.loc.Begin.IsSet() {
+			message = fmt.Sprintf("Variable %s shadows the standard library", id)
+		}
+		diagnostics = append(diagnostics, diagnostic{
+			Rule:          "shadowed-variable",
+			Message:       message,
+			LocationRange: b.loc,
+		})
+	}}
+	w.walk(f.Root, rootScope())
+	return diagnostics
+}
+
+// staticObject returns the fields of the object that an expression evaluates to, if it can be determined
+// from the raw AST. It follows merges, locals, and imports relative to the importing file.
+// Fields of later objects in a merge replace those of earlier objects.
+func staticObject(node ast.Node, file string, env map[ast.Identifier]ast.Node, depth int) (map[string]ast.ObjectField, bool) {
+	if node == nil || depth > 100 {
+		return nil, false
+	}
+	switch i := node.(type) {
+	case *ast.Object:
+		fields := make(map[string]ast.ObjectField)
+		for _, field := range i.Fields {
+			if name, ok := fieldName(field); ok {
+				fields[name] = field
+			}
+		}
+		return fields, true
+	case *ast.Parens:
+		return staticObject(i.Inner, file, env, depth+1)
+	case *ast.Binary:
+		if i.Op != ast.BopPlus {
+			return nil, false
+		}
+		return mergeStaticObjects(i.Left, i.Right, file, env, depth)
+	case *ast.ApplyBrace:
+		return mergeStaticObjects(i.Left, i.Right, file, env, depth)
+	case *ast.Var:
+		return staticObject(env[i.Id], file, env, depth+1)
+	case *ast.Local:
+		return staticObject(i.Body, file, bindLocals(i, env), depth+1)
+	case *ast.Import:
+		path := filepath.Join(filepath.Dir(file), i.File.Value)
+		input, err := os.ReadFile(path)
+		if err != nil {
+			return nil, false
+		}
+		root, _, err := formatter.SnippetToRawAST(path, string(input))
+		if err != nil {
+			return nil, false
+		}
+		return staticObject(root, path, map[ast.Identifier]ast.Node{}, depth+1)
+	}
+	return nil, false
+}
+
+// mergeStaticObjects returns the fields of the merge of two objects.
+func mergeStaticObjects(left, right ast.Node, file string, env map[ast.Identifier]ast.Node, depth int) (map[string]ast.ObjectField, bool) {
+	l, ok := staticObject(left, file, env, depth+1)
+	if !ok {
+		return nil, false
+	}
+	r, ok := staticObject(right, file, env, depth+1)
+	if !ok {
+		return nil, false
+	}
+	fields := make(map[string]ast.ObjectField, len(l)+len(r))
+	for name, field := range l {
+		fields[name] = field
+	}
+	for name, field := range r {
+		fields[name] = field
+	}
+	return fields, true
+}
+
+// bindLocals returns a copy of env extended with the non-function binds of the local.
+func bindLocals(local *ast.Local, env map[ast.Identifier]ast.Node) map[ast.Identifier]ast.Node {
+	extended := make(map[ast.Identifier]ast.Node, len(env)+len(local.Binds))
+	for id, node := range env {
+		extended[id] = node
+	}
+	for _, bind := range local.Binds {
+		if bind.Fun == nil {
+			extended[bind.Variable] = bind.Body
+		}
+	}
+	return extended
+}
+
+// checkOverridesWithoutPlus reports fields in the right hand side of an object merge that replace an
+// object field of the left hand side with another object using ':' rather than '+:'.
+// Any fields of the inherited object that are not also in the replacement become unreachable.
+func checkOverridesWithoutPlus(f lintFile) (diagnostics []diagnostic) {
+	file := f.Name
+	check := func(left, right ast.Node, env map[ast.Identifier]ast.Node) {
+		obj, ok := right.(*ast.Object)
+		if !ok {
+			return
+		}
+		inherited, ok := staticObject(left, file, env, 0)
+		if !ok {
+			return
+		}
+		for _, field := range obj.Fields {
+			name, ok := fieldName(field)
+			if !ok || field.SuperSugar || field.Kind == ast.ObjectLocal {
+				continue
+			}
+			base, ok := inherited[name]
+			if !ok || base.Method != nil || field.Method != nil {
+				continue
+			}
+			baseFields, ok := staticObject(base.Expr2, file, env, 0)
+			if !ok {
+				continue
+			}
+			fields, ok := field.Expr2.(*ast.Object)
+			if !ok {
+				continue
+			}
+			replacement, _ := staticObject(fields, file, env, 0)
+			var unreachable []string
+			for sub := range baseFields {
+				if _, ok := replacement[sub]; !ok {
+					unreachable = append(unreachable, sub)
+				}
+			}
+			if len(unreachable) == 0 {
+				continue
+			}
+			sort.Strings(unreachable)
+			diagnostics = append(diagnostics, diagnostic{
+				Rule: "override-without-plus",
+				Message: fmt.Sprintf("Field %s replaces the inherited field at %s without '+:' so its fields %s are unreachable",
+					name, makeLocationRange(&base.LocRange), strings.Join(unreachable, ", ")),
+				LocationRange: makeLocationRange(&field.LocRange),
+			})
+		}
+	}
+
+	var walk func(node ast.Node, env map[ast.Identifier]ast.Node)
+	walk = func(node ast.Node, env map[ast.Identifier]ast.Node) {
+		if node == nil {
+			return
+		}
+		switch i := node.(type) {
+		case *ast.Binary:
+			if i.Op == ast.BopPlus {
+				check(i.Left, i.Right, env)
+			}
+		case *ast.ApplyBrace:
+			check(i.Left, i.Right, env)
+		case *ast.Local:
+			env = bindLocals(i, env)
+		}
+		for _, child := range traverse.Children(node) {
+			walk(child, env)
+		}
+	}
+	walk(f.Root, map[ast.Identifier]ast.Node{})
+	return diagnostics
+}
+
+// concatenation returns the operands of a chain of '+' operations.
+func concatenation(node ast.Node) []ast.Node {
+	if b, ok := node.(*ast.Binary); ok && b.Op == ast.BopPlus {
+		return append(concatenation(b.Left), concatenation(b.Right)...)
+	}
+	return []ast.Node{node}
+}
+
+// checkStringConcatenation reports chains of '+' operations that interleave at least two string
+// literals with other expressions, like 'a-' + b + '-c', which is clearer as 'a-%s-c' % b.
+func checkStringConcatenation(f lintFile) (diagnostics []diagnostic) {
+	seen := make(map[ast.Node]bool)
+	traverse.Traverse(f.Root,
+		func(node *ast.Node) error {
+			b, ok := (*node).(*ast.Binary)
+			if !ok || b.Op != ast.BopPlus || seen[b] {
+				return nil
+			}
+			// Nested operations of the chain are reported with the outermost operation.
+			for _, inner := range binaryChain(b) {
+				seen[inner] = true
+			}
+			var literals, others int
+			for _, operand := range concatenation(b) {
+				if _, ok := operand.(*ast.LiteralString); ok {
+					literals++
+				} else {
+					others++
+				}
+			}
+			if literals >= 2 && others >= 1 {
+				diagnostics = append(diagnostics, diagnostic{
+					Rule:          "string-concatenation",
+					Message:       "String concatenation is clearer using '%' formatting",
+					LocationRange: makeLocationRange(b.Loc()),
+				})
+			}
+			return nil
+		},
+		traverse.Nop,
+		traverse.Nop,
+	)
+	return diagnostics
+}
+
+// binaryChain returns the '+' operations of a chain.
+func binaryChain(node ast.Node) (chain []*ast.Binary) {
+	if b, ok := node.(*ast.Binary); ok && b.Op == ast.BopPlus {
+		chain = append(chain, b)
+		chain = append(chain, binaryChain(b.Left)...)
+		chain = append(chain, binaryChain(b.Right)...)
+	}
+	return chain
+}
+
+// checkBareErrors reports error expressions with an empty message and assertions without a message.
+func checkBareErrors(f lintFile) (diagnostics []diagnostic) {
+	traverse.Traverse(f.Root,
+		func(node *ast.Node) error {
+			switch i := (*node).(type) {
+			case *ast.Error:
+				if s, ok := i.Expr.(*ast.LiteralString); ok && strings.TrimSpace(s.Value) == "" {
+					diagnostics = append(diagnostics, diagnostic{
+						Rule:          "bare-error",
+						Message:       "Error has an empty message",
+						LocationRange: makeLocationRange(i.Loc()),
+					})
+				}
+			case *ast.Assert:
+				if i.Message == nil {
+					diagnostics = append(diagnostics, diagnostic{
+						Rule:          "bare-error",
+						Message:       "Assertion has no message",
+						LocationRange: makeLocationRange(i.Loc()),
+					})
+				}
+			case *ast.Object:
+				for _, field := range i.Fields {
+					if field.Kind == ast.ObjectAssert && field.Expr3 == nil {
+						diagnostics = append(diagnostics, diagnostic{
+							Rule:          "bare-error",
+							Message:       "Assertion has no message",
+							LocationRange: makeLocationRange(&field.LocRange),
+						})
+					}
+				}
+			}
+			return nil
+		},
+		traverse.Nop,
+		traverse.Nop,
+	)
+	return diagnostics
+}
+
+// applyFixes applies the fixes of the diagnostics to the input and returns the fixed input and
+// the number of fixes applied. Fixes that overlap an earlier fix are not applied.
+func applyFixes(input string, diagnostics []diagnostic) (string, int) {
+	var edits []textEdit
+	for _, d := range diagnostics {
+		if d.Fix != nil {
+			edits = append(edits, *d.Fix)
+		}
+	}
+	return applyEdits(input, edits)
+}
+
+// applyEdits applies the edits to the input and returns the edited input and the number of edits applied.
+// Edits that overlap an earlier edit are not applied.
+func applyEdits(input string, edits []textEdit) (string, int) {
+	type edit struct {
+		begin, end int
+		text       string
+	}
+	offsets := make([]edit, 0, len(edits))
+	for _, e := range edits {
+		offsets = append(offsets, edit{
+			begin: sourceOffset(input, e.LocationRange.Begin),
+			end:   sourceOffset(input, e.LocationRange.End),
+			text:  e.NewText,
+		})
+	}
+	sort.SliceStable(offsets, func(i, j int) bool { return offsets[i].begin < offsets[j].begin })
+	var edited strings.Builder
+	applied, offset := 0, 0
+	for _, e := range offsets {
+		if e.begin < offset {
+			continue
+		}
+		edited.WriteString(input[offset:e.begin])
+		edited.WriteString(e.text)
+		offset = e.end
+		applied++
+	}
+	edited.WriteString(input[offset:])
+	return edited.String(), applied
+}
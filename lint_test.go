@@ -0,0 +1,96 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// lintSnippet lints the snippet as the file test.jsonnet with the rules of the configuration and returns the
+// diagnostics.
+func lintSnippet(t *testing.T, snippet string, config lintConfig) []string {
+	t.Helper()
+	inTempDir(t, map[string]string{"test.jsonnet": snippet})
+	diagnostics, err := lint("test.jsonnet", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, d := range diagnostics {
+		got = append(got, d.String())
+	}
+	return got
+}
+
+func TestLint(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		snippet string
+		config  lintConfig
+		want    []string
+	}{
+		{
+			name:    "unused local",
+			snippet: "local u = 1; { a: 1 }",
+			want:    []string{"test.jsonnet:1:7-12 Local variable u is never used [unused-local]"},
+		},
+		{
+			name:    "local used through field access",
+			snippet: "local x = { a: 1 }; { b: x.a }",
+		},
+		{
+			name:    "import used through a method call",
+			snippet: "local lib = { greet(name): name }; lib.greet('world')",
+		},
+		{
+			name:    "shadowed parameter",
+			snippet: "local x = 1; local f(x) = x; f(2)",
+			want: []string{
+				"test.jsonnet:1:7-12 Local variable x is never used [unused-local]",
+				"test.jsonnet:1:22-23 Variable x shadows the variable at test.jsonnet:1:7-12 [shadowed-variable]",
+			},
+		},
+		{
+			name:    "override within a field access",
+			snippet: "local base = { a: { b: 1 } }; (base { a: { c: 2 } }).a",
+			want: []string{
+				"test.jsonnet:1:39-50 Field a replaces the inherited field at test.jsonnet:1:16-27 without '+:' so its fields b are unreachable [override-without-plus]",
+			},
+		},
+		{
+			name:    "override with plus",
+			snippet: "local base = { a: { b: 1 } }; base { a+: { c: 2 } }",
+		},
+		{
+			name:    "disabled rule",
+			snippet: "local x = 1; local f(x) = x; f(2)",
+			config:  lintConfig{Rules: map[string]bool{"unused-local": false}},
+			want:    []string{"test.jsonnet:1:22-23 Variable x shadows the variable at test.jsonnet:1:7-12 [shadowed-variable]"},
+		},
+		{
+			name:    "string concatenation",
+			snippet: "local name = 'x'; 'Hello ' + name + '!'",
+			want:    []string{"test.jsonnet:1:19-40 String concatenation is clearer using '%' formatting [string-concatenation]"},
+		},
+		{
+			name:    "error with an empty message",
+			snippet: "error ''",
+			want:    []string{"test.jsonnet:1:1-9 Error has an empty message [bare-error]"},
+		},
+		{
+			name:    "assertion without a message",
+			snippet: "{ assert true, a: 1 }",
+			want:    []string{"test.jsonnet:1:3-14 Assertion has no message [bare-error]"},
+		},
+		{
+			name:    "invisible character",
+			snippet: "'a\u200bb'",
+			want:    []string{"test.jsonnet:1:1-8 String contains invisible characters U+200B [unicode-invisible]"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := lintSnippet(t, tc.snippet, tc.config); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
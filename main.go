@@ -3,316 +3,106 @@ package main
 import (
 	"bufio"
 	"encoding/json"
-	"errors"
+	"flag"
 	"fmt"
-	"io"
 	"io/ioutil"
+	"net"
 	"os"
 	"path/filepath"
 	"regexp"
-	"strconv"
+	"runtime"
 	"strings"
-	"unicode"
+	"time"
 	"unicode/utf8"
 
 	"github.com/google/go-jsonnet"
 	"github.com/google/go-jsonnet/ast"
 	"github.com/google/go-jsonnet/formatter"
 
-	"github.com/grafana/tanka/pkg/jsonnet/native"
+	"github.com/jdbaldry/jsonnet-tool/pkg/astgraph"
+	"github.com/jdbaldry/jsonnet-tool/pkg/layers"
+	"github.com/jdbaldry/jsonnet-tool/pkg/location"
+	"github.com/jdbaldry/jsonnet-tool/pkg/repl"
+	"github.com/jdbaldry/jsonnet-tool/pkg/symbols"
+	toolvm "github.com/jdbaldry/jsonnet-tool/pkg/vm"
 )
 
-var (
-	command string
-	errExit = errors.New("exit")
-)
+var command string
 
-// scanDoubleSemiColon is a split function for a Scanner that returns each string of text
-// separated by two semicolons ";;".
-func scanDoubleSemiColon(data []byte, atEOF bool) (advance int, token []byte, err error) {
-	// Skip leading spaces.
-	start := 0
-	for width := 0; start < len(data); start += width {
-		var r rune
-		r, width = utf8.DecodeRune(data[start:])
-		if !unicode.IsSpace(r) {
-			break
+// importJPaths returns the import search directories of the project configuration, $JSONNET_PATH, and the
+// --jpath flags, in increasing order of precedence.
+func importJPaths() []string {
+	jpaths := projectJPaths()
+	for _, jpath := range append(filepath.SplitList(os.Getenv("JSONNET_PATH")), jpathFlags...) {
+		if !containsPath(jpaths, jpath) {
+			jpaths = append(jpaths, jpath)
 		}
 	}
-	// Scan until two semicolons are encountered.
-	var prev rune
-	for width, i := 0, start; i < len(data); i += width {
-		var r rune
-		r, width = utf8.DecodeRune(data[i:])
-		if r == ';' && prev == ';' {
-			return i + 2*width, data[start : i-1], nil
-		}
-		prev = r
-	}
-	// If we're at EOF, we have a final, non-empty, non-terminated string of text.
-	if atEOF && len(data) > start {
-		return len(data), data[start:], nil
-	}
-	// Request more data.
-	return start, nil, nil
-}
-
-// help writes help text.
-// If no writer is provided, it writes to stderr.
-func help(w io.Writer) {
-	if w == nil {
-		w = os.Stderr
-	}
-	fmt.Fprintf(w, `A tool for working with Jsonnet files.
-
-Produce a .dot diagram of the Jsonnet AST for <file>:
-  $ %s dot <file>
-
-Evaluate Jsonnet using the jsonnet-tool interpreter:
-  $ %s eval <file>
-
-Produce an expanded Jsonnet representation:
-  $ %s expand <file>
-
-Produce a JSON array of the layers of object evaluations for <file>:
-  $ %s layers <file>
-
-List the imports for <file>:
-  $ %s imports <file>
-
-List the referenceable symbols in <file>:
-  $ %s symbols <file>
-
-Run a Jsonnet REPL:
-  $ %s repl
-`, os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0])
+	return jpaths
 }
 
-// makeVM creates a Jsonnet VM configured to import from the Jpaths specified in the
+// makeImporter creates a Jsonnet file importer configured to import from the Jpaths specified in the
 // JSONNET_PATH environment variable.
-// TODO: this should support -J flags too.
-func makeVM() *jsonnet.VM {
-	vm := jsonnet.MakeVM()
-	importer := &jsonnet.FileImporter{JPaths: filepath.SplitList(os.Getenv("JSONNET_PATH"))}
-	vm.Importer(importer)
-
-	for _, fn := range native.Funcs() {
-		vm.NativeFunction(fn)
+// If the current directory is within a jsonnet-bundler project, the project vendor and lib
+// directories are also used as Jpaths, followed by the jpath of the project configuration,
+// all with lower precedence than JSONNET_PATH. The -J flags of commands that have them take precedence
+// over all of these. Directories already used are not repeated.
+// Imported files are transformed by the import hooks of the project configuration.
+// In-memory input, like input read from stdin with a "-" file argument, is imported by its filename.
+func makeImporter() jsonnet.Importer {
+	var importer jsonnet.Importer = &jsonnet.FileImporter{JPaths: importJPaths()}
+	if len(config.ImportHooks) > 0 {
+		importer = &transformingImporter{importer: importer, transforms: []transform{hookTransform(config)}}
 	}
-
-	// Add in a `manifestYamlFromJson` native function which is used by a number of Jsonnet libraries.
-	// I don't care for YAML so it actually outputs JSON.
-	manifestYaml := &jsonnet.NativeFunction{
-		Func: func(data []interface{}) (interface{}, error) {
-			bytes, err := json.Marshal(data[0])
-			if err != nil {
-				return nil, err
-			}
-			return string(bytes), nil
-		},
-		Params: []ast.Identifier{"json"},
-		Name:   "manifestYamlFromJson",
+	if memorySource != nil {
+		importer = memoryImporter{importer: importer}
 	}
-	vm.NativeFunction(manifestYaml)
-
-	return vm
+	return importer
 }
 
-// repl can be used for interactive evaluation of Jsonnet.
-type repl struct {
-	// in is where the REPL reads input from.
-	in *bufio.Scanner
-	// evalFile is where the REPL will write out evaluations partitioned by namespace index.
-	evalFile []string
-	// namespaceFile is where the REPL will write out the current namespace partitioned by namespace index.
-	namespaceFile []string
-	// help is the REPL help text.
-	help string
-	// preExprs are a expressions partitioned by namespace index and prepended to evaluation.
-	preExprs [][]string
-	// ns is the index of the current namespace.
-	ns int
-	// vm performs the Jsonnet evaluations.
-	vm *jsonnet.VM
+// makeVM creates a Jsonnet VM configured to import using makeImporter, with the native functions, external
+// variables, std.trace output, and stack limits of the project configuration and command flags.
+func makeVM() *jsonnet.VM {
+	if ignoredAllowEnv && !config.AllowEnv {
+		warnIgnoredAllowEnv.Do(func() {
+			fmt.Fprintf(os.Stderr, "Ignoring allowEnv in %s, use --trust-project-config or --allow-env to read environment variables\n", filepath.Join(config.Dir, projectConfigFile))
+		})
+	}
+	return toolvm.New(toolvm.Options{
+		Importer:           makeImporter(),
+		ManifestYAMLAsJSON: config.ManifestYamlAsJSON,
+		AllowEnv:           config.AllowEnv,
+		ExtVars:            config.ExtVars,
+		TraceOut:           traceOut,
+		MaxStack:           limits.maxStack,
+		MaxTrace:           limits.maxTraceOption(),
+	})
 }
 
-// prompt returns the REPL prompt.
-func (r *repl) prompt() string { return fmt.Sprintf("repl [%d]> ", r.ns) }
+// LocationRange is a range of Jsonnet source, as written by commands.
+type LocationRange = location.Range
 
-// read reads a line from the repl input.
-func (r *repl) read() (string, error) {
-	r.in.Scan()
-	return r.in.Text(), r.in.Err()
+// makeLocationRange converts a go-jsonnet location range into a LocationRange.
+func makeLocationRange(loc *ast.LocationRange) LocationRange {
+	return location.FromAST(loc)
 }
 
-// eval evaluates the input string.
-// It expects the string to be trimmed of preceding whitespace.
-// See the repl.help for behaviors.
-// Anything else is evaluated as Jsonnet input.
-func (r *repl) eval(input string) (string, error) {
-	if len(input) == 0 {
-		return "", errExit
-	}
-	switch input[0] {
-	case '\\':
-		if len(input) < 2 {
-			return r.help, fmt.Errorf("expected command such as \\h, got %s", input)
-		}
-		switch input[1] {
-		case 'd':
-			re := regexp.MustCompile(`^(?s)\\d\s+([0-9]+)$`)
-			matches := re.FindStringSubmatch(input)
-			if len(matches) != 2 {
-				return "", fmt.Errorf("invalid delete command syntax. Wanted \\d INDEX")
-			}
-			i, err := strconv.Atoi(matches[1])
-			if err != nil {
-				return "", fmt.Errorf("invalid delete command index.")
-			}
-			if i < 0 || i > len(r.preExprs[r.ns])-1 {
-				return "", fmt.Errorf("delete command index out of range")
-			}
-			r.preExprs[r.ns] = append(r.preExprs[r.ns][:i], r.preExprs[r.ns][i+1:]...)
-			return "", nil
-		case 'f':
-			re := regexp.MustCompile(`^(?s)\\f\s+(.+)$`)
-			matches := re.FindStringSubmatch(input)
-			if len(matches) != 2 {
-				return "", fmt.Errorf("invalid file command syntax. Wanted \\f FILE")
-			}
-			path, err := filepath.Abs(matches[1])
-			if err != nil {
-				return "", fmt.Errorf("unable to determine path to file: %w", err)
-			}
-			r.evalFile[r.ns] = path
-			return fmt.Sprintf("Writing evaluations to file %s\n", r.evalFile[r.ns]), nil
-		case 'h', '?':
-			return r.help, nil
-		case 'n':
-			if len(input) == 2 {
-				r.preExprs = append(r.preExprs, []string{})
-				r.evalFile = append(r.evalFile, "")
-				r.namespaceFile = append(r.namespaceFile, "")
-				r.ns = len(r.preExprs) - 1
-				return fmt.Sprintf("Switched to namespace %d\n", r.ns), nil
-			}
-			re := regexp.MustCompile(`^(?s)\\n\s+([0-9]+)$`)
-			matches := re.FindStringSubmatch(input)
-			if len(matches) != 2 {
-				return "", fmt.Errorf("invalid namespace command syntax. Wanted \\n or \\n INDEX")
-			}
-			i, err := strconv.Atoi(matches[1])
-			if err != nil {
-				return "", fmt.Errorf("invalid namespace command index.")
-			}
-			if i < 0 || i > len(r.preExprs)-1 {
-				return "", fmt.Errorf("namespace command index out of range")
-			}
-			r.ns = i
-			builder := strings.Builder{}
-			builder.WriteString(fmt.Sprintf("Switched to namespace %d\n", r.ns))
-			if r.evalFile[r.ns] != "" {
-				builder.WriteString(fmt.Sprintf("Writing evaluations to file %s\n", r.evalFile[r.ns]))
-			}
-			if r.namespaceFile[r.ns] != "" {
-				builder.WriteString(fmt.Sprintf("Writing namespace to file %s\n", r.namespaceFile[r.ns]))
-			}
-			return builder.String(), nil
-		case 'q':
-			return "", errExit
-		case 'v':
-			re := regexp.MustCompile(`(?s)^\\v\s*(.*)$`)
-			matches := re.FindStringSubmatch(input)
-			if len(matches) != 2 {
-				return "", fmt.Errorf("invalid variable expression command syntax. Wanted \\v or \\v EXPR.\n")
-			}
-			if len(matches[1]) > 0 {
-				r.preExprs[r.ns] = append(r.preExprs[r.ns], strings.Trim(strings.TrimPrefix(input, `\v`), " ;"))
-				return "", nil
-			}
-			builder := strings.Builder{}
-			for i, s := range r.preExprs[r.ns] {
-				builder.WriteString(fmt.Sprintf("[%d] %s\n", i, s))
-			}
-			return builder.String(), nil
-		case 'w':
-			re := regexp.MustCompile(`(?s)^\\w\s+(.+)$`)
-			matches := re.FindStringSubmatch(input)
-			if len(matches) != 2 {
-				return "", fmt.Errorf("invalid write command syntax. Wanted \\w file")
-			}
-			path, err := filepath.Abs(matches[1])
-			if err != nil {
-				return "", fmt.Errorf("unable to determine path to file: %w", err)
-			}
-			r.namespaceFile[r.ns] = path
-			return fmt.Sprintf("Writing namespace to file %s\n", r.namespaceFile[r.ns]), nil
-		default:
-			return "", fmt.Errorf("unknown command %s", input)
-		}
-	default:
-		builder := strings.Builder{}
-		for _, s := range r.preExprs[r.ns] {
-			builder.WriteString(fmt.Sprintf("%s;\n", s))
-		}
-		builder.WriteString(input)
-		if r.namespaceFile[r.ns] != "" {
-			err := ioutil.WriteFile(r.namespaceFile[r.ns], []byte(builder.String()), 0o644)
-			if err != nil {
-				return "", fmt.Errorf("unable to write namespace to file %s: %w", r.namespaceFile, err)
-			}
-		}
-		result, err := r.vm.EvaluateAnonymousSnippet("repl", builder.String())
-		if err != nil {
-			return "", err
-		}
-		if r.evalFile[r.ns] != "" {
-			err := ioutil.WriteFile(r.evalFile[r.ns], []byte(result), 0o644)
-			if err != nil {
-				return "", fmt.Errorf("unable to write evaluation to file %s: %w", r.evalFile, err)
-			}
+// sourceOffset returns the byte offset in input of the location.
+// Columns count runes, as they do in go-jsonnet locations.
+func sourceOffset(input string, loc ast.Location) int {
+	offset := 0
+	for line := 1; line < loc.Line; line++ {
+		i := strings.IndexByte(input[offset:], '\n')
+		if i < 0 {
+			return len(input)
 		}
-		return result, nil
+		offset += i + 1
 	}
-}
-
-// newREPL produces a REPL.
-func newREPL(in io.Reader) repl {
-	scanner := bufio.NewScanner(in)
-	scanner.Split(scanDoubleSemiColon)
-	return repl{
-		in:            scanner,
-		evalFile:      make([]string, 1),
-		namespaceFile: make([]string, 1),
-		help: `A Jsonnet REPL.
-
-Commands and expressions should be terminated with two semicolons ';;'.
-For example,
-repl [0]> \v local bar = 'Hello, world!';;
-repl [0]> bar;;
-"Hello, world!"
-
-\d i            removes the ith namespace variable expression (zero indexed).
-\f FILE         writes subsequent evaluation of the current namespace to FILE.
-\n              creates a new namespace.
-\n i            switches to the ith namespace (zero indexed).
-\h              prints this help message.
-\q              quits the REPL.
-\v              prints the namespace expressions.
-\v EXPR         creates a new namespace EXPR that is prepended to evaluation.
-\w FILE         writes the state of the current namespace to FILE.
-Anything else is evaluated as Jsonnet.
-`,
-		preExprs: make([][]string, 1),
-		ns:       0,
-		vm:       makeVM(),
+	for column := 1; column < loc.Column && offset < len(input) && input[offset] != '\n'; column++ {
+		_, size := utf8.DecodeRuneInString(input[offset:])
+		offset += size
 	}
-}
-
-type LocationRange struct {
-	FileName string
-	Begin    ast.Location
-	End      ast.Location
+	return offset
 }
 
 // uncons returns the head of the slice and the tail of the slice.
@@ -326,190 +116,1718 @@ func uncons(args []string) (string, []string) {
 	return args[0], args[1:]
 }
 
+// parseFlags parses flags that may be interspersed with positional arguments.
+// It returns the positional arguments in order.
+// All arguments after a "--" terminator are positional.
+func parseFlags(flags *flag.FlagSet, args []string) (positional []string) {
+	for {
+		flags.Parse(args)
+		rest := flags.Args()
+		if len(rest) == 0 {
+			return positional
+		}
+		if len(rest) < len(args) && args[len(args)-len(rest)-1] == "--" {
+			return append(positional, rest...)
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// capture is the captured stdout of the command when the --output global option is given.
+var capture *outputCapture
+
+// exit exits with the status code, first writing the --output file if the status code is zero.
+// The --output file is left unchanged if the command fails.
+func exit(code int) {
+	if capture != nil && code == 0 {
+		if err := capture.commit(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing output file %s: %v\n", capture.path, err)
+			code = 1
+		}
+	}
+	os.Exit(code)
+}
+
 func main() {
 	args := os.Args
 	if len(args) < 2 {
 		help(os.Stderr)
-		os.Exit(1)
+		exit(1)
 	}
 
 	_, args = uncons(args)
+	options, args, err := parseGlobalOptions(args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		help(os.Stderr)
+		exit(2)
+	}
 	command, args = uncons(args)
+	ctx := notifyContext()
+
+	c, err := loadProjectConfig(".")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading project configuration: %v\n", err)
+		exit(1)
+	}
+	trustedConfig = options.TrustProjectConfig
+	setProjectConfig(c)
+	errorFormat = options.ErrorFormat
+	plain = options.Plain || os.Getenv("TERM") == "dumb"
+	if options.Output != "" {
+		if capture, err = captureOutput(options.Output, options.CreateDirs); err != nil {
+			fmt.Fprintf(os.Stderr, "Error capturing output: %v\n", err)
+			exit(1)
+		}
+	}
+
+	if _, ok := daemonCommands[command]; ok && options.UseDaemon {
+		addr := options.DaemonAddr
+		if addr == "" {
+			var ok bool
+			if addr, ok = findDaemon(); !ok {
+				fmt.Fprintf(os.Stderr, "There is no %s at or above the current directory, start a daemon with %s daemon\n", daemonSocketName, os.Args[0])
+				exit(1)
+			}
+		}
+		code, err := runInDaemon(ctx, addr, command, args)
+		if ctx.Err() != nil {
+			interrupted()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error running %s in the daemon: %v\n", command, err)
+			exit(1)
+		}
+		exit(code)
+	}
 
 	switch command {
 
 	case "--help", "-h":
 		help(os.Stdout)
-		os.Exit(0)
+		exit(0)
 
-	case "dot":
-		if len(args) != 1 {
-			help(os.Stderr)
-			os.Exit(1)
+	case "astdiff":
+		flags := newFlagSet(command)
+		args = parseFlags(flags, args)
+		if len(args) != 2 {
+			flags.Usage()
+			exit(1)
 		}
-		file, _ := uncons(args)
-		body, err := ioutil.ReadFile(file)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Unable to read file %s: %v\n", file, err)
+		var inputs [2]string
+		for i, file := range args {
+			input, err := ioutil.ReadFile(file)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to read file %s: %v\n", file, err)
+				exit(1)
+			}
+			inputs[i] = string(input)
 		}
-		root, _, err := formatter.SnippetToRawAST(file, string(body))
+		edits, err := astDiff(args[0], inputs[0], args[1], inputs[1])
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Unable to produce AST for file %s: %v\n", file, err)
-			os.Exit(1)
+			reportJsonnetError(err, "Unable to produce AST: %v\n", err)
+			exit(1)
 		}
-		out, err := dot(root)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error producing DOT from AST: %v\n", err)
-			os.Exit(1)
+		for _, edit := range edits {
+			fmt.Println(edit)
+		}
+		if len(edits) > 0 {
+			exit(1)
 		}
-		fmt.Print(out)
 
-	case "eval":
-		if len(args) != 1 {
-			help(os.Stderr)
-			os.Exit(1)
+	case "check":
+		flags := newFlagSet(command)
+		args = parseFlags(flags, args)
+		if len(args) < 1 {
+			flags.Usage()
+			exit(1)
 		}
-		file, _ := uncons(args)
-		json, err := makeVM().EvaluateFile(file)
+		files, err := expandFiles(args)
 		if err != nil {
-			// The newline after the initial error allows this tools error
-			// output to match the regexps used by flycheck (and probably
-			// other editor error checkers).
-			fmt.Fprintf(os.Stderr, "Error evaluating Jsonnet for file %s:\n%v\n", file, err)
-			os.Exit(1)
+			fmt.Fprintf(os.Stderr, "Error finding files: %v\n", err)
+			exit(1)
+		}
+		c := newChecker()
+		for _, file := range files {
+			if ctx.Err() != nil {
+				interrupted()
+			}
+			c.check("", file, LocationRange{FileName: file})
+		}
+		for _, diagnostic := range c.diagnostics {
+			fmt.Println(diagnostic)
+		}
+		if len(c.diagnostics) > 0 {
+			exit(1)
 		}
-		fmt.Print(json)
 
-	case "expand":
+	case "complete":
+		flags := newFlagSet(command)
+		args = parseFlags(flags, args)
 		if len(args) != 1 {
-			help(os.Stderr)
-			os.Exit(1)
+			flags.Usage()
+			exit(1)
 		}
-		file, _ := uncons(args)
-		input, err := ioutil.ReadFile(file)
+		pos, input := readSourcePosition(args[0])
+		completions, err := complete(pos.File, input, pos.Location)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error reading file %s: %v\n", file, err)
-			os.Exit(1)
+			fmt.Fprintf(os.Stderr, "Error completing %s: %v\n", args[0], err)
+			exit(1)
 		}
-		_, _, err = formatter.SnippetToRawAST(file, string(input))
+		b, err := json.MarshalIndent(completions, "", "  ")
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error importing AST for file %s: %v\n", file, err)
-			os.Exit(1)
+			fmt.Fprintf(os.Stderr, "Unable to marshal to JSON: %v\n", err)
+			exit(1)
 		}
-		// output, err := makeVM().Expand(root, finalFodder)
-		// if err != nil {
-		// 	fmt.Fprintf(os.Stderr, "Error expanding file %s: %v\n", file, err)
-		// 	os.Exit(1)
-		// }
-		// fmt.Print(output)
+		os.Stdout.Write(b)
+		os.Stdout.Write([]byte{'\n'})
 
-	case "imports":
+	case "coverage":
+		flags := newFlagSet(command)
+		importedOnly := flags.Bool("imported-only", false, "only report the files imported by <file>, not the other files in the current directory and library paths")
+		args = parseFlags(flags, args)
 		if len(args) != 1 {
-			help(os.Stderr)
-			os.Exit(1)
+			flags.Usage()
+			exit(1)
 		}
 		file, _ := uncons(args)
 		vm := makeVM()
-		imports, err := vm.FindDependencies("", []string{file})
+		in := &instrumenter{}
+		recorder := newCoverageRecorder(in)
+		importer := in.importer(makeImporter())
+		vm.Importer(importer)
+		vm.SetTraceOut(&probeWriter{w: os.Stderr, onEnter: recorder.enter})
+		root, _, err := vm.ImportAST("", file)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Unable to find imports for file %s: %v\n", file, err)
-			os.Exit(1)
+			reportJsonnetError(err, "Unable to produce AST for file %s: %v\n", file, err)
+			exit(1)
+		}
+		_, err = toolvm.Evaluate(ctx, vm, root)
+		if ctx.Err() != nil {
+			interrupted()
 		}
-		b, err := json.MarshalIndent(imports, "", "  ")
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Unable to marshal to JSON: %v\n", err)
-			os.Exit(1)
+			reportJsonnetError(err, "Error evaluating Jsonnet for file %s:\n%v\n", file, vm.ErrorFormatter.Format(err))
+			exit(1)
 		}
-		os.Stdout.Write(b)
-		os.Stdout.Write([]byte{'\n'})
+		if !*importedOnly {
+			if err := recorder.addUnimported(importer, append([]string{"."}, importJPaths()...), os.Stderr); err != nil {
+				fmt.Fprintf(os.Stderr, "Error finding unimported files: %v\n", err)
+				exit(1)
+			}
+		}
+		fmt.Print(recorder.report())
 
-	case "layers":
+	case "daemon":
+		flags := newFlagSet(command)
+		addr := flags.String("addr", "unix://"+daemonSocketName, "address to listen on, unix:///path/to/socket or tcp://host:port")
+		addLimitFlags(flags)
+		args = parseFlags(flags, args)
+		if len(args) != 0 {
+			flags.Usage()
+			exit(1)
+		}
+		network, address, err := listenAddress(*addr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error parsing --addr: %v\n", err)
+			exit(1)
+		}
+		if network == "unix" {
+			removeStaleSocket(address)
+		}
+		d, err := newDaemon()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error starting daemon: %v\n", err)
+			exit(1)
+		}
+		d.settings.reloadOnSignal()
+		l, err := net.Listen(network, address)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error listening: %v\n", err)
+			exit(1)
+		}
+		fmt.Printf("Listening on %s\n", l.Addr())
+		if err := d.serve(ctx, l); err != nil {
+			fmt.Fprintf(os.Stderr, "Error serving requests: %v\n", err)
+			exit(1)
+		}
+
+	case "dataflow":
+		flags := newFlagSet(command)
+		format := flags.String("format", "table", "output format, one of table or dot")
+		args = parseFlags(flags, args)
 		if len(args) != 1 {
-			help(os.Stderr)
-			os.Exit(1)
+			flags.Usage()
+			exit(1)
 		}
 		file, _ := uncons(args)
 		vm := makeVM()
 		root, _, err := vm.ImportAST("", file)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Unable to produce AST for file %s: %v\n", file, err)
-			os.Exit(1)
+			reportJsonnetError(err, "Unable to produce AST for file %s: %v\n", file, err)
+			exit(1)
+		}
+		d := newDataflow(vm)
+		influenced := influencedPaths(d.outputTaints(d.analyzeEntrypoint(root)))
+		switch *format {
+		case "table":
+			if err := writeDataflowTable(os.Stdout, d.inputs, influenced); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing table: %v\n", err)
+				exit(1)
+			}
+		case "dot":
+			writeDataflowDot(os.Stdout, d.inputs, influenced)
+		default:
+			fmt.Fprintf(os.Stderr, "Unrecognized dataflow format %s\n", *format)
+			exit(1)
+		}
+		if d.incomplete() {
+			fmt.Fprintf(os.Stderr, "Analysis of %s stopped early because it is too large so some influences may be missing\n", file)
+		}
+
+	case "debug":
+		flags := newFlagSet(command)
+		d := newDebugger()
+		flags.Func("b", "set a breakpoint at `FILE:LINE` before running, which can be repeated", func(value string) error {
+			b, err := parseDebugBreakpoint(value)
+			if err == nil {
+				d.breakpoints = append(d.breakpoints, b)
+			}
+			return err
+		})
+		args = parseFlags(flags, args)
+		if len(args) != 1 {
+			flags.Usage()
+			exit(1)
+		}
+		file, _ := uncons(args)
+		vm := makeVM()
+		vm.Importer(&transformingImporter{importer: makeImporter(), transforms: []transform{d.transform}})
+		vm.NativeFunction(d.native())
+		vm.SetTraceOut(&probeWriter{
+			w:       os.Stderr,
+			onEnter: func(id int) { d.stack = append(d.stack, id) },
+			onExit:  func(id int) { d.stack = d.stack[:len(d.stack)-1] },
+		})
+		fmt.Print(debugHelp)
+		d.run(vm, file, os.Stdin, os.Stdout)
+
+	case "definition":
+		flags := newFlagSet(command)
+		args = parseFlags(flags, args)
+		if len(args) != 1 {
+			flags.Usage()
+			exit(1)
 		}
-		layers, err := findLayers(vm, root)
+		pos, input := readSourcePosition(args[0])
+		def, err := definition(pos.File, input, pos.Location)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error processing layers for file %s: %v\n", file, err)
-			os.Exit(1)
+			fmt.Fprintf(os.Stderr, "Error finding the definition at %s: %v\n", args[0], err)
+			exit(1)
 		}
-		b, err := json.MarshalIndent(layers, "", "  ")
+		b, err := json.MarshalIndent(def, "", "  ")
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Unable to marshal to JSON: %v\n", err)
-			os.Exit(1)
+			exit(1)
 		}
 		os.Stdout.Write(b)
 		os.Stdout.Write([]byte{'\n'})
 
-	case "repl":
-		repl := newREPL(os.Stdin)
-
-		// read
-		fmt.Print(repl.help)
-		fmt.Print(repl.prompt())
-		input, err := repl.read()
+	case "dot":
+		flags := newFlagSet(command)
+		filename := flags.String("filename", defaultStdinFilename, "filename of the input read from stdin when <file> is -, used in error messages and to resolve relative imports")
+		followImports := flags.Bool("follow-imports", false, "include the ASTs of imported files, transitively, each in its own cluster")
+		var opts astgraph.Options
+		flags.Func("at", "highlight the chain of nodes from the root to the innermost node at `LINE:COLUMN` of <file>", func(value string) error {
+			at, err := parseLineColumn(value)
+			opts.At = &at
+			return err
+		})
+		flags.BoolVar(&opts.Focus, "focus", false, "with --at, omit the nodes that are not highlighted, children of highlighted nodes, or within the innermost highlighted node")
+		args = parseFlags(flags, args)
+		if len(args) != 1 || opts.Focus && opts.At == nil {
+			flags.Usage()
+			exit(1)
+		}
+		file, _ := uncons(args)
+		file, err := inputFile(file, *filename)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error reading input: %v\n", err)
-			os.Exit(1)
+			exit(1)
+		}
+		body, err := readInput(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to read file %s: %v\n", file, err)
+		}
+		root, _, err := formatter.SnippetToRawAST(file, string(body))
+		if err != nil {
+			reportJsonnetError(err, "Unable to produce AST for file %s: %v\n", file, err)
+			exit(1)
 		}
+		var out string
+		if *followImports {
+			out, err = astgraph.DotFollowImports(makeImporter(), file, root, opts)
+		} else {
+			out, err = astgraph.Dot(root, opts)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error producing DOT from AST: %v\n", err)
+			exit(1)
+		}
+		fmt.Print(out)
 
-		for {
-			// eval
-			result, err := repl.eval(input)
-			if err != nil {
-				if err == errExit {
-					fmt.Println("Bye!")
-					os.Exit(0)
-				}
-				fmt.Printf("Evaluation error: %v\n", err)
+	case "duplicates":
+		flags := newFlagSet(command)
+		args = parseFlags(flags, args)
+		if len(args) != 1 {
+			flags.Usage()
+			exit(1)
+		}
+		file, _ := uncons(args)
+		input, err := ioutil.ReadFile(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading file %s: %v\n", file, err)
+			exit(1)
+		}
+		root, _, err := formatter.SnippetToRawAST(file, string(input))
+		if err != nil {
+			reportJsonnetError(err, "Unable to produce AST for file %s: %v\n", file, err)
+			exit(1)
+		}
+		diagnostics, err := findDuplicateKeys(root)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error finding duplicate keys for file %s: %v\n", file, err)
+			exit(1)
+		}
+		for _, diagnostic := range diagnostics {
+			fmt.Println(diagnostic)
+		}
+		if len(diagnostics) > 0 {
+			exit(1)
+		}
+		// Keys that can only be known at manifestation are checked by evaluation.
+		vm := makeVM()
+		node, _, err := vm.ImportAST("", file)
+		if err != nil {
+			reportJsonnetError(err, "Unable to produce AST for file %s: %v\n", file, vm.ErrorFormatter.Format(err))
+			exit(1)
+		}
+		_, err = toolvm.Evaluate(ctx, vm, node)
+		if ctx.Err() != nil {
+			interrupted()
+		}
+		if err != nil {
+			reportJsonnetError(err, "Error evaluating Jsonnet for file %s:\n%v\n", file, vm.ErrorFormatter.Format(err))
+			if explanation, ok := explainDuplicateKey(err); ok && errorFormat == "text" {
+				fmt.Fprint(os.Stderr, explanation)
 			}
+			exit(1)
+		}
 
-			// print
-			fmt.Print(result)
+	case "env":
+		flags := newFlagSet(command)
+		format := flags.String("format", "direnv", "output format, one of direnv or nix")
+		args = parseFlags(flags, args)
+		if len(args) != 1 || args[0] != "export" {
+			flags.Usage()
+			exit(1)
+		}
+		variables, skipped := projectEnvironment()
+		for _, name := range skipped {
+			fmt.Fprintf(os.Stderr, "External variable %s is not exported because it is not a valid environment variable name\n", name)
+		}
+		switch *format {
+		case "direnv":
+			writeDirenv(os.Stdout, variables)
+		case "nix":
+			writeNix(os.Stdout, variables)
+		default:
+			fmt.Fprintf(os.Stderr, "Unrecognized env format %s\n", *format)
+			exit(1)
+		}
 
-			// loop
-			fmt.Print(repl.prompt())
-			input, err = repl.read()
+	case "eval":
+		flags := newFlagSet(command)
+		recursionReport := flags.Bool("recursion-report", false, "report the deepest call chain and most frequently evaluated expressions to stderr")
+		vmStatsFlag := flags.Bool("vm-stats", false, "report evaluation time, VM import cache, and memory statistics to stderr")
+		vmCacheSize := flags.Int("vm-cache-size", 0, "when evaluating more than one <file>, clear the import cache of a VM before its next file once it holds more than this many files, trading speed for memory (default is no limit)")
+		sourceMapFile := flags.String("source-map", "", "write a source map relating ranges of the output to Jsonnet source locations to this file")
+		allowEnv := flags.Bool("allow-env", false, "allow the env native function to read environment variables")
+		yamlAsJSON := flags.Bool("manifest-yaml-as-json", false, "make the manifestYamlFromJson native function produce JSON rather than YAML")
+		checkpointDir := flags.String("checkpoint-dir", "", "evaluate each top level field separately, reusing the evaluations of unchanged fields cached in this directory")
+		filename := flags.String("filename", defaultStdinFilename, "filename of the input read from stdin when <file> is -, used in error messages and to resolve relative imports")
+		bundleDir := flags.String("bundle-dir", "", "evaluate the .jsonnet and .libsonnet files in this directory combined, instead of <file>")
+		recursive := flags.Bool("recursive", false, "include the files in the subdirectories of the --bundle-dir directory")
+		bundleMode := flags.String("bundle-mode", "object", "combine the files of the --bundle-dir directory as an object keyed by filename, by merging them, or as an array: "+strings.Join(bundleModes, ", "))
+		outputDir := flags.String("m", "", "write the output of each <file> to this directory, at the path of the file")
+		suffix := flags.String("suffix", "", "write the output of each <file> next to it, or in the -m directory, replacing its extension with this one (default \".json\")")
+		jobs := flags.Int("j", 0, "number of files to evaluate in parallel (default is the number of CPUs)")
+		full := flags.Bool("full", false, "write all of the output to a terminal, however large it is")
+		terminalLimit := flags.Int("terminal-limit", defaultTerminalLimit, "size in bytes of output that is written to a terminal before it is paged with $PAGER or truncated")
+		progressMode := flags.String("progress", "auto", "report the progress of evaluating more than one <file> to stderr as a status line (tty), as JSON line events (json), or not at all (none); auto shows a status line if stderr is a terminal")
+		traceFormat := flags.String("trace-format", "text", "write std.trace messages as text or as JSON records, one per line, with their location")
+		traceFile := flags.String("trace-out", "", "write std.trace messages to this file instead of stderr")
+		schemaFile := flags.String("validate", "", "check the output against the JSON Schema in this file, reporting violations instead of writing the output")
+		openAPIFile := flags.String("openapi", "", "check the Kubernetes objects in the output against the definitions of this Kubernetes OpenAPI document, like --validate")
+		addLimitFlags(flags)
+		args = parseFlags(flags, args)
+		if (*bundleDir == "") == (len(args) == 0) {
+			flags.Usage()
+			exit(1)
+		}
+		// Evaluating more than one file writes each output to a file.
+		multiple := len(args) > 1 || *outputDir != "" || *suffix != ""
+		if multiple && (*bundleDir != "" || *checkpointDir != "" || *sourceMapFile != "" || *recursionReport || *schemaFile != "" || *openAPIFile != "") {
+			fmt.Fprintf(os.Stderr, "--bundle-dir, --checkpoint-dir, --source-map, --recursion-report, --validate, and --openapi can only be used with a single <file>\n")
+			exit(1)
+		}
+		var outputValidator *validator
+		if *schemaFile != "" || *openAPIFile != "" {
+			v, err := newValidator(*schemaFile, *openAPIFile)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error reading schema: %v\n", err)
+				exit(1)
+			}
+			outputValidator = v
+		}
+		var file string
+		var err error
+		switch {
+		case multiple:
+			for _, arg := range args {
+				if arg == stdinFile {
+					fmt.Fprintf(os.Stderr, "Input from stdin can only be evaluated as a single <file>\n")
+					exit(1)
+				}
+			}
+		case *bundleDir != "":
+			file, err = makeBundle(*bundleDir, *recursive, *bundleMode)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error bundling %s: %v\n", *bundleDir, err)
+				exit(1)
+			}
+		default:
+			file, err = inputFile(args[0], *filename)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "Error reading input: %v\n", err)
+				exit(1)
 			}
 		}
-
-	case "symbols":
-		if len(args) != 1 {
-			help(os.Stderr)
-			os.Exit(1)
+		if *yamlAsJSON {
+			config.ManifestYamlAsJSON = true
+		}
+		if *allowEnv {
+			config.AllowEnv = true
+		}
+		if err := setTraceOutput(*traceFormat, *traceFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error configuring trace output: %v\n", err)
+			exit(1)
+		}
+		if multiple {
+			files, err := expandFiles(args)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error finding files: %v\n", err)
+				exit(1)
+			}
+			if *suffix == "" {
+				*suffix = ".json"
+			}
+			paths, err := outputPaths(files, *outputDir, *suffix)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error choosing output files: %v\n", err)
+				exit(1)
+			}
+			progress := newProgressFlag(*progressMode, len(files))
+			evalCtx, cancel := limits.withTimeout(ctx)
+			defer cancel()
+			stats := newVMStats()
+			stats.begin()
+			results, err := evalFiles(evalCtx, files, paths, *jobs, *vmCacheSize, stats, progress)
+			progress.end()
+			if evalCtx.Err() != nil {
+				stopped(evalCtx)
+			}
+			if *vmStatsFlag {
+				stats.end()
+				fmt.Fprint(os.Stderr, stats.report())
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing outputs: %v\n", err)
+				exit(1)
+			}
+			if reportEvalResults(results) > 0 {
+				exit(1)
+			}
+			break
 		}
-		file, _ := uncons(args)
 		vm := makeVM()
+		importer := jsonnet.Importer(makeImporter())
+		// reports write any requested evaluation reports, whether or not evaluation succeeded.
+		var reports []func()
+		if *recursionReport {
+			in := &instrumenter{}
+			recorder := newRecursionRecorder(in)
+			importer = in.importer(importer)
+			vm.SetTraceOut(&probeWriter{w: os.Stderr, onEnter: recorder.enter, onExit: recorder.exit})
+			reports = append(reports, func() { fmt.Fprint(os.Stderr, recorder.report()) })
+		}
+		if *vmStatsFlag {
+			s := newVMStats()
+			importer = s.importer(importer)
+			s.begin()
+			reports = append(reports, func() {
+				s.end()
+				fmt.Fprint(os.Stderr, s.report())
+			})
+		}
+		vm.Importer(importer)
+		report := func() {
+			for _, r := range reports {
+				r()
+			}
+		}
 		root, _, err := vm.ImportAST("", file)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Unable to produce AST for file %s: %v\n", file, err)
-			os.Exit(1)
+			reportJsonnetError(err, "Error evaluating Jsonnet for file %s:\n%v\n", file, vm.ErrorFormatter.Format(err))
+			exit(1)
+		}
+		var output string
+		cf, checkpointed := checkpointedFile{}, false
+		if *checkpointDir != "" && config.AllowEnv {
+			fmt.Fprintf(os.Stderr, "File %s is evaluated without checkpoints because they cannot record the environment variables read by the env native function\n", file)
+		} else if *checkpointDir != "" {
+			if cf, checkpointed, err = parseCheckpointedFile(file); err == nil && !checkpointed {
+				fmt.Fprintf(os.Stderr, "File %s does not evaluate to an object with static field names so it is evaluated without checkpoints\n", file)
+			}
+		}
+		evalCtx, cancel := limits.withTimeout(ctx)
+		defer cancel()
+		if checkpointed {
+			output, err = evaluateCheckpointed(evalCtx, vm, importer, cf, *checkpointDir, os.Stderr)
+		} else {
+			output, err = toolvm.Evaluate(evalCtx, vm, root)
+		}
+		if evalCtx.Err() != nil {
+			stopped(evalCtx)
 		}
-		symbols, err := findSymbols(&root, []string{"$"})
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error processing symbols for file %s: %v\n", file, err)
-			os.Exit(1)
+			// The newline after the initial error allows this tools error
+			// output to match the regexps used by flycheck (and probably
+			// other editor error checkers).
+			reportJsonnetError(err, "Error evaluating Jsonnet for file %s:\n%v\n", file, vm.ErrorFormatter.Format(err))
+			if explanation, ok := explainDuplicateKey(err); ok && errorFormat == "text" {
+				fmt.Fprint(os.Stderr, explanation)
+			}
+			report()
+			exit(1)
+		}
+		if err := limits.checkOutput([]byte(output)); err != nil {
+			fmt.Fprintf(os.Stderr, "Error evaluating Jsonnet for file %s: %v\n", file, err)
+			report()
+			exit(1)
 		}
-		b, err := json.MarshalIndent(symbols, "", "  ")
+		if outputValidator != nil {
+			violations, err := outputValidator.validate(vm, root, output)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error validating output for file %s: %v\n", file, err)
+				exit(1)
+			}
+			for _, v := range violations {
+				fmt.Fprintln(os.Stderr, v)
+			}
+			if len(violations) > 0 {
+				report()
+				exit(1)
+			}
+		}
+		if *full {
+			*terminalLimit = 0
+		}
+		if err := writeTerminalOutput(output, *terminalLimit); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing output: %v\n", err)
+			exit(1)
+		}
+		report()
+		if *sourceMapFile != "" {
+			sm, err := makeSourceMap(vm, root, output)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error producing source map for file %s: %v\n", file, err)
+				exit(1)
+			}
+			b, err := json.MarshalIndent(sm, "", "  ")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to marshal to JSON: %v\n", err)
+				exit(1)
+			}
+			if err := ioutil.WriteFile(*sourceMapFile, append(b, '\n'), 0o644); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing source map %s: %v\n", *sourceMapFile, err)
+				exit(1)
+			}
+		}
+
+	case "examples":
+		flags := newFlagSet(command)
+		args = parseFlags(flags, args)
+		if len(args) > 1 {
+			flags.Usage()
+			exit(1)
+		}
+		if len(args) == 0 {
+			for _, doc := range commands {
+				writeExamples(os.Stdout, doc)
+			}
+			break
+		}
+		doc, ok := findCommand(args[0])
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Unrecognized command %s\n", args[0])
+			exit(1)
+		}
+		writeExamples(os.Stdout, doc)
+
+	case "expand":
+		flags := newFlagSet(command)
+		args = parseFlags(flags, args)
+		if len(args) != 1 {
+			flags.Usage()
+			exit(1)
+		}
+		file, _ := uncons(args)
+		input, err := ioutil.ReadFile(file)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Unable to marshal to JSON: %v\n", err)
-			os.Exit(1)
+			fmt.Fprintf(os.Stderr, "Error reading file %s: %v\n", file, err)
+			exit(1)
+		}
+		_, _, err = formatter.SnippetToRawAST(file, string(input))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error importing AST for file %s: %v\n", file, err)
+			exit(1)
+		}
+		// output, err := makeVM().Expand(root, finalFodder)
+		// if err != nil {
+		// 	fmt.Fprintf(os.Stderr, "Error expanding file %s: %v\n", file, err)
+		// 	exit(1)
+		// }
+		// fmt.Print(output)
+
+	case "explore":
+		flags := newFlagSet(command)
+		addLimitFlags(flags)
+		args = parseFlags(flags, args)
+		if len(args) != 1 {
+			flags.Usage()
+			exit(1)
+		}
+		file := args[0]
+		if !isTerminal(os.Stdin) || !isTerminal(os.Stdout) {
+			fmt.Fprintf(os.Stderr, "The explore command needs a terminal, use dot, eval --source-map, or layers instead\n")
+			exit(1)
+		}
+		input, err := os.ReadFile(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading file %s: %v\n", file, err)
+			exit(1)
+		}
+		root, _, err := formatter.SnippetToRawAST(file, string(input))
+		if err != nil {
+			reportJsonnetError(err, "Unable to produce AST for file %s: %v\n", file, err)
+			exit(1)
+		}
+		evalCtx, cancel := limits.withTimeout(ctx)
+		defer cancel()
+		e := newExplorer(evalCtx, file, root)
+		if ctx.Err() != nil {
+			interrupted()
+		}
+		if err := e.run(os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Error exploring file %s: %v\n", file, err)
+			exit(1)
+		}
+
+	case "extract":
+		flags := newFlagSet(command)
+		all := flags.Bool("all", false, "also replace the expressions that are the same apart from whitespace and comments")
+		write := flags.Bool("w", false, "write result to the source file instead of stdout")
+		args = parseFlags(flags, args)
+		if len(args) != 2 {
+			flags.Usage()
+			exit(1)
+		}
+		r, err := parseSourceRange(args[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exit(1)
+		}
+		input, err := os.ReadFile(r.File)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading file %s: %v\n", r.File, err)
+			exit(1)
+		}
+		root, finalFodder, err := formatter.SnippetToRawAST(r.File, string(input))
+		if err != nil {
+			reportJsonnetError(err, "Unable to produce AST for file %s: %v\n", r.File, err)
+			exit(1)
+		}
+		if _, err := extractLocal(&root, r, args[1], *all); err != nil {
+			fmt.Fprintf(os.Stderr, "Error extracting %s: %v\n", args[0], err)
+			exit(1)
+		}
+		output, err := formatter.FormatNode(root, finalFodder, formatter.DefaultOptions())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error formatting file %s: %v\n", r.File, err)
+			exit(1)
+		}
+		if !*write {
+			fmt.Print(output)
+			break
+		}
+		if err := writeFileAtomic(r.File, []byte(output), false); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing file %s: %v\n", r.File, err)
+			exit(1)
+		}
+
+	case "hover":
+		flags := newFlagSet(command)
+		entry := flags.String("eval", "", "evaluate this file, which imports <file>, instead of <file>, like the environment that uses a library")
+		summary := flags.Bool("shape", false, "print a summary of the type and shape of the value instead of the value")
+		args = parseFlags(flags, args)
+		if len(args) != 1 {
+			flags.Usage()
+			exit(1)
+		}
+		pos, input := readSourcePosition(args[0])
+		h, err := newHover(pos.File, input, pos.Location, *summary)
+		if err != nil {
+			reportJsonnetError(err, "Error finding the expression at %s: %v\n", args[0], err)
+			exit(1)
+		}
+		if *entry == "" {
+			*entry = pos.File
+		}
+		value, captured, err := h.evaluate(ctx, *entry)
+		if ctx.Err() != nil {
+			interrupted()
+		}
+		if !captured {
+			if err != nil {
+				reportJsonnetError(err, "Error evaluating Jsonnet for file %s:\n%v\n", *entry, err)
+				exit(1)
+			}
+			fmt.Fprintf(os.Stderr, "The expression at %s is not evaluated by %s\n", h.loc, *entry)
+			exit(1)
+		}
+		description, err := describeHover(value, *summary)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error describing the value: %v\n", err)
+			exit(1)
+		}
+		fmt.Print(description)
+
+	case "imports":
+		flags := newFlagSet(command)
+		format := flags.String("format", "json", "output format, one of json or make")
+		target := flags.String("target", "", "target of the make rule (default is <file> with a .json extension)")
+		filename := flags.String("filename", defaultStdinFilename, "filename of the input read from stdin when <file> is -, used in error messages and to resolve relative imports")
+		args = parseFlags(flags, args)
+		if len(args) != 1 {
+			flags.Usage()
+			exit(1)
+		}
+		file, _ := uncons(args)
+		file, err := inputFile(file, *filename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading input: %v\n", err)
+			exit(1)
+		}
+		vm := makeVM()
+		imports, err := vm.FindDependencies("", []string{file})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to find imports for file %s: %v\n", file, err)
+			exit(1)
+		}
+		switch *format {
+		case "json":
+			b, err := json.MarshalIndent(imports, "", "  ")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to marshal to JSON: %v\n", err)
+				exit(1)
+			}
+			os.Stdout.Write(b)
+			os.Stdout.Write([]byte{'\n'})
+		case "make":
+			if *target == "" {
+				*target = strings.TrimSuffix(file, filepath.Ext(file)) + ".json"
+			}
+			fmt.Print(makeRule(*target, file, imports))
+		default:
+			fmt.Fprintf(os.Stderr, "Unrecognized imports format %s\n", *format)
+			exit(1)
+		}
+
+	case "import-usage":
+		flags := newFlagSet(command)
+		args = parseFlags(flags, args)
+		if len(args) != 1 {
+			flags.Usage()
+			exit(1)
+		}
+		file, _ := uncons(args)
+		vm := makeVM()
+		dependencies, err := vm.FindDependencies("", []string{file})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to find imports for file %s: %v\n", file, err)
+			exit(1)
+		}
+		in := &instrumenter{}
+		recorder := newImportRecorder(in.importer(makeImporter()), in)
+		vm.Importer(recorder)
+		vm.SetTraceOut(&probeWriter{w: os.Stderr, onEnter: recorder.enter, onExit: recorder.exit})
+		root, _, err := vm.ImportAST("", file)
+		if err != nil {
+			reportJsonnetError(err, "Unable to produce AST for file %s: %v\n", file, err)
+			exit(1)
+		}
+		_, err = toolvm.Evaluate(ctx, vm, root)
+		if ctx.Err() != nil {
+			interrupted()
+		}
+		if err != nil {
+			reportJsonnetError(err, "Error evaluating Jsonnet for file %s:\n%v\n", file, vm.ErrorFormatter.Format(err))
+			exit(1)
+		}
+		b, err := json.MarshalIndent(recorder.usage(file, dependencies), "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to marshal to JSON: %v\n", err)
+			exit(1)
+		}
+		os.Stdout.Write(b)
+		os.Stdout.Write([]byte{'\n'})
+
+	case "inline":
+		flags := newFlagSet(command)
+		target := flags.String("import", "", "import to inline: the path as written, a file path, or a local variable bound to an import")
+		write := flags.Bool("w", false, "write result to the source file instead of stdout")
+		args = parseFlags(flags, args)
+		if len(args) != 1 || *target == "" {
+			flags.Usage()
+			exit(1)
+		}
+		file, _ := uncons(args)
+		input, err := os.ReadFile(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading file %s: %v\n", file, err)
+			exit(1)
+		}
+		root, finalFodder, err := formatter.SnippetToRawAST(file, string(input))
+		if err != nil {
+			reportJsonnetError(err, "Unable to produce AST for file %s: %v\n", file, err)
+			exit(1)
+		}
+		if _, err := inlineImports(makeImporter(), file, &root, *target); err != nil {
+			fmt.Fprintf(os.Stderr, "Error inlining %s: %v\n", *target, err)
+			exit(1)
+		}
+		output, err := formatter.FormatNode(root, finalFodder, formatter.DefaultOptions())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error formatting file %s: %v\n", file, err)
+			exit(1)
+		}
+		if !*write {
+			fmt.Print(output)
+			break
+		}
+		if err := writeFileAtomic(file, []byte(output), false); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing file %s: %v\n", file, err)
+			exit(1)
+		}
+
+	case "layers":
+		flags := newFlagSet(command)
+		outputDir := flags.String("m", "", "write each layer to its own file in this directory, named by the location of the merge, with an index.json describing their order")
+		filename := flags.String("filename", defaultStdinFilename, "filename of the input read from stdin when <file> is -, used in error messages and to resolve relative imports")
+		addLimitFlags(flags)
+		args = parseFlags(flags, args)
+		if len(args) != 1 {
+			flags.Usage()
+			exit(1)
+		}
+		file, _ := uncons(args)
+		file, err := inputFile(file, *filename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading input: %v\n", err)
+			exit(1)
+		}
+		vm := makeVM()
+		root, _, err := vm.ImportAST("", file)
+		if err != nil {
+			reportJsonnetError(err, "Unable to produce AST for file %s: %v\n", file, err)
+			exit(1)
+		}
+		evalCtx, cancel := limits.withTimeout(ctx)
+		defer cancel()
+		found, err := layers.Find(evalCtx, vm, root)
+		if evalCtx.Err() != nil {
+			stopped(evalCtx)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error processing layers for file %s: %v\n", file, err)
+			exit(1)
+		}
+		b, err := json.MarshalIndent(found, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to marshal to JSON: %v\n", err)
+			exit(1)
+		}
+		if err := limits.checkOutput(b); err != nil {
+			fmt.Fprintf(os.Stderr, "Error processing layers for file %s: %v\n", file, err)
+			exit(1)
+		}
+		if *outputDir != "" {
+			paths, err := writeLayers(*outputDir, found)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing layers: %v\n", err)
+				exit(1)
+			}
+			for _, path := range paths {
+				fmt.Println(path)
+			}
+			break
+		}
+		os.Stdout.Write(b)
+		os.Stdout.Write([]byte{'\n'})
+
+	case "lint":
+		flags := newFlagSet(command)
+		enable := flags.String("enable", "", "comma separated lint rules to enable")
+		disable := flags.String("disable", "", "comma separated lint rules to disable")
+		configFile := flags.String("config", "", "JSON lint configuration file")
+		list := flags.Bool("list", false, "list the lint rules")
+		fix := flags.Bool("fix", false, "apply the fixes of fixable diagnostics to the source files")
+		reportFile := flags.String("report", "", "write a JSON report of the per-file results and timings to this file, or an HTML report if it ends in .html")
+		previousFile := flags.String("previous", "", "compare the --report with this previous JSON report")
+		progressMode := flags.String("progress", "auto", "report progress to stderr as a status line (tty), as JSON line events (json), or not at all (none); auto shows a status line if stderr is a terminal")
+		args = parseFlags(flags, args)
+		if *list {
+			for _, rule := range lintRules {
+				fmt.Printf("%s: %s\n", rule.Name, rule.Description)
+			}
+			break
+		}
+		if len(args) < 1 {
+			flags.Usage()
+			exit(1)
+		}
+		settings, err := lintSettings(*enable, *disable, *configFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error configuring lint rules: %v\n", err)
+			exit(1)
+		}
+		var files []string
+		for _, arg := range args {
+			if info, err := os.Stat(arg); err != nil || !info.IsDir() {
+				files = append(files, arg)
+				continue
+			}
+			found, err := findFiles(arg, ".jsonnet", ".libsonnet")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error finding files in %s: %v\n", arg, err)
+				exit(1)
+			}
+			files = append(files, found...)
+		}
+		previous := readPreviousReport(*previousFile)
+		report := newBatchReport(command)
+		progress := newProgressFlag(*progressMode, len(files))
+		failed := false
+		for _, file := range files {
+			if ctx.Err() != nil {
+				break
+			}
+			started := time.Now()
+			progress.start(file)
+			diagnostics, err := lint(file, settings)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error linting file %s: %v\n", file, err)
+				exit(1)
+			}
+			if *fix {
+				input, err := ioutil.ReadFile(file)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error reading file %s: %v\n", file, err)
+					exit(1)
+				}
+				fixed, applied := applyFixes(string(input), diagnostics)
+				if applied > 0 {
+					if err := writeFileAtomic(file, []byte(fixed), false); err != nil {
+						fmt.Fprintf(os.Stderr, "Error writing file %s: %v\n", file, err)
+						exit(1)
+					}
+					// Diagnostics are reported against the fixed file.
+					if diagnostics, err = lint(file, settings); err != nil {
+						fmt.Fprintf(os.Stderr, "Error linting file %s: %v\n", file, err)
+						exit(1)
+					}
+				}
+			}
+			progress.finish(file, len(diagnostics) == 0)
+			problems := make([]string, 0, len(diagnostics))
+			for _, diagnostic := range diagnostics {
+				fmt.Println(diagnostic)
+				problems = append(problems, fmt.Sprint(diagnostic))
+			}
+			report.add(file, problems, time.Since(started))
+			failed = failed || len(diagnostics) > 0
+		}
+		progress.end()
+		// The report of an interrupted command has the files that finished.
+		writeReport(report, previous, *reportFile)
+		if ctx.Err() != nil {
+			interrupted()
+		}
+		if failed {
+			exit(1)
+		}
+
+	case "params":
+		flags := newFlagSet(command)
+		filename := flags.String("filename", defaultStdinFilename, "filename of the input read from stdin when <file> is -, used in error messages and to resolve relative imports")
+		args = parseFlags(flags, args)
+		if len(args) != 1 {
+			flags.Usage()
+			exit(1)
+		}
+		file, _ := uncons(args)
+		file, err := inputFile(file, *filename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading input: %v\n", err)
+			exit(1)
+		}
+		input, err := readInput(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to read file %s: %v\n", file, err)
+			exit(1)
+		}
+		vm := makeVM()
+		params, err := findParams(vm, file, string(input))
+		if err != nil {
+			reportJsonnetError(err, "Unable to find the parameters of %s: %v\n", file, err)
+			exit(1)
+		}
+		b, err := json.MarshalIndent(params, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to marshal to JSON: %v\n", err)
+			exit(1)
+		}
+		os.Stdout.Write(b)
+		os.Stdout.Write([]byte{'\n'})
+
+	case "repl":
+		flags := newFlagSet(command)
+		allowEnv := flags.Bool("allow-env", false, "allow the env native function to read environment variables")
+		color := flags.String("color", "auto", "highlight evaluations: auto, always, or never")
+		indent := flags.Int("indent", 3, "number of spaces per level of indentation of evaluations, or 0 to write each evaluation on one line")
+		pageLines := flags.Int("page-lines", -1, "number of lines of an evaluation to show before paging, or 0 to never page (default is the terminal height)")
+		traceFormat := flags.String("trace-format", "text", "write std.trace messages as text or as JSON records, one per line, with their location")
+		traceFile := flags.String("trace-out", "", "write std.trace messages to this file instead of stderr")
+		workspaceFile := flags.String("workspace", "", "workspace file that the session is restored from and saved to, or none to not save the session (default is the closest "+workspaceFileName+" at or above the current directory, if there is one)")
+		listen := flags.String("listen", "", "serve sessions to clients connecting to this address, unix:///path/to/socket or tcp://host:port, instead of reading stdin")
+		as := flags.String("as", "it", "name of the local variable that <file> is imported as")
+		addLimitFlags(flags)
+		args = parseFlags(flags, args)
+		if len(args) > 1 {
+			flags.Usage()
+			exit(1)
+		}
+		if *allowEnv {
+			config.AllowEnv = true
+		}
+		var preload string
+		if len(args) == 1 {
+			if _, err := os.Stat(args[0]); err != nil {
+				fmt.Fprintf(os.Stderr, "Error reading file %s: %v\n", args[0], err)
+				exit(1)
+			}
+			// The directory of the file takes precedence over other Jpaths, as it does for relative imports.
+			jpathFlags = append(jpathFlags, filepath.Dir(args[0]))
+			// JSON strings are also Jsonnet strings.
+			path, _ := json.Marshal(args[0])
+			preload = fmt.Sprintf("local %s = import %s", *as, path)
+		}
+		if err := setTraceOutput(*traceFormat, *traceFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error configuring trace output: %v\n", err)
+			exit(1)
+		}
+		if *listen != "" {
+			// Clients are not terminals so evaluations are only highlighted with --color always, and never paged.
+			if *color == "auto" {
+				*color = "never"
+			}
+			*pageLines = 0
+		}
+		output, err := newREPLOutput(*color, *indent, *pageLines)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error configuring output: %v\n", err)
+			exit(1)
+		}
+		r := repl.New(makeVM(), &output)
+		r.Timeout, r.NewVM, r.MaxOutputBytes = limits.timeout, makeVM, limits.maxOutputBytes
+		var workspacePath string
+		if *workspaceFile == "" {
+			*workspaceFile, _ = findWorkspace()
+		}
+		if *workspaceFile != "" && *workspaceFile != "none" {
+			w, err := loadWorkspace(*workspaceFile)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error loading workspace: %v\n", err)
+				exit(1)
+			}
+			restoreWorkspace(r, w)
+			workspacePath = *workspaceFile
+		}
+		if preload != "" {
+			// A restored session may have already imported the file.
+			preloaded := false
+			for _, expr := range r.Namespaces[0].Exprs {
+				preloaded = preloaded || expr == preload
+			}
+			if !preloaded {
+				r.Namespaces[0].Exprs = append([]string{preload}, r.Namespaces[0].Exprs...)
+			}
+		}
+		if *listen != "" {
+			network, address, err := listenAddress(*listen)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error parsing --listen: %v\n", err)
+				exit(1)
+			}
+			l, err := net.Listen(network, address)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error listening: %v\n", err)
+				exit(1)
+			}
+			fmt.Printf("Listening on %s\n", l.Addr())
+			if workspacePath != "" {
+				fmt.Printf("Using workspace %s\n", workspacePath)
+			}
+			if preload != "" {
+				fmt.Printf("Imported %s as %s in namespace 0\n", args[0], *as)
+			}
+			if err := newREPLServer(r, workspacePath, output).serve(ctx, l); err != nil {
+				fmt.Fprintf(os.Stderr, "Error serving REPL sessions: %v\n", err)
+				exit(1)
+			}
+			break
+		}
+
+		// read
+		in := bufio.NewScanner(os.Stdin)
+		in.Split(repl.ScanDoubleSemiColon)
+		read := func() (string, error) {
+			in.Scan()
+			return in.Text(), in.Err()
+		}
+		fmt.Print(repl.Help)
+		if workspacePath != "" {
+			fmt.Printf("Using workspace %s\n", workspacePath)
+		}
+		if preload != "" {
+			fmt.Printf("Imported %s as %s in namespace 0\n", args[0], *as)
+		}
+		fmt.Print(r.Prompt())
+		input, err := read()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading input: %v\n", err)
+			exit(1)
+		}
+
+		for {
+			// eval
+			result, err := r.Eval(input)
+			if err != nil {
+				if err == repl.ErrExit {
+					fmt.Println("Bye!")
+					exit(0)
+				}
+				fmt.Printf("Evaluation error: %v\n", err)
+			}
+
+			// print
+			fmt.Print(result)
+			if err := saveWorkspace(r, workspacePath); err != nil {
+				fmt.Fprintf(os.Stderr, "Error saving workspace: %v\n", err)
+			}
+
+			// loop
+			fmt.Print(r.Prompt())
+			input, err = read()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error reading input: %v\n", err)
+			}
+		}
+
+	case "schema":
+		flags := newFlagSet(command)
+		filename := flags.String("filename", defaultStdinFilename, "filename of the input read from stdin when <file> is -, used in error messages and to resolve relative imports")
+		args = parseFlags(flags, args)
+		if len(args) != 1 {
+			flags.Usage()
+			exit(1)
+		}
+		file, _ := uncons(args)
+		file, err := inputFile(file, *filename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading input: %v\n", err)
+			exit(1)
+		}
+		vm := makeVM()
+		root, _, err := vm.ImportAST("", file)
+		if err != nil {
+			reportJsonnetError(err, "Unable to produce AST for file %s: %v\n", file, err)
+			exit(1)
+		}
+		output, err := toolvm.Evaluate(ctx, vm, root)
+		if ctx.Err() != nil {
+			interrupted()
+		}
+		if err != nil {
+			reportJsonnetError(err, "Error evaluating Jsonnet for file %s:\n%v\n", file, vm.ErrorFormatter.Format(err))
+			exit(1)
+		}
+		schema, err := makeSchema(vm, root, output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error inferring schema for file %s: %v\n", file, err)
+			exit(1)
+		}
+		b, err := json.MarshalIndent(schema, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to marshal to JSON: %v\n", err)
+			exit(1)
+		}
+		os.Stdout.Write(b)
+		os.Stdout.Write([]byte{'\n'})
+
+	case "scrub":
+		flags := newFlagSet(command)
+		keep := flags.String("keep", defaultScrubKeep, "regular expression matching hostnames that are not replaced")
+		evaluate := flags.Bool("evaluate", false, "scrub the evaluated JSON output instead of the source")
+		write := flags.Bool("w", false, "write result to the source file instead of stdout")
+		args = parseFlags(flags, args)
+		if len(args) != 1 || (*evaluate && *write) {
+			flags.Usage()
+			exit(1)
+		}
+		file, _ := uncons(args)
+		keepRegexp, err := regexp.Compile(*keep)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --keep regular expression: %v\n", err)
+			exit(1)
+		}
+		s := newScrubber(keepRegexp)
+
+		if *evaluate {
+			vm := makeVM()
+			root, _, err := vm.ImportAST("", file)
+			if err != nil {
+				reportJsonnetError(err, "Unable to produce AST for file %s: %v\n", file, err)
+				exit(1)
+			}
+			evaluated, err := toolvm.Evaluate(ctx, vm, root)
+			if ctx.Err() != nil {
+				interrupted()
+			}
+			if err != nil {
+				reportJsonnetError(err, "Error evaluating Jsonnet for file %s:\n%v\n", file, vm.ErrorFormatter.Format(err))
+				exit(1)
+			}
+			var value interface{}
+			if err := json.Unmarshal([]byte(evaluated), &value); err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to parse output of file %s: %v\n", file, err)
+				exit(1)
+			}
+			b, err := json.MarshalIndent(s.scrubValue(value, false), "", "  ")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to marshal to JSON: %v\n", err)
+				exit(1)
+			}
+			os.Stdout.Write(b)
+			os.Stdout.Write([]byte{'\n'})
+			fmt.Fprintln(os.Stderr, s.summary())
+			break
+		}
+
+		input, err := ioutil.ReadFile(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading file %s: %v\n", file, err)
+			exit(1)
+		}
+		root, _, err := formatter.SnippetToRawAST(file, string(input))
+		if err != nil {
+			reportJsonnetError(err, "Unable to produce AST for file %s: %v\n", file, err)
+			exit(1)
+		}
+		scrubbed, _ := applyEdits(string(input), s.scrubSource(root))
+		fmt.Fprintln(os.Stderr, s.summary())
+		if !*write {
+			fmt.Print(scrubbed)
+			break
+		}
+		if err := writeFileAtomic(file, []byte(scrubbed), false); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing file %s: %v\n", file, err)
+			exit(1)
+		}
+
+	case "serve":
+		flags := newFlagSet(command)
+		addr := flags.String("addr", "localhost:8080", "address to listen on, host:port or unix:///path/to/socket")
+		root := flags.String("root", ".", "directory that the files of requests are evaluated from and that snippets import relative to")
+		timeout := flags.Duration("timeout", 30*time.Second, "maximum duration of an evaluation")
+		maxConcurrent := flags.Int("max-concurrent", runtime.NumCPU(), "maximum number of concurrent evaluations")
+		allowEnv := flags.Bool("allow-env", false, "allow the env native function to read environment variables")
+		flags.Func("J", "add `DIR` to the Jpaths, with the highest precedence, which can be repeated", func(dir string) error {
+			jpathFlags = append(jpathFlags, dir)
+			return nil
+		})
+		args = parseFlags(flags, args)
+		if len(args) != 0 || *timeout <= 0 || *maxConcurrent < 1 {
+			flags.Usage()
+			exit(1)
+		}
+		if *allowEnv {
+			config.AllowEnv = true
+		}
+		settings := newReloader(projectFiles, func() error { return reloadProjectConfig(*allowEnv) })
+		settings.reloadOnSignal()
+		server := newEvalServer(*root, *timeout, *maxConcurrent, settings)
+		if err := serve(ctx, *addr, server.handler()); err != nil {
+			fmt.Fprintf(os.Stderr, "Error serving evaluations: %v\n", err)
+			exit(1)
+		}
+
+	case "shrink":
+		flags := newFlagSet(command)
+		dir := flags.String("dir", "shrunk", "directory to write the reduced files to")
+		args = parseFlags(flags, args)
+		if len(args) != 1 {
+			flags.Usage()
+			exit(1)
+		}
+		file, _ := uncons(args)
+		s, err := newShrinker(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error evaluating Jsonnet for file %s: %v\n", file, err)
+			exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "Reproducing %s\n", s.signature)
+		s.shrink(os.Stderr)
+		written, err := s.write(*dir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing reduced files: %v\n", err)
+			exit(1)
+		}
+		for _, path := range written {
+			fmt.Println(path)
+		}
+
+	case "sort-fields":
+		flags := newFlagSet(command)
+		order := flags.String("order", strings.Join(defaultFieldOrder, ","), "comma separated field names to sort first")
+		write := flags.Bool("w", false, "write result to the source file instead of stdout")
+		args = parseFlags(flags, args)
+		if len(args) < 1 {
+			flags.Usage()
+			exit(1)
+		}
+		for _, file := range args {
+			input, err := ioutil.ReadFile(file)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error reading file %s: %v\n", file, err)
+				exit(1)
+			}
+			root, finalFodder, err := formatter.SnippetToRawAST(file, string(input))
+			if err != nil {
+				reportJsonnetError(err, "Unable to produce AST for file %s: %v\n", file, err)
+				exit(1)
+			}
+			if err := sortFields(root, strings.Split(*order, ",")); err != nil {
+				fmt.Fprintf(os.Stderr, "Error sorting fields for file %s: %v\n", file, err)
+				exit(1)
+			}
+			output, err := formatter.FormatNode(root, finalFodder, formatter.DefaultOptions())
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error formatting file %s: %v\n", file, err)
+				exit(1)
+			}
+			if !*write {
+				fmt.Print(output)
+				continue
+			}
+			if err := writeFileAtomic(file, []byte(output), false); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing file %s: %v\n", file, err)
+				exit(1)
+			}
+		}
+
+	case "symbols":
+		flags := newFlagSet(command)
+		filename := flags.String("filename", defaultStdinFilename, "filename of the input read from stdin when <file> is -, used in error messages and to resolve relative imports")
+		positionEncoding := flags.String("position-encoding", string(location.Runes), "unit that columns are counted in: rune, byte, or utf-16")
+		args = parseFlags(flags, args)
+		if len(args) != 1 {
+			flags.Usage()
+			exit(1)
+		}
+		encoding, err := location.ParseEncoding(*positionEncoding)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exit(1)
+		}
+		file, _ := uncons(args)
+		file, err = inputFile(file, *filename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading input: %v\n", err)
+			exit(1)
+		}
+		vm := makeVM()
+		root, _, err := vm.ImportAST("", file)
+		if err != nil {
+			reportJsonnetError(err, "Unable to produce AST for file %s: %v\n", file, err)
+			exit(1)
+		}
+		found, err := symbols.Find(&root, []string{"$"})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error processing symbols for file %s: %v\n", file, err)
+			exit(1)
+		}
+		if encoding != location.Runes {
+			source, err := readInput(file)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error reading input: %v\n", err)
+				exit(1)
+			}
+			symbols.Encode(found, string(source), encoding)
+		}
+		b, err := json.MarshalIndent(found, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to marshal to JSON: %v\n", err)
+			exit(1)
+		}
+		os.Stdout.Write(b)
+		os.Stdout.Write([]byte{'\n'})
+
+	case "test":
+		flags := newFlagSet(command)
+		update := flags.Bool("update", false, "write the actual values of tests to their golden files instead of comparing them")
+		verbose := flags.Bool("v", false, "also report passing tests")
+		allowEnv := flags.Bool("allow-env", false, "allow the env native function to read environment variables")
+		reportFile := flags.String("report", "", "write a JSON report of the per-file results and timings to this file, or an HTML report if it ends in .html")
+		previousFile := flags.String("previous", "", "compare the --report with this previous JSON report")
+		progressMode := flags.String("progress", "auto", "report progress to stderr as a status line (tty), as JSON line events (json), or not at all (none); auto shows a status line if stderr is a terminal")
+		args = parseFlags(flags, args)
+		if *allowEnv {
+			config.AllowEnv = true
+		}
+		if len(args) > 1 {
+			flags.Usage()
+			exit(1)
+		}
+		dir := "."
+		if len(args) == 1 {
+			dir = args[0]
+		}
+		files, err := findFiles(dir, testFileSuffix)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error finding test files in %s: %v\n", dir, err)
+			exit(1)
+		}
+		previous := readPreviousReport(*previousFile)
+		report := newBatchReport(command)
+		progress := newProgressFlag(*progressMode, len(files))
+		failed := false
+		for _, file := range files {
+			if ctx.Err() != nil {
+				break
+			}
+			started := time.Now()
+			progress.start(file)
+			results, err := runTestFile(ctx, file, *update)
+			if ctx.Err() != nil {
+				break
+			}
+			if err != nil {
+				progress.finish(file, false)
+				fmt.Printf("FAIL\t%s\n%v\n", file, err)
+				report.add(file, []string{err.Error()}, time.Since(started))
+				failed = true
+				continue
+			}
+			passed := true
+			for _, result := range results {
+				passed = passed && result.Passed
+			}
+			progress.finish(file, passed)
+			failures := 0
+			var problems []string
+			for _, result := range results {
+				loc := result.LocationRange.String()
+				if result.Passed {
+					if *verbose {
+						fmt.Printf("--- PASS: %s (%s)\n", result.Name, loc)
+					}
+					continue
+				}
+				failures++
+				fmt.Printf("--- FAIL: %s (%s)\n%s", result.Name, loc, indent(result.Message, "    "))
+				problems = append(problems, fmt.Sprintf("%s (%s): %s", result.Name, loc, result.Message))
+			}
+			report.add(file, problems, time.Since(started))
+			if failures > 0 {
+				fmt.Printf("FAIL\t%s (%d tests, %d failed)\n", file, len(results), failures)
+				failed = true
+				continue
+			}
+			fmt.Printf("ok\t%s (%d tests)\n", file, len(results))
+		}
+		progress.end()
+		// The report of an interrupted command has the files that finished.
+		writeReport(report, previous, *reportFile)
+		if ctx.Err() != nil {
+			interrupted()
+		}
+		if failed {
+			exit(1)
+		}
+
+	case "transform":
+		flags := newFlagSet(command)
+		sortFields := flags.Bool("sort-fields", false, "sort the fields of every object alphabetically")
+		stripComments := flags.Bool("strip-comments", false, "remove all comments")
+		quotes := flags.String("quotes", "single", "quote style of string literals: single, double, or leave")
+		minify := flags.Bool("minify", false, "remove all comments and whitespace that are not needed")
+		write := flags.Bool("w", false, "write result to the source file instead of stdout")
+		args = parseFlags(flags, args)
+		if len(args) < 1 {
+			flags.Usage()
+			exit(1)
+		}
+		style, ok := stringStyles[*quotes]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Invalid --quotes %s, expected single, double, or leave\n", *quotes)
+			exit(1)
+		}
+		opts := transformOptions{sortFields: *sortFields, stripComments: *stripComments, quotes: style, minify: *minify}
+		for _, file := range args {
+			input, err := ioutil.ReadFile(file)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error reading file %s: %v\n", file, err)
+				exit(1)
+			}
+			output, err := transformSource(file, string(input), opts)
+			if err != nil {
+				reportJsonnetError(err, "Unable to transform file %s: %v\n", file, err)
+				exit(1)
+			}
+			if !*write {
+				fmt.Print(output)
+				continue
+			}
+			if err := writeFileAtomic(file, []byte(output), false); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing file %s: %v\n", file, err)
+				exit(1)
+			}
+		}
+
+	case "upgrade-check":
+		flags := newFlagSet(command)
+		lockFile := flags.String("lock", "", "jsonnet-bundler lock file of the project, by default the "+lockFileName+" of the project containing the current directory")
+		args = parseFlags(flags, args)
+		if len(args) != 2 {
+			flags.Usage()
+			exit(1)
+		}
+		if *lockFile == "" {
+			root, ok := findProjectRoot(".")
+			if !ok {
+				fmt.Fprintf(os.Stderr, "No %s found in the current directory or above\n", lockFileName)
+				exit(1)
+			}
+			*lockFile = filepath.Join(root, lockFileName)
+		}
+		dependency, err := findLockDependency(*lockFile, args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error finding dependency: %v\n", err)
+			exit(1)
+		}
+		root := filepath.Dir(*lockFile)
+		if rel, err := filepath.Rel(absPath("."), absPath(root)); err == nil {
+			root = rel
+		}
+		changes, breaking, err := upgradeCheck(root, dependency, args[1])
+		if err != nil {
+			reportJsonnetError(err, "Error checking upgrade of %s: %v\n", args[0], err)
+			exit(1)
+		}
+		for _, change := range changes {
+			fmt.Println(change)
+		}
+		for _, diagnostic := range breaking {
+			fmt.Println(diagnostic)
+		}
+		if len(breaking) > 0 {
+			exit(1)
+		}
+
+	case "validate":
+		flags := newFlagSet(command)
+		schemaFile := flags.String("schema", "", "check the output of each <file> against the JSON Schema in this file")
+		openAPIFile := flags.String("openapi", "", "check the Kubernetes objects in the output of each <file> against the definitions of this Kubernetes OpenAPI document")
+		args = parseFlags(flags, args)
+		if len(args) < 1 || (*schemaFile == "" && *openAPIFile == "") {
+			flags.Usage()
+			exit(1)
+		}
+		v, err := newValidator(*schemaFile, *openAPIFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading schema: %v\n", err)
+			exit(1)
+		}
+		failed := false
+		for _, file := range args {
+			if ctx.Err() != nil {
+				interrupted()
+			}
+			vm := makeVM()
+			root, _, err := vm.ImportAST("", file)
+			if err != nil {
+				reportJsonnetError(err, "Unable to produce AST for file %s: %v\n", file, err)
+				exit(1)
+			}
+			output, err := toolvm.Evaluate(ctx, vm, root)
+			if ctx.Err() != nil {
+				interrupted()
+			}
+			if err != nil {
+				reportJsonnetError(err, "Error evaluating Jsonnet for file %s:\n%v\n", file, vm.ErrorFormatter.Format(err))
+				exit(1)
+			}
+			violations, err := v.validate(vm, root, output)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error validating output for file %s: %v\n", file, err)
+				exit(1)
+			}
+			for _, violation := range violations {
+				fmt.Println(violation)
+			}
+			failed = failed || len(violations) > 0
+		}
+		if failed {
+			exit(1)
 		}
-		os.Stdout.Write(b)
-		os.Stdout.Write([]byte{'\n'})
 
 	default:
 		fmt.Fprintf(os.Stderr, "Unrecognized command %s\n", command)
 		help(os.Stderr)
-		os.Exit(1)
+		exit(1)
 	}
+	exit(0)
 }
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/mattn/go-isatty"
+)
+
+// globalOptions are the options shared by every command.
+// They can be given before or after the command name.
+type globalOptions struct {
+	// Output is the file that stdout is written to instead of the terminal.
+	Output string
+	// CreateDirs creates the missing parent directories of Output.
+	CreateDirs bool
+	// ErrorFormat is the format of Jsonnet parse and evaluation errors, either text or json.
+	ErrorFormat string
+	// Plain disables colors, status lines, and paging.
+	Plain bool
+	// UseDaemon runs the command in a daemon, if the daemon runs it.
+	UseDaemon bool
+	// DaemonAddr is the address of the daemon. If it is empty, the closest daemon socket is used.
+	DaemonAddr string
+	// TrustProjectConfig allows the project configuration to run import hook commands and to allow the env
+	// native function.
+	TrustProjectConfig bool
+}
+
+// globalUsage describes the global options.
+const globalUsage = `  -o, --output <file>
+    	write stdout to <file>, which is only replaced if the command succeeds
+  --create-dirs
+    	create the missing parent directories of the --output file
+  --error-format text|json
+    	write Jsonnet parse and evaluation errors to stderr as text or as JSON records, one per line (default "text")
+  --plain
+    	write plain text without colors, status lines, or paging, for screen readers and dumb terminals;
+    	the default when $TERM is dumb
+  --use-daemon[=<address>]
+    	run complete, definition, eval, imports, lint, and symbols in the daemon listening on the address, with warm caches
+    	(default is the closest .jsonnet-tool-daemon.sock at or above the current directory)
+  --trust-project-config
+    	allow the jsonnet-tool.json project configuration to run import hook commands and to set allowEnv
+`
+
+// parseGlobalOptions removes the global options from the arguments.
+// All arguments after a "--" terminator are left as they are.
+func parseGlobalOptions(args []string) (globalOptions, []string, error) {
+	options := globalOptions{ErrorFormat: "text"}
+	rest := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") {
+			name = ""
+		}
+		switch name {
+		case "o", "output", "error-format":
+			if !hasValue {
+				if i+1 == len(args) {
+					return options, nil, fmt.Errorf("flag needs an argument: %s", arg)
+				}
+				i++
+				value = args[i]
+			}
+			if name == "error-format" {
+				if value != "text" && value != "json" {
+					return options, nil, fmt.Errorf("invalid value %q for flag %s: must be text or json", value, arg)
+				}
+				options.ErrorFormat = value
+				continue
+			}
+			options.Output = value
+		case "create-dirs":
+			options.CreateDirs = !hasValue || value == "true"
+		case "plain":
+			options.Plain = !hasValue || value == "true"
+		case "use-daemon":
+			options.UseDaemon, options.DaemonAddr = true, value
+		case "trust-project-config":
+			options.TrustProjectConfig = !hasValue || value == "true"
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	return options, rest, nil
+}
+
+// outputCapture captures everything written to stdout so that it can be written to the --output file
+// only if the command succeeds.
+type outputCapture struct {
+	path       string
+	createDirs bool
+	// w replaces os.Stdout.
+	w    *os.File
+	buf  bytes.Buffer
+	err  error
+	done chan struct{}
+}
+
+// captureOutput replaces os.Stdout with a pipe that is read into memory until the capture is committed.
+func captureOutput(path string, createDirs bool) (*outputCapture, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	c := &outputCapture{path: path, createDirs: createDirs, w: w, done: make(chan struct{})}
+	go func() {
+		_, c.err = c.buf.ReadFrom(r)
+		r.Close()
+		close(c.done)
+	}()
+	os.Stdout = w
+	return c, nil
+}
+
+// commit writes the captured output to the --output file.
+func (c *outputCapture) commit() error {
+	c.w.Close()
+	<-c.done
+	if c.err != nil {
+		return c.err
+	}
+	return writeFileAtomic(c.path, c.buf.Bytes(), c.createDirs)
+}
+
+// writeFileAtomic writes the data to a temporary file in the same directory as path and renames it to path
+// so that path is never partially written. The existing permissions of the file are kept, otherwise the
+// file is readable by everyone. If createDirs is true, the missing parent directories of path are created.
+func writeFileAtomic(path string, data []byte, createDirs bool) error {
+	if createDirs {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+	}
+	tmp, err := writeTemp(path, data)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// writeTemp writes the data to a temporary file in the same directory as path, with the permissions of path if
+// it exists, and returns the name of the temporary file.
+func writeTemp(path string, data []byte) (name string, err error) {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := f.Chmod(mode); err != nil {
+		return "", err
+	}
+	if _, err := f.Write(data); err != nil {
+		return "", err
+	}
+	if err := f.Sync(); err != nil {
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// stagedFiles are the outputs of a command that writes multiple files. Each file is written to a temporary file
+// next to its path and all of them replace their paths only when committed, so that an interrupted or failed
+// command does not leave some files updated and others not.
+type stagedFiles struct {
+	mu sync.Mutex
+	// paths are the staged paths in the order they were first written.
+	paths []string
+	// temps are the temporary files by path.
+	temps map[string]string
+	// dirs are the directories created for the files, parents first.
+	dirs []string
+}
+
+// write stages the data to be written to path, creating the missing parent directories of path.
+func (s *stagedFiles) write(path string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.mkdirAll(filepath.Dir(path)); err != nil {
+		return err
+	}
+	tmp, err := writeTemp(path, data)
+	if err != nil {
+		return err
+	}
+	if s.temps == nil {
+		s.temps = make(map[string]string)
+	}
+	if previous, ok := s.temps[path]; ok {
+		os.Remove(previous)
+	} else {
+		s.paths = append(s.paths, path)
+	}
+	s.temps[path] = tmp
+	return nil
+}
+
+// mkdirAll creates the directory and its missing parents, recording the directories that it creates.
+func (s *stagedFiles) mkdirAll(dir string) error {
+	var missing []string
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil {
+			break
+		}
+		missing = append(missing, d)
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		s.dirs = append(s.dirs, missing[i])
+	}
+	return nil
+}
+
+// commit renames the staged files to their paths. If a file cannot be renamed, the files that are not yet
+// renamed are discarded.
+func (s *stagedFiles) commit() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, path := range s.paths {
+		if err := os.Rename(s.temps[path], path); err != nil {
+			for _, rest := range s.paths[i:] {
+				os.Remove(s.temps[rest])
+			}
+			s.paths, s.temps = nil, nil
+			return err
+		}
+	}
+	s.paths, s.temps, s.dirs = nil, nil, nil
+	return nil
+}
+
+// discard removes the staged files and the directories created for them that are left empty.
+func (s *stagedFiles) discard() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, path := range s.paths {
+		os.Remove(s.temps[path])
+	}
+	// os.Remove does not remove directories that are not empty.
+	for i := len(s.dirs) - 1; i >= 0; i-- {
+		os.Remove(s.dirs[i])
+	}
+	s.paths, s.temps, s.dirs = nil, nil, nil
+}
+
+// isTerminal reports whether the file is a terminal.
+func isTerminal(f *os.File) bool {
+	fd := f.Fd()
+	return isatty.IsTerminal(fd) || isatty.IsCygwinTerminal(fd)
+}
+
+// plain is true if the --plain global option is given or $TERM is dumb.
+// Plain output has the same information without colors, status lines, or paging.
+var plain bool
+
+// interactive reports whether the file is a terminal that can show colors, status lines, and a pager.
+func interactive(f *os.File) bool {
+	return !plain && isTerminal(f)
+}
+
+// defaultTerminalLimit is the default size in bytes of output that is written to a terminal without paging or truncation.
+const defaultTerminalLimit = 1 << 20
+
+// writeTerminalOutput writes the output to stdout. If stdout is a terminal and the output is larger than limit bytes,
+// it is piped through $PAGER if it is set, and is otherwise truncated at a line break with a notice on stderr.
+// Output written to a pipe or file, and output when limit is not positive, is always written in full.
+func writeTerminalOutput(output string, limit int) error {
+	if limit <= 0 || len(output) <= limit || !interactive(os.Stdout) {
+		_, err := fmt.Print(output)
+		return err
+	}
+	if pager := os.Getenv("PAGER"); pager != "" {
+		return page(pager, output)
+	}
+	truncated := output[:limit]
+	if i := strings.LastIndexByte(truncated, '\n'); i >= 0 {
+		truncated = truncated[:i+1]
+	}
+	if _, err := fmt.Print(truncated); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "... output truncated after %d of %d bytes. Use --full, set $PAGER, or redirect stdout for the rest.\n", len(truncated), len(output))
+	return nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/formatter"
+)
+
+// param is a parameter of a function.
+type param struct {
+	Name string
+	// Required is true if the parameter has no default argument.
+	Required bool
+	// Default is the value of a literal default argument.
+	Default json.RawMessage `json:",omitempty"`
+	// DefaultSource is the default argument unparsed as Jsonnet, literal or not.
+	DefaultSource string `json:",omitempty"`
+	// Doc is the text of the comments describing the parameter.
+	Doc           string `json:",omitempty"`
+	LocationRange LocationRange
+}
+
+// functionParams describes the function that a file evaluates to.
+type functionParams struct {
+	File string
+	// Doc is the text of the comments preceding the function.
+	Doc           string `json:",omitempty"`
+	Params        []param
+	LocationRange LocationRange
+}
+
+// commentText returns the text of the comments in the fodder without their comment markers.
+func commentText(fodder ast.Fodder) string {
+	var lines []string
+	for _, elem := range fodder {
+		for _, comment := range elem.Comment {
+			for _, line := range strings.Split(comment, "\n") {
+				line = strings.TrimSpace(line)
+				for _, marker := range []string{"//", "#", "/*"} {
+					line = strings.TrimPrefix(line, marker)
+				}
+				line = strings.TrimSuffix(line, "*/")
+				line = strings.TrimPrefix(strings.TrimSpace(line), "* ")
+				if line = strings.TrimSpace(line); line != "" && line != "*" {
+					lines = append(lines, line)
+				}
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// scopedBind is a local variable in scope and the comments that precede it.
+type scopedBind struct {
+	bind *ast.LocalBind
+	doc  ast.Fodder
+}
+
+// findFunction follows the locals, variables, and parentheses that the node evaluates through to
+// the function literal it evaluates to, if any.
+// It returns the function and the fodder of the comments that precede it.
+// binds are the local variables in scope.
+func findFunction(node ast.Node, binds map[ast.Identifier]scopedBind, doc ast.Fodder) (*ast.Function, ast.Fodder) {
+	switch n := node.(type) {
+	case *ast.Function:
+		return n, append(doc, n.Fodder...)
+	case *ast.Parens:
+		return findFunction(n.Inner, binds, append(doc, n.Fodder...))
+	case *ast.Local:
+		scope := make(map[ast.Identifier]scopedBind, len(binds)+len(n.Binds))
+		for name, bind := range binds {
+			scope[name] = bind
+		}
+		for i := range n.Binds {
+			bind := scopedBind{bind: &n.Binds[i], doc: n.Binds[i].VarFodder}
+			// Comments before the first bind of a local precede the local keyword.
+			if i == 0 {
+				bind.doc = append(append(ast.Fodder{}, doc...), append(n.Fodder, bind.doc...)...)
+			}
+			scope[n.Binds[i].Variable] = bind
+		}
+		return findFunction(n.Body, scope, nil)
+	case *ast.Var:
+		b, ok := binds[n.Id]
+		if !ok {
+			return nil, nil
+		}
+		if b.bind.Fun != nil {
+			return b.bind.Fun, b.doc
+		}
+		return findFunction(b.bind.Body, binds, b.doc)
+	}
+	return nil, nil
+}
+
+// isLiteral returns true if the node is a literal value, or an array or object of literal values.
+func isLiteral(node ast.Node) bool {
+	switch n := node.(type) {
+	case *ast.LiteralNull, *ast.LiteralBoolean, *ast.LiteralNumber, *ast.LiteralString:
+		return true
+	case *ast.Unary:
+		return isLiteral(n.Expr)
+	case *ast.Parens:
+		return isLiteral(n.Inner)
+	case *ast.Array:
+		for _, elem := range n.Elements {
+			if !isLiteral(elem.Expr) {
+				return false
+			}
+		}
+		return true
+	case *ast.Object:
+		for _, field := range n.Fields {
+			if _, ok := fieldName(field); !ok || field.Method != nil || field.SuperSugar || !isLiteral(field.Expr2) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// sourceText returns the source of the node.
+func sourceText(input string, node ast.Node) string {
+	loc := node.Loc()
+	return input[sourceOffset(input, loc.Begin):sourceOffset(input, loc.End)]
+}
+
+// unparse returns the Jsonnet source of the raw expression, formatted like jsonnetfmt without the comments
+// and line breaks that precede it.
+func unparse(node ast.Node) (string, error) {
+	if fodder := node.OpenFodder(); fodder != nil {
+		*fodder = nil
+	}
+	source, err := formatter.FormatNode(node, nil, formatter.DefaultOptions())
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(source), nil
+}
+
+// paramDocs returns the doc comments of each parameter of the function.
+// Comments before a parameter describe it, as do comments that end the line of the parameter.
+func paramDocs(fn *ast.Function) []string {
+	docs := make([][]ast.Fodder, len(fn.Parameters))
+	for i, p := range fn.Parameters {
+		leading := p.NameFodder
+		// A comment that ends the line of the previous parameter describes the previous parameter.
+		if i > 0 && len(leading) > 0 && isTrailingComment(leading[0]) {
+			docs[i-1] = append(docs[i-1], leading[:1])
+			leading = leading[1:]
+		}
+		docs[i] = append(docs[i], leading, p.CommaFodder)
+	}
+	if n := len(fn.Parameters); n > 0 && len(fn.ParenRightFodder) > 0 && isTrailingComment(fn.ParenRightFodder[0]) {
+		docs[n-1] = append(docs[n-1], fn.ParenRightFodder[:1])
+	}
+	texts := make([]string, len(docs))
+	for i, fodders := range docs {
+		var all ast.Fodder
+		for _, f := range fodders {
+			all = append(all, f...)
+		}
+		texts[i] = commentText(all)
+	}
+	return texts
+}
+
+// findParams describes the parameters of the function that the file evaluates to.
+// input is the source of the file. Literal default arguments are evaluated with vm.
+// If the file does not statically evaluate to a function literal, the type of its value is reported in the error.
+func findParams(vm *jsonnet.VM, file string, input string) (functionParams, error) {
+	root, _, err := formatter.SnippetToRawAST(file, input)
+	if err != nil {
+		return functionParams{}, err
+	}
+	fn, doc := findFunction(root, nil, nil)
+	if fn == nil {
+		return functionParams{}, notAFunctionError(vm, file, input)
+	}
+	result := functionParams{File: file, Doc: commentText(doc), LocationRange: makeLocationRange(fn.Loc())}
+	docs := paramDocs(fn)
+	for i, p := range fn.Parameters {
+		pp := param{Name: string(p.Name), Required: p.DefaultArg == nil, Doc: docs[i], LocationRange: makeLocationRange(&p.LocRange)}
+		if p.DefaultArg != nil {
+			literal := isLiteral(p.DefaultArg)
+			source, err := unparse(p.DefaultArg)
+			if err != nil {
+				return result, err
+			}
+			pp.DefaultSource = source
+			if literal {
+				value, err := vm.EvaluateAnonymousSnippet(file, source)
+				if err != nil {
+					return result, err
+				}
+				var b bytes.Buffer
+				if err := json.Compact(&b, []byte(value)); err != nil {
+					return result, err
+				}
+				pp.Default = b.Bytes()
+			}
+		}
+		result.Params = append(result.Params, pp)
+	}
+	return result, nil
+}
+
+// notAFunctionError evaluates the file to explain why it has no parameters.
+func notAFunctionError(vm *jsonnet.VM, file string, input string) error {
+	value, err := vm.EvaluateAnonymousSnippet(file, fmt.Sprintf("std.type(\n%s\n)", input))
+	if err != nil {
+		return fmt.Errorf("%s is not a function literal: %w", file, err)
+	}
+	var t string
+	if err := json.Unmarshal([]byte(value), &t); err != nil {
+		return err
+	}
+	if t == "function" {
+		return fmt.Errorf("%s evaluates to a function that is not a function literal, so its parameters are unknown", file)
+	}
+	return fmt.Errorf("%s evaluates to %s, not a function", file, t)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"path/filepath"
+)
+
+// absPath returns the absolute path of the file or the path itself if it cannot be determined.
+func absPath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	return abs
+}
+
+// withinDir returns true if the path is the directory dir or is within it, after resolving symbolic links in
+// both where they exist.
+func withinDir(dir, path string) bool {
+	rel, err := filepath.Rel(resolvePath(dir), resolvePath(path))
+	return err == nil && filepath.IsLocal(rel)
+}
+
+// resolvePath returns the absolute path with symbolic links resolved, or the absolute path if it cannot be
+// resolved, like when the file does not exist.
+func resolvePath(path string) string {
+	resolved, err := filepath.EvalSymlinks(absPath(path))
+	if err != nil {
+		return absPath(path)
+	}
+	return resolved
+}
@@ -0,0 +1,238 @@
+// Package astgraph draws Jsonnet ASTs as graphs in the DOT language of Graphviz.
+package astgraph
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/formatter"
+
+	"github.com/jdbaldry/jsonnet-tool/pkg/location"
+	"github.com/jdbaldry/jsonnet-tool/pkg/traverse"
+)
+
+// toString provides a reasonably concise string representation of the Jsonnet AST node.
+// loc is useful as not all nodes have location information. For example, object fields have a location,
+// but the LiteralString is the Name of a field does not.
+func toString(node ast.Node, loc *ast.LocationRange) string {
+	switch node := node.(type) {
+	case *ast.Binary:
+		return fmt.Sprintf("[%s] %p %T %s", loc, node, node, node.Op)
+	case *ast.DesugaredObject:
+		return fmt.Sprintf("[%s] %p %T", loc, node, node)
+	case *ast.LiteralString:
+		return fmt.Sprintf("[%s] %p %T %s", loc, node, node, node.Value)
+	case *ast.Import:
+		return fmt.Sprintf("[%s] %p %T", loc, node, node)
+	case *ast.Var:
+		return fmt.Sprintf("[%s] %p %T %s", loc, node, node, node.Id)
+	default:
+		return fmt.Sprintf("[%s] %p %T", loc, node, node)
+	}
+}
+
+// dotID returns the quoted DOT identifier of the node.
+func dotID(node ast.Node, loc *ast.LocationRange) string {
+	return fmt.Sprintf("\"%s\"", strings.ReplaceAll(toString(node, loc), `"`, `\"`))
+}
+
+// Options configures the graphs of Dot and DotFollowImports.
+type Options struct {
+	// At is a position in the file of the root AST. If it is set, the chain of nodes from the root to the
+	// innermost node that contains the position is highlighted.
+	At *ast.Location
+	// Focus omits the nodes that are not in the highlighted chain, children of its nodes, or within its
+	// innermost node.
+	Focus bool
+}
+
+// highlightStyle and targetStyle are the DOT attributes of the highlighted chain of nodes and its innermost node.
+const (
+	highlightStyle = "color=red penwidth=2"
+	targetStyle    = highlightStyle + " style=filled fillcolor=mistyrose"
+)
+
+// Path returns the chain of nodes from the root to the innermost node whose location contains the position.
+// Nodes without a location, like the functions of methods, are searched through but not included.
+// It returns nil if the root does not contain the position.
+func Path(root ast.Node, at ast.Location) []ast.Node {
+	loc := root.Loc()
+	located := loc != nil && loc.IsSet()
+	if located && (location.Before(at, loc.Begin) || !location.Before(at, loc.End)) {
+		return nil
+	}
+	for _, child := range traverse.Children(root) {
+		if path := Path(child, at); path != nil {
+			if located {
+				return append([]ast.Node{root}, path...)
+			}
+			return path
+		}
+	}
+	if located {
+		return []ast.Node{root}
+	}
+	return nil
+}
+
+// highlight is the chain of nodes highlighted in a graph.
+type highlight struct {
+	path  map[ast.Node]bool
+	focus bool
+	// target is the innermost node of the chain.
+	target ast.Node
+}
+
+// newHighlight returns the highlight of the options for the AST, or nil if nothing is highlighted.
+func newHighlight(root ast.Node, opts Options) (*highlight, error) {
+	if opts.At == nil {
+		return nil, nil
+	}
+	path := Path(root, *opts.At)
+	if len(path) == 0 {
+		return nil, fmt.Errorf("there is no node at %d:%d", opts.At.Line, opts.At.Column)
+	}
+	h := &highlight{path: make(map[ast.Node]bool, len(path)), focus: opts.Focus, target: path[len(path)-1]}
+	for _, node := range path {
+		h.path[node] = true
+	}
+	return h, nil
+}
+
+// dotEdges writes a DOT edge statement for each edge of the Jsonnet AST, indented by indent, and a node statement
+// styling each highlighted node. It returns the nodes that are drawn.
+func dotEdges(builder *strings.Builder, root ast.Node, indent string, h *highlight) (map[ast.Node]bool, error) {
+	drawn := map[ast.Node]bool{root: true}
+	// Within the innermost highlighted node, every node is drawn.
+	within := 0
+	edge := func(from, to ast.Node, fromLoc, toLoc *ast.LocationRange) {
+		drawn[to] = true
+		style := ""
+		if h != nil && h.path[from] && h.path[to] {
+			style = " [" + highlightStyle + "]"
+		}
+		fmt.Fprintf(builder, "%s%s->%s%s\n", indent, dotID(from, fromLoc), dotID(to, toLoc), style)
+	}
+	err := traverse.Traverse(root,
+		func(node *ast.Node) error {
+			if h != nil && *node == h.target {
+				within++
+			}
+			return nil
+		},
+		func(node *ast.Node) error {
+			if h != nil && h.focus && !h.path[*node] && within == 0 {
+				return nil
+			}
+			switch node := (*node).(type) {
+			case *ast.DesugaredObject:
+				for _, field := range node.Fields {
+					edge(node, field.Name, node.Loc(), &field.LocRange)
+					edge(field.Name, field.Body, &field.LocRange, field.Body.Loc())
+				}
+				return nil
+			default:
+				for _, child := range traverse.Children(node) {
+					edge(node, child, node.Loc(), child.Loc())
+				}
+				return nil
+			}
+		},
+		func(node *ast.Node) error {
+			if h != nil && *node == h.target {
+				within--
+			}
+			return nil
+		},
+	)
+	if h != nil {
+		for node := range h.path {
+			style := highlightStyle
+			if node == h.target {
+				style = targetStyle
+			}
+			fmt.Fprintf(builder, "%s%s [%s]\n", indent, dotID(node, node.Loc()), style)
+		}
+	}
+	return drawn, err
+}
+
+// Dot produces a DOT language graph for the Jsonnet AST.
+func Dot(root ast.Node, opts Options) (string, error) {
+	h, err := newHighlight(root, opts)
+	if err != nil {
+		return "", err
+	}
+	builder := strings.Builder{}
+	builder.WriteString("digraph {\n")
+	_, err = dotEdges(&builder, root, "  ", h)
+	builder.WriteString("}\n")
+	return builder.String(), err
+}
+
+// DotFollowImports produces a DOT language graph for the Jsonnet AST of the file and the ASTs of the files it imports,
+// transitively. The AST of each file is in its own cluster and there is an edge from each import to the root of the
+// imported AST. Each file is only included once. The options apply to the AST of the file, and with Focus,
+// only the imports that are drawn are followed.
+func DotFollowImports(importer jsonnet.Importer, file string, root ast.Node, opts Options) (string, error) {
+	h, err := newHighlight(root, opts)
+	if err != nil {
+		return "", err
+	}
+	builder := strings.Builder{}
+	builder.WriteString("digraph {\n")
+	type imported struct {
+		foundAt string
+		root    ast.Node
+	}
+	files := []imported{{foundAt: file, root: root}}
+	roots := map[string]ast.Node{file: root}
+	var imports []string
+	for i := 0; i < len(files); i++ {
+		f := files[i]
+		fmt.Fprintf(&builder, "  subgraph cluster_%d {\n", i)
+		fmt.Fprintf(&builder, "    label=%q\n", f.foundAt)
+		fmt.Fprintf(&builder, "    %s\n", dotID(f.root, f.root.Loc()))
+		var fileHighlight *highlight
+		if i == 0 {
+			fileHighlight = h
+		}
+		drawn, err := dotEdges(&builder, f.root, "    ", fileHighlight)
+		if err != nil {
+			return "", err
+		}
+		builder.WriteString("  }\n")
+		err = traverse.Traverse(f.root,
+			func(node *ast.Node) error {
+				n, ok := (*node).(*ast.Import)
+				if !ok || fileHighlight != nil && fileHighlight.focus && !drawn[n] {
+					return nil
+				}
+				contents, foundAt, err := importer.Import(f.foundAt, n.File.Value)
+				if err != nil {
+					return fmt.Errorf("%s: unable to follow import: %w", location.FromAST(n.Loc()), err)
+				}
+				importedRoot, ok := roots[foundAt]
+				if !ok {
+					importedRoot, _, err = formatter.SnippetToRawAST(foundAt, contents.String())
+					if err != nil {
+						return err
+					}
+					roots[foundAt] = importedRoot
+					files = append(files, imported{foundAt: foundAt, root: importedRoot})
+				}
+				imports = append(imports, fmt.Sprintf("  %s->%s [style=dashed]\n", dotID(n, n.Loc()), dotID(importedRoot, importedRoot.Loc())))
+				return nil
+			}, traverse.Nop, traverse.Nop)
+		if err != nil {
+			return "", err
+		}
+	}
+	for _, edge := range imports {
+		builder.WriteString(edge)
+	}
+	builder.WriteString("}\n")
+	return builder.String(), nil
+}
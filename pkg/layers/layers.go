@@ -0,0 +1,153 @@
+// Package layers steps through the object merges of Jsonnet evaluations.
+package layers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+
+	"github.com/jdbaldry/jsonnet-tool/pkg/location"
+	"github.com/jdbaldry/jsonnet-tool/pkg/traverse"
+	toolvm "github.com/jdbaldry/jsonnet-tool/pkg/vm"
+)
+
+// Layer is an intermediate Jsonnet evaluation and its location.
+type Layer struct {
+	Evaluation    string
+	LocationRange location.Range
+}
+
+// evaluatesToObject returns a boolean representing whether or not the evaluation of a Jsonnet
+// node evaluates to a JSON object value.
+// TODO: implement.
+func evaluatesToObject(node *ast.Node) bool {
+	return true
+}
+
+// stdFunction returns the name of the standard library function called by the Apply node, if it is one.
+func stdFunction(apply *ast.Apply) (string, bool) {
+	index, ok := apply.Target.(*ast.Index)
+	if !ok {
+		return "", false
+	}
+	if v, ok := index.Target.(*ast.Var); !ok || (v.Id != "std" && v.Id != "$std") {
+		return "", false
+	}
+	name, ok := index.Index.(*ast.LiteralString)
+	if !ok {
+		return "", false
+	}
+	return name.Value, true
+}
+
+// overlays returns the array literal of overlays merged by the Apply node and the minimum number of them that
+// are kept when it is peeled apart, if it is a merge over a list of overlays.
+// A merge over a list of overlays is either a std.foldl over an array literal, or an object comprehension, which
+// merges the object produced by each element of the array it iterates over.
+func overlays(apply *ast.Apply) (*ast.Array, int, bool) {
+	name, ok := stdFunction(apply)
+	if !ok {
+		return nil, 0, false
+	}
+	args := apply.Arguments.Positional
+	switch {
+	case name == "foldl" && len(args) == 3:
+		arr, ok := args[1].Expr.(*ast.Array)
+		return arr, 0, ok
+	case name == "$objectFlatMerge" && len(args) == 1:
+		flatMap, ok := args[0].Expr.(*ast.Apply)
+		if !ok {
+			return nil, 0, false
+		}
+		if name, ok := stdFunction(flatMap); !ok || name != "flatMap" || len(flatMap.Arguments.Positional) != 2 {
+			return nil, 0, false
+		}
+		arr, ok := flatMap.Arguments.Positional[1].Expr.(*ast.Array)
+		return arr, 1, ok
+	}
+	return nil, 0, false
+}
+
+// Find returns intermediate layers of evaluation of the top level Jsonnet. The first layer in the slice is the final evaluation.
+// Each subsequent layer steps through the merges of objects, which are binary merges, including the a { b: c } syntax,
+// std.mergePatch calls, and merges over a list of overlays, which are peeled apart one overlay at a time.
+// For example: { a: 1 } + { a: 2 } would return layers:
+// { "a": 2 }
+// { "a": 1 }
+// The merges are removed from the AST as it is stepped through, so root must not be evaluated again.
+// If the context is cancelled, the context error is returned.
+func Find(ctx context.Context, vm *jsonnet.VM, root ast.Node) (layers []Layer, err error) {
+	final, err := toolvm.Evaluate(ctx, vm, root)
+	if ctx.Err() != nil {
+		return nil, err
+	}
+	if err != nil {
+		return layers, fmt.Errorf("error evaluating root Jsonnet: %w", err)
+	}
+	layers = append(layers, Layer{
+		Evaluation: final,
+		LocationRange: location.Range{
+			FileName: root.Loc().FileName,
+			Begin:    root.Loc().Begin,
+			End:      root.Loc().End,
+		},
+	})
+
+	// addLayer evaluates the modified root as a layer at the location.
+	addLayer := func(loc *ast.LocationRange) {
+		intermediate := Layer{LocationRange: location.FromAST(loc)}
+		intermediate.Evaluation, err = toolvm.Evaluate(ctx, vm, root)
+		if ctx.Err() != nil {
+			return
+		}
+		// Not all errors are evaluation errors but for simplicity, this is ignored.
+		if err != nil {
+			intermediate.Evaluation = fmt.Sprintln(err)
+		}
+		layers = append(layers, intermediate)
+	}
+
+	// Perform a pre-order traversal of the AST, removing the RHS of any '+' binary operation performed on objects,
+	// the patch of any std.mergePatch call, and the overlays of any merge over a list of overlays.
+	err = traverse.Traverse(root,
+		func(node *ast.Node) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			switch i := (*node).(type) {
+			case *ast.Binary:
+				if i.Op == ast.BopPlus {
+					if evaluatesToObject(&i.Right) {
+						i.Right = &ast.DesugaredObject{}
+						addLayer(i.Left.Loc())
+					}
+				}
+			case *ast.Apply:
+				if name, ok := stdFunction(i); ok && name == "mergePatch" && len(i.Arguments.Positional) == 2 {
+					i.Arguments.Positional[1].Expr = &ast.DesugaredObject{}
+					addLayer(i.Arguments.Positional[0].Expr.Loc())
+					return nil
+				}
+				if arr, keep, ok := overlays(i); ok {
+					for len(arr.Elements) > keep {
+						arr.Elements = arr.Elements[:len(arr.Elements)-1]
+						loc := arr.Loc()
+						if len(arr.Elements) > 0 {
+							loc = arr.Elements[len(arr.Elements)-1].Expr.Loc()
+						}
+						addLayer(loc)
+					}
+				}
+			}
+			return nil
+		},
+		traverse.Nop,
+		traverse.Nop,
+	)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return
+}
@@ -0,0 +1,111 @@
+package layers
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/google/go-jsonnet"
+
+	toolvm "github.com/jdbaldry/jsonnet-tool/pkg/vm"
+)
+
+func TestFind(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		snippet string
+		// layers are the evaluations of the layers and their locations.
+		layers []string
+		locs   []string
+	}{
+		{
+			name:    "binary merge",
+			snippet: "{ a: 1 } + { a: 2 }",
+			layers:  []string{`{"a": 2}`, `{"a": 1}`},
+			locs:    []string{"test.jsonnet:1:1-20", "test.jsonnet:1:1-9"},
+		},
+		{
+			name:    "chained binary merges",
+			snippet: "{ a: 1 } + { b: 2 } + { c: 3 }",
+			layers:  []string{`{"a": 1, "b": 2, "c": 3}`, `{"a": 1, "b": 2}`, `{"a": 1}`},
+			locs:    []string{"test.jsonnet:1:1-31", "test.jsonnet:1:1-20", "test.jsonnet:1:1-9"},
+		},
+		{
+			name:    "implicit merge",
+			snippet: "{ a: 1 } { b: 2 }",
+			layers:  []string{`{"a": 1, "b": 2}`, `{"a": 1}`},
+			locs:    []string{"test.jsonnet:1:1-18", "test.jsonnet:1:1-9"},
+		},
+		{
+			name:    "mergePatch",
+			snippet: "std.mergePatch({ a: 1, b: 2 }, { b: null })",
+			layers:  []string{`{"a": 1}`, `{"a": 1, "b": 2}`},
+			locs:    []string{"test.jsonnet:1:1-44", "test.jsonnet:1:16-30"},
+		},
+		{
+			name:    "foldl over overlays",
+			snippet: "std.foldl(std.mergePatch, [{ a: 1 }, { b: 2 }], {})",
+			layers:  []string{`{"a": 1, "b": 2}`, `{"a": 1}`, `{}`},
+			locs:    []string{"test.jsonnet:1:1-52", "test.jsonnet:1:28-36", "test.jsonnet:1:27-47"},
+		},
+		{
+			name:    "objectFlatMerge of a comprehension",
+			snippet: "{ [k]: 1 for k in ['a', 'b', 'c'] }",
+			layers:  []string{`{"a": 1, "b": 1, "c": 1}`, `{"a": 1, "b": 1}`, `{"a": 1}`},
+			locs:    []string{"test.jsonnet:1:1-36", "test.jsonnet:1:25-28", "test.jsonnet:1:20-23"},
+		},
+		{
+			name:    "no merges",
+			snippet: "{ a: 1 }",
+			layers:  []string{`{"a": 1}`},
+			locs:    []string{"test.jsonnet:1:1-9"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			root, err := jsonnet.SnippetToAST("test.jsonnet", tc.snippet)
+			if err != nil {
+				t.Fatal(err)
+			}
+			layers, err := Find(context.Background(), toolvm.New(toolvm.Options{}), root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got, gotLocs []string
+			for _, layer := range layers {
+				got = append(got, layer.Evaluation)
+				gotLocs = append(gotLocs, layer.LocationRange.String())
+			}
+			if len(got) != len(tc.layers) {
+				t.Fatalf("got %d layers %q, want %d", len(got), got, len(tc.layers))
+			}
+			for i := range got {
+				var gotValue, wantValue interface{}
+				if err := json.Unmarshal([]byte(got[i]), &gotValue); err != nil {
+					t.Fatalf("layer %d: %v", i, err)
+				}
+				if err := json.Unmarshal([]byte(tc.layers[i]), &wantValue); err != nil {
+					t.Fatalf("layer %d: %v", i, err)
+				}
+				if !reflect.DeepEqual(gotValue, wantValue) {
+					t.Errorf("layer %d: got %s, want %s", i, got[i], tc.layers[i])
+				}
+			}
+			if !reflect.DeepEqual(gotLocs, tc.locs) {
+				t.Errorf("got locations %q, want %q", gotLocs, tc.locs)
+			}
+		})
+	}
+}
+
+func TestFindCancelled(t *testing.T) {
+	root, err := jsonnet.SnippetToAST("test.jsonnet", "{ a: 1 } + { a: 2 }")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Find(ctx, toolvm.New(toolvm.Options{}), root); err == nil {
+		t.Error("got no error for a cancelled context")
+	}
+}
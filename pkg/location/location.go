@@ -0,0 +1,115 @@
+// Package location describes ranges of Jsonnet source in the form written by jsonnet-tool commands.
+package location
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/go-jsonnet/ast"
+)
+
+// Encoding is the unit that the columns of locations are counted in. Lines and columns start at one
+// in every encoding.
+type Encoding string
+
+const (
+	// Runes counts Unicode code points, like go-jsonnet.
+	Runes Encoding = "rune"
+	// Bytes counts the bytes of the UTF-8 encoded source.
+	Bytes Encoding = "byte"
+	// UTF16 counts UTF-16 code units, like the Language Server Protocol does by default.
+	UTF16 Encoding = "utf-16"
+)
+
+// Encodings are the supported encodings.
+var Encodings = []Encoding{Runes, Bytes, UTF16}
+
+// ParseEncoding returns the encoding with the name.
+func ParseEncoding(name string) (Encoding, error) {
+	for _, e := range Encodings {
+		if string(e) == name {
+			return e, nil
+		}
+	}
+	names := make([]string, len(Encodings))
+	for i, e := range Encodings {
+		names[i] = string(e)
+	}
+	return "", fmt.Errorf("unknown position encoding %s, expected one of %s", name, strings.Join(names, ", "))
+}
+
+// Column converts a column of the line counted in runes into a column counted in the encoding.
+// Columns beyond the end of the line are counted as if the line were padded with spaces.
+func (e Encoding) Column(line string, column int) int {
+	if e == Runes {
+		return column
+	}
+	units := 0
+	for i := 1; i < column; i++ {
+		if line == "" {
+			units++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(line)
+		line = line[size:]
+		switch {
+		case e == Bytes:
+			units += size
+		case r > 0xFFFF:
+			// Characters outside of the Basic Multilingual Plane are encoded as surrogate pairs.
+			units += 2
+		default:
+			units++
+		}
+	}
+	return units + 1
+}
+
+// Before returns true if the location a is before the location b.
+func Before(a, b ast.Location) bool {
+	return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+}
+
+// Range is a range of Jsonnet source.
+type Range struct {
+	FileName string
+	Begin    ast.Location
+	End      ast.Location
+}
+
+// FromAST converts a go-jsonnet location range into a Range.
+// Location ranges in raw ASTs only have the diagnostic file name of their source.
+func FromAST(loc *ast.LocationRange) Range {
+	lr := Range{FileName: loc.FileName, Begin: loc.Begin, End: loc.End}
+	if lr.FileName == "" && loc.File != nil {
+		lr.FileName = string(loc.File.DiagnosticFileName)
+	}
+	return lr
+}
+
+// String returns the location range in the same format as go-jsonnet error messages.
+func (lr Range) String() string {
+	if !lr.Begin.IsSet() {
+		return lr.FileName
+	}
+	if lr.Begin.Line == lr.End.Line {
+		if lr.Begin.Column == lr.End.Column {
+			return fmt.Sprintf("%s:%s", lr.FileName, lr.Begin.String())
+		}
+		return fmt.Sprintf("%s:%s-%d", lr.FileName, lr.Begin.String(), lr.End.Column)
+	}
+	return fmt.Sprintf("%s:(%s)-(%s)", lr.FileName, lr.Begin.String(), lr.End.String())
+}
+
+// Encode returns the range with its columns counted in the encoding, given the lines of the source of the file.
+func (lr Range) Encode(lines []string, e Encoding) Range {
+	column := func(l ast.Location) int {
+		if l.Line < 1 || l.Line > len(lines) {
+			return l.Column
+		}
+		return e.Column(lines[l.Line-1], l.Column)
+	}
+	lr.Begin.Column, lr.End.Column = column(lr.Begin), column(lr.End)
+	return lr
+}
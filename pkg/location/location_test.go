@@ -0,0 +1,126 @@
+package location
+
+import (
+	"testing"
+
+	"github.com/google/go-jsonnet/ast"
+)
+
+func TestParseEncoding(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		want    Encoding
+		wantErr bool
+	}{
+		{name: "rune", want: Runes},
+		{name: "byte", want: Bytes},
+		{name: "utf-16", want: UTF16},
+		{name: "utf-8", wantErr: true},
+		{name: "", wantErr: true},
+	} {
+		got, err := ParseEncoding(tc.name)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("ParseEncoding(%q): got error %v, want error %t", tc.name, err, tc.wantErr)
+		}
+		if got != tc.want {
+			t.Errorf("ParseEncoding(%q): got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestColumn(t *testing.T) {
+	// é is two bytes and one UTF-16 code unit, and 😀 is four bytes and two UTF-16 code units.
+	const line = "aé😀b"
+	for _, tc := range []struct {
+		encoding Encoding
+		column   int
+		want     int
+	}{
+		{Runes, 4, 4},
+		{Bytes, 1, 1},
+		{Bytes, 2, 2},
+		{Bytes, 3, 4},
+		{Bytes, 4, 8},
+		{Bytes, 5, 9},
+		{UTF16, 3, 3},
+		{UTF16, 4, 5},
+		{UTF16, 5, 6},
+		// Columns beyond the end of the line count as padding.
+		{Bytes, 7, 11},
+		{UTF16, 7, 8},
+	} {
+		if got := tc.encoding.Column(line, tc.column); got != tc.want {
+			t.Errorf("%s column %d: got %d, want %d", tc.encoding, tc.column, got, tc.want)
+		}
+	}
+}
+
+func TestBefore(t *testing.T) {
+	for _, tc := range []struct {
+		a, b ast.Location
+		want bool
+	}{
+		{ast.Location{Line: 1, Column: 1}, ast.Location{Line: 1, Column: 2}, true},
+		{ast.Location{Line: 1, Column: 9}, ast.Location{Line: 2, Column: 1}, true},
+		{ast.Location{Line: 2, Column: 1}, ast.Location{Line: 1, Column: 9}, false},
+		{ast.Location{Line: 1, Column: 2}, ast.Location{Line: 1, Column: 2}, false},
+	} {
+		if got := Before(tc.a, tc.b); got != tc.want {
+			t.Errorf("Before(%s, %s): got %t, want %t", tc.a.String(), tc.b.String(), got, tc.want)
+		}
+	}
+}
+
+func TestRangeString(t *testing.T) {
+	for _, tc := range []struct {
+		r    Range
+		want string
+	}{
+		{Range{FileName: "a.jsonnet"}, "a.jsonnet"},
+		{Range{"a.jsonnet", ast.Location{Line: 2, Column: 3}, ast.Location{Line: 2, Column: 3}}, "a.jsonnet:2:3"},
+		{Range{"a.jsonnet", ast.Location{Line: 2, Column: 3}, ast.Location{Line: 2, Column: 8}}, "a.jsonnet:2:3-8"},
+		{Range{"a.jsonnet", ast.Location{Line: 2, Column: 3}, ast.Location{Line: 4, Column: 1}}, "a.jsonnet:(2:3)-(4:1)"},
+	} {
+		if got := tc.r.String(); got != tc.want {
+			t.Errorf("got %q, want %q", got, tc.want)
+		}
+	}
+}
+
+func TestFromAST(t *testing.T) {
+	begin, end := ast.Location{Line: 1, Column: 1}, ast.Location{Line: 1, Column: 5}
+	for _, tc := range []struct {
+		name string
+		loc  ast.LocationRange
+		want string
+	}{
+		{"file name", ast.LocationRange{FileName: "a.jsonnet", Begin: begin, End: end}, "a.jsonnet:1:1-5"},
+		{"diagnostic file name", ast.LocationRange{File: &ast.Source{DiagnosticFileName: "b.jsonnet"}, Begin: begin, End: end}, "b.jsonnet:1:1-5"},
+	} {
+		if got := FromAST(&tc.loc).String(); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	lines := []string{"{", "  'é': 1,", "}"}
+	r := Range{"a.jsonnet", ast.Location{Line: 2, Column: 3}, ast.Location{Line: 2, Column: 6}}
+	for _, tc := range []struct {
+		encoding Encoding
+		want     string
+	}{
+		{Runes, "a.jsonnet:2:3-6"},
+		{Bytes, "a.jsonnet:2:3-7"},
+		{UTF16, "a.jsonnet:2:3-6"},
+	} {
+		if got := r.Encode(lines, tc.encoding).String(); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.encoding, got, tc.want)
+		}
+	}
+	// Locations outside of the lines are not converted.
+	outside := Range{"a.jsonnet", ast.Location{Line: 9, Column: 3}, ast.Location{Line: 9, Column: 5}}
+	if got := outside.Encode(lines, Bytes); got != outside {
+		t.Errorf("got %s, want %s", got, outside)
+	}
+}
@@ -0,0 +1,362 @@
+// Package repl is the engine of the jsonnet-tool REPL, which evaluates Jsonnet expressions and REPL commands
+// in namespaces of expressions, like local variables, that are prepended to evaluations.
+package repl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+
+	"github.com/jdbaldry/jsonnet-tool/pkg/vm"
+)
+
+// ErrExit is returned by Eval when the input ends the session.
+var ErrExit = errors.New("exit")
+
+// Help is the REPL help text.
+const Help = `A Jsonnet REPL.
+
+Commands and expressions should be terminated with two semicolons ';;'.
+For example,
+repl [0]> \v local bar = 'Hello, world!';;
+repl [0]> bar;;
+"Hello, world!"
+
+\d i            removes the ith namespace variable expression (zero indexed).
+\e              prints the external variables.
+\e NAME=VALUE   sets the external variable NAME to the string VALUE.
+\f FILE         writes subsequent evaluation of the current namespace to FILE.
+\n              creates a new namespace.
+\n i            switches to the ith namespace (zero indexed).
+\h              prints this help message.
+\more           prints the next page of a long evaluation.
+\q              quits the REPL.
+\t EXPR         prints the type and shape of EXPR: object fields with their types, and array lengths and element types.
+\v              prints the namespace expressions.
+\v EXPR         creates a new namespace EXPR that is prepended to evaluation.
+\w FILE         writes the state of the current namespace to FILE.
+Anything else is evaluated as Jsonnet.
+`
+
+// Output displays the JSON results of evaluations.
+type Output interface {
+	// Display returns the result formatted for display.
+	Display(result string) (string, error)
+	// Next returns the next page of the last result displayed, for the \more command.
+	Next() string
+}
+
+// rawOutput displays results as they are.
+type rawOutput struct{}
+
+// Display returns the result.
+func (rawOutput) Display(result string) (string, error) { return result, nil }
+
+// Next returns nothing because results are not paged.
+func (rawOutput) Next() string { return "" }
+
+// Namespace is a set of expressions prepended to the evaluations in it.
+type Namespace struct {
+	// Exprs are prepended to evaluations, each followed by a semicolon.
+	Exprs []string
+	// EvalFile is where evaluations in the namespace are written, if it is set.
+	EvalFile string
+	// File is where the namespace is written, with the evaluated expression, if it is set.
+	File string
+}
+
+// REPL evaluates Jsonnet expressions and REPL commands. It is not safe for concurrent use.
+type REPL struct {
+	// VM performs the Jsonnet evaluations.
+	VM *jsonnet.VM
+	// Output displays evaluation results. If it is nil, results are displayed as they are.
+	Output Output
+	// Namespaces are the namespaces of the REPL. There is always at least one.
+	Namespaces []Namespace
+	// NS is the index of the current namespace.
+	NS int
+	// ExtVars are the external variables set by the \e command.
+	ExtVars map[string]string
+	// Evaluated are the expressions evaluated successfully, for callers to record as history and clear.
+	Evaluated []string
+	// Timeout is the maximum duration of an evaluation, if it is positive. An evaluation that times out cannot be
+	// stopped, so its VM is replaced by one from NewVM, which is required, with the external variables set again.
+	Timeout time.Duration
+	// NewVM creates a VM to replace one whose evaluation timed out.
+	NewVM func() *jsonnet.VM
+	// MaxOutputBytes is the maximum size of the result of an evaluation, if it is positive.
+	MaxOutputBytes int
+}
+
+// New returns a REPL that evaluates with the VM and displays evaluation results with output.
+func New(vm *jsonnet.VM, output Output) *REPL {
+	return &REPL{
+		VM:         vm,
+		Output:     output,
+		Namespaces: make([]Namespace, 1),
+		ExtVars:    make(map[string]string),
+	}
+}
+
+// Prompt returns the REPL prompt, which shows the index of the current namespace.
+func (r *REPL) Prompt() string { return fmt.Sprintf("repl [%d]> ", r.NS) }
+
+// AddNamespace adds an empty namespace and returns its index.
+func (r *REPL) AddNamespace() int {
+	r.Namespaces = append(r.Namespaces, Namespace{})
+	return len(r.Namespaces) - 1
+}
+
+// output returns the output that displays evaluation results.
+func (r *REPL) output() Output {
+	if r.Output == nil {
+		return rawOutput{}
+	}
+	return r.Output
+}
+
+// Eval evaluates the input, which is a REPL command or a Jsonnet expression, and returns the result to display.
+// It expects the string to be trimmed of preceding whitespace.
+// See Help for the commands. Anything else is evaluated as Jsonnet in the current namespace.
+// ErrExit is returned if the input ends the session.
+func (r *REPL) Eval(input string) (string, error) {
+	if len(input) == 0 {
+		return "", ErrExit
+	}
+	switch input[0] {
+	case '\\':
+		if len(input) < 2 {
+			return Help, fmt.Errorf("expected command such as \\h, got %s", input)
+		}
+		switch input[1] {
+		case 'd':
+			re := regexp.MustCompile(`^(?s)\\d\s+([0-9]+)$`)
+			matches := re.FindStringSubmatch(input)
+			if len(matches) != 2 {
+				return "", fmt.Errorf("invalid delete command syntax. Wanted \\d INDEX")
+			}
+			i, err := strconv.Atoi(matches[1])
+			if err != nil {
+				return "", fmt.Errorf("invalid delete command index.")
+			}
+			if i < 0 || i > len(r.Namespaces[r.NS].Exprs)-1 {
+				return "", fmt.Errorf("delete command index out of range")
+			}
+			r.Namespaces[r.NS].Exprs = append(r.Namespaces[r.NS].Exprs[:i], r.Namespaces[r.NS].Exprs[i+1:]...)
+			return "", nil
+		case 'e':
+			re := regexp.MustCompile(`(?s)^\\e\s*(.*)$`)
+			matches := re.FindStringSubmatch(input)
+			if len(matches) != 2 {
+				return "", fmt.Errorf("invalid external variable command syntax. Wanted \\e or \\e NAME=VALUE")
+			}
+			if len(matches[1]) == 0 {
+				names := make([]string, 0, len(r.ExtVars))
+				for name := range r.ExtVars {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+				builder := strings.Builder{}
+				for _, name := range names {
+					builder.WriteString(fmt.Sprintf("%s=%s\n", name, r.ExtVars[name]))
+				}
+				return builder.String(), nil
+			}
+			name, value, ok := strings.Cut(matches[1], "=")
+			if !ok || name == "" {
+				return "", fmt.Errorf("invalid external variable command syntax. Wanted \\e or \\e NAME=VALUE")
+			}
+			r.SetExtVar(name, value)
+			return "", nil
+		case 'f':
+			re := regexp.MustCompile(`^(?s)\\f\s+(.+)$`)
+			matches := re.FindStringSubmatch(input)
+			if len(matches) != 2 {
+				return "", fmt.Errorf("invalid file command syntax. Wanted \\f FILE")
+			}
+			path, err := filepath.Abs(matches[1])
+			if err != nil {
+				return "", fmt.Errorf("unable to determine path to file: %w", err)
+			}
+			r.Namespaces[r.NS].EvalFile = path
+			return fmt.Sprintf("Writing evaluations to file %s\n", r.Namespaces[r.NS].EvalFile), nil
+		case 'h', '?':
+			return Help, nil
+		case 'm':
+			if input != `\more` && input != `\m` {
+				return "", fmt.Errorf("invalid more command syntax. Wanted \\more")
+			}
+			return r.output().Next(), nil
+		case 'n':
+			if len(input) == 2 {
+				r.NS = r.AddNamespace()
+				return fmt.Sprintf("Switched to namespace %d\n", r.NS), nil
+			}
+			re := regexp.MustCompile(`^(?s)\\n\s+([0-9]+)$`)
+			matches := re.FindStringSubmatch(input)
+			if len(matches) != 2 {
+				return "", fmt.Errorf("invalid namespace command syntax. Wanted \\n or \\n INDEX")
+			}
+			i, err := strconv.Atoi(matches[1])
+			if err != nil {
+				return "", fmt.Errorf("invalid namespace command index.")
+			}
+			if i < 0 || i > len(r.Namespaces)-1 {
+				return "", fmt.Errorf("namespace command index out of range")
+			}
+			r.NS = i
+			builder := strings.Builder{}
+			builder.WriteString(fmt.Sprintf("Switched to namespace %d\n", r.NS))
+			if r.Namespaces[r.NS].EvalFile != "" {
+				builder.WriteString(fmt.Sprintf("Writing evaluations to file %s\n", r.Namespaces[r.NS].EvalFile))
+			}
+			if r.Namespaces[r.NS].File != "" {
+				builder.WriteString(fmt.Sprintf("Writing namespace to file %s\n", r.Namespaces[r.NS].File))
+			}
+			return builder.String(), nil
+		case 'q':
+			return "", ErrExit
+		case 't':
+			re := regexp.MustCompile(`(?s)^\\t\s+(.+)$`)
+			matches := re.FindStringSubmatch(input)
+			if len(matches) != 2 {
+				return "", fmt.Errorf("invalid type command syntax. Wanted \\t EXPR")
+			}
+			return r.DescribeShape(matches[1])
+		case 'v':
+			re := regexp.MustCompile(`(?s)^\\v\s*(.*)$`)
+			matches := re.FindStringSubmatch(input)
+			if len(matches) != 2 {
+				return "", fmt.Errorf("invalid variable expression command syntax. Wanted \\v or \\v EXPR.\n")
+			}
+			if len(matches[1]) > 0 {
+				r.Namespaces[r.NS].Exprs = append(r.Namespaces[r.NS].Exprs, strings.Trim(strings.TrimPrefix(input, `\v`), " ;"))
+				return "", nil
+			}
+			builder := strings.Builder{}
+			for i, s := range r.Namespaces[r.NS].Exprs {
+				builder.WriteString(fmt.Sprintf("[%d] %s\n", i, s))
+			}
+			return builder.String(), nil
+		case 'w':
+			re := regexp.MustCompile(`(?s)^\\w\s+(.+)$`)
+			matches := re.FindStringSubmatch(input)
+			if len(matches) != 2 {
+				return "", fmt.Errorf("invalid write command syntax. Wanted \\w file")
+			}
+			path, err := filepath.Abs(matches[1])
+			if err != nil {
+				return "", fmt.Errorf("unable to determine path to file: %w", err)
+			}
+			r.Namespaces[r.NS].File = path
+			return fmt.Sprintf("Writing namespace to file %s\n", r.Namespaces[r.NS].File), nil
+		default:
+			return "", fmt.Errorf("unknown command %s", input)
+		}
+	default:
+		snippet := r.Snippet(input)
+		if r.Namespaces[r.NS].File != "" {
+			err := os.WriteFile(r.Namespaces[r.NS].File, []byte(snippet), 0o644)
+			if err != nil {
+				return "", fmt.Errorf("unable to write namespace to file %s: %w", r.Namespaces[r.NS].File, err)
+			}
+		}
+		result, err := r.evaluate(snippet)
+		if err != nil {
+			return "", err
+		}
+		r.Evaluated = append(r.Evaluated, input)
+		if r.Namespaces[r.NS].EvalFile != "" {
+			err := os.WriteFile(r.Namespaces[r.NS].EvalFile, []byte(result), 0o644)
+			if err != nil {
+				return "", fmt.Errorf("unable to write evaluation to file %s: %w", r.Namespaces[r.NS].EvalFile, err)
+			}
+		}
+		return r.output().Display(result)
+	}
+}
+
+// evaluate evaluates the snippet with the VM within the Timeout and MaxOutputBytes limits.
+func (r *REPL) evaluate(snippet string) (string, error) {
+	var result string
+	var err error
+	if r.Timeout > 0 {
+		var node ast.Node
+		if node, err = jsonnet.SnippetToAST("repl", snippet); err != nil {
+			return "", err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), r.Timeout)
+		defer cancel()
+		if result, err = vm.Evaluate(ctx, r.VM, node); ctx.Err() != nil {
+			r.VM = r.NewVM()
+			for name, value := range r.ExtVars {
+				r.VM.ExtVar(name, value)
+			}
+			return "", fmt.Errorf("evaluation timed out after %s", r.Timeout)
+		}
+	} else {
+		result, err = r.VM.EvaluateAnonymousSnippet("repl", snippet)
+	}
+	if err == nil && r.MaxOutputBytes > 0 && len(result) > r.MaxOutputBytes {
+		err = fmt.Errorf("result of %d bytes is larger than the maximum of %d bytes", len(result), r.MaxOutputBytes)
+	}
+	return result, err
+}
+
+// Snippet returns the expression prepended with the expressions of the current namespace.
+func (r *REPL) Snippet(expr string) string {
+	builder := strings.Builder{}
+	for _, s := range r.Namespaces[r.NS].Exprs {
+		builder.WriteString(fmt.Sprintf("%s;\n", s))
+	}
+	builder.WriteString(expr)
+	return builder.String()
+}
+
+// SetExtVar sets the string external variable of evaluations.
+func (r *REPL) SetExtVar(name, value string) {
+	r.ExtVars[name] = value
+	r.VM.ExtVar(name, value)
+}
+
+// ScanDoubleSemiColon is a split function for a Scanner that returns each string of text
+// separated by two semicolons ";;", which terminate REPL commands and expressions.
+func ScanDoubleSemiColon(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	// Skip leading spaces.
+	start := 0
+	for width := 0; start < len(data); start += width {
+		var r rune
+		r, width = utf8.DecodeRune(data[start:])
+		if !unicode.IsSpace(r) {
+			break
+		}
+	}
+	// Scan until two semicolons are encountered.
+	var prev rune
+	for width, i := 0, start; i < len(data); i += width {
+		var r rune
+		r, width = utf8.DecodeRune(data[i:])
+		if r == ';' && prev == ';' {
+			return i + width, data[start : i-1], nil
+		}
+		prev = r
+	}
+	// If we're at EOF, we have a final, non-empty, non-terminated string of text.
+	if atEOF && len(data) > start {
+		return len(data), data[start:], nil
+	}
+	// Request more data.
+	return start, nil, nil
+}
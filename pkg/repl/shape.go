@@ -0,0 +1,89 @@
+package repl
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/jdbaldry/jsonnet-tool/internal/english"
+)
+
+// ShapeSnippet is Jsonnet that describes the shape of the value of shapeValue without manifesting it.
+// Only the value, its fields, and the elements of arrays are evaluated, not their contents.
+const ShapeSnippet = `
+local describe(v) = { type: std.type(v) } + (
+  if std.isObject(v) then { length: std.length(std.objectFieldsAll(v)) }
+  else if std.isArray(v) then { length: std.length(v), elements: std.set([std.type(e) for e in v]) }
+  else if std.isString(v) || std.isFunction(v) then { length: std.length(v) }
+  else {}
+);
+describe(shapeValue) + (
+  if std.isObject(shapeValue) then {
+    fields: [
+      { name: k, hidden: !std.objectHas(shapeValue, k) } + describe(shapeValue[k])
+      for k in std.objectFieldsAll(shapeValue)
+    ],
+  }
+  else {}
+)
+`
+
+// Shape is the type and size of a value.
+type Shape struct {
+	Name   string `json:"name"`
+	Hidden bool   `json:"hidden"`
+	Type   string `json:"type"`
+	// Length is the number of fields of an object, elements of an array, characters of a string,
+	// or parameters of a function.
+	Length int `json:"length"`
+	// Elements are the types of the elements of an array.
+	Elements []string `json:"elements"`
+	// Fields are the shapes of the fields of an object, including hidden fields.
+	Fields []Shape `json:"fields"`
+}
+
+// String summarizes the shape on one line.
+func (s Shape) String() string {
+	switch s.Type {
+	case "object":
+		return fmt.Sprintf("object (%s)", english.Plural(s.Length, "field"))
+	case "array":
+		if s.Length == 0 {
+			return "array (empty)"
+		}
+		return fmt.Sprintf("array (%s of %s)", english.Plural(s.Length, "element"), strings.Join(s.Elements, ", "))
+	case "string":
+		return fmt.Sprintf("string (%s)", english.Plural(s.Length, "character"))
+	case "function":
+		return fmt.Sprintf("function (%s)", english.Plural(s.Length, "parameter"))
+	}
+	return s.Type
+}
+
+// DescribeShape evaluates the expression with the namespace expressions and summarizes the shape of its value.
+func (r *REPL) DescribeShape(expr string) (string, error) {
+	snippet := r.Snippet(fmt.Sprintf("local shapeValue = (\n%s\n);\n%s", expr, ShapeSnippet))
+	result, err := r.evaluate(snippet)
+	if err != nil {
+		return "", err
+	}
+	var s Shape
+	if err := json.Unmarshal([]byte(result), &s); err != nil {
+		return "", err
+	}
+	return s.Describe(), nil
+}
+
+// Describe summarizes the shape and the shapes of its fields, one per line.
+func (s Shape) Describe() string {
+	var b strings.Builder
+	fmt.Fprintln(&b, s)
+	for _, field := range s.Fields {
+		separator := ":"
+		if field.Hidden {
+			separator = "::"
+		}
+		fmt.Fprintf(&b, "  %s%s %s\n", field.Name, separator, field)
+	}
+	return b.String()
+}
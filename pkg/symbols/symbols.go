@@ -0,0 +1,99 @@
+// Package symbols finds the symbols of Jsonnet files that can be referenced by variables and indexes.
+package symbols
+
+import (
+	"strings"
+
+	"github.com/google/go-jsonnet/ast"
+
+	"github.com/jdbaldry/jsonnet-tool/pkg/location"
+	"github.com/jdbaldry/jsonnet-tool/pkg/traverse"
+)
+
+// Symbol is a referencable symbol in a Jsonnet file.
+type Symbol struct {
+	Identifier string
+	// Type is field for object fields, local for local variables, and objlocal for object locals.
+	Type string
+	// Context is the path of the object containing the symbol, like $.a.b.
+	Context       string
+	LocationRange location.Range
+}
+
+// Find finds all the Jsonnet symbols of the desugared AST that can be referenced by some variable or index.
+// This includes object fields and local variables. The context is the path of the node, like ["$"] for the
+// root of a file.
+func Find(node *ast.Node, context []string) (symbols []Symbol, err error) {
+	switch i := (*node).(type) {
+	case *ast.DesugaredObject:
+		for _, local := range i.Locals {
+			symbols = append(symbols, Symbol{
+				Identifier: string(local.Variable),
+				Type:       "objlocal",
+				Context:    strings.Join(context, "."),
+				LocationRange: location.Range{
+					FileName: local.LocRange.FileName,
+					Begin:    local.LocRange.Begin,
+					End:      local.LocRange.End,
+				}})
+		}
+		for _, field := range i.Fields {
+			// TODO: evaluate expressions.
+			switch name := field.Name.(type) {
+			case *ast.LiteralString:
+				symbols = append(symbols, Symbol{
+					Identifier: name.Value,
+					Context:    strings.Join(context, "."),
+					Type:       "field",
+					LocationRange: location.Range{
+						FileName: field.LocRange.FileName,
+						Begin:    field.LocRange.Begin,
+						End:      field.LocRange.End,
+					}})
+				children, err := Find(&field.Body, append(context, name.Value))
+				if err != nil {
+					return symbols, err
+				}
+				symbols = append(symbols, children...)
+			}
+		}
+
+	case *ast.Local:
+		for _, bind := range i.Binds {
+			symbols = append(symbols, Symbol{
+				Identifier: string(bind.Variable),
+				Type:       "local",
+				Context:    strings.Join(context, "."),
+				LocationRange: location.Range{
+					FileName: bind.LocRange.FileName,
+					Begin:    bind.LocRange.Begin,
+					End:      bind.LocRange.End,
+				}})
+		}
+		for _, node := range traverse.Children(i) {
+			additional, err := Find(&node, context)
+			if err != nil {
+				return symbols, err
+			}
+			symbols = append(symbols, additional...)
+		}
+
+	default:
+		for _, node := range traverse.Children(i) {
+			additional, err := Find(&node, context)
+			if err != nil {
+				return symbols, err
+			}
+			symbols = append(symbols, additional...)
+		}
+	}
+	return
+}
+
+// Encode counts the columns of the locations of the symbols, which are all in the source, in the encoding.
+func Encode(symbols []Symbol, source string, e location.Encoding) {
+	lines := strings.Split(source, "\n")
+	for i := range symbols {
+		symbols[i].LocationRange = symbols[i].LocationRange.Encode(lines, e)
+	}
+}
@@ -0,0 +1,63 @@
+// Package traverse walks Jsonnet ASTs depth first.
+package traverse
+
+import (
+	"fmt"
+
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/toolutils"
+)
+
+// Nop performs no operation on the AST node.
+func Nop(_ *ast.Node) error { return nil }
+
+// Children returns the children of the node, which Traverse visits, in order.
+// Unlike toolutils.Children, it includes the target of field access with a dot before desugaring.
+func Children(node ast.Node) []ast.Node {
+	if index, ok := node.(*ast.Index); ok && index.Id != nil {
+		return []ast.Node{index.Target}
+	}
+	return toolutils.Children(node)
+}
+
+// Traverse can be used to perform depth-first pre-order, in-order, or post-order
+// traversal of the Jsonnet AST. The in function is called after all but the last child of a node.
+// The functions are given pointers to copies of the nodes, so they can modify a node but not replace it.
+func Traverse(root ast.Node, pre, in, post func(node *ast.Node) error) error {
+	if err := pre(&root); err != nil {
+		return fmt.Errorf("pre error: %w", err)
+	}
+
+	children := Children(root)
+
+	if len(children) == 0 {
+		if err := in(&root); err != nil {
+			return fmt.Errorf("in error: %w", err)
+		}
+		if err := post(&root); err != nil {
+			return fmt.Errorf("post error: %w", err)
+		}
+		return nil
+	}
+
+	last := len(children) - 1
+	for i := 0; i <= last-1; i++ {
+		if err := Traverse(children[i], pre, in, post); err != nil {
+			return err
+		}
+	}
+
+	if err := in(&root); err != nil {
+		return fmt.Errorf("in error: %w", err)
+	}
+
+	if err := Traverse(children[last], pre, in, post); err != nil {
+		return err
+	}
+
+	if err := post(&root); err != nil {
+		return fmt.Errorf("post error: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package traverse
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/formatter"
+)
+
+// label describes a node by its type and, for variables and literals, its value.
+func label(node ast.Node) string {
+	switch n := node.(type) {
+	case *ast.Var:
+		return string(n.Id)
+	case *ast.LiteralNumber:
+		return n.OriginalString
+	case *ast.Index:
+		if n.Id != nil {
+			return "." + string(*n.Id)
+		}
+	}
+	return strings.TrimPrefix(fmt.Sprintf("%T", node), "*ast.")
+}
+
+func TestTraverse(t *testing.T) {
+	for _, tc := range []struct {
+		snippet string
+		want    []string
+	}{
+		{
+			snippet: "1",
+			want:    []string{"pre 1", "in 1", "post 1"},
+		},
+		{
+			snippet: "1 + 2",
+			want:    []string{"pre Binary", "pre 1", "in 1", "post 1", "in Binary", "pre 2", "in 2", "post 2", "post Binary"},
+		},
+		{
+			snippet: "[1, 2, 3]",
+			want: []string{
+				"pre Array",
+				"pre 1", "in 1", "post 1",
+				"pre 2", "in 2", "post 2",
+				"in Array",
+				"pre 3", "in 3", "post 3",
+				"post Array",
+			},
+		},
+		{
+			// The target of field access with a dot is a child before desugaring.
+			snippet: "a.b",
+			want:    []string{"pre .b", "in .b", "pre a", "in a", "post a", "post .b"},
+		},
+	} {
+		t.Run(tc.snippet, func(t *testing.T) {
+			root, _, err := formatter.SnippetToRawAST("test.jsonnet", tc.snippet)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			record := func(order string) func(*ast.Node) error {
+				return func(node *ast.Node) error {
+					got = append(got, order+" "+label(*node))
+					return nil
+				}
+			}
+			if err := Traverse(root, record("pre"), record("in"), record("post")); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTraverseError(t *testing.T) {
+	root, _, err := formatter.SnippetToRawAST("test.jsonnet", "1 + 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := errors.New("stop")
+	visited := 0
+	pre := func(node *ast.Node) error {
+		visited++
+		if _, ok := (*node).(*ast.LiteralNumber); ok {
+			return stop
+		}
+		return nil
+	}
+	err = Traverse(root, pre, Nop, Nop)
+	if !errors.Is(err, stop) {
+		t.Fatalf("got error %v, want %v", err, stop)
+	}
+	if visited != 2 {
+		t.Errorf("visited %d nodes, want 2", visited)
+	}
+}
+
+func TestChildren(t *testing.T) {
+	for _, tc := range []struct {
+		snippet string
+		want    []string
+	}{
+		{"a.b", []string{"a"}},
+		{"a[1]", []string{"a", "1"}},
+		{"f(1, 2)", []string{"f", "1", "2"}},
+		{"1", nil},
+	} {
+		root, _, err := formatter.SnippetToRawAST("test.jsonnet", tc.snippet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, child := range Children(root) {
+			got = append(got, label(child))
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %q, want %q", tc.snippet, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package vm
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"gopkg.in/yaml.v3"
+)
+
+// yamlIndent is the indentation of YAML produced by native functions.
+const yamlIndent = 2
+
+// nativeValue returns the value of a native function argument that is either a Jsonnet value
+// or, as Tanka expects, a string of JSON encoding the value.
+// Strings that are not valid JSON are returned as they are.
+func nativeValue(arg interface{}) interface{} {
+	s, ok := arg.(string)
+	if !ok {
+		return arg
+	}
+	var value interface{}
+	if err := json.Unmarshal([]byte(s), &value); err != nil {
+		return s
+	}
+	return value
+}
+
+// marshalYAML returns the YAML document for the value with object keys in sorted order.
+func marshalYAML(value interface{}) (string, error) {
+	var b bytes.Buffer
+	encoder := yaml.NewEncoder(&b)
+	encoder.SetIndent(yamlIndent)
+	if err := encoder.Encode(value); err != nil {
+		return "", err
+	}
+	if err := encoder.Close(); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// manifestYamlFromJson returns a native function that manifests a value as a YAML document.
+// If asJSON is true, the argument is manifested as JSON instead, which is also valid YAML.
+// This is the behavior of earlier versions of jsonnet-tool.
+func manifestYamlFromJson(asJSON bool) *jsonnet.NativeFunction {
+	return &jsonnet.NativeFunction{
+		Func: func(data []interface{}) (interface{}, error) {
+			if asJSON {
+				b, err := json.Marshal(data[0])
+				if err != nil {
+					return nil, err
+				}
+				return string(b), nil
+			}
+			return marshalYAML(nativeValue(data[0]))
+		},
+		Params: []ast.Identifier{"json"},
+		Name:   "manifestYamlFromJson",
+	}
+}
+
+// manifestYamlStream returns a native function that manifests an array of values as a stream of YAML
+// documents in the same format as std.manifestYamlStream.
+func manifestYamlStream() *jsonnet.NativeFunction {
+	return &jsonnet.NativeFunction{
+		Func: func(data []interface{}) (interface{}, error) {
+			documents, ok := nativeValue(data[0]).([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("manifestYamlStream expects an array of documents, got %T", data[0])
+			}
+			var stream strings.Builder
+			for _, document := range documents {
+				yaml, err := marshalYAML(document)
+				if err != nil {
+					return nil, err
+				}
+				stream.WriteString("---\n")
+				stream.WriteString(yaml)
+			}
+			stream.WriteString("...\n")
+			return stream.String(), nil
+		},
+		Params: []ast.Identifier{"json"},
+		Name:   "manifestYamlStream",
+	}
+}
+
+// md5Native returns a native function that returns the hex encoded MD5 hash of a string.
+func md5Native() *jsonnet.NativeFunction {
+	return &jsonnet.NativeFunction{
+		Func: func(data []interface{}) (interface{}, error) {
+			s, ok := data[0].(string)
+			if !ok {
+				return nil, fmt.Errorf("md5 expects a string, got %T", data[0])
+			}
+			return fmt.Sprintf("%x", md5.Sum([]byte(s))), nil
+		},
+		Params: []ast.Identifier{"str"},
+		Name:   "md5",
+	}
+}
+
+// nativeBytes returns the bytes of a native function argument that is either a string or an array of bytes.
+func nativeBytes(arg interface{}) ([]byte, error) {
+	switch v := arg.(type) {
+	case string:
+		return []byte(v), nil
+	case []interface{}:
+		b := make([]byte, len(v))
+		for i, element := range v {
+			n, ok := element.(float64)
+			if !ok || n < 0 || n > 255 || n != float64(int(n)) {
+				return nil, fmt.Errorf("element %d is not a byte: %v", i, element)
+			}
+			b[i] = byte(n)
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("expected a string or an array of bytes, got %T", arg)
+}
+
+// base64Encode returns a native function that base64 encodes a string or an array of bytes.
+func base64Encode() *jsonnet.NativeFunction {
+	return &jsonnet.NativeFunction{
+		Func: func(data []interface{}) (interface{}, error) {
+			b, err := nativeBytes(data[0])
+			if err != nil {
+				return nil, fmt.Errorf("base64Encode: %w", err)
+			}
+			return base64.StdEncoding.EncodeToString(b), nil
+		},
+		Params: []ast.Identifier{"data"},
+		Name:   "base64Encode",
+	}
+}
+
+// base64Decode returns a native function that decodes base64 to a string.
+// Unlike std.base64Decode, it is an error if the decoded bytes are not valid UTF-8.
+func base64Decode() *jsonnet.NativeFunction {
+	return &jsonnet.NativeFunction{
+		Func: func(data []interface{}) (interface{}, error) {
+			s, ok := data[0].(string)
+			if !ok {
+				return nil, fmt.Errorf("base64Decode expects a string, got %T", data[0])
+			}
+			b, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return nil, fmt.Errorf("base64Decode: %w", err)
+			}
+			if !utf8.Valid(b) {
+				return nil, fmt.Errorf("base64Decode: decoded data is not valid UTF-8, use base64DecodeBytes instead")
+			}
+			return string(b), nil
+		},
+		Params: []ast.Identifier{"str"},
+		Name:   "base64Decode",
+	}
+}
+
+// base64DecodeBytes returns a native function that decodes base64 to an array of bytes.
+func base64DecodeBytes() *jsonnet.NativeFunction {
+	return &jsonnet.NativeFunction{
+		Func: func(data []interface{}) (interface{}, error) {
+			s, ok := data[0].(string)
+			if !ok {
+				return nil, fmt.Errorf("base64DecodeBytes expects a string, got %T", data[0])
+			}
+			b, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return nil, fmt.Errorf("base64DecodeBytes: %w", err)
+			}
+			bytes := make([]interface{}, len(b))
+			for i, c := range b {
+				bytes[i] = float64(c)
+			}
+			return bytes, nil
+		},
+		Params: []ast.Identifier{"str"},
+		Name:   "base64DecodeBytes",
+	}
+}
+
+// env returns a native function that returns the value of an environment variable, or null if it is unset.
+// Reading the environment makes evaluation depend on more than its source so unless allowed,
+// the function returns an error instead.
+func env(allowed bool) *jsonnet.NativeFunction {
+	return &jsonnet.NativeFunction{
+		Func: func(data []interface{}) (interface{}, error) {
+			name, ok := data[0].(string)
+			if !ok {
+				return nil, fmt.Errorf("env expects a string, got %T", data[0])
+			}
+			if !allowed {
+				return nil, fmt.Errorf("env(%q) is not allowed, use --allow-env to read environment variables", name)
+			}
+			value, ok := os.LookupEnv(name)
+			if !ok {
+				return nil, nil
+			}
+			return value, nil
+		},
+		Params: []ast.Identifier{"name"},
+		Name:   "env",
+	}
+}
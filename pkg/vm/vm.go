@@ -0,0 +1,89 @@
+// Package vm constructs the Jsonnet VMs of jsonnet-tool, with its native functions, and evaluates with them.
+package vm
+
+import (
+	"context"
+	"io"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/grafana/tanka/pkg/jsonnet/native"
+)
+
+// Options configures a VM.
+type Options struct {
+	// Importer imports files. If it is nil, files are imported relative to the importing file only.
+	Importer jsonnet.Importer
+	// ManifestYAMLAsJSON makes the manifestYamlFromJson native function produce JSON rather than YAML.
+	ManifestYAMLAsJSON bool
+	// AllowEnv allows the env native function to read environment variables.
+	AllowEnv bool
+	// ExtVars are the string external variables available to std.extVar.
+	ExtVars map[string]string
+	// TraceOut is where std.trace messages are written. If it is nil, they are written to stderr.
+	TraceOut io.Writer
+	// MaxStack is the maximum number of stack frames of evaluations. If it is 0, the go-jsonnet default is used.
+	MaxStack int
+	// MaxTrace is the maximum number of stack frames in the stack traces of formatted errors. If it is 0, the
+	// go-jsonnet default is used, and if it is negative, every frame is included.
+	MaxTrace int
+}
+
+// New creates a Jsonnet VM with the Tanka native functions, which include regexMatch, regexSubst, sha256, and
+// parseYaml, extended by manifestYamlFromJson, manifestYamlStream, md5, base64Encode, base64Decode,
+// base64DecodeBytes, and env.
+func New(opts Options) *jsonnet.VM {
+	vm := jsonnet.MakeVM()
+	if opts.Importer != nil {
+		vm.Importer(opts.Importer)
+	}
+
+	for _, fn := range native.Funcs() {
+		vm.NativeFunction(fn)
+	}
+
+	// Add in a `manifestYamlFromJson` native function which is used by a number of Jsonnet libraries.
+	vm.NativeFunction(manifestYamlFromJson(opts.ManifestYAMLAsJSON))
+	vm.NativeFunction(manifestYamlStream())
+	for _, fn := range []*jsonnet.NativeFunction{md5Native(), base64Encode(), base64Decode(), base64DecodeBytes(), env(opts.AllowEnv)} {
+		vm.NativeFunction(fn)
+	}
+	for name, value := range opts.ExtVars {
+		vm.ExtVar(name, value)
+	}
+	if opts.TraceOut != nil {
+		vm.SetTraceOut(opts.TraceOut)
+	}
+	if opts.MaxStack > 0 {
+		vm.MaxStack = opts.MaxStack
+	}
+	switch {
+	case opts.MaxTrace > 0:
+		vm.ErrorFormatter.SetMaxStackTraceSize(opts.MaxTrace)
+	case opts.MaxTrace < 0:
+		vm.ErrorFormatter.SetMaxStackTraceSize(0)
+	}
+
+	return vm
+}
+
+// Evaluate evaluates the node with the VM, returning the error of the context if it is cancelled first.
+// go-jsonnet evaluations cannot be stopped, so a cancelled evaluation is abandoned to run in the background
+// and the VM must not be used again.
+func Evaluate(ctx context.Context, vm *jsonnet.VM, node ast.Node) (string, error) {
+	type result struct {
+		output string
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		output, err := vm.Evaluate(node)
+		done <- result{output, err}
+	}()
+	select {
+	case r := <-done:
+		return r.output, r.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// progressEvent is a line of --progress json output.
+type progressEvent struct {
+	// Event is start when a file is started, finish when a file is finished, and end when every file is finished.
+	Event  string `json:"event"`
+	File   string `json:"file,omitempty"`
+	Passed *bool  `json:"passed,omitempty"`
+	Done   int    `json:"done"`
+	Total  int    `json:"total"`
+	Failed int    `json:"failed"`
+	// ElapsedMS is the time since the command started.
+	ElapsedMS float64 `json:"elapsedMs"`
+	// ETAMS is the estimated time until every file is finished.
+	ETAMS float64 `json:"etaMs,omitempty"`
+}
+
+// progress reports the progress of a batch command that processes files.
+// Its methods are safe to call from concurrent workers.
+type progress struct {
+	// mode is tty for a live status line, json for line events, or none.
+	mode    string
+	w       io.Writer
+	mu      sync.Mutex
+	started time.Time
+	total   int
+	done    int
+	failed  int
+}
+
+// newProgress returns the progress of a batch command of total files written to stderr in the mode.
+// In auto mode, a live status line is shown if stderr is a terminal. Status lines are never shown in plain mode.
+func newProgress(mode string, total int) (*progress, error) {
+	switch mode {
+	case "auto", "tty":
+		if !interactive(os.Stderr) && (mode == "auto" || plain) {
+			mode = "none"
+		} else {
+			mode = "tty"
+		}
+	case "json", "none":
+	default:
+		return nil, fmt.Errorf("unknown progress mode %s, expected one of auto, tty, json, none", mode)
+	}
+	return &progress{mode: mode, w: os.Stderr, started: time.Now(), total: total}, nil
+}
+
+// newProgressFlag returns the progress for the --progress flag of a batch command of total files, exiting if the
+// flag is invalid.
+func newProgressFlag(mode string, total int) *progress {
+	p, err := newProgress(mode, total)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error configuring progress: %v\n", err)
+		exit(1)
+	}
+	return p
+}
+
+// eta estimates the time until every file is finished from the average time per finished file.
+func (p *progress) eta() time.Duration {
+	if p.done == 0 {
+		return 0
+	}
+	elapsed := time.Since(p.started)
+	return elapsed / time.Duration(p.done) * time.Duration(p.total-p.done)
+}
+
+// event writes a JSON line event.
+func (p *progress) event(event string, file string, passed *bool) {
+	b, _ := json.Marshal(progressEvent{
+		Event:     event,
+		File:      file,
+		Passed:    passed,
+		Done:      p.done,
+		Total:     p.total,
+		Failed:    p.failed,
+		ElapsedMS: milliseconds(time.Since(p.started)),
+		ETAMS:     milliseconds(p.eta()),
+	})
+	fmt.Fprintf(p.w, "%s\n", b)
+}
+
+// start reports that processing of the file has started.
+// On a terminal, the status line stays until finish is called, so nothing else should be written in between.
+func (p *progress) start(file string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch p.mode {
+	case "json":
+		p.event("start", file, nil)
+	case "tty":
+		percent := 0
+		if p.total > 0 {
+			percent = 100 * p.done / p.total
+		}
+		status := fmt.Sprintf("[%d/%d] %d%%", p.done, p.total, percent)
+		if eta := p.eta(); eta > 0 {
+			status += fmt.Sprintf(" ETA %s", eta.Round(time.Second))
+		}
+		fmt.Fprintf(p.w, "\r\x1b[K%s %s", status, file)
+	}
+}
+
+// finish reports that processing of the file has finished and whether it passed.
+func (p *progress) finish(file string, passed bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done++
+	if !passed {
+		p.failed++
+	}
+	switch p.mode {
+	case "json":
+		p.event("finish", file, &passed)
+	case "tty":
+		fmt.Fprint(p.w, "\r\x1b[K")
+	}
+}
+
+// end reports that every file has been processed.
+func (p *progress) end() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch p.mode {
+	case "json":
+		p.event("end", "", nil)
+	case "tty":
+		fmt.Fprint(p.w, "\r\x1b[K")
+	}
+}
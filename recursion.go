@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// maxHotspots is the number of most frequently evaluated expressions in a recursion report.
+const maxHotspots = 20
+
+// recursionRecorder records the call stack and evaluation counts of an instrumented evaluation.
+type recursionRecorder struct {
+	in *instrumenter
+	// stack is the current chain of function probe IDs, outermost first.
+	stack []int
+	// deepest is a copy of the stack when it was at its deepest.
+	deepest []int
+	// counts are the number of evaluations of each probe ID.
+	counts map[int]int
+}
+
+// newRecursionRecorder returns a recursionRecorder for the probes of the instrumenter.
+func newRecursionRecorder(in *instrumenter) *recursionRecorder {
+	return &recursionRecorder{in: in, counts: make(map[int]int)}
+}
+
+// enter records the start of the evaluation of a probe.
+func (r *recursionRecorder) enter(id int) {
+	r.counts[id]++
+	if r.in.probe(id).Kind != probeFunction {
+		return
+	}
+	r.stack = append(r.stack, id)
+	if len(r.stack) > len(r.deepest) {
+		r.deepest = append(r.deepest[:0], r.stack...)
+	}
+}
+
+// exit records the end of the evaluation of a probe.
+func (r *recursionRecorder) exit(id int) {
+	if r.in.probe(id).Kind != probeFunction || len(r.stack) == 0 {
+		return
+	}
+	r.stack = r.stack[:len(r.stack)-1]
+}
+
+// report returns a human readable report of the deepest call chain and the most frequently
+// evaluated expressions. Consecutive calls of the same function in the call chain are collapsed.
+func (r *recursionRecorder) report() string {
+	builder := strings.Builder{}
+	builder.WriteString(fmt.Sprintf("Maximum call depth: %d\n", len(r.deepest)))
+	if len(r.deepest) > 0 {
+		builder.WriteString("Deepest call chain (outermost first):\n")
+	}
+	for i := 0; i < len(r.deepest); {
+		j := i
+		for j < len(r.deepest) && r.deepest[j] == r.deepest[i] {
+			j++
+		}
+		if j-i > 1 {
+			builder.WriteString(fmt.Sprintf("  %s (x%d)\n", r.in.probe(r.deepest[i]), j-i))
+		} else {
+			builder.WriteString(fmt.Sprintf("  %s\n", r.in.probe(r.deepest[i])))
+		}
+		i = j
+	}
+
+	ids := make([]int, 0, len(r.counts))
+	for id := range r.counts {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if r.counts[ids[i]] == r.counts[ids[j]] {
+			return ids[i] < ids[j]
+		}
+		return r.counts[ids[i]] > r.counts[ids[j]]
+	})
+	if len(ids) > maxHotspots {
+		ids = ids[:maxHotspots]
+	}
+	if len(ids) > 0 {
+		builder.WriteString("Most frequently evaluated expressions:\n")
+	}
+	for _, id := range ids {
+		builder.WriteString(fmt.Sprintf("  %8d %s\n", r.counts[id], r.in.probe(id)))
+	}
+	return builder.String()
+}
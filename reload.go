@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// fileVersion identifies the contents of a file by when it was last modified and its size.
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+}
+
+// statFile returns the version of the file. The zero version is returned if the file cannot be read.
+func statFile(path string) fileVersion {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileVersion{}
+	}
+	return fileVersion{modTime: info.ModTime(), size: info.Size()}
+}
+
+// reloader reloads the settings of a long-lived command, like its library paths and lint rules, when any of
+// the files that they are read from is created, changed, or removed, or when the process receives SIGHUP.
+// Requests that are already being handled keep the settings that they started with.
+type reloader struct {
+	// files returns the paths of the files that the settings are read from, which do not have to exist.
+	files func() []string
+	// load reads the settings. If it fails, the previous settings are kept until a file changes again.
+	load func() error
+
+	mu       sync.Mutex
+	versions map[string]fileVersion
+}
+
+// newReloader returns a reloader of settings that have just been loaded from the files.
+func newReloader(files func() []string, load func() error) *reloader {
+	r := &reloader{files: files, load: load}
+	r.versions = r.stat()
+	return r
+}
+
+// stat returns the current versions of the files.
+func (r *reloader) stat() map[string]fileVersion {
+	versions := make(map[string]fileVersion)
+	for _, path := range r.files() {
+		versions[path] = statFile(path)
+	}
+	return versions
+}
+
+// changedFile returns the first path, in lexical order, that is in only one of the versions or has a different
+// version in each. It returns false if the versions are the same.
+func changedFile(old, current map[string]fileVersion) (string, bool) {
+	var changed []string
+	for path, version := range current {
+		if v, ok := old[path]; !ok || v != version {
+			changed = append(changed, path)
+		}
+	}
+	for path := range old {
+		if _, ok := current[path]; !ok {
+			changed = append(changed, path)
+		}
+	}
+	if len(changed) == 0 {
+		return "", false
+	}
+	sort.Strings(changed)
+	return changed[0], true
+}
+
+// check reloads the settings if any of the files has changed since they were last loaded, and returns true if
+// they were reloaded. Commands check before handling each request.
+func (r *reloader) check() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	versions := r.stat()
+	path, ok := changedFile(r.versions, versions)
+	if !ok {
+		return false
+	}
+	r.versions = versions
+	return r.reload(fmt.Sprintf("%s changed", path))
+}
+
+// reloadOnSignal reloads the settings whenever the process receives SIGHUP, without waiting for a request.
+func (r *reloader) reloadOnSignal() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			r.mu.Lock()
+			r.versions = r.stat()
+			r.reload("received SIGHUP")
+			r.mu.Unlock()
+		}
+	}()
+}
+
+// reload loads the settings and reports why, returning true if they were loaded. r.mu must be held.
+func (r *reloader) reload(reason string) bool {
+	if err := r.load(); err != nil {
+		fmt.Fprintf(os.Stderr, "Keeping the previous settings after %s: %v\n", reason, err)
+		return false
+	}
+	fmt.Printf("Reloaded settings: %s\n", reason)
+	return true
+}
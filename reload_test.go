@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReloaderCheck(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// change changes the watched file at path, which initially contains "{}".
+		change  func(t *testing.T, path string)
+		loadErr error
+		want    bool
+		loads   int
+	}{
+		{
+			name:   "unchanged",
+			change: func(t *testing.T, path string) {},
+			want:   false,
+		},
+		{
+			name: "changed",
+			change: func(t *testing.T, path string) {
+				if err := os.WriteFile(path, []byte(`{"rules": {}}`), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want:  true,
+			loads: 1,
+		},
+		{
+			name: "removed",
+			change: func(t *testing.T, path string) {
+				if err := os.Remove(path); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want:  true,
+			loads: 1,
+		},
+		{
+			name: "changed with an error",
+			change: func(t *testing.T, path string) {
+				if err := os.WriteFile(path, []byte("{"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			loadErr: errors.New("invalid"),
+			want:    false,
+			loads:   1,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "settings.json")
+			if err := os.WriteFile(path, []byte("{}"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			loads := 0
+			r := newReloader(func() []string { return []string{path} }, func() error {
+				loads++
+				return tc.loadErr
+			})
+			tc.change(t, path)
+			if got := r.check(); got != tc.want {
+				t.Errorf("got reloaded %t, want %t", got, tc.want)
+			}
+			// A change is only reloaded once, whether or not it could be loaded.
+			if r.check() {
+				t.Error("reloaded again without a change")
+			}
+			if loads != tc.loads {
+				t.Errorf("loaded %d times, want %d", loads, tc.loads)
+			}
+		})
+	}
+}
+
+func TestReloaderCheckCreated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "settings.json")
+	r := newReloader(func() []string { return []string{path} }, func() error { return nil })
+	if r.check() {
+		t.Error("reloaded before the file was created")
+	}
+	if err := os.WriteFile(path, []byte("{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if !r.check() {
+		t.Error("not reloaded after the file was created")
+	}
+}
+
+func TestChangedFile(t *testing.T) {
+	a, b := fileVersion{size: 1}, fileVersion{size: 2}
+	for _, tc := range []struct {
+		name         string
+		old, current map[string]fileVersion
+		want         string
+		wantChanged  bool
+	}{
+		{"same", map[string]fileVersion{"a": a}, map[string]fileVersion{"a": a}, "", false},
+		{"version", map[string]fileVersion{"a": a, "b": a}, map[string]fileVersion{"a": a, "b": b}, "b", true},
+		{"added", map[string]fileVersion{"b": a}, map[string]fileVersion{"a": a, "b": a}, "a", true},
+		{"watched no longer", map[string]fileVersion{"a": a, "b": a}, map[string]fileVersion{"b": a}, "a", true},
+		{"first in order", map[string]fileVersion{"a": a, "b": a}, map[string]fileVersion{"a": b, "b": b}, "a", true},
+	} {
+		got, changed := changedFile(tc.old, tc.current)
+		if got != tc.want || changed != tc.wantChanged {
+			t.Errorf("%s: got %q, %t, want %q, %t", tc.name, got, changed, tc.want, tc.wantChanged)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// ANSI escape sequences used to highlight JSON.
+const (
+	ansiReset  = "\x1b[0m"
+	ansiKey    = "\x1b[34;1m"
+	ansiString = "\x1b[32m"
+	ansiNumber = "\x1b[36m"
+	ansiBool   = "\x1b[33m"
+	ansiNull   = "\x1b[90m"
+)
+
+// defaultPageLines is the number of lines in a page of REPL output when the terminal height is unknown.
+const defaultPageLines = 40
+
+// replOutput configures how the REPL displays evaluation results.
+type replOutput struct {
+	// color enables JSON syntax highlighting.
+	color bool
+	// indent is the number of spaces per level of indentation.
+	indent int
+	// pageLines is the number of lines shown before output is paged. Zero disables paging.
+	pageLines int
+	// pager is the command that long output is piped through. If empty, long output is truncated.
+	pager string
+	// more is the output that has not been shown yet.
+	more []string
+}
+
+// newREPLOutput returns the output configuration for the repl command flags.
+// Color may be auto, always, or never. With auto, output is colored if stdout is a terminal
+// and the NO_COLOR environment variable is unset. Output is never colored in plain mode.
+// A negative pageLines pages output that is taller than the terminal, using $LINES if it is set.
+// Output is only piped through $PAGER if stdout is a terminal.
+func newREPLOutput(color string, indent int, pageLines int) (replOutput, error) {
+	terminal := interactive(os.Stdout)
+	o := replOutput{indent: indent, pageLines: pageLines}
+	switch color {
+	case "always":
+		o.color = !plain
+	case "never":
+	case "auto":
+		o.color = terminal && os.Getenv("NO_COLOR") == ""
+	default:
+		return o, fmt.Errorf("unknown color mode %s, expected one of auto, always, never", color)
+	}
+	if indent < 0 {
+		return o, fmt.Errorf("indent must not be negative, got %d", indent)
+	}
+	if pageLines < 0 {
+		o.pageLines = 0
+		if terminal {
+			o.pageLines = defaultPageLines
+			// Leave room for the prompt and the truncation message.
+			if lines, err := strconv.Atoi(os.Getenv("LINES")); err == nil && lines > 2 {
+				o.pageLines = lines - 2
+			}
+		}
+	}
+	if terminal {
+		o.pager = os.Getenv("PAGER")
+	}
+	return o, nil
+}
+
+// format reindents the JSON result and highlights it if color is enabled.
+// An indent of zero formats the result on a single line.
+// Results that are not JSON are returned unchanged.
+func (o *replOutput) format(result string) string {
+	var b bytes.Buffer
+	var err error
+	if o.indent == 0 {
+		err = json.Compact(&b, []byte(result))
+	} else {
+		err = json.Indent(&b, []byte(strings.TrimSpace(result)), "", strings.Repeat(" ", o.indent))
+	}
+	if err != nil {
+		return result
+	}
+	b.WriteByte('\n')
+	if o.color {
+		return highlightJSON(b.String())
+	}
+	return b.String()
+}
+
+// highlightJSON colors the strings, object keys, numbers, booleans, and nulls of valid JSON with ANSI escape sequences.
+func highlightJSON(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '"':
+			j := i + 1
+			for j < len(s) && s[j] != '"' {
+				if s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			j++
+			// A string followed by a colon is an object key.
+			color := ansiString
+			if rest := strings.TrimLeft(s[j:], " \t\r\n"); strings.HasPrefix(rest, ":") {
+				color = ansiKey
+			}
+			b.WriteString(color + s[i:j] + ansiReset)
+			i = j
+		case c == '-' || (c >= '0' && c <= '9'):
+			j := i + 1
+			for j < len(s) && strings.IndexByte("0123456789+-.eE", s[j]) >= 0 {
+				j++
+			}
+			b.WriteString(ansiNumber + s[i:j] + ansiReset)
+			i = j
+		case strings.HasPrefix(s[i:], "true"):
+			b.WriteString(ansiBool + "true" + ansiReset)
+			i += len("true")
+		case strings.HasPrefix(s[i:], "false"):
+			b.WriteString(ansiBool + "false" + ansiReset)
+			i += len("false")
+		case strings.HasPrefix(s[i:], "null"):
+			b.WriteString(ansiNull + "null" + ansiReset)
+			i += len("null")
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String()
+}
+
+// Display returns the formatted result for the REPL to print.
+// Output taller than a page is piped through the pager, in which case nothing is returned,
+// or truncated, in which case the rest is shown by the \more command.
+func (o *replOutput) Display(result string) (string, error) {
+	output := o.format(result)
+	o.more = nil
+	lines := strings.SplitAfter(output, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if o.pageLines == 0 || len(lines) <= o.pageLines {
+		return output, nil
+	}
+	if o.pager != "" {
+		return "", page(o.pager, output)
+	}
+	o.more = lines
+	return o.Next(), nil
+}
+
+// page pipes the output through the pager command.
+func page(pager string, output string) error {
+	cmd := exec.Command("sh", "-c", pager)
+	cmd.Stdin = strings.NewReader(output)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	// Like git, make less show colors and quit if the output fits on one screen.
+	if os.Getenv("LESS") == "" {
+		cmd.Env = append(os.Environ(), "LESS=FRX")
+	}
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("unable to run pager %s: %w", pager, err)
+	}
+	return nil
+}
+
+// Next returns the next page of the output that has not been shown yet.
+func (o *replOutput) Next() string {
+	if len(o.more) == 0 {
+		return "No more output.\n"
+	}
+	n := o.pageLines
+	if n == 0 || n > len(o.more) {
+		n = len(o.more)
+	}
+	page := strings.Join(o.more[:n], "")
+	o.more = o.more[n:]
+	if len(o.more) > 0 {
+		page += fmt.Sprintf("... %d more lines, enter \\more;; to continue.\n", len(o.more))
+	}
+	return page
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/jdbaldry/jsonnet-tool/pkg/repl"
+)
+
+// listenAddress parses the address of repl --listen and serve --addr, which is either unix:///path/to/socket, tcp://host:port,
+// or host:port, into the network and address of net.Listen.
+func listenAddress(addr string) (network, address string, err error) {
+	switch {
+	case strings.HasPrefix(addr, "unix://"):
+		network, address = "unix", strings.TrimPrefix(addr, "unix://")
+	case strings.HasPrefix(addr, "tcp://"):
+		network, address = "tcp", strings.TrimPrefix(addr, "tcp://")
+	case strings.Contains(addr, "://"):
+		return "", "", fmt.Errorf("unsupported address %s, expected unix:///path/to/socket or tcp://host:port", addr)
+	default:
+		network, address = "tcp", addr
+	}
+	if address == "" {
+		return "", "", fmt.Errorf("missing path or host and port in address %s", addr)
+	}
+	return network, address, nil
+}
+
+// replServer serves REPL sessions to clients connected to a listener, like editors evaluating regions of a file.
+// All sessions share the REPL, and its VM, so imports stay cached between expressions and sessions.
+// Expressions are evaluated one at a time.
+type replServer struct {
+	// mu guards repl and active.
+	mu   sync.Mutex
+	repl *repl.REPL
+	// workspace is the path of the workspace file that the REPL is saved to, if any.
+	workspace string
+	// output is how evaluations are displayed to each session.
+	output replOutput
+	// active is the number of sessions in each namespace.
+	active map[int]int
+}
+
+// newREPLServer returns a server of sessions of the REPL that display evaluations with output and save the
+// REPL to the workspace file, if there is one.
+func newREPLServer(r *repl.REPL, workspace string, output replOutput) *replServer {
+	return &replServer{repl: r, workspace: workspace, output: output, active: make(map[int]int)}
+}
+
+// replSession is the state of a client of a replServer.
+type replSession struct {
+	// ns is the index of the namespace of the session.
+	ns     int
+	output replOutput
+}
+
+// serve serves sessions to the clients of the listener until the context is cancelled, when the listener is closed.
+func (s *replServer) serve(ctx context.Context, l net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go s.session(conn)
+	}
+}
+
+// session reads expressions and REPL commands terminated by ';;' from the connection and writes their
+// evaluations, each followed by the prompt, until the client quits or closes the connection.
+// The session starts in a namespace of its own so that the variables of concurrent sessions do not conflict,
+// and can switch to the namespaces of other sessions with \n.
+func (s *replServer) session(conn net.Conn) {
+	defer conn.Close()
+	in := bufio.NewScanner(conn)
+	in.Split(repl.ScanDoubleSemiColon)
+	session := &replSession{output: s.output}
+	s.mu.Lock()
+	session.ns = s.namespace()
+	s.active[session.ns]++
+	fmt.Fprint(conn, repl.Help)
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.active[session.ns]--
+		s.mu.Unlock()
+	}()
+	for {
+		fmt.Fprintf(conn, "repl [%d]> ", session.ns)
+		if !in.Scan() {
+			return
+		}
+		result, err := s.eval(session, in.Text())
+		if err == repl.ErrExit {
+			fmt.Fprintln(conn, "Bye!")
+			return
+		}
+		if err != nil {
+			fmt.Fprintf(conn, "Evaluation error: %v\n", err)
+		}
+		fmt.Fprint(conn, result)
+	}
+}
+
+// namespace returns the first namespace that is empty and has no sessions, creating one if there is none.
+func (s *replServer) namespace() int {
+	for i, ns := range s.repl.Namespaces {
+		if s.active[i] == 0 && len(ns.Exprs) == 0 && ns.EvalFile == "" && ns.File == "" {
+			return i
+		}
+	}
+	return s.repl.AddNamespace()
+}
+
+// eval evaluates the input in the namespace of the session and saves the workspace of the REPL.
+func (s *replServer) eval(session *replSession, input string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := s.repl
+	r.NS, r.Output = session.ns, &session.output
+	result, err := r.Eval(input)
+	if r.NS != session.ns {
+		s.active[session.ns]--
+		s.active[r.NS]++
+	}
+	session.ns = r.NS
+	if err := saveWorkspace(r, s.workspace); err != nil {
+		result += fmt.Sprintf("Error saving workspace: %v\n", err)
+	}
+	return result, err
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// fileResult is the result of a batch command for one file.
+type fileResult struct {
+	File   string `json:"file"`
+	Passed bool   `json:"passed"`
+	// Problems are the failed tests or lint diagnostics of the file.
+	Problems   []string `json:"problems,omitempty"`
+	DurationMS float64  `json:"durationMs"`
+}
+
+// reportTrend compares a report with a previous report.
+type reportTrend struct {
+	Previous        time.Time `json:"previous"`
+	PassedDelta     int       `json:"passedDelta"`
+	FailedDelta     int       `json:"failedDelta"`
+	DurationMSDelta float64   `json:"durationMsDelta"`
+	// NewFailures are the files that passed in the previous report and fail now.
+	NewFailures []string `json:"newFailures,omitempty"`
+	// Fixed are the files that failed in the previous report and pass now.
+	Fixed []string `json:"fixed,omitempty"`
+	// Added are the files that are not in the previous report.
+	Added []string `json:"added,omitempty"`
+	// Removed are the files that are only in the previous report.
+	Removed []string `json:"removed,omitempty"`
+}
+
+// batchReport is a local report of the per-file results and timings of a batch command.
+// Nothing is sent anywhere: the report is only written to a file.
+type batchReport struct {
+	Command    string       `json:"command"`
+	Started    time.Time    `json:"started"`
+	DurationMS float64      `json:"durationMs"`
+	Passed     int          `json:"passed"`
+	Failed     int          `json:"failed"`
+	Files      []fileResult `json:"files"`
+	Trend      *reportTrend `json:"trend,omitempty"`
+}
+
+// newBatchReport starts a report for the command.
+func newBatchReport(command string) *batchReport {
+	return &batchReport{Command: command, Started: time.Now()}
+}
+
+// milliseconds returns the duration in milliseconds.
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// add records the result of a file that took the duration to process.
+func (r *batchReport) add(file string, problems []string, duration time.Duration) {
+	result := fileResult{File: file, Passed: len(problems) == 0, Problems: problems, DurationMS: milliseconds(duration)}
+	if result.Passed {
+		r.Passed++
+	} else {
+		r.Failed++
+	}
+	r.Files = append(r.Files, result)
+}
+
+// finish records the duration of the command and compares the report with the previous report, if any.
+func (r *batchReport) finish(previous *batchReport) {
+	r.DurationMS = milliseconds(time.Since(r.Started))
+	if previous == nil {
+		return
+	}
+	trend := &reportTrend{
+		Previous:        previous.Started,
+		PassedDelta:     r.Passed - previous.Passed,
+		FailedDelta:     r.Failed - previous.Failed,
+		DurationMSDelta: r.DurationMS - previous.DurationMS,
+	}
+	previousPassed := make(map[string]bool, len(previous.Files))
+	for _, f := range previous.Files {
+		previousPassed[f.File] = f.Passed
+	}
+	for _, f := range r.Files {
+		passed, ok := previousPassed[f.File]
+		switch {
+		case !ok:
+			trend.Added = append(trend.Added, f.File)
+		case passed && !f.Passed:
+			trend.NewFailures = append(trend.NewFailures, f.File)
+		case !passed && f.Passed:
+			trend.Fixed = append(trend.Fixed, f.File)
+		}
+		delete(previousPassed, f.File)
+	}
+	for file := range previousPassed {
+		trend.Removed = append(trend.Removed, file)
+	}
+	sort.Strings(trend.Removed)
+	r.Trend = trend
+}
+
+// readReport reads a JSON report written by a previous run.
+func readReport(path string) (*batchReport, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var r batchReport
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, fmt.Errorf("unable to parse report %s, only JSON reports can be compared: %w", path, err)
+	}
+	return &r, nil
+}
+
+// reportTemplate renders a report as a standalone HTML page.
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	// signed formats a difference with its sign.
+	"signed": func(v interface{}) string {
+		if f, ok := v.(float64); ok {
+			return fmt.Sprintf("%+.0f", f)
+		}
+		return fmt.Sprintf("%+d", v)
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>jsonnet-tool {{.Command}} report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; vertical-align: top; }
+.pass { color: #176f2c; }
+.fail { color: #b00020; }
+pre { margin: 0; white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<h1>jsonnet-tool {{.Command}}</h1>
+<p>Started {{.Started.Format "2006-01-02 15:04:05 MST"}}, took {{printf "%.0f" .DurationMS}} ms.
+<span class="pass">{{.Passed}} passed</span>, <span class="fail">{{.Failed}} failed</span>.</p>
+{{with .Trend}}
+<h2>Compared with {{.Previous.Format "2006-01-02 15:04:05 MST"}}</h2>
+<ul>
+<li>{{signed .PassedDelta}} passed, {{signed .FailedDelta}} failed, {{signed .DurationMSDelta}} ms</li>
+{{with .NewFailures}}<li class="fail">New failures: {{range $i, $f := .}}{{if $i}}, {{end}}{{$f}}{{end}}</li>{{end}}
+{{with .Fixed}}<li class="pass">Fixed: {{range $i, $f := .}}{{if $i}}, {{end}}{{$f}}{{end}}</li>{{end}}
+{{with .Added}}<li>Added: {{range $i, $f := .}}{{if $i}}, {{end}}{{$f}}{{end}}</li>{{end}}
+{{with .Removed}}<li>Removed: {{range $i, $f := .}}{{if $i}}, {{end}}{{$f}}{{end}}</li>{{end}}
+</ul>
+{{end}}
+<table>
+<tr><th>Result</th><th>File</th><th>Duration (ms)</th><th>Problems</th></tr>
+{{range .Files}}<tr>
+<td class="{{if .Passed}}pass">ok{{else}}fail">FAIL{{end}}</td>
+<td>{{.File}}</td>
+<td>{{printf "%.1f" .DurationMS}}</td>
+<td>{{if .Problems}}<details><summary>{{len .Problems}}</summary><pre>{{range .Problems}}{{.}}
+{{end}}</pre></details>{{end}}</td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// write writes the report to path as HTML if path has a .html or .htm extension, and as JSON otherwise.
+func (r *batchReport) write(path string) error {
+	var b strings.Builder
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".html", ".htm":
+		if err := reportTemplate.Execute(&b, r); err != nil {
+			return err
+		}
+	default:
+		j, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			return err
+		}
+		b.Write(append(j, '\n'))
+	}
+	return writeFileAtomic(path, []byte(b.String()), true)
+}
+
+// readPreviousReport reads the previous report for the --previous flag of a batch command, exiting if it
+// cannot be read. It returns nil if path is empty.
+func readPreviousReport(path string) *batchReport {
+	if path == "" {
+		return nil
+	}
+	previous, err := readReport(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading previous report: %v\n", err)
+		exit(1)
+	}
+	return previous
+}
+
+// writeReport finishes the report and writes it to the file for the --report flag of a batch command,
+// exiting if it cannot be written. Nothing is written if path is empty.
+func writeReport(report *batchReport, previous *batchReport, path string) {
+	if path == "" {
+		return
+	}
+	report.finish(previous)
+	if err := report.write(path); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing report %s: %v\n", path, err)
+		exit(1)
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+)
+
+// jsonSchemaDialect is the JSON Schema version of inferred schemas.
+const jsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// schemaTypes are the JSON Schema types of a value, written as a string if there is only one.
+type schemaTypes []string
+
+// MarshalJSON writes a single type as a string and multiple types as an array.
+func (t schemaTypes) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+	return json.Marshal([]string(t))
+}
+
+// UnmarshalJSON reads a type written as a string or an array.
+func (t *schemaTypes) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*t = schemaTypes{single}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(t))
+}
+
+// has returns true if name is one of the types.
+func (t schemaTypes) has(name string) bool {
+	for _, n := range t {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// unionTypes returns the sorted types that are in any of the type lists. Integers are numbers, so integer is
+// left out if number is in the union.
+func unionTypes(lists ...schemaTypes) schemaTypes {
+	var union schemaTypes
+	for _, list := range lists {
+		for _, t := range list {
+			if !union.has(t) {
+				union = append(union, t)
+			}
+		}
+	}
+	if union.has("number") {
+		types := union[:0]
+		for _, t := range union {
+			if t != "integer" {
+				types = append(types, t)
+			}
+		}
+		union = types
+	}
+	sort.Strings(union)
+	return union
+}
+
+// jsonSchema is a JSON Schema.
+type jsonSchema struct {
+	Schema     string                 `json:"$schema,omitempty"`
+	Type       schemaTypes            `json:"type,omitempty"`
+	Enum       []interface{}          `json:"enum,omitempty"`
+	Properties map[string]*jsonSchema `json:"properties,omitempty"`
+	Required   []string               `json:"required,omitempty"`
+	Items      *jsonSchema            `json:"items,omitempty"`
+}
+
+// literalUnions statically maps output paths to the values of the fields that define them when every value that
+// the field can have is a literal, like the branches of if env == 'prod' then 'large' else 'small'.
+// Branches that raise errors have no value. Paths are followed like those of fieldLocations, and a path that
+// is later defined by any other expression is removed.
+func literalUnions(vm *jsonnet.VM, node ast.Node, path string, env map[ast.Identifier]ast.Node, unions map[string][]interface{}, depth int) {
+	if node == nil || depth > 100 {
+		return
+	}
+	switch i := node.(type) {
+	case *ast.DesugaredObject:
+		for _, field := range i.Fields {
+			name, ok := field.Name.(*ast.LiteralString)
+			if !ok {
+				continue
+			}
+			p := fieldPath(path, name.Value)
+			if values, ok := literalValues(field.Body, env, depth); ok && len(values) > 1 {
+				unions[p] = values
+			} else {
+				delete(unions, p)
+			}
+			literalUnions(vm, field.Body, p, env, unions, depth+1)
+		}
+	case *ast.Array:
+		for j, element := range i.Elements {
+			literalUnions(vm, element.Expr, fmt.Sprintf("%s[%d]", path, j), env, unions, depth+1)
+		}
+	case *ast.Binary:
+		if i.Op == ast.BopPlus {
+			literalUnions(vm, i.Left, path, env, unions, depth+1)
+			literalUnions(vm, i.Right, path, env, unions, depth+1)
+		}
+	case *ast.Conditional:
+		literalUnions(vm, i.BranchTrue, path, env, unions, depth+1)
+		literalUnions(vm, i.BranchFalse, path, env, unions, depth+1)
+	case *ast.Import:
+		imported, _, err := vm.ImportAST(i.Loc().FileName, i.File.Value)
+		if err != nil {
+			return
+		}
+		literalUnions(vm, imported, path, map[ast.Identifier]ast.Node{}, unions, depth+1)
+	case *ast.Local:
+		scope := make(map[ast.Identifier]ast.Node, len(env)+len(i.Binds))
+		for id, bound := range env {
+			scope[id] = bound
+		}
+		for _, bind := range i.Binds {
+			scope[bind.Variable] = bind.Body
+		}
+		literalUnions(vm, i.Body, path, scope, unions, depth+1)
+	case *ast.Var:
+		literalUnions(vm, env[i.Id], path, env, unions, depth+1)
+	}
+}
+
+// literalValues returns the distinct values of the expression if it is a literal, or conditionals and locals
+// whose branches are literals.
+func literalValues(node ast.Node, env map[ast.Identifier]ast.Node, depth int) ([]interface{}, bool) {
+	if depth > 100 {
+		return nil, false
+	}
+	switch i := node.(type) {
+	case *ast.LiteralString:
+		return []interface{}{i.Value}, true
+	case *ast.LiteralNumber:
+		// Numbers are compared as decoded from the JSON output.
+		var value interface{}
+		if err := json.Unmarshal([]byte(i.OriginalString), &value); err != nil {
+			return nil, false
+		}
+		return []interface{}{value}, true
+	case *ast.LiteralBoolean:
+		return []interface{}{i.Value}, true
+	case *ast.LiteralNull:
+		return []interface{}{nil}, true
+	case *ast.Error:
+		return nil, true
+	case *ast.Conditional:
+		t, ok := literalValues(i.BranchTrue, env, depth+1)
+		if !ok {
+			return nil, false
+		}
+		f, ok := literalValues(i.BranchFalse, env, depth+1)
+		if !ok {
+			return nil, false
+		}
+		values := t
+		for _, v := range f {
+			if !containsValue(values, v) {
+				values = append(values, v)
+			}
+		}
+		return values, true
+	case *ast.Local:
+		scope := make(map[ast.Identifier]ast.Node, len(env)+len(i.Binds))
+		for id, bound := range env {
+			scope[id] = bound
+		}
+		for _, bind := range i.Binds {
+			scope[bind.Variable] = bind.Body
+		}
+		return literalValues(i.Body, scope, depth+1)
+	case *ast.Var:
+		if bound, ok := env[i.Id]; ok {
+			return literalValues(bound, env, depth+1)
+		}
+	}
+	return nil, false
+}
+
+// containsValue returns true if the JSON value is one of the values.
+func containsValue(values []interface{}, value interface{}) bool {
+	for _, v := range values {
+		if reflect.DeepEqual(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// schemaType returns the JSON Schema type of the decoded JSON value. Whole numbers are integers.
+func schemaType(value interface{}) string {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case bool:
+		return "boolean"
+	}
+	return "null"
+}
+
+// inferSchema returns the schema of the decoded JSON value at the output path. Every field of an object is
+// required, and the items of an array are described by a single schema that all of its elements satisfy.
+// Values at the paths of literal unions that are one of the union's values are described by an enum.
+func inferSchema(value interface{}, path string, unions map[string][]interface{}) *jsonSchema {
+	s := &jsonSchema{Type: schemaTypes{schemaType(value)}}
+	if values, ok := unions[path]; ok && containsValue(values, value) {
+		s.Enum = values
+		for _, v := range values {
+			s.Type = unionTypes(s.Type, schemaTypes{schemaType(v)})
+		}
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		s.Properties = make(map[string]*jsonSchema, len(v))
+		for name, field := range v {
+			s.Properties[name] = inferSchema(field, fieldPath(path, name), unions)
+			s.Required = append(s.Required, name)
+		}
+		sort.Strings(s.Required)
+	case []interface{}:
+		for i, element := range v {
+			s.Items = mergeSchemas(s.Items, inferSchema(element, fmt.Sprintf("%s[%d]", path, i), unions))
+		}
+	}
+	return s
+}
+
+// mergeSchemas returns a schema that is satisfied by the values of either schema, as inferred by inferSchema.
+// Fields are only required if both objects require them, and enums are only kept if both schemas have one.
+func mergeSchemas(a, b *jsonSchema) *jsonSchema {
+	if a == nil {
+		return b
+	}
+	merged := &jsonSchema{Type: unionTypes(a.Type, b.Type), Items: a.Items}
+	if a.Enum != nil && b.Enum != nil {
+		merged.Enum = append([]interface{}{}, a.Enum...)
+		for _, v := range b.Enum {
+			if !containsValue(merged.Enum, v) {
+				merged.Enum = append(merged.Enum, v)
+			}
+		}
+	}
+	if a.Properties != nil || b.Properties != nil {
+		merged.Properties = make(map[string]*jsonSchema)
+		for name, field := range a.Properties {
+			merged.Properties[name] = field
+		}
+		for name, field := range b.Properties {
+			merged.Properties[name] = mergeSchemas(merged.Properties[name], field)
+		}
+	}
+	switch {
+	case a.Properties == nil:
+		merged.Required = b.Required
+	case b.Properties == nil:
+		merged.Required = a.Required
+	default:
+		required := make(map[string]bool, len(b.Required))
+		for _, name := range b.Required {
+			required[name] = true
+		}
+		for _, name := range a.Required {
+			if required[name] {
+				merged.Required = append(merged.Required, name)
+			}
+		}
+	}
+	if b.Items != nil {
+		merged.Items = mergeSchemas(merged.Items, b.Items)
+	}
+	return merged
+}
+
+// makeSchema infers the schema of the evaluated JSON output of the root node.
+func makeSchema(vm *jsonnet.VM, root ast.Node, output string) (*jsonSchema, error) {
+	var value interface{}
+	if err := json.Unmarshal([]byte(output), &value); err != nil {
+		return nil, fmt.Errorf("unable to decode output: %w", err)
+	}
+	unions := make(map[string][]interface{})
+	literalUnions(vm, root, "$", map[ast.Identifier]ast.Node{}, unions, 0)
+	s := inferSchema(value, "$", unions)
+	s.Schema = jsonSchemaDialect
+	return s, nil
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/google/go-jsonnet/ast"
+
+	"github.com/jdbaldry/jsonnet-tool/pkg/traverse"
+)
+
+// defaultScrubKeep matches hostnames that are kept by scrub because they are public and not identifying.
+const defaultScrubKeep = `(^|\.)(kubernetes\.io|k8s\.io|example\.(com|org|net))$`
+
+// secretKey matches object field and local variable names whose string values are assumed to be secret.
+var secretKey = regexp.MustCompile(`(?i)(passw(or)?d|secret|token|api[_-]?key|private[_-]?key|credential|auth)`)
+
+// scrubPattern finds sensitive substrings of one kind.
+type scrubPattern struct {
+	kind   string
+	regexp *regexp.Regexp
+	// valid optionally filters matches of the regular expression.
+	valid func(match string) bool
+}
+
+// hostnameTLDs are the top level domains of hostnames that are scrubbed.
+// They are limited to avoid scrubbing file names like config.yaml.
+const hostnameTLDs = `com|net|org|io|dev|app|cloud|internal|local|lan|corp|intra|edu|gov|co|uk|de|fr|nl|eu|us|ca|au|jp|ch|se|no|dk|fi|es|it|pl|be|at|info|biz|ai|tech|xyz`
+
+// scrubPatterns are the kinds of sensitive substrings in the order that they are scrubbed.
+var scrubPatterns = []scrubPattern{
+	{kind: "private-key", regexp: regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`)},
+	{kind: "url-password", regexp: regexp.MustCompile(`://[^/:@\s]+:[^/@\s]+@`)},
+	{kind: "token", regexp: regexp.MustCompile(`AKIA[0-9A-Z]{16}|gh[pousr]_[A-Za-z0-9]{36,}|xox[abprs]-[A-Za-z0-9-]{10,}|eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`)},
+	{kind: "email", regexp: regexp.MustCompile(`[A-Za-z0-9._%+-]+@(?:[A-Za-z0-9-]+\.)+[A-Za-z]{2,}`)},
+	{kind: "ipv4", regexp: regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`), valid: func(match string) bool {
+		ip := net.ParseIP(match)
+		return ip != nil && !ip.IsLoopback() && !ip.IsUnspecified()
+	}},
+	{kind: "ipv6", regexp: regexp.MustCompile(`(?i)[0-9a-f]*:[0-9a-f:]*:[0-9a-f]*`), valid: func(match string) bool {
+		ip := net.ParseIP(match)
+		return ip != nil && ip.To4() == nil && !ip.IsLoopback() && !ip.IsUnspecified()
+	}},
+	{kind: "hostname", regexp: regexp.MustCompile(`(?i)\b(?:[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?\.)+(?:` + hostnameTLDs + `)\b`)},
+	{kind: "high-entropy", regexp: regexp.MustCompile(`[A-Za-z0-9+/=_-]{24,}`), valid: func(match string) bool {
+		return entropy(match) > 4 && strings.ContainsAny(match, "0123456789") && strings.ToLower(match) != match
+	}},
+}
+
+// entropy returns the Shannon entropy of the string in bits per character.
+func entropy(s string) float64 {
+	counts := make(map[rune]int)
+	for _, r := range s {
+		counts[r]++
+	}
+	var h float64
+	n := float64(len([]rune(s)))
+	for _, count := range counts {
+		p := float64(count) / n
+		h -= p * math.Log2(p)
+	}
+	return h
+}
+
+// scrubber consistently replaces sensitive strings with placeholders so that the same original value
+// always has the same placeholder and references between values are preserved.
+type scrubber struct {
+	keep         *regexp.Regexp
+	placeholders map[string]string
+	// counts are the number of distinct values of each kind.
+	counts map[string]int
+	// redacted is the number of distinct values replaced with a REDACTED-N placeholder.
+	redacted int
+}
+
+// newScrubber returns a scrubber that keeps hostnames matching keep.
+func newScrubber(keep *regexp.Regexp) *scrubber {
+	return &scrubber{keep: keep, placeholders: make(map[string]string), counts: make(map[string]int)}
+}
+
+// placeholder returns the placeholder for the original value of a kind.
+func (s *scrubber) placeholder(kind, original string) string {
+	key := kind + "\x00" + original
+	if p, ok := s.placeholders[key]; ok {
+		return p
+	}
+	s.counts[kind]++
+	n := s.counts[kind]
+	var p string
+	switch kind {
+	case "ipv4":
+		// Addresses are taken from the documentation ranges of RFC 5737.
+		p = fmt.Sprintf("%s.%d", []string{"192.0.2", "198.51.100", "203.0.113"}[(n-1)/254%3], (n-1)%254+1)
+	case "ipv6":
+		// Addresses are taken from the documentation range of RFC 3849.
+		p = fmt.Sprintf("2001:db8::%x", n)
+	case "hostname":
+		p = fmt.Sprintf("host-%d.example.com", n)
+	case "email":
+		p = fmt.Sprintf("user-%d@example.com", n)
+	case "url-password":
+		p = fmt.Sprintf("://user-%d:REDACTED@", n)
+	default:
+		s.redacted++
+		p = fmt.Sprintf("REDACTED-%d", s.redacted)
+	}
+	s.placeholders[key] = p
+	return p
+}
+
+// scrub returns the string with its sensitive substrings replaced. If secret is true, the whole
+// non-empty string is replaced.
+// Every pattern is matched against the original string and matches that overlap the match of an
+// earlier pattern are skipped, so that placeholders are never scrubbed again.
+func (s *scrubber) scrub(value string, secret bool) string {
+	if secret && value != "" {
+		return s.placeholder("secret", value)
+	}
+	type replacement struct {
+		begin, end int
+		text       string
+	}
+	var replacements []replacement
+	for _, pattern := range scrubPatterns {
+	matches:
+		for _, match := range pattern.regexp.FindAllStringIndex(value, -1) {
+			begin, end := match[0], match[1]
+			original := value[begin:end]
+			if pattern.valid != nil && !pattern.valid(original) {
+				continue
+			}
+			if pattern.kind == "hostname" && s.keep != nil && s.keep.MatchString(strings.ToLower(original)) {
+				continue
+			}
+			for _, r := range replacements {
+				if begin < r.end && r.begin < end {
+					continue matches
+				}
+			}
+			replacements = append(replacements, replacement{begin, end, s.placeholder(pattern.kind, original)})
+		}
+	}
+	sort.Slice(replacements, func(i, j int) bool { return replacements[i].begin < replacements[j].begin })
+	var scrubbed strings.Builder
+	offset := 0
+	for _, r := range replacements {
+		scrubbed.WriteString(value[offset:r.begin])
+		scrubbed.WriteString(r.text)
+		offset = r.end
+	}
+	scrubbed.WriteString(value[offset:])
+	return scrubbed.String()
+}
+
+// summary returns the number of distinct values scrubbed of each kind.
+func (s *scrubber) summary() string {
+	kinds := make([]string, 0, len(s.counts))
+	for kind := range s.counts {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	parts := make([]string, 0, len(kinds))
+	for _, kind := range kinds {
+		parts = append(parts, fmt.Sprintf("%d %s", s.counts[kind], kind))
+	}
+	if len(parts) == 0 {
+		return "Nothing scrubbed"
+	}
+	return "Scrubbed " + strings.Join(parts, ", ")
+}
+
+// scrubSource returns the edits that replace the sensitive string literals of the raw AST.
+// Literals that are the value of a field or local variable with a secret name are replaced entirely.
+// Replaced literals are written as double quoted strings.
+func (s *scrubber) scrubSource(root ast.Node) []textEdit {
+	secrets := make(map[*ast.LiteralString]bool)
+	var edits []textEdit
+	traverse.Traverse(root,
+		func(node *ast.Node) error {
+			switch i := (*node).(type) {
+			case *ast.Object:
+				for _, field := range i.Fields {
+					name, ok := fieldName(field)
+					if field.Kind == ast.ObjectLocal {
+						name, ok = string(*field.Id), true
+					}
+					if literal, isLiteral := field.Expr2.(*ast.LiteralString); ok && isLiteral && secretKey.MatchString(name) {
+						secrets[literal] = true
+					}
+				}
+			case *ast.Local:
+				for _, bind := range i.Binds {
+					if literal, ok := bind.Body.(*ast.LiteralString); ok && secretKey.MatchString(string(bind.Variable)) {
+						secrets[literal] = true
+					}
+				}
+			case *ast.LiteralString:
+				scrubbed := s.scrub(i.Value, secrets[i])
+				if scrubbed == i.Value {
+					return nil
+				}
+				// JSON strings are also Jsonnet strings.
+				quoted, _ := json.Marshal(scrubbed)
+				edits = append(edits, textEdit{LocationRange: makeLocationRange(i.Loc()), NewText: string(quoted)})
+			}
+			return nil
+		},
+		traverse.Nop,
+		traverse.Nop,
+	)
+	return edits
+}
+
+// scrubValue returns the JSON value with its sensitive strings, including object keys, replaced.
+// Strings that are the value of a key with a secret name are replaced entirely.
+func (s *scrubber) scrubValue(value interface{}, secret bool) interface{} {
+	switch v := value.(type) {
+	case string:
+		return s.scrub(v, secret)
+	case []interface{}:
+		for i, element := range v {
+			v[i] = s.scrubValue(element, secret)
+		}
+		return v
+	case map[string]interface{}:
+		// Keys are scrubbed in order so that placeholders are deterministic.
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		scrubbed := make(map[string]interface{}, len(v))
+		for _, key := range keys {
+			scrubbed[s.scrub(key, false)] = s.scrubValue(v[key], secretKey.MatchString(key))
+		}
+		return scrubbed
+	}
+	return value
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+)
+
+// maxRequestBytes is the maximum size of the body of an evaluation request.
+const maxRequestBytes = 10 << 20
+
+// evalRequest is the body of a request to the /eval endpoint of the serve command.
+// Exactly one of Snippet and File is set.
+type evalRequest struct {
+	// Snippet is Jsonnet source to evaluate. Its relative imports are resolved from the root directory.
+	Snippet string `json:"snippet,omitempty"`
+	// File is the path of a file in the root directory to evaluate.
+	File string `json:"file,omitempty"`
+	// ExtVars are string external variables of the evaluation.
+	ExtVars map[string]string `json:"extVars,omitempty"`
+}
+
+// evalServer evaluates Jsonnet over HTTP.
+type evalServer struct {
+	// root is the directory that files are evaluated from.
+	root string
+	// timeout is the maximum duration of an evaluation.
+	timeout time.Duration
+	// slots limits the number of concurrent evaluations. An evaluation holds a slot until it finishes,
+	// even if its request has timed out.
+	slots chan struct{}
+	// settings reloads the project configuration before a request when it has changed. Evaluations in progress
+	// keep the configuration that they started with.
+	settings *reloader
+}
+
+// newEvalServer returns a server that evaluates files in root and snippets with at most concurrency
+// evaluations at a time, each taking at most timeout, with the project configuration reloaded by settings.
+func newEvalServer(root string, timeout time.Duration, concurrency int, settings *reloader) *evalServer {
+	return &evalServer{root: root, timeout: timeout, slots: make(chan struct{}, concurrency), settings: settings}
+}
+
+// handler returns the HTTP handler of the server.
+func (s *evalServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/eval", s.eval)
+	return mux
+}
+
+// confinedImporter is a jsonnet.Importer that refuses to import files outside of the root directory and the
+// library paths, so that requests cannot read arbitrary files of the server.
+type confinedImporter struct {
+	importer jsonnet.Importer
+	root     string
+	jpaths   []string
+}
+
+// Import imports the file using the wrapped importer if every path that it could be found at, and the path that
+// it was found at, are within the root directory or the library paths.
+func (c confinedImporter) Import(importedFrom, importedPath string) (jsonnet.Contents, string, error) {
+	candidates := []string{importedPath}
+	if !filepath.IsAbs(importedPath) {
+		candidates = []string{filepath.Join(filepath.Dir(importedFrom), importedPath)}
+		for _, jpath := range c.jpaths {
+			candidates = append(candidates, filepath.Join(jpath, importedPath))
+		}
+	}
+	for _, candidate := range candidates {
+		if !c.contains(candidate) {
+			return jsonnet.Contents{}, "", fmt.Errorf("import %s is outside of the served directory and library paths", importedPath)
+		}
+	}
+	contents, foundAt, err := c.importer.Import(importedFrom, importedPath)
+	if err == nil && !c.contains(foundAt) {
+		return jsonnet.Contents{}, "", fmt.Errorf("import %s is outside of the served directory and library paths", importedPath)
+	}
+	return contents, foundAt, err
+}
+
+// contains returns true if the path is within the root directory or any of the library paths.
+func (c confinedImporter) contains(path string) bool {
+	for _, dir := range append([]string{c.root}, c.jpaths...) {
+		if withinDir(dir, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeError writes the error as a JSON jsonnetError with the HTTP status code.
+func writeError(w http.ResponseWriter, code int, e jsonnetError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(e)
+}
+
+// file returns the path of the request file, which must be within the root directory.
+func (s *evalServer) file(name string) (string, error) {
+	if filepath.IsAbs(name) || !filepath.IsLocal(name) {
+		return "", fmt.Errorf("file %s is not a relative path within the served directory", name)
+	}
+	return filepath.Join(s.root, name), nil
+}
+
+// eval handles a POST of an evalRequest, responding with the JSON evaluation.
+// Jsonnet errors are responded to with status 422, missing files with 404, timeouts and requests beyond the
+// concurrency limit with 503, and invalid requests with 400.
+func (s *evalServer) eval(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, jsonnetError{Kind: "error", Message: "evaluations must be POSTed"})
+		return
+	}
+	var req evalRequest
+	decoder := json.NewDecoder(io.LimitReader(r.Body, maxRequestBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, jsonnetError{Kind: "error", Message: fmt.Sprintf("invalid request: %v", err)})
+		return
+	}
+	if (req.Snippet == "") == (req.File == "") {
+		writeError(w, http.StatusBadRequest, jsonnetError{Kind: "error", Message: "invalid request: exactly one of snippet and file is required"})
+		return
+	}
+	file := filepath.Join(s.root, "request.jsonnet")
+	if req.File != "" {
+		var err error
+		if file, err = s.file(req.File); err != nil {
+			writeError(w, http.StatusBadRequest, jsonnetError{Kind: "error", Message: fmt.Sprintf("invalid request: %v", err)})
+			return
+		}
+		if _, err := os.Stat(file); err != nil {
+			writeError(w, http.StatusNotFound, jsonnetError{Kind: "error", Message: fmt.Sprintf("unable to read file %s: %v", req.File, err)})
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.timeout)
+	defer cancel()
+	select {
+	case s.slots <- struct{}{}:
+	case <-ctx.Done():
+		writeError(w, http.StatusServiceUnavailable, jsonnetError{Kind: "error", Message: "too many concurrent evaluations"})
+		return
+	}
+	type result struct {
+		output string
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		defer func() { <-s.slots }()
+		s.settings.check()
+		configMu.RLock()
+		vm := makeVM()
+		vm.Importer(confinedImporter{importer: makeImporter(), root: s.root, jpaths: importJPaths()})
+		configMu.RUnlock()
+		for name, value := range req.ExtVars {
+			vm.ExtVar(name, value)
+		}
+		var root ast.Node
+		var r result
+		if req.File != "" {
+			root, _, r.err = vm.ImportAST("", file)
+		} else {
+			root, r.err = jsonnet.SnippetToAST(file, req.Snippet)
+		}
+		if r.err == nil {
+			r.output, r.err = vm.Evaluate(root)
+		}
+		done <- r
+	}()
+	select {
+	case res := <-done:
+		if res.err != nil {
+			writeError(w, http.StatusUnprocessableEntity, makeJsonnetError(res.err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, res.output)
+	case <-ctx.Done():
+		message := fmt.Sprintf("evaluation timed out after %s", s.timeout)
+		if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			message = "request cancelled"
+		}
+		writeError(w, http.StatusServiceUnavailable, jsonnetError{Kind: "error", Message: message})
+	}
+}
+
+// serve serves the handler at the address, which is parsed like that of repl --listen, until the context is
+// cancelled, then waits for the responses in progress to be written.
+func serve(ctx context.Context, addr string, handler http.Handler) error {
+	network, address, err := listenAddress(addr)
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: handler}
+	fmt.Printf("Listening on %s\n", l.Addr())
+	errs := make(chan error, 1)
+	go func() { errs <- server.Serve(l) }()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	shutdown, cancel := context.WithTimeout(context.Background(), interruptGrace)
+	defer cancel()
+	return server.Shutdown(shutdown)
+}
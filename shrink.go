@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/formatter"
+
+	"github.com/jdbaldry/jsonnet-tool/pkg/traverse"
+)
+
+// errorSignature identifies an evaluation error independently of where it occurred
+// so that a reduced file can be checked to fail with the same error.
+func errorSignature(err error) string {
+	e := makeJsonnetError(err)
+	if e.Kind == "error" {
+		return e.Message
+	}
+	return strings.ToUpper(e.Kind) + " ERROR: " + e.Message
+}
+
+// overlayImporter is a jsonnet.Importer that imports the contents of files from memory, by the
+// absolute path that the wrapped importer finds them at, instead of from disk.
+type overlayImporter struct {
+	importer jsonnet.Importer
+	files    map[string]string
+}
+
+// Import imports the file using the wrapped importer and replaces its contents if it is in the overlay.
+func (o overlayImporter) Import(importedFrom, importedPath string) (jsonnet.Contents, string, error) {
+	contents, foundAt, err := o.importer.Import(importedFrom, importedPath)
+	if err != nil {
+		return contents, foundAt, err
+	}
+	if s, ok := o.files[absPath(foundAt)]; ok {
+		return jsonnet.MakeContents(s), foundAt, nil
+	}
+	return contents, foundAt, nil
+}
+
+// shrinker reduces an entrypoint and the files it imports while evaluation fails with the same error.
+type shrinker struct {
+	file      string
+	signature string
+	// files are the current contents of the entrypoint and its imports by absolute path.
+	files map[string]string
+	// evaluations is the number of evaluations that have been tried.
+	evaluations int
+}
+
+// newShrinker evaluates the file and returns a shrinker for the error it fails with.
+func newShrinker(file string) (*shrinker, error) {
+	s := &shrinker{file: file, files: make(map[string]string)}
+	vm := s.vm()
+	err := s.evaluate(vm)
+	if err == nil {
+		return nil, fmt.Errorf("file %s evaluates without error so there is nothing to reproduce", file)
+	}
+	s.signature = errorSignature(err)
+	dependencies, err := vm.FindDependencies("", []string{file})
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range append([]string{file}, dependencies...) {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		s.files[absPath(path)] = string(b)
+	}
+	return s, nil
+}
+
+// vm returns a VM that imports the current contents of the files.
+func (s *shrinker) vm() *jsonnet.VM {
+	vm := makeVM()
+	vm.Importer(overlayImporter{importer: makeImporter(), files: s.files})
+	return vm
+}
+
+// reproduces returns true if evaluation fails with the same error when the file has the contents.
+func (s *shrinker) reproduces(path, contents string) bool {
+	previous := s.files[path]
+	s.files[path] = contents
+	defer func() { s.files[path] = previous }()
+	s.evaluations++
+	err := s.evaluate(s.vm())
+	return err != nil && errorSignature(err) == s.signature
+}
+
+// evaluate evaluates the entrypoint, returning the unformatted error if evaluation fails.
+func (s *shrinker) evaluate(vm *jsonnet.VM) error {
+	node, _, err := vm.ImportAST("", s.file)
+	if err != nil {
+		return err
+	}
+	_, err = vm.Evaluate(node)
+	return err
+}
+
+// shrinkCandidate is an edit that removes or simplifies part of a file.
+type shrinkCandidate struct {
+	edit       textEdit
+	begin, end int
+}
+
+// candidates returns the edits that remove or simplify parts of the input, largest first.
+// Object fields, array elements, local variable bindings, and function arguments are removed in runs,
+// halving the length of the runs down to single elements, and expressions are replaced by each of their
+// children and by null. Edits may produce invalid Jsonnet, which fails to reproduce the error.
+func candidates(file, input string) []shrinkCandidate {
+	root, _, err := formatter.SnippetToRawAST(file, input)
+	if err != nil {
+		return nil
+	}
+	var result []shrinkCandidate
+	add := func(begin, end ast.Location, text string) {
+		c := shrinkCandidate{
+			edit:  textEdit{LocationRange: LocationRange{FileName: file, Begin: begin, End: end}, NewText: text},
+			begin: sourceOffset(input, begin),
+			end:   sourceOffset(input, end),
+		}
+		if c.begin < c.end && input[c.begin:c.end] != text {
+			result = append(result, c)
+		}
+	}
+	// list removes runs of the elements with the location ranges, along with their separators.
+	list := func(ranges []ast.LocationRange) {
+		n := len(ranges)
+		for size := n; size > 0; size /= 2 {
+			for i := 0; i < n; i += size {
+				j := i + size
+				if j > n {
+					j = n
+				}
+				switch {
+				case j < n:
+					add(ranges[i].Begin, ranges[j].Begin, "")
+				case i > 0:
+					add(ranges[i-1].End, ranges[j-1].End, "")
+				default:
+					add(ranges[i].Begin, ranges[j-1].End, "")
+				}
+			}
+		}
+	}
+	traverse.Traverse(root,
+		func(node *ast.Node) error {
+			var ranges []ast.LocationRange
+			switch i := (*node).(type) {
+			case *ast.Object:
+				for _, field := range i.Fields {
+					ranges = append(ranges, field.LocRange)
+				}
+			case *ast.Array:
+				for _, element := range i.Elements {
+					ranges = append(ranges, *element.Expr.Loc())
+				}
+			case *ast.Local:
+				for _, bind := range i.Binds {
+					ranges = append(ranges, bind.LocRange)
+				}
+			case *ast.Apply:
+				for _, arg := range i.Arguments.Positional {
+					ranges = append(ranges, *arg.Expr.Loc())
+				}
+			case *ast.LiteralNull:
+				return nil
+			}
+			list(ranges)
+			loc := (*node).Loc()
+			if loc == nil || !loc.IsSet() {
+				return nil
+			}
+			for _, child := range traverse.Children(*node) {
+				if c := child.Loc(); c != nil && c.IsSet() {
+					begin, end := sourceOffset(input, c.Begin), sourceOffset(input, c.End)
+					add(loc.Begin, loc.End, input[begin:end])
+				}
+			}
+			add(loc.Begin, loc.End, "null")
+			return nil
+		},
+		traverse.Nop,
+		traverse.Nop,
+	)
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].end-result[i].begin > result[j].end-result[j].begin
+	})
+	return result
+}
+
+// shrinkFile makes one pass over the candidate edits of a file, keeping each edit that,
+// along with the edits kept before it, still reproduces the error.
+// It returns true if any edit was kept.
+func (s *shrinker) shrinkFile(path string) bool {
+	input := s.files[path]
+	var kept []shrinkCandidate
+	edits := func(c shrinkCandidate) []textEdit {
+		result := []textEdit{c.edit}
+		for _, k := range kept {
+			result = append(result, k.edit)
+		}
+		return result
+	}
+next:
+	for _, c := range candidates(path, input) {
+		for _, k := range kept {
+			if c.begin < k.end && k.begin < c.end {
+				continue next
+			}
+		}
+		if shrunk, _ := applyEdits(input, edits(c)); s.reproduces(path, shrunk) {
+			kept = append(kept, c)
+		}
+	}
+	if len(kept) == 0 {
+		return false
+	}
+	edited := make([]textEdit, 0, len(kept))
+	for _, k := range kept {
+		edited = append(edited, k.edit)
+	}
+	s.files[path], _ = applyEdits(input, edited)
+	return true
+}
+
+// shrink reduces the files until no candidate edit of any file reproduces the error.
+// It writes the progress of each pass to log.
+func (s *shrinker) shrink(log io.Writer) {
+	for pass := 1; ; pass++ {
+		changed := false
+		for _, path := range s.paths() {
+			if s.shrinkFile(path) {
+				changed = true
+			}
+		}
+		fmt.Fprintf(log, "Pass %d: %d bytes after %d evaluations\n", pass, s.size(), s.evaluations)
+		if !changed {
+			return
+		}
+	}
+}
+
+// paths returns the absolute paths of the files that are still imported, entrypoint first.
+func (s *shrinker) paths() []string {
+	paths := []string{absPath(s.file)}
+	dependencies, _ := s.vm().FindDependencies("", []string{s.file})
+	for _, path := range dependencies {
+		if _, ok := s.files[absPath(path)]; ok {
+			paths = append(paths, absPath(path))
+		}
+	}
+	return paths
+}
+
+// size returns the total size of the files that are still imported.
+func (s *shrinker) size() int {
+	size := 0
+	for _, path := range s.paths() {
+		size += len(s.files[path])
+	}
+	return size
+}
+
+// write writes the files that are still imported to dir, at their paths relative to the current directory,
+// or at their absolute paths within dir if they are outside of the current directory.
+// It returns the paths of the written files.
+func (s *shrinker) write(dir string) ([]string, error) {
+	var written []string
+	for _, path := range s.paths() {
+		rel, err := filepath.Rel(absPath("."), path)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			rel = strings.TrimPrefix(path, filepath.VolumeName(path))
+		}
+		out := filepath.Join(dir, rel)
+		if err := os.MkdirAll(filepath.Dir(out), 0o755); err != nil {
+			return written, err
+		}
+		if err := os.WriteFile(out, []byte(s.files[path]), 0o644); err != nil {
+			return written, err
+		}
+		written = append(written, out)
+	}
+	return written, nil
+}
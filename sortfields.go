@@ -0,0 +1,116 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/google/go-jsonnet/ast"
+
+	"github.com/jdbaldry/jsonnet-tool/pkg/traverse"
+)
+
+// defaultFieldOrder is the field order used by the sort-fields command when none is provided.
+// It matches the conventional layout of Kubernetes resources.
+var defaultFieldOrder = []string{"apiVersion", "kind", "metadata", "spec"}
+
+// fieldName returns the name of an object field and whether the field has a static name.
+// Computed fields with a literal string name like ['a'] have a static name.
+// Other computed fields, locals and asserts do not.
+func fieldName(field ast.ObjectField) (string, bool) {
+	switch field.Kind {
+	case ast.ObjectFieldID:
+		return string(*field.Id), true
+	case ast.ObjectFieldStr, ast.ObjectFieldExpr:
+		if name, ok := field.Expr1.(*ast.LiteralString); ok {
+			return name.Value, true
+		}
+	}
+	return "", false
+}
+
+// isTrailingComment returns true if the fodder element is a comment that ends the previous line.
+// The parser attaches these comments to the following token but they are written about the preceding one.
+func isTrailingComment(elem ast.FodderElement) bool {
+	return elem.Kind == ast.FodderLineEnd && len(elem.Comment) > 0
+}
+
+// sortObjectFields reorders the statically named fields of a single raw object.
+// Fields named in rank come first in rank order and all other fields keep their relative order.
+// Locals, asserts and computed fields are not moved.
+// Comments that end the line of a field move with that field.
+func sortObjectFields(obj *ast.Object, rank map[string]int) {
+	n := len(obj.Fields)
+	// next returns the fodder of the token that follows the ith field.
+	next := func(i int) *ast.Fodder {
+		if i == n-1 {
+			return &obj.CloseFodder
+		}
+		return &obj.Fields[i+1].Fodder1
+	}
+
+	trailing := make([][]string, n)
+	for i := 0; i < n; i++ {
+		if f := next(i); len(*f) > 0 && isTrailingComment((*f)[0]) {
+			trailing[i] = (*f)[0].Comment
+			(*f)[0].Comment = nil
+		}
+	}
+
+	var slots []int
+	for i, field := range obj.Fields {
+		if _, ok := fieldName(field); ok {
+			slots = append(slots, i)
+		}
+	}
+	order := make([]int, len(slots))
+	copy(order, slots)
+	position := func(i int) int {
+		name, _ := fieldName(obj.Fields[i])
+		if r, ok := rank[name]; ok {
+			return r
+		}
+		return len(rank)
+	}
+	sort.SliceStable(order, func(i, j int) bool { return position(order[i]) < position(order[j]) })
+
+	fields := make(ast.ObjectFields, n)
+	moved := make([][]string, n)
+	copy(fields, obj.Fields)
+	copy(moved, trailing)
+	for i, slot := range slots {
+		fields[slot] = obj.Fields[order[i]]
+		moved[slot] = trailing[order[i]]
+	}
+	obj.Fields = fields
+
+	for i := 0; i < n; i++ {
+		if len(moved[i]) == 0 {
+			continue
+		}
+		f := next(i)
+		if len(*f) > 0 && (*f)[0].Kind == ast.FodderLineEnd && len((*f)[0].Comment) == 0 {
+			(*f)[0].Comment = moved[i]
+			continue
+		}
+		*f = append(ast.Fodder{{Kind: ast.FodderLineEnd, Comment: moved[i]}}, *f...)
+	}
+}
+
+// sortFields reorders the fields of every object in the raw Jsonnet AST according to order.
+func sortFields(root ast.Node, order []string) error {
+	rank := make(map[string]int, len(order))
+	for _, name := range order {
+		if _, ok := rank[name]; !ok {
+			rank[name] = len(rank)
+		}
+	}
+	return traverse.Traverse(root,
+		func(node *ast.Node) error {
+			if obj, ok := (*node).(*ast.Object); ok {
+				sortObjectFields(obj, rank)
+			}
+			return nil
+		},
+		traverse.Nop,
+		traverse.Nop,
+	)
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+)
+
+// sourceMapVersion is the version of the source map format.
+const sourceMapVersion = 1
+
+// outputPosition is a position in the evaluated output.
+// Line and Column are 1-based and Column counts bytes. Offset is the 0-based byte offset.
+type outputPosition struct {
+	Line   int
+	Column int
+	Offset int
+}
+
+// outputRange is the range of a single value in the evaluated output.
+type outputRange struct {
+	Path  string
+	Begin outputPosition
+	End   outputPosition
+}
+
+// mapping relates the range of a value in the evaluated output to the Jsonnet source that defines it.
+type mapping struct {
+	Path   string
+	Output struct {
+		Begin outputPosition
+		End   outputPosition
+	}
+	Source LocationRange
+}
+
+// sourceMap relates ranges of evaluated output to Jsonnet source locations, in the spirit of JavaScript source maps.
+type sourceMap struct {
+	Version  int
+	Sources  []string
+	Mappings []mapping
+}
+
+// outputScanner finds the range of every value in JSON output.
+type outputScanner struct {
+	data   string
+	pos    int
+	line   int
+	column int
+	ranges []outputRange
+}
+
+// position returns the current position of the scanner.
+func (s *outputScanner) position() outputPosition {
+	return outputPosition{Line: s.line, Column: s.column, Offset: s.pos}
+}
+
+// advance moves the scanner forward a byte.
+func (s *outputScanner) advance() {
+	if s.data[s.pos] == '\n' {
+		s.line++
+		s.column = 0
+	}
+	s.pos++
+	s.column++
+}
+
+// skipSpace moves the scanner past any whitespace.
+func (s *outputScanner) skipSpace() {
+	for s.pos < len(s.data) && (s.data[s.pos] == ' ' || s.data[s.pos] == '\t' || s.data[s.pos] == '\n' || s.data[s.pos] == '\r') {
+		s.advance()
+	}
+}
+
+// expect moves the scanner past the byte c.
+func (s *outputScanner) expect(c byte) error {
+	s.skipSpace()
+	if s.pos >= len(s.data) || s.data[s.pos] != c {
+		return fmt.Errorf("expected %q at offset %d", c, s.pos)
+	}
+	s.advance()
+	return nil
+}
+
+// scanString scans a JSON string and returns its unquoted value.
+func (s *outputScanner) scanString() (string, error) {
+	s.skipSpace()
+	start := s.pos
+	if err := s.expect('"'); err != nil {
+		return "", err
+	}
+	for s.pos < len(s.data) && s.data[s.pos] != '"' {
+		if s.data[s.pos] == '\\' {
+			s.advance()
+		}
+		s.advance()
+	}
+	if err := s.expect('"'); err != nil {
+		return "", err
+	}
+	return strconv.Unquote(s.data[start:s.pos])
+}
+
+// scanValue scans the JSON value at path and records its range along with the ranges of any nested values.
+func (s *outputScanner) scanValue(path string) error {
+	s.skipSpace()
+	if s.pos >= len(s.data) {
+		return fmt.Errorf("unexpected end of output")
+	}
+	begin := s.position()
+	switch s.data[s.pos] {
+	case '{':
+		s.advance()
+		s.skipSpace()
+		for s.pos < len(s.data) && s.data[s.pos] != '}' {
+			key, err := s.scanString()
+			if err != nil {
+				return err
+			}
+			if err := s.expect(':'); err != nil {
+				return err
+			}
+			if err := s.scanValue(fieldPath(path, key)); err != nil {
+				return err
+			}
+			s.skipSpace()
+			if s.pos < len(s.data) && s.data[s.pos] == ',' {
+				s.advance()
+				s.skipSpace()
+			}
+		}
+		if err := s.expect('}'); err != nil {
+			return err
+		}
+	case '[':
+		s.advance()
+		s.skipSpace()
+		for i := 0; s.pos < len(s.data) && s.data[s.pos] != ']'; i++ {
+			if err := s.scanValue(fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+			s.skipSpace()
+			if s.pos < len(s.data) && s.data[s.pos] == ',' {
+				s.advance()
+				s.skipSpace()
+			}
+		}
+		if err := s.expect(']'); err != nil {
+			return err
+		}
+	case '"':
+		if _, err := s.scanString(); err != nil {
+			return err
+		}
+	default:
+		// Numbers, booleans, and null end at the next delimiter or whitespace.
+		for s.pos < len(s.data) && !strings.ContainsRune(",}] \t\r\n", rune(s.data[s.pos])) {
+			s.advance()
+		}
+	}
+	s.ranges = append(s.ranges, outputRange{Path: path, Begin: begin, End: s.position()})
+	return nil
+}
+
+// scanOutput returns the range of every value in the JSON output, identified by its path from the root "$".
+func scanOutput(output string) ([]outputRange, error) {
+	s := &outputScanner{data: output, line: 1, column: 1}
+	if err := s.scanValue("$"); err != nil {
+		return nil, err
+	}
+	return s.ranges, nil
+}
+
+// fieldLocations statically maps output paths to the locations of the object fields that define them.
+// It follows arrays, object merges, conditionals, imports, and locals that are bound to any of those.
+// Later definitions of a path replace earlier ones, as the right hand side of a merge does.
+// Values produced by function calls or comprehensions are not followed.
+func fieldLocations(vm *jsonnet.VM, node ast.Node, path string, env map[ast.Identifier]ast.Node, locations map[string]LocationRange, depth int) {
+	// depth guards against cyclic imports and self referential locals.
+	if node == nil || depth > 100 {
+		return
+	}
+	switch i := node.(type) {
+	case *ast.DesugaredObject:
+		for _, field := range i.Fields {
+			name, ok := field.Name.(*ast.LiteralString)
+			if !ok {
+				continue
+			}
+			p := fieldPath(path, name.Value)
+			locations[p] = makeLocationRange(&field.LocRange)
+			fieldLocations(vm, field.Body, p, env, locations, depth+1)
+		}
+	case *ast.Array:
+		for j, element := range i.Elements {
+			fieldLocations(vm, element.Expr, fmt.Sprintf("%s[%d]", path, j), env, locations, depth+1)
+		}
+	case *ast.Binary:
+		if i.Op == ast.BopPlus {
+			fieldLocations(vm, i.Left, path, env, locations, depth+1)
+			fieldLocations(vm, i.Right, path, env, locations, depth+1)
+		}
+	case *ast.Conditional:
+		fieldLocations(vm, i.BranchTrue, path, env, locations, depth+1)
+		fieldLocations(vm, i.BranchFalse, path, env, locations, depth+1)
+	case *ast.Import:
+		imported, _, err := vm.ImportAST(i.Loc().FileName, i.File.Value)
+		if err != nil {
+			return
+		}
+		// Imported files have their own scope.
+		fieldLocations(vm, imported, path, map[ast.Identifier]ast.Node{}, locations, depth+1)
+	case *ast.Local:
+		scope := make(map[ast.Identifier]ast.Node, len(env)+len(i.Binds))
+		for id, bound := range env {
+			scope[id] = bound
+		}
+		for _, bind := range i.Binds {
+			scope[bind.Variable] = bind.Body
+		}
+		fieldLocations(vm, i.Body, path, scope, locations, depth+1)
+	case *ast.Var:
+		fieldLocations(vm, env[i.Id], path, env, locations, depth+1)
+	}
+}
+
+// makeSourceMap relates every value in the evaluated output of the root node to the source location of
+// the object field that defines it. Values without a known definition use the location of their closest ancestor.
+func makeSourceMap(vm *jsonnet.VM, root ast.Node, output string) (sourceMap, error) {
+	ranges, err := scanOutput(output)
+	if err != nil {
+		return sourceMap{}, fmt.Errorf("unable to scan output: %w", err)
+	}
+	locations := map[string]LocationRange{"$": makeLocationRange(root.Loc())}
+	fieldLocations(vm, root, "$", map[ast.Identifier]ast.Node{}, locations, 0)
+
+	sm := sourceMap{Version: sourceMapVersion}
+	sources := make(map[string]bool)
+	for _, r := range ranges {
+		m := mapping{Path: r.Path}
+		m.Output.Begin, m.Output.End = r.Begin, r.End
+		m.Source = locate(locations, r.Path)
+		if !sources[m.Source.FileName] {
+			sources[m.Source.FileName] = true
+			sm.Sources = append(sm.Sources, m.Source.FileName)
+		}
+		sm.Mappings = append(sm.Mappings, m)
+	}
+	sort.SliceStable(sm.Mappings, func(i, j int) bool {
+		return sm.Mappings[i].Output.Begin.Offset < sm.Mappings[j].Output.Begin.Offset
+	})
+	return sm, nil
+}
+
+// locate returns the location of the value at the output path, or of its closest ancestor with a location.
+func locate(locations map[string]LocationRange, path string) LocationRange {
+	for {
+		if loc, ok := locations[path]; ok {
+			return loc
+		}
+		parent := parentPath(path)
+		if parent == path {
+			return LocationRange{}
+		}
+		path = parent
+	}
+}
+
+// parentPath returns the path of the object or array containing the value at path.
+// The parent of the root path "$" is itself.
+func parentPath(path string) string {
+	// Quoted field names can contain dots and brackets, so the path is split from the start.
+	last := len(path)
+	for i := 1; i < len(path); {
+		last = i
+		switch {
+		case path[i] == '.':
+			i++
+			for i < len(path) && path[i] != '.' && path[i] != '[' {
+				i++
+			}
+		case strings.HasPrefix(path[i:], `["`):
+			quoted, err := strconv.QuotedPrefix(path[i+1:])
+			if err != nil {
+				return path
+			}
+			i += len(quoted) + 2
+		default:
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 {
+				return path
+			}
+			i += end + 1
+		}
+	}
+	return path[:last]
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-jsonnet"
+)
+
+func TestParentPath(t *testing.T) {
+	for _, tc := range []struct {
+		path, want string
+	}{
+		{"$", "$"},
+		{"$.a", "$"},
+		{"$.a.b", "$.a"},
+		{"$.a[0]", "$.a"},
+		{"$[0].a", "$[0]"},
+		{`$.metadata.labels["app.kubernetes.io/name"]`, "$.metadata.labels"},
+		{`$["a.b"].c`, `$["a.b"]`},
+		{`$["a[\"0\"]"][1]`, `$["a[\"0\"]"]`},
+	} {
+		if got := parentPath(tc.path); got != tc.want {
+			t.Errorf("parentPath(%q) = %q, want %q", tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestMakeSourceMap(t *testing.T) {
+	snippet := `{
+  metadata: {
+    labels: {
+      'app.kubernetes.io/name': 'a',
+      app: { kubernetes: { io: 'b' } },
+    },
+  },
+  list: [{ name: 'c' }],
+}
+`
+	root, err := jsonnet.SnippetToAST("test.jsonnet", snippet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm := makeVM()
+	output, err := vm.Evaluate(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm, err := makeSourceMap(vm, root, output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := make(map[string]int, len(sm.Mappings))
+	for _, m := range sm.Mappings {
+		lines[m.Path] = m.Source.Begin.Line
+	}
+	for _, tc := range []struct {
+		path string
+		line int
+	}{
+		{"$", 1},
+		{"$.metadata.labels", 3},
+		{`$.metadata.labels["app.kubernetes.io/name"]`, 4},
+		{"$.metadata.labels.app", 5},
+		{"$.metadata.labels.app.kubernetes.io", 5},
+		{"$.list[0]", 8},
+		{"$.list[0].name", 8},
+	} {
+		if got, ok := lines[tc.path]; !ok || got != tc.line {
+			t.Errorf("%s: got line %d (mapped %t), want %d", tc.path, got, ok, tc.line)
+		}
+	}
+}
+
+func TestScanOutput(t *testing.T) {
+	output := `{
+   "a": "}\"",
+   "b": [1, {"c": null}],
+   "d.e": true
+}`
+	ranges, err := scanOutput(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]string, len(ranges))
+	for _, r := range ranges {
+		got[r.Path] = output[r.Begin.Offset:r.End.Offset]
+	}
+	for path, want := range map[string]string{
+		"$":        output,
+		"$.a":      `"}\""`,
+		"$.b":      `[1, {"c": null}]`,
+		"$.b[0]":   "1",
+		"$.b[1]":   `{"c": null}`,
+		"$.b[1].c": "null",
+		`$["d.e"]`: "true",
+	} {
+		if got[path] != want {
+			t.Errorf("%s: got %q, want %q", path, got[path], want)
+		}
+	}
+	if len(got) != 7 {
+		t.Errorf("got %d ranges, want 7", len(got))
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-jsonnet"
+)
+
+// maxReportedImports is the number of most frequently imported files in a statistics report.
+const maxReportedImports = 10
+
+// vmStats collects statistics about the import caches of the VMs of an evaluation.
+// A VM calls its importer every time an import is evaluated but only evaluates each file once until its cache is
+// cleared, so every import of a file that is already in the cache of the VM is a hit.
+// go-jsonnet has no hooks into the caches of the values and thunks within a file, so there are no statistics
+// about them.
+type vmStats struct {
+	mu sync.Mutex
+	// imports are the number of imports of each file by the path it was found at.
+	imports map[string]int
+	// misses are the number of imports of files that were not in the cache of the VM.
+	misses int
+	// vms are the number of VMs and clears are the number of times that the cache of a VM was cleared.
+	vms, clears int
+	// largest is the largest number of files that were in the cache of a VM.
+	largest int
+	start   time.Time
+	elapsed time.Duration
+	before  runtime.MemStats
+	after   runtime.MemStats
+}
+
+// newVMStats returns an empty vmStats.
+func newVMStats() *vmStats {
+	return &vmStats{imports: make(map[string]int)}
+}
+
+// cacheImporter is a jsonnet.Importer that records the imports of a VM in its statistics and tracks the files in
+// the import cache of the VM.
+type cacheImporter struct {
+	stats    *vmStats
+	importer jsonnet.Importer
+	// cached are the paths of the files in the cache of the VM, which only the VM imports.
+	cached map[string]bool
+}
+
+// Import imports the file using the wrapped importer and records the import.
+func (c *cacheImporter) Import(importedFrom, importedPath string) (jsonnet.Contents, string, error) {
+	contents, foundAt, err := c.importer.Import(importedFrom, importedPath)
+	if err == nil {
+		c.stats.mu.Lock()
+		c.stats.imports[foundAt]++
+		if !c.cached[foundAt] {
+			c.cached[foundAt] = true
+			c.stats.misses++
+			if len(c.cached) > c.stats.largest {
+				c.stats.largest = len(c.cached)
+			}
+		}
+		c.stats.mu.Unlock()
+	}
+	return contents, foundAt, err
+}
+
+// size returns the number of files in the cache of the VM.
+func (c *cacheImporter) size() int {
+	c.stats.mu.Lock()
+	defer c.stats.mu.Unlock()
+	return len(c.cached)
+}
+
+// importer returns a cacheImporter that records the imports of a new VM with the importer. It must only be used by
+// that VM.
+func (s *vmStats) importer(importer jsonnet.Importer) *cacheImporter {
+	s.mu.Lock()
+	s.vms++
+	s.mu.Unlock()
+	return &cacheImporter{stats: s, importer: importer, cached: make(map[string]bool)}
+}
+
+// clear clears the cache of the VM, which then imports with the importer, and returns the cacheImporter that
+// replaces the one it was given.
+func (s *vmStats) clear(vm *jsonnet.VM, importer jsonnet.Importer) *cacheImporter {
+	s.mu.Lock()
+	s.clears++
+	s.mu.Unlock()
+	c := &cacheImporter{stats: s, importer: importer, cached: make(map[string]bool)}
+	// Replacing the importer flushes the caches of the VM.
+	vm.Importer(c)
+	return c
+}
+
+// begin marks the start of the evaluation.
+func (s *vmStats) begin() {
+	runtime.ReadMemStats(&s.before)
+	s.start = time.Now()
+}
+
+// end marks the end of the evaluation.
+func (s *vmStats) end() {
+	s.elapsed = time.Since(s.start)
+	runtime.ReadMemStats(&s.after)
+}
+
+// mebibytes formats a number of bytes in MiB.
+func mebibytes(b uint64) string { return fmt.Sprintf("%.1f MiB", float64(b)/(1<<20)) }
+
+// report returns a human readable report of the statistics.
+func (s *vmStats) report() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var requests int
+	files := make([]string, 0, len(s.imports))
+	for file, n := range s.imports {
+		requests += n
+		files = append(files, file)
+	}
+
+	builder := strings.Builder{}
+	builder.WriteString("VM statistics:\n")
+	builder.WriteString(fmt.Sprintf("  Evaluation time:     %s\n", s.elapsed))
+	builder.WriteString(fmt.Sprintf("  VMs:                 %d\n", s.vms))
+	builder.WriteString(fmt.Sprintf("  Import requests:     %d\n", requests))
+	builder.WriteString(fmt.Sprintf("  Import cache hits:   %d\n", requests-s.misses))
+	builder.WriteString(fmt.Sprintf("  Import cache misses: %d\n", s.misses))
+	builder.WriteString(fmt.Sprintf("  Import cache clears: %d\n", s.clears))
+	builder.WriteString(fmt.Sprintf("  Largest cache:       %d files\n", s.largest))
+	builder.WriteString(fmt.Sprintf("  Heap in use:         %s\n", mebibytes(s.after.HeapInuse)))
+	builder.WriteString(fmt.Sprintf("  Total allocated:     %s\n", mebibytes(s.after.TotalAlloc-s.before.TotalAlloc)))
+	builder.WriteString(fmt.Sprintf("  GC cycles:           %d\n", s.after.NumGC-s.before.NumGC))
+
+	sort.Slice(files, func(i, j int) bool {
+		if s.imports[files[i]] == s.imports[files[j]] {
+			return files[i] < files[j]
+		}
+		return s.imports[files[i]] > s.imports[files[j]]
+	})
+	if len(files) > maxReportedImports {
+		files = files[:maxReportedImports]
+	}
+	if len(files) > 0 {
+		builder.WriteString("Most frequently imported files:\n")
+	}
+	for _, file := range files {
+		builder.WriteString(fmt.Sprintf("  %8d %s\n", s.imports[file], file))
+	}
+	return builder.String()
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/formatter"
+
+	"github.com/jdbaldry/jsonnet-tool/pkg/location"
+	toolvm "github.com/jdbaldry/jsonnet-tool/pkg/vm"
+)
+
+// testFileSuffix is the suffix of Jsonnet test files.
+const testFileSuffix = "_test.jsonnet"
+
+// testResult is the result of a single test in a test file.
+type testResult struct {
+	Name          string
+	LocationRange LocationRange
+	Passed        bool
+	// Message describes why the test failed.
+	Message string
+}
+
+// findFiles returns the files in dir and its subdirectories with any of the suffixes.
+// Hidden directories and jsonnet-bundler vendor directories are skipped.
+func findFiles(dir string, suffixes ...string) (files []string, err error) {
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != dir && (strings.HasPrefix(d.Name(), ".") || d.Name() == "vendor") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		for _, suffix := range suffixes {
+			if strings.HasSuffix(path, suffix) {
+				files = append(files, path)
+				break
+			}
+		}
+		return nil
+	})
+	return files, err
+}
+
+// topLevelFields returns the locations of the fields of the object that a raw Jsonnet AST evaluates to,
+// following locals and parentheses.
+func topLevelFields(node ast.Node) map[string]LocationRange {
+	locations := make(map[string]LocationRange)
+	for node != nil {
+		switch i := node.(type) {
+		case *ast.Local:
+			node = i.Body
+		case *ast.Parens:
+			node = i.Inner
+		case *ast.Object:
+			for _, field := range i.Fields {
+				if name, ok := fieldName(field); ok {
+					locations[name] = makeLocationRange(&field.LocRange)
+				}
+			}
+			return locations
+		default:
+			return locations
+		}
+	}
+	return locations
+}
+
+// testCase is the conventional shape of a test that compares values.
+type testCase struct {
+	Actual   *interface{} `json:"actual"`
+	Expected *interface{} `json:"expected"`
+	// Golden is the path, relative to the test file, of a JSON file containing the expected value.
+	Golden *string `json:"golden"`
+}
+
+// runTest interprets the evaluated value of a test.
+// A test is either a boolean assertion or an object with the actual value and either the expected
+// value or the path to a golden file containing the expected value.
+// If update is true, golden files are written with the actual value instead of being compared.
+func runTest(file string, value json.RawMessage, update bool) (bool, string) {
+	var passed bool
+	if err := json.Unmarshal(value, &passed); err == nil {
+		if !passed {
+			return false, "assertion is false"
+		}
+		return true, ""
+	}
+	var tc testCase
+	if err := json.Unmarshal(value, &tc); err != nil || tc.Actual == nil || (tc.Expected == nil) == (tc.Golden == nil) {
+		return false, "a test must be a boolean or an object with an actual field and either an expected or golden field"
+	}
+	expected := tc.Expected
+	if tc.Golden != nil {
+		golden := filepath.Join(filepath.Dir(file), *tc.Golden)
+		if update {
+			b, err := json.MarshalIndent(*tc.Actual, "", "  ")
+			if err != nil {
+				return false, fmt.Sprintf("unable to marshal actual value: %v", err)
+			}
+			if err := os.MkdirAll(filepath.Dir(golden), 0o755); err != nil {
+				return false, fmt.Sprintf("unable to create golden file directory: %v", err)
+			}
+			if err := os.WriteFile(golden, append(b, '\n'), 0o644); err != nil {
+				return false, fmt.Sprintf("unable to write golden file: %v", err)
+			}
+			return true, ""
+		}
+		b, err := os.ReadFile(golden)
+		if err != nil {
+			return false, fmt.Sprintf("unable to read golden file, run with --update to create it: %v", err)
+		}
+		expected = new(interface{})
+		if err := json.Unmarshal(b, expected); err != nil {
+			return false, fmt.Sprintf("unable to parse golden file %s: %v", golden, err)
+		}
+	}
+	if reflect.DeepEqual(*tc.Actual, *expected) {
+		return true, ""
+	}
+	a, _ := json.MarshalIndent(*tc.Actual, "  ", "  ")
+	e, _ := json.MarshalIndent(*expected, "  ", "  ")
+	return false, fmt.Sprintf("expected:\n  %s\nactual:\n  %s", e, a)
+}
+
+// runTestFile evaluates a test file and runs each of its tests in source order.
+// The test file must evaluate to an object of test names to tests.
+func runTestFile(ctx context.Context, file string, update bool) ([]testResult, error) {
+	vm := makeVM()
+	root, _, err := vm.ImportAST("", file)
+	if err != nil {
+		return nil, errors.New(strings.TrimSpace(vm.ErrorFormatter.Format(err)))
+	}
+	output, err := toolvm.Evaluate(ctx, vm, root)
+	if ctx.Err() != nil {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errors.New(strings.TrimSpace(vm.ErrorFormatter.Format(err)))
+	}
+	var tests map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(output), &tests); err != nil {
+		return nil, fmt.Errorf("a test file must evaluate to an object of test names to tests")
+	}
+
+	var locations map[string]LocationRange
+	if input, err := os.ReadFile(file); err == nil {
+		if raw, _, err := formatter.SnippetToRawAST(file, string(input)); err == nil {
+			locations = topLevelFields(raw)
+		}
+	}
+
+	results := make([]testResult, 0, len(tests))
+	for name, value := range tests {
+		result := testResult{Name: name, LocationRange: LocationRange{FileName: file}}
+		if loc, ok := locations[name]; ok {
+			result.LocationRange = loc
+		}
+		result.Passed, result.Message = runTest(file, value, update)
+		results = append(results, result)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i].LocationRange.Begin, results[j].LocationRange.Begin
+		if a == b {
+			return results[i].Name < results[j].Name
+		}
+		return location.Before(a, b)
+	})
+	return results, nil
+}
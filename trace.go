@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+)
+
+// traceOut is where std.trace messages are written by the VMs made by makeVM, if it is set.
+var traceOut io.Writer
+
+// traceEvent is a std.trace message written as a JSON record by --trace-format json.
+type traceEvent struct {
+	Message string
+	// LocationRange is the location of the std.trace call. Only the file and line are known.
+	LocationRange LocationRange
+}
+
+// traceLine matches a std.trace message written by go-jsonnet.
+var traceLine = regexp.MustCompile(`(?s)^TRACE: (.*?):(\d+) (.*)\n$`)
+
+// traceWriter rewrites the std.trace messages written by go-jsonnet, which writes each message in a single write,
+// as JSON records, one per line. Its methods are safe to call from concurrent evaluations.
+type traceWriter struct {
+	w  io.Writer
+	mu sync.Mutex
+}
+
+// Write writes the trace message as a JSON record. Messages that are not recognized are written unchanged.
+func (t *traceWriter) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	matches := traceLine.FindSubmatch(p)
+	if matches == nil {
+		return t.w.Write(p)
+	}
+	line, _ := strconv.Atoi(string(matches[2]))
+	event := traceEvent{Message: string(matches[3]), LocationRange: LocationRange{FileName: string(matches[1])}}
+	event.LocationRange.Begin.Line, event.LocationRange.End.Line = line, line
+	b, err := json.Marshal(event)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := fmt.Fprintf(t.w, "%s\n", b); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// setTraceOutput configures where std.trace messages are written for the --trace-format and --trace-out flags.
+// Messages are written to the file, or stderr if it is empty, as text or as JSON records.
+func setTraceOutput(format, file string) error {
+	if format != "text" && format != "json" {
+		return fmt.Errorf("unknown trace format %s, expected one of text, json", format)
+	}
+	var w io.Writer = os.Stderr
+	if file != "" {
+		f, err := os.Create(file)
+		if err != nil {
+			return fmt.Errorf("unable to create trace file: %w", err)
+		}
+		w = f
+	}
+	if format == "json" {
+		w = &traceWriter{w: w}
+	}
+	traceOut = w
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/formatter"
+
+	"github.com/jdbaldry/jsonnet-tool/pkg/traverse"
+)
+
+// stringStyles are the values of the --quotes flag of the transform command.
+var stringStyles = map[string]formatter.StringStyle{
+	"single": formatter.StringStyleSingle,
+	"double": formatter.StringStyleDouble,
+	"leave":  formatter.StringStyleLeave,
+}
+
+// transformOptions configures the passes of the transform command.
+type transformOptions struct {
+	// sortFields sorts the statically named fields of every object alphabetically.
+	sortFields bool
+	// stripComments removes all comments.
+	stripComments bool
+	// quotes is the style that string literals are rewritten in.
+	quotes formatter.StringStyle
+	// minify writes the source on one line without comments, indentation, or trailing commas.
+	minify bool
+}
+
+// sortFieldsAlphabetically reorders the statically named fields of every object in the raw Jsonnet AST
+// alphabetically. Locals, asserts and computed fields are not moved.
+func sortFieldsAlphabetically(root ast.Node) error {
+	return traverse.Traverse(root,
+		func(node *ast.Node) error {
+			obj, ok := (*node).(*ast.Object)
+			if !ok {
+				return nil
+			}
+			var names []string
+			for _, field := range obj.Fields {
+				if name, ok := fieldName(field); ok {
+					names = append(names, name)
+				}
+			}
+			sort.Strings(names)
+			rank := make(map[string]int, len(names))
+			for _, name := range names {
+				if _, ok := rank[name]; !ok {
+					rank[name] = len(rank)
+				}
+			}
+			sortObjectFields(obj, rank)
+			return nil
+		},
+		traverse.Nop,
+		traverse.Nop,
+	)
+}
+
+// visitFodder calls visit with every fodder of the raw Jsonnet AST.
+func visitFodder(node ast.Node, visit func(*ast.Fodder)) {
+	visitFodderValue(reflect.ValueOf(&node).Elem(), visit)
+}
+
+// visitFodderValue calls visit with every fodder within the addressable value, which is part of a raw AST.
+func visitFodderValue(v reflect.Value, visit func(*ast.Fodder)) {
+	if v.Type() == reflect.TypeOf(ast.Fodder{}) {
+		visit(v.Addr().Interface().(*ast.Fodder))
+		return
+	}
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if !v.IsNil() {
+			visitFodderValue(v.Elem(), visit)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			visitFodderValue(v.Field(i), visit)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			visitFodderValue(v.Index(i), visit)
+		}
+	}
+}
+
+// stripComments removes the comments of the fodder but keeps its line breaks, so that code is laid out as
+// before. A comment between line breaks is removed with one of them so that it does not leave an empty line.
+func stripComments(fodder *ast.Fodder) {
+	var stripped ast.Fodder
+	interstitial := false
+	for _, elem := range *fodder {
+		switch elem.Kind {
+		case ast.FodderInterstitial:
+			interstitial = true
+		case ast.FodderLineEnd:
+			elem.Comment = nil
+			if last := len(stripped) - 1; interstitial && last >= 0 && stripped[last].Kind == ast.FodderLineEnd {
+				stripped[last].Blanks += elem.Blanks
+				stripped[last].Indent = elem.Indent
+			} else {
+				stripped = append(stripped, elem)
+			}
+			interstitial = false
+		}
+	}
+	*fodder = stripped
+}
+
+// transformSource applies the passes to the Jsonnet input of the file and returns the rewritten source.
+// The output is formatted like jsonnetfmt unless minify is set. Text blocks keep their lines when minified.
+func transformSource(file, input string, opts transformOptions) (string, error) {
+	root, finalFodder, err := formatter.SnippetToRawAST(file, input)
+	if err != nil {
+		return "", err
+	}
+	if opts.sortFields {
+		if err := sortFieldsAlphabetically(root); err != nil {
+			return "", fmt.Errorf("sorting fields: %w", err)
+		}
+	}
+	options := formatter.DefaultOptions()
+	options.StringStyle = opts.quotes
+	switch {
+	case opts.minify:
+		// Without line breaks, the formatter also removes trailing commas.
+		strip := func(fodder *ast.Fodder) { *fodder = nil }
+		visitFodder(root, strip)
+		strip(&finalFodder)
+		options.Indent = 0
+		options.SortImports = false
+		options.PadArrays = false
+		options.PadObjects = false
+	case opts.stripComments:
+		visitFodder(root, stripComments)
+		stripComments(&finalFodder)
+	}
+	return formatter.FormatNode(root, finalFodder, options)
+}
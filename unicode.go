@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+
+	"github.com/google/go-jsonnet/ast"
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/jdbaldry/jsonnet-tool/pkg/traverse"
+)
+
+// isInvisible returns true if the rune is invisible or easily confused with a space when rendered:
+// format characters like zero width spaces and bidirectional controls, spaces other than U+0020,
+// line and paragraph separators, and control characters other than tabs and newlines.
+func isInvisible(r rune) bool {
+	switch {
+	case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+		return false
+	case unicode.In(r, unicode.Cf, unicode.Zs, unicode.Zl, unicode.Zp, unicode.Cc):
+		return true
+	}
+	return false
+}
+
+// unicodeEscape returns the Jsonnet escape sequence of the rune.
+// Runes outside of the Basic Multilingual Plane are escaped as UTF-16 surrogate pairs.
+func unicodeEscape(r rune) string {
+	if r > 0xffff {
+		r1, r2 := utf16.EncodeRune(r)
+		return fmt.Sprintf("\\u%04x\\u%04x", r1, r2)
+	}
+	return fmt.Sprintf("\\u%04x", r)
+}
+
+// stringLiterals calls fn for each string literal in the raw AST, including quoted field names.
+func stringLiterals(root ast.Node, fn func(*ast.LiteralString)) {
+	traverse.Traverse(root,
+		func(node *ast.Node) error {
+			if s, ok := (*node).(*ast.LiteralString); ok {
+				fn(s)
+			}
+			return nil
+		},
+		traverse.Nop,
+		traverse.Nop,
+	)
+}
+
+// checkInvisibleCharacters reports string literals containing invisible characters.
+// For quoted strings the fix replaces the characters with escape sequences.
+// Verbatim strings and text blocks cannot contain escape sequences so they are not fixed.
+func checkInvisibleCharacters(f lintFile) (diagnostics []diagnostic) {
+	stringLiterals(f.Root, func(s *ast.LiteralString) {
+		var found []string
+		for _, r := range s.Value {
+			if isInvisible(r) {
+				found = append(found, fmt.Sprintf("U+%04X", r))
+			}
+		}
+		if len(found) == 0 {
+			return
+		}
+		d := diagnostic{
+			Rule:          "unicode-invisible",
+			Message:       fmt.Sprintf("String contains invisible characters %s", strings.Join(found, ", ")),
+			LocationRange: makeLocationRange(s.Loc()),
+		}
+		if s.Kind == ast.StringSingle || s.Kind == ast.StringDouble {
+			begin, end := sourceOffset(f.Input, s.Loc().Begin), sourceOffset(f.Input, s.Loc().End)
+			var fixed strings.Builder
+			for _, r := range f.Input[begin:end] {
+				if isInvisible(r) {
+					fixed.WriteString(unicodeEscape(r))
+					continue
+				}
+				fixed.WriteRune(r)
+			}
+			d.Fix = &textEdit{LocationRange: d.LocationRange, NewText: fixed.String()}
+		}
+		diagnostics = append(diagnostics, d)
+	})
+	return diagnostics
+}
+
+// checkNormalization reports string literals that are not in Unicode Normalization Form C.
+// Decomposed characters may compare or render differently across platforms from their precomposed forms.
+func checkNormalization(f lintFile) (diagnostics []diagnostic) {
+	stringLiterals(f.Root, func(s *ast.LiteralString) {
+		if norm.NFC.IsNormalString(s.Value) {
+			return
+		}
+		// The first character that is not normalized is reported, including its combining characters.
+		i := norm.NFC.QuickSpanString(s.Value)
+		j := i + norm.NFC.NextBoundaryInString(s.Value[i:], true)
+		var points []string
+		for _, r := range s.Value[i:j] {
+			points = append(points, fmt.Sprintf("U+%04X", r))
+		}
+		diagnostics = append(diagnostics, diagnostic{
+			Rule:          "unicode-normalization",
+			Message:       fmt.Sprintf("String contains %q (%s) which is not NFC normalized, its NFC form is %q", s.Value[i:j], strings.Join(points, " "), norm.NFC.String(s.Value[i:j])),
+			LocationRange: makeLocationRange(s.Loc()),
+		})
+	})
+	return diagnostics
+}
+
+// isStdCall returns true if the node is a call of the named standard library function.
+func isStdCall(node ast.Node, name string) bool {
+	apply, ok := node.(*ast.Apply)
+	if !ok {
+		return false
+	}
+	index, ok := apply.Target.(*ast.Index)
+	if !ok || index.Id == nil || string(*index.Id) != name {
+		return false
+	}
+	target, ok := index.Target.(*ast.Var)
+	return ok && target.Id == "std"
+}
+
+// checkByteSlicing reports indexing and slicing of the UTF-8 bytes of strings, which can split
+// multi-byte characters. Jsonnet strings are indexed by code point so they can be sliced directly.
+// Variables are followed to their local definitions, ignoring shadowing.
+func checkByteSlicing(f lintFile) (diagnostics []diagnostic) {
+	encoded := make(map[ast.Identifier]bool)
+	traverse.Traverse(f.Root,
+		func(node *ast.Node) error {
+			if local, ok := (*node).(*ast.Local); ok {
+				for _, bind := range local.Binds {
+					if bind.Fun == nil && isStdCall(bind.Body, "encodeUTF8") {
+						encoded[bind.Variable] = true
+					}
+				}
+			}
+			return nil
+		},
+		traverse.Nop,
+		traverse.Nop,
+	)
+	isBytes := func(node ast.Node) bool {
+		if v, ok := node.(*ast.Var); ok {
+			return encoded[v.Id]
+		}
+		return isStdCall(node, "encodeUTF8")
+	}
+	report := func(node ast.Node) {
+		diagnostics = append(diagnostics, diagnostic{
+			Rule:          "unicode-byte-slicing",
+			Message:       "Indexing the UTF-8 bytes of a string can split multi-byte characters, index the string instead",
+			LocationRange: makeLocationRange(node.Loc()),
+		})
+	}
+	traverse.Traverse(f.Root,
+		func(node *ast.Node) error {
+			switch i := (*node).(type) {
+			case *ast.Index:
+				if i.Id == nil && isBytes(i.Target) {
+					report(i)
+				}
+			case *ast.Slice:
+				if isBytes(i.Target) {
+					report(i)
+				}
+			case *ast.Apply:
+				if isStdCall(i, "slice") && len(i.Arguments.Positional) > 0 && isBytes(i.Arguments.Positional[0].Expr) {
+					report(i)
+				}
+			}
+			return nil
+		},
+		traverse.Nop,
+		traverse.Nop,
+	)
+	return diagnostics
+}
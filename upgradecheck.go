@@ -0,0 +1,472 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/formatter"
+
+	"github.com/jdbaldry/jsonnet-tool/internal/english"
+	"github.com/jdbaldry/jsonnet-tool/pkg/traverse"
+)
+
+// lockFileName is the name of the jsonnet-bundler lock file.
+const lockFileName = "jsonnetfile.lock.json"
+
+// lockDependency is a dependency in a jsonnet-bundler lock file.
+type lockDependency struct {
+	Source struct {
+		Git *struct {
+			Remote string `json:"remote"`
+			Subdir string `json:"subdir"`
+		} `json:"git"`
+		Local *struct {
+			Directory string `json:"directory"`
+		} `json:"local"`
+	} `json:"source"`
+	Version string `json:"version"`
+	// Name is the legacy name of the dependency, which is also linked in the vendor directory.
+	Name string `json:"name"`
+}
+
+// vendorPath returns the path of the dependency within the vendor directory, like jsonnet-bundler.
+func (d lockDependency) vendorPath() string {
+	switch {
+	case d.Source.Git != nil:
+		remote := strings.TrimSuffix(d.Source.Git.Remote, ".git")
+		if i := strings.Index(remote, "://"); i >= 0 {
+			remote = remote[i+3:]
+			if at := strings.Index(remote, "@"); at >= 0 && at < strings.Index(remote+"/", "/") {
+				remote = remote[at+1:]
+			}
+		} else if at := strings.Index(remote, "@"); at >= 0 {
+			// An scp-like address such as git@github.com:org/repo.
+			remote = strings.Replace(remote[at+1:], ":", "/", 1)
+		}
+		return filepath.Join(remote, d.Source.Git.Subdir)
+	case d.Source.Local != nil:
+		return filepath.Base(d.Source.Local.Directory)
+	}
+	return d.Name
+}
+
+// findLockDependency returns the dependency of the lock file with the vendor path, legacy name, or git remote.
+func findLockDependency(lockFile, name string) (lockDependency, error) {
+	b, err := os.ReadFile(lockFile)
+	if err != nil {
+		return lockDependency{}, fmt.Errorf("unable to read lock file: %w", err)
+	}
+	var lock struct {
+		Dependencies []lockDependency `json:"dependencies"`
+	}
+	if err := json.Unmarshal(b, &lock); err != nil {
+		return lockDependency{}, fmt.Errorf("unable to parse lock file %s: %w", lockFile, err)
+	}
+	var names []string
+	for _, d := range lock.Dependencies {
+		if d.vendorPath() == filepath.Clean(name) || (d.Name != "" && d.Name == name) ||
+			(d.Source.Git != nil && d.Source.Git.Remote == name) {
+			return d, nil
+		}
+		names = append(names, d.vendorPath())
+	}
+	return lockDependency{}, fmt.Errorf("no dependency %s in %s, expected one of %s", name, lockFile, strings.Join(names, ", "))
+}
+
+// apiSymbol is a field of the object that a library file evaluates to, or the function that it evaluates to.
+type apiSymbol struct {
+	// Path is the path of the field from the root of the file, like $.a.b.
+	Path     string
+	Function bool
+	// Params are the parameters of a function, without their defaults.
+	Params        []param
+	LocationRange LocationRange
+}
+
+// signature returns the parameters of a function symbol like (a, b=...).
+func (s apiSymbol) signature() string {
+	var params []string
+	for _, p := range s.Params {
+		if p.Required {
+			params = append(params, p.Name)
+		} else {
+			params = append(params, p.Name+"=...")
+		}
+	}
+	return "(" + strings.Join(params, ", ") + ")"
+}
+
+// libraryAPI returns the symbols of the Jsonnet files in the library directory by their slash separated path
+// relative to the directory and their path within the file.
+func libraryAPI(dir string) (map[string]map[string]apiSymbol, error) {
+	files, err := findFiles(dir, ".jsonnet", ".libsonnet")
+	if err != nil {
+		return nil, err
+	}
+	api := make(map[string]map[string]apiSymbol, len(files))
+	for _, file := range files {
+		input, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		root, _, err := formatter.SnippetToRawAST(file, string(input))
+		if err != nil {
+			return nil, err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return nil, err
+		}
+		symbols := make(map[string]apiSymbol)
+		findAPISymbols(root, "$", symbols)
+		api[filepath.ToSlash(rel)] = symbols
+	}
+	return api, nil
+}
+
+// functionSymbol returns the symbol of a function with the parameters at the path.
+func functionSymbol(path string, params []ast.Parameter, loc *ast.LocationRange) apiSymbol {
+	s := apiSymbol{Path: path, Function: true, LocationRange: makeLocationRange(loc)}
+	for _, p := range params {
+		s.Params = append(s.Params, param{Name: string(p.Name), Required: p.DefaultArg == nil, LocationRange: makeLocationRange(&p.LocRange)})
+	}
+	return s
+}
+
+// findAPISymbols adds the symbols of the raw AST at the path to symbols. Fields with names that are only known
+// at evaluation, and objects that are not written literally in the file, like imports, are not included.
+func findAPISymbols(node ast.Node, path string, symbols map[string]apiSymbol) {
+	switch n := unparen(node).(type) {
+	case *ast.Local:
+		findAPISymbols(n.Body, path, symbols)
+	case *ast.Binary:
+		if n.Op == ast.BopPlus {
+			findAPISymbols(n.Left, path, symbols)
+			findAPISymbols(n.Right, path, symbols)
+		}
+	case *ast.ApplyBrace:
+		findAPISymbols(n.Left, path, symbols)
+		findAPISymbols(n.Right, path, symbols)
+	case *ast.Function:
+		symbols[path] = functionSymbol(path, n.Parameters, n.Loc())
+	case *ast.Object:
+		for _, field := range n.Fields {
+			name, ok := fieldName(field)
+			if !ok || field.Kind == ast.ObjectLocal || field.Kind == ast.ObjectAssert {
+				continue
+			}
+			fp := fieldPath(path, name)
+			if field.Method != nil {
+				symbols[fp] = functionSymbol(fp, field.Method.Parameters, &field.LocRange)
+				continue
+			}
+			symbols[fp] = apiSymbol{Path: fp, LocationRange: makeLocationRange(&field.LocRange)}
+			findAPISymbols(field.Expr2, fp, symbols)
+		}
+	}
+}
+
+// apiChanges returns the changes to the symbols of the library from the old to the new version.
+func apiChanges(oldAPI, newAPI map[string]map[string]apiSymbol) []astEdit {
+	var edits []astEdit
+	for _, file := range sortedKeys(oldAPI) {
+		newSymbols, ok := newAPI[file]
+		if !ok {
+			edits = append(edits, astEdit{Path: "$", Message: "file removed", LocationRange: LocationRange{FileName: file}})
+			continue
+		}
+		oldSymbols := oldAPI[file]
+		for _, path := range sortedKeys(oldSymbols) {
+			o := oldSymbols[path]
+			n, ok := newSymbols[path]
+			switch {
+			case !ok:
+				edits = append(edits, astEdit{Path: path, Message: "removed", LocationRange: o.LocationRange})
+			case o.Function && !n.Function:
+				edits = append(edits, astEdit{Path: path, Message: "is no longer a function", LocationRange: n.LocationRange})
+			case !o.Function && n.Function:
+				edits = append(edits, astEdit{Path: path, Message: "is now a function", LocationRange: n.LocationRange})
+			case o.Function && o.signature() != n.signature():
+				edits = append(edits, astEdit{
+					Path:          path,
+					Message:       fmt.Sprintf("signature changed from %s to %s", o.signature(), n.signature()),
+					LocationRange: n.LocationRange,
+				})
+			}
+		}
+		for _, path := range sortedKeys(newSymbols) {
+			if _, ok := oldSymbols[path]; !ok {
+				edits = append(edits, astEdit{Path: path, Message: "added", LocationRange: newSymbols[path].LocationRange})
+			}
+		}
+	}
+	for _, file := range sortedKeys(newAPI) {
+		if _, ok := oldAPI[file]; !ok {
+			edits = append(edits, astEdit{Path: "$", Message: "file added", LocationRange: LocationRange{FileName: file}})
+		}
+	}
+	return edits
+}
+
+// sortedKeys returns the keys of the map in order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// callSite is a reference to a symbol of a library file.
+type callSite struct {
+	// File is the slash separated path of the library file relative to the library directory.
+	File string
+	// Path are the names of the fields indexed from the root of the library file.
+	Path []string
+	// Call is the application of the symbol, if it is called.
+	Call          *ast.Apply
+	LocationRange LocationRange
+}
+
+// libraryFile returns the path of the file imported from importedFrom relative to the library directory,
+// if the import resolves to a file within the library.
+func libraryFile(importer jsonnet.Importer, importedFrom, path, libDir string) (string, bool) {
+	_, foundAt, err := importer.Import(importedFrom, path)
+	if err != nil {
+		return "", false
+	}
+	if resolved, err := filepath.EvalSymlinks(foundAt); err == nil {
+		foundAt = resolved
+	}
+	rel, err := filepath.Rel(libDir, absPath(foundAt))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// findCallSites returns the references in the raw AST of the file to the symbols of the files of the library in
+// libDir, which has had its symbolic links resolved. A reference is a chain of field accesses on an import of a
+// library file or on a local variable bound to one. Shadowing of local variables is not taken into account.
+func findCallSites(importer jsonnet.Importer, file string, root ast.Node, libDir string) []callSite {
+	imported := func(node ast.Node) (string, bool) {
+		imp, ok := unparen(node).(*ast.Import)
+		if !ok {
+			return "", false
+		}
+		return libraryFile(importer, file, imp.File.Value, libDir)
+	}
+	vars := make(map[ast.Identifier]string)
+	traverse.Traverse(root,
+		func(node *ast.Node) error {
+			var binds []ast.LocalBind
+			switch n := (*node).(type) {
+			case *ast.Local:
+				binds = n.Binds
+			case *ast.Object:
+				for _, field := range n.Fields {
+					if field.Kind == ast.ObjectLocal {
+						binds = append(binds, ast.LocalBind{Variable: *field.Id, Body: field.Expr2})
+					}
+				}
+			}
+			for _, bind := range binds {
+				if lib, ok := imported(bind.Body); ok {
+					vars[bind.Variable] = lib
+				}
+			}
+			return nil
+		}, traverse.Nop, traverse.Nop)
+
+	// chain returns the library file and the field names of a chain of field accesses.
+	chain := func(node ast.Node) (string, []string, []ast.Node, bool) {
+		var names []string
+		var indexes []ast.Node
+		for {
+			node = unparen(node)
+			switch n := node.(type) {
+			case *ast.Index:
+				name := ""
+				if n.Id != nil {
+					name = string(*n.Id)
+				} else if s, ok := n.Index.(*ast.LiteralString); ok {
+					name = s.Value
+				} else {
+					return "", nil, nil, false
+				}
+				names = append([]string{name}, names...)
+				indexes = append(indexes, n)
+				node = n.Target
+				continue
+			case *ast.Var:
+				lib, ok := vars[n.Id]
+				return lib, names, indexes, ok
+			}
+			lib, ok := imported(node)
+			return lib, names, indexes, ok
+		}
+	}
+
+	var sites []callSite
+	seen := make(map[ast.Node]bool)
+	traverse.Traverse(root,
+		func(node *ast.Node) error {
+			if seen[*node] {
+				return nil
+			}
+			var call *ast.Apply
+			target := *node
+			if apply, ok := target.(*ast.Apply); ok {
+				call, target = apply, apply.Target
+			}
+			if _, ok := unparen(target).(*ast.Index); !ok {
+				return nil
+			}
+			lib, names, indexes, ok := chain(target)
+			if !ok {
+				return nil
+			}
+			for _, index := range indexes {
+				seen[index] = true
+			}
+			sites = append(sites, callSite{File: lib, Path: names, Call: call, LocationRange: makeLocationRange((*node).Loc())})
+			return nil
+		}, traverse.Nop, traverse.Nop)
+	return sites
+}
+
+// callProblems returns the reasons that the call site does not match the symbol.
+func callProblems(site callSite, s apiSymbol) []string {
+	if site.Call == nil {
+		return nil
+	}
+	if !s.Function {
+		return []string{"is not a function"}
+	}
+	var problems []string
+	positional := len(site.Call.Arguments.Positional)
+	if positional > len(s.Params) {
+		problems = append(problems, fmt.Sprintf("takes %s but is called with %d positional arguments", english.Plural(len(s.Params), "parameter"), positional))
+	}
+	named := make(map[string]bool)
+	for _, arg := range site.Call.Arguments.Named {
+		named[string(arg.Name)] = true
+		if indexOf(paramNames(s.Params), string(arg.Name)) < 0 {
+			problems = append(problems, fmt.Sprintf("has no parameter %s", arg.Name))
+		}
+	}
+	for i, p := range s.Params {
+		if p.Required && i >= positional && !named[p.Name] {
+			problems = append(problems, fmt.Sprintf("requires parameter %s", p.Name))
+		}
+	}
+	return problems
+}
+
+// paramNames returns the names of the parameters.
+func paramNames(params []param) []string {
+	names := make([]string, len(params))
+	for i, p := range params {
+		names[i] = p.Name
+	}
+	return names
+}
+
+// breakingCallSites returns a diagnostic for each call site that matches the old API of the library but would
+// not match the new API, because the file or a symbol it references is removed or the arguments of a call no
+// longer match the parameters. References to fields that are not in the API of either version are not checked.
+func breakingCallSites(sites []callSite, oldAPI, newAPI map[string]map[string]apiSymbol) []diagnostic {
+	var diagnostics []diagnostic
+	for _, site := range sites {
+		oldSymbols, ok := oldAPI[site.File]
+		if !ok {
+			continue
+		}
+		newSymbols, ok := newAPI[site.File]
+		if !ok {
+			diagnostics = append(diagnostics, diagnostic{
+				Rule:          "breaking-change",
+				Message:       fmt.Sprintf("%s is removed", site.File),
+				LocationRange: site.LocationRange,
+			})
+			continue
+		}
+		// The longest path of the reference that is a symbol of the old version is checked.
+		for i := len(site.Path); i >= 0; i-- {
+			path := "$"
+			for _, name := range site.Path[:i] {
+				path = fieldPath(path, name)
+			}
+			o, ok := oldSymbols[path]
+			if !ok {
+				continue
+			}
+			n, ok := newSymbols[path]
+			if !ok {
+				diagnostics = append(diagnostics, diagnostic{
+					Rule:          "breaking-change",
+					Message:       fmt.Sprintf("%s of %s is removed", path, site.File),
+					LocationRange: site.LocationRange,
+				})
+				break
+			}
+			if i < len(site.Path) {
+				break
+			}
+			old := callProblems(site, o)
+			for _, problem := range callProblems(site, n) {
+				if indexOf(old, problem) < 0 {
+					diagnostics = append(diagnostics, diagnostic{
+						Rule:          "breaking-change",
+						Message:       fmt.Sprintf("%s of %s %s", path, site.File, problem),
+						LocationRange: site.LocationRange,
+					})
+				}
+			}
+			break
+		}
+	}
+	return diagnostics
+}
+
+// upgradeCheck compares the API of the vendored version of the dependency of the project in root with the
+// candidate version in candidateDir, and finds the call sites in the Jsonnet files of the project that would break.
+func upgradeCheck(root string, dependency lockDependency, candidateDir string) ([]astEdit, []diagnostic, error) {
+	libDir := absPath(filepath.Join(root, "vendor", dependency.vendorPath()))
+	if resolved, err := filepath.EvalSymlinks(libDir); err == nil {
+		libDir = resolved
+	}
+	oldAPI, err := libraryAPI(libDir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read the vendored version of %s: %w", dependency.vendorPath(), err)
+	}
+	newAPI, err := libraryAPI(candidateDir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read the candidate version of %s: %w", dependency.vendorPath(), err)
+	}
+	files, err := findFiles(root, ".jsonnet", ".libsonnet")
+	if err != nil {
+		return nil, nil, err
+	}
+	importer := makeImporter()
+	var sites []callSite
+	for _, file := range files {
+		input, err := os.ReadFile(file)
+		if err != nil {
+			return nil, nil, err
+		}
+		node, _, err := formatter.SnippetToRawAST(file, string(input))
+		if err != nil {
+			return nil, nil, err
+		}
+		sites = append(sites, findCallSites(importer, file, node, libDir)...)
+	}
+	return apiChanges(oldAPI, newAPI), breakingCallSites(sites, oldAPI, newAPI), nil
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+)
+
+// violation is a value of the evaluated output that does not satisfy a schema.
+type violation struct {
+	// Path locates the value in the output, like $.spec.replicas.
+	Path    string
+	Message string
+	// LocationRange is the source of the value, or of its closest ancestor with a known source.
+	LocationRange LocationRange
+}
+
+// String returns the violation in the same format as astdiff edits.
+func (v violation) String() string {
+	return fmt.Sprintf("%s %s: %s", v.LocationRange, v.Path, v.Message)
+}
+
+// schemaDocument is a JSON Schema, or an OpenAPI document whose definitions are schemas, decoded as JSON.
+// It resolves the local references of the schemas within it.
+type schemaDocument struct {
+	root interface{}
+	// closed rejects the fields of objects that are not in their properties, unless additionalProperties allows them.
+	// Kubernetes rejects unknown fields, unlike JSON Schema.
+	closed bool
+}
+
+// readSchemaDocument reads a JSON Schema or OpenAPI document from the JSON file.
+func readSchemaDocument(path string, closed bool) (*schemaDocument, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var root interface{}
+	if err := json.Unmarshal(b, &root); err != nil {
+		return nil, fmt.Errorf("unable to decode schema %s: %w", path, err)
+	}
+	return &schemaDocument{root: root, closed: closed}, nil
+}
+
+// resolve returns the schema referenced by a local JSON pointer like #/definitions/name.
+func (d *schemaDocument) resolve(ref string) (interface{}, error) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("unsupported reference %s, only references within the schema are supported", ref)
+	}
+	node := d.root
+	for _, token := range strings.Split(strings.TrimPrefix(ref, "#"), "/")[1:] {
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		object, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("unable to resolve reference %s", ref)
+		}
+		if node, ok = object[token]; !ok {
+			return nil, fmt.Errorf("unable to resolve reference %s", ref)
+		}
+	}
+	return node, nil
+}
+
+// validate returns the violations of the schema by the decoded JSON value at the output path.
+// It supports the type, enum, const, properties, required, additionalProperties, items, length, range, pattern,
+// and combining keywords of JSON Schema, and local references. Other keywords are ignored.
+func (d *schemaDocument) validate(schema, value interface{}, path string) []violation {
+	var violations []violation
+	fail := func(format string, args ...interface{}) {
+		violations = append(violations, violation{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+	s, ok := schema.(map[string]interface{})
+	if !ok {
+		// The schema false allows no values and true allows any.
+		if allowed, ok := schema.(bool); ok && !allowed {
+			fail("no value is allowed")
+		}
+		return violations
+	}
+	if ref, ok := s["$ref"].(string); ok {
+		// Kubernetes accepts numbers as quantities, like cpu: 1, but its OpenAPI definition is a string.
+		if t := schemaType(value); strings.HasSuffix(ref, ".resource.Quantity") && (t == "integer" || t == "number") {
+			return violations
+		}
+		resolved, err := d.resolve(ref)
+		if err != nil {
+			fail("%v", err)
+			return violations
+		}
+		violations = append(violations, d.validate(resolved, value, path)...)
+	}
+
+	if types := schemaKeywordTypes(s["type"]); len(types) > 0 && !satisfiesType(types, s, value) {
+		fail("expected %s, found %s", strings.Join(types, " or "), schemaType(value))
+		// The other keywords describe values of the expected types.
+		return violations
+	}
+	if enum, ok := s["enum"].([]interface{}); ok && !containsValue(enum, value) {
+		fail("%s is not one of %s", jsonText(value), jsonText(enum))
+	}
+	if c, ok := s["const"]; ok && !reflect.DeepEqual(c, value) {
+		fail("%s is not %s", jsonText(value), jsonText(c))
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		violations = append(violations, d.validateObject(s, v, path)...)
+	case []interface{}:
+		violations = append(violations, d.validateArray(s, v, path)...)
+	case string:
+		n := float64(utf8.RuneCountInString(v))
+		if min, ok := s["minLength"].(float64); ok && n < min {
+			fail("length %v is less than the minimum %v", n, min)
+		}
+		if max, ok := s["maxLength"].(float64); ok && n > max {
+			fail("length %v is more than the maximum %v", n, max)
+		}
+		if pattern, ok := s["pattern"].(string); ok {
+			if re, err := regexp.Compile(pattern); err != nil {
+				fail("invalid pattern %s: %v", pattern, err)
+			} else if !re.MatchString(v) {
+				fail("%s does not match the pattern %s", jsonText(v), pattern)
+			}
+		}
+	case float64:
+		if min, ok := s["minimum"].(float64); ok {
+			if exclusive, _ := s["exclusiveMinimum"].(bool); exclusive && v <= min {
+				fail("%v is not more than the minimum %v", v, min)
+			} else if v < min {
+				fail("%v is less than the minimum %v", v, min)
+			}
+		}
+		if max, ok := s["maximum"].(float64); ok {
+			if exclusive, _ := s["exclusiveMaximum"].(bool); exclusive && v >= max {
+				fail("%v is not less than the maximum %v", v, max)
+			} else if v > max {
+				fail("%v is more than the maximum %v", v, max)
+			}
+		}
+		if min, ok := s["exclusiveMinimum"].(float64); ok && v <= min {
+			fail("%v is not more than the minimum %v", v, min)
+		}
+		if max, ok := s["exclusiveMaximum"].(float64); ok && v >= max {
+			fail("%v is not less than the maximum %v", v, max)
+		}
+	}
+
+	if all, ok := s["allOf"].([]interface{}); ok {
+		for _, sub := range all {
+			violations = append(violations, d.validate(sub, value, path)...)
+		}
+	}
+	if any, ok := s["anyOf"].([]interface{}); ok && d.matches(any, value, path) == 0 {
+		fail("does not match any of the schemas of anyOf")
+	}
+	if one, ok := s["oneOf"].([]interface{}); ok {
+		if n := d.matches(one, value, path); n != 1 {
+			fail("matches %d of the schemas of oneOf instead of exactly one", n)
+		}
+	}
+	if not, ok := s["not"]; ok && len(d.validate(not, value, path)) == 0 {
+		fail("matches the schema of not")
+	}
+	return violations
+}
+
+// matches returns the number of the schemas that the value satisfies.
+func (d *schemaDocument) matches(schemas []interface{}, value interface{}, path string) int {
+	n := 0
+	for _, sub := range schemas {
+		if len(d.validate(sub, value, path)) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// validateObject returns the violations of the object keywords of the schema by the object at the output path.
+func (d *schemaDocument) validateObject(s map[string]interface{}, object map[string]interface{}, path string) []violation {
+	var violations []violation
+	if required, ok := s["required"].([]interface{}); ok {
+		for _, name := range required {
+			if name, ok := name.(string); ok {
+				if _, ok := object[name]; !ok {
+					violations = append(violations, violation{Path: path, Message: fmt.Sprintf("missing required field %s", name)})
+				}
+			}
+		}
+	}
+	properties, _ := s["properties"].(map[string]interface{})
+	additional, hasAdditional := s["additionalProperties"]
+	preserveUnknown, _ := s["x-kubernetes-preserve-unknown-fields"].(bool)
+	for _, name := range sortedKeys(object) {
+		p := fieldPath(path, name)
+		if property, ok := properties[name]; ok {
+			violations = append(violations, d.validate(property, object[name], p)...)
+			continue
+		}
+		switch {
+		case hasAdditional:
+			violations = append(violations, d.validate(additional, object[name], p)...)
+		case d.closed && properties != nil && !preserveUnknown:
+			violations = append(violations, violation{Path: p, Message: "unknown field"})
+		}
+	}
+	return violations
+}
+
+// validateArray returns the violations of the array keywords of the schema by the array at the output path.
+func (d *schemaDocument) validateArray(s map[string]interface{}, array []interface{}, path string) []violation {
+	var violations []violation
+	n := float64(len(array))
+	if min, ok := s["minItems"].(float64); ok && n < min {
+		violations = append(violations, violation{Path: path, Message: fmt.Sprintf("%v elements is less than the minimum %v", n, min)})
+	}
+	if max, ok := s["maxItems"].(float64); ok && n > max {
+		violations = append(violations, violation{Path: path, Message: fmt.Sprintf("%v elements is more than the maximum %v", n, max)})
+	}
+	// Arrays of items are the tuples of earlier drafts, where additionalItems describes the rest.
+	tuple, _ := s["items"].([]interface{})
+	if prefix, ok := s["prefixItems"].([]interface{}); ok {
+		tuple = prefix
+	}
+	rest, hasRest := s["items"]
+	if tuple != nil {
+		rest, hasRest = s["additionalItems"]
+		if _, ok := s["prefixItems"]; ok {
+			rest, hasRest = s["items"]
+		}
+	}
+	for i, element := range array {
+		p := fmt.Sprintf("%s[%d]", path, i)
+		switch {
+		case i < len(tuple):
+			violations = append(violations, d.validate(tuple[i], element, p)...)
+		case hasRest:
+			violations = append(violations, d.validate(rest, element, p)...)
+		}
+	}
+	return violations
+}
+
+// schemaKeywordTypes returns the types of the type keyword, which is a string or an array of strings.
+func schemaKeywordTypes(keyword interface{}) []string {
+	switch t := keyword.(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		var types []string
+		for _, name := range t {
+			if name, ok := name.(string); ok {
+				types = append(types, name)
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+// satisfiesType returns true if the value has one of the types. Integers are numbers, and the int-or-string
+// format and extension of Kubernetes allow integers as strings.
+func satisfiesType(types []string, s map[string]interface{}, value interface{}) bool {
+	actual := schemaType(value)
+	for _, t := range types {
+		switch {
+		case t == actual, t == "number" && actual == "integer":
+			return true
+		case t == "string" && actual == "integer" && (s["format"] == "int-or-string" || s["x-kubernetes-int-or-string"] == true):
+			return true
+		}
+	}
+	return false
+}
+
+// jsonText returns the value as compact JSON for messages.
+func jsonText(value interface{}) string {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(b)
+}
+
+// kubernetesSchemas maps the apiVersion and kind of Kubernetes objects to their schemas in an OpenAPI document,
+// by the x-kubernetes-group-version-kind extension of its definitions.
+type kubernetesSchemas struct {
+	document *schemaDocument
+	kinds    map[string]interface{}
+}
+
+// readKubernetesSchemas reads the definitions of a Kubernetes OpenAPI v2 document, like the swagger.json of
+// the Kubernetes API, or the component schemas of an OpenAPI v3 document.
+func readKubernetesSchemas(path string) (*kubernetesSchemas, error) {
+	document, err := readSchemaDocument(path, true)
+	if err != nil {
+		return nil, err
+	}
+	root, _ := document.root.(map[string]interface{})
+	definitions, ok := root["definitions"].(map[string]interface{})
+	if !ok {
+		components, _ := root["components"].(map[string]interface{})
+		if definitions, ok = components["schemas"].(map[string]interface{}); !ok {
+			return nil, fmt.Errorf("%s has neither definitions nor components.schemas", path)
+		}
+	}
+	k := &kubernetesSchemas{document: document, kinds: make(map[string]interface{})}
+	for _, definition := range definitions {
+		d, _ := definition.(map[string]interface{})
+		gvks, _ := d["x-kubernetes-group-version-kind"].([]interface{})
+		for _, gvk := range gvks {
+			gvk, _ := gvk.(map[string]interface{})
+			group, _ := gvk["group"].(string)
+			version, _ := gvk["version"].(string)
+			kind, _ := gvk["kind"].(string)
+			apiVersion := version
+			if group != "" {
+				apiVersion = group + "/" + version
+			}
+			k.kinds[apiVersion+" "+kind] = definition
+		}
+	}
+	return k, nil
+}
+
+// validate returns the violations of the schemas of the Kubernetes objects within the decoded JSON value at the
+// output path. Kubernetes objects are the objects with a string apiVersion and kind, which are found in any
+// object or array, like the items of a List or the resources of an environment. Objects of unknown kinds are
+// reported. Kubernetes objects are not searched for other Kubernetes objects.
+func (k *kubernetesSchemas) validate(value interface{}, path string) []violation {
+	var violations []violation
+	switch v := value.(type) {
+	case map[string]interface{}:
+		apiVersion, isVersioned := v["apiVersion"].(string)
+		kind, isKind := v["kind"].(string)
+		if isVersioned && isKind {
+			schema, ok := k.kinds[apiVersion+" "+kind]
+			if !ok {
+				return []violation{{Path: path, Message: fmt.Sprintf("no schema for kind %s of apiVersion %s", kind, apiVersion)}}
+			}
+			return k.document.validate(schema, v, path)
+		}
+		for _, name := range sortedKeys(v) {
+			violations = append(violations, k.validate(v[name], fieldPath(path, name))...)
+		}
+	case []interface{}:
+		for i, element := range v {
+			violations = append(violations, k.validate(element, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	}
+	return violations
+}
+
+// validator validates evaluated output against a JSON Schema, the Kubernetes OpenAPI definitions, or both.
+type validator struct {
+	schema     *schemaDocument
+	kubernetes *kubernetesSchemas
+}
+
+// newValidator reads the JSON Schema and Kubernetes OpenAPI document. Either path can be empty.
+func newValidator(schemaPath, openAPIPath string) (*validator, error) {
+	v := &validator{}
+	var err error
+	if schemaPath != "" {
+		if v.schema, err = readSchemaDocument(schemaPath, false); err != nil {
+			return nil, err
+		}
+	}
+	if openAPIPath != "" {
+		if v.kubernetes, err = readKubernetesSchemas(openAPIPath); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// validate returns the violations of the JSON output of the root node, located at the source of the fields
+// that define the values where they can be found statically, in output order.
+func (v *validator) validate(vm *jsonnet.VM, root ast.Node, output string) ([]violation, error) {
+	var value interface{}
+	if err := json.Unmarshal([]byte(output), &value); err != nil {
+		return nil, fmt.Errorf("unable to decode output: %w", err)
+	}
+	var violations []violation
+	if v.schema != nil {
+		violations = append(violations, v.schema.validate(v.schema.root, value, "$")...)
+	}
+	if v.kubernetes != nil {
+		violations = append(violations, v.kubernetes.validate(value, "$")...)
+	}
+	if len(violations) == 0 {
+		return nil, nil
+	}
+	locations := map[string]LocationRange{"$": makeLocationRange(root.Loc())}
+	fieldLocations(vm, root, "$", map[ast.Identifier]ast.Node{}, locations, 0)
+	for i := range violations {
+		violations[i].LocationRange = locate(locations, violations[i].Path)
+	}
+	ranges, err := scanOutput(output)
+	if err != nil {
+		return violations, nil
+	}
+	offsets := make(map[string]int, len(ranges))
+	for _, r := range ranges {
+		offsets[r.Path] = r.Begin.Offset
+	}
+	sort.SliceStable(violations, func(i, j int) bool {
+		return offsets[violations[i].Path] < offsets[violations[j].Path]
+	})
+	return violations, nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/jdbaldry/jsonnet-tool/pkg/repl"
+)
+
+// workspaceFileName is the name of the workspace file.
+const workspaceFileName = ".jsonnet-tool-workspace"
+
+// maxRecent is the number of recent evaluation targets kept in the workspace.
+const maxRecent = 20
+
+// workspaceNamespace is a REPL namespace.
+type workspaceNamespace struct {
+	// Exprs are the expressions prepended to evaluations in the namespace.
+	Exprs []string `json:"exprs"`
+	// EvalFile is where evaluations in the namespace are written.
+	EvalFile string `json:"evalFile,omitempty"`
+	// NamespaceFile is where the namespace is written.
+	NamespaceFile string `json:"namespaceFile,omitempty"`
+}
+
+// workspace is the session state shared by the REPL and editor tooling, so that switching between them keeps
+// the same namespaces, external variables, and history. It is stored as JSON in the workspace file.
+type workspace struct {
+	Namespaces []workspaceNamespace `json:"namespaces"`
+	// Namespace is the index of the current namespace.
+	Namespace int `json:"namespace"`
+	// Watched are the files that are watched for changes.
+	Watched []string `json:"watched,omitempty"`
+	// ExtVars are the string external variables of evaluations in the session.
+	ExtVars map[string]string `json:"extVars,omitempty"`
+	// Recent are the most recent evaluation targets, like files or REPL expressions, most recent first.
+	Recent []string `json:"recent,omitempty"`
+}
+
+// findWorkspace returns the path of the closest workspace file at or above the current directory.
+// It returns false if there is no such file.
+func findWorkspace() (string, bool) {
+	dir, ok := findDirContaining(".", []string{workspaceFileName})
+	if !ok {
+		return "", false
+	}
+	return filepath.Join(dir, workspaceFileName), true
+}
+
+// loadWorkspace reads the workspace file. If it does not exist or is empty, the zero workspace is returned.
+func loadWorkspace(path string) (workspace, error) {
+	var w workspace
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return w, nil
+	}
+	if err != nil {
+		return w, fmt.Errorf("unable to read workspace: %w", err)
+	}
+	if len(bytes.TrimSpace(b)) == 0 {
+		return w, nil
+	}
+	if err := json.Unmarshal(b, &w); err != nil {
+		return w, fmt.Errorf("unable to parse workspace %s: %w", path, err)
+	}
+	return w, nil
+}
+
+// updateWorkspace applies the update to the current contents of the workspace file and writes it back,
+// so that the state written by other tools sharing the workspace is kept.
+func updateWorkspace(path string, update func(*workspace)) error {
+	w, err := loadWorkspace(path)
+	if err != nil {
+		return err
+	}
+	update(&w)
+	b, err := json.MarshalIndent(w, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(b, '\n'), false)
+}
+
+// addRecent makes the target the most recent evaluation target.
+func (w *workspace) addRecent(target string) {
+	recent := []string{target}
+	for _, r := range w.Recent {
+		if r != target && len(recent) < maxRecent {
+			recent = append(recent, r)
+		}
+	}
+	w.Recent = recent
+}
+
+// restoreWorkspace restores the namespaces and external variables of the REPL from the workspace.
+func restoreWorkspace(r *repl.REPL, w workspace) {
+	if len(w.Namespaces) > 0 {
+		r.Namespaces = nil
+		for _, ns := range w.Namespaces {
+			r.Namespaces = append(r.Namespaces, repl.Namespace{Exprs: ns.Exprs, EvalFile: ns.EvalFile, File: ns.NamespaceFile})
+		}
+		if w.Namespace >= 0 && w.Namespace < len(w.Namespaces) {
+			r.NS = w.Namespace
+		}
+	}
+	for name, value := range w.ExtVars {
+		r.SetExtVar(name, value)
+	}
+}
+
+// saveWorkspace writes the namespaces, external variables, and evaluations of the REPL to the workspace file,
+// if there is one.
+func saveWorkspace(r *repl.REPL, path string) error {
+	if path == "" {
+		return nil
+	}
+	evaluated := r.Evaluated
+	r.Evaluated = nil
+	return updateWorkspace(path, func(w *workspace) {
+		w.Namespaces = make([]workspaceNamespace, len(r.Namespaces))
+		for i, ns := range r.Namespaces {
+			w.Namespaces[i] = workspaceNamespace{Exprs: ns.Exprs, EvalFile: ns.EvalFile, NamespaceFile: ns.File}
+		}
+		w.Namespace = r.NS
+		w.ExtVars = r.ExtVars
+		for _, expr := range evaluated {
+			w.addRecent(expr)
+		}
+	})
+}